@@ -31,19 +31,157 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+type InstanceConfigure struct {
+	Regist            bool   `yaml:"regist"`
+	AppName           string `yaml:"appName"`
+	HostName          string `yaml:"hostName"`
+	IPAddr            string `yaml:"ipAddr"`
+	HeartBeatInterval int    `yaml:"heartBeatInterval"`
+}
+
 type DiscorveryConfigure struct {
-	ServerUrls        []string `yaml:"serverUrls"`
-	ConnectionTimeout int      `yaml:"connectTimeout"`
-	PollInterval      int      `yaml:"pollInterval"`
-	Retry             int      `yaml:"retry"`
+	ServerUrls        []string          `yaml:"serverUrls"`
+	ConnectionTimeout int               `yaml:"connectTimeout"`
+	PollInterval      int               `yaml:"pollInterval"`
+	Retry             int               `yaml:"retry"`
+	Instance          InstanceConfigure `yaml:"instance"`
+}
+
+type AdmissionConfigure struct {
+	MaxSessions     int     `yaml:"maxSessions"`
+	MaxTranscodings int     `yaml:"maxTranscodings"`
+	MaxCpuUsage     float64 `yaml:"maxCpuUsage"`
+	CheckInterval   int     `yaml:"checkInterval"`
+}
+
+type TimeoutConfigure struct {
+	Handshake  int `yaml:"handshake"`
+	Publish    int `yaml:"publish"`
+	Inactivity int `yaml:"inactivity"`
+}
+
+type ProxyProtocolConfigure struct {
+	Enable         bool     `yaml:"enable"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+	HeaderTimeout  int      `yaml:"headerTimeout"`
+}
+
+type RtmpsConfigure struct {
+	Enable         bool     `yaml:"enable"`
+	Port           string   `yaml:"port"`
+	Addresses      []string `yaml:"addresses"`
+	CertFile       string   `yaml:"certFile"`
+	KeyFile        string   `yaml:"keyFile"`
+	ReloadInterval int      `yaml:"reloadInterval"`
+}
+
+type SrtCallerConfigure struct {
+	Address  string `yaml:"address"`
+	StreamId string `yaml:"streamId"`
+}
+
+type SrtConfigure struct {
+	Enable        bool                 `yaml:"enable"`
+	Port          string               `yaml:"port"`
+	Addresses     []string             `yaml:"addresses"`
+	Passphrase    string               `yaml:"passphrase"`
+	PbKeyLength   int                  `yaml:"pbKeyLength"`
+	Latency       int                  `yaml:"latency"`
+	Callers       []SrtCallerConfigure `yaml:"callers"`
+	RetryInterval int                  `yaml:"retryInterval"`
+}
+
+type WhipConfigure struct {
+	Enable         bool     `yaml:"enable"`
+	Port           string   `yaml:"port"`
+	Addresses      []string `yaml:"addresses"`
+	Path           string   `yaml:"path"`
+	AllowOrigin    string   `yaml:"allowOrigin"`
+	CertFile       string   `yaml:"certFile"`
+	KeyFile        string   `yaml:"keyFile"`
+	ReloadInterval int      `yaml:"reloadInterval"`
+	IceServers     []string `yaml:"iceServers"`
+	PublicIps      []string `yaml:"publicIps"`
+	UdpPortMin     uint16   `yaml:"udpPortMin"`
+	UdpPortMax     uint16   `yaml:"udpPortMax"`
+}
+
+type PlayConfigure struct {
+	Enable       bool `yaml:"enable"`
+	Authenticate bool `yaml:"authenticate"`
+	QueueSize    int  `yaml:"queueSize"`
+	Renditions   bool `yaml:"renditions"`
+}
+
+type RelayTargetConfigure struct {
+	Name string `yaml:"name"`
+	Url  string `yaml:"url"`
+}
+
+type RelayConfigure struct {
+	Enable         bool                   `yaml:"enable"`
+	Targets        []RelayTargetConfigure `yaml:"targets"`
+	ConnectTimeout int                    `yaml:"connectTimeout"`
+	MinBackoff     int                    `yaml:"minBackoff"`
+	MaxBackoff     int                    `yaml:"maxBackoff"`
+	ChunkSize      int                    `yaml:"chunkSize"`
+}
+
+type FlvConfigure struct {
+	Enable       bool   `yaml:"enable"`
+	Path         string `yaml:"path"`
+	AllowOrigin  string `yaml:"allowOrigin"`
+	WriteTimeout int    `yaml:"writeTimeout"`
+}
+
+type LlHlsConfigure struct {
+	Enable       bool   `yaml:"enable"`
+	Path         string `yaml:"path"`
+	PartDuration int    `yaml:"partDuration"`
+	Segments     int    `yaml:"segments"`
+	Container    string `yaml:"container"`
+	AllowOrigin  string `yaml:"allowOrigin"`
+}
+
+type DashConfigure struct {
+	Enable      bool   `yaml:"enable"`
+	Path        string `yaml:"path"`
+	Segments    int    `yaml:"segments"`
+	AllowOrigin string `yaml:"allowOrigin"`
+}
+
+type ApiAdminConfigure struct {
+	Port      string   `yaml:"port"`
+	Addresses []string `yaml:"addresses"`
+	Token     string   `yaml:"token"`
+}
+
+type ApiConfigure struct {
+	Enable    bool              `yaml:"enable"`
+	Port      string            `yaml:"port"`
+	Addresses []string          `yaml:"addresses"`
+	Admin     ApiAdminConfigure `yaml:"admin"`
+	Flv       FlvConfigure      `yaml:"flv"`
+	LlHls     LlHlsConfigure    `yaml:"llhls"`
+	Dash      DashConfigure     `yaml:"dash"`
 }
 
 type ServerConfigure struct {
-	RtmpPort               string              `yaml:"rtmpPort"`
-	Discovery              DiscorveryConfigure `yaml:"discovery"`
-	BroadcastServerAddress string              `yaml:"broadcastServerAddress"`
-	PacketSize             int                 `yaml:"packetSize"`
-	RequestTimeout         int                 `yaml:"requestTimeout"`
+	RtmpPort               string                 `yaml:"rtmpPort"`
+	RtmpAddresses          []string               `yaml:"rtmpAddresses"`
+	Rtmps                  RtmpsConfigure         `yaml:"rtmps"`
+	Srt                    SrtConfigure           `yaml:"srt"`
+	Whip                   WhipConfigure          `yaml:"whip"`
+	Play                   PlayConfigure          `yaml:"play"`
+	Relay                  RelayConfigure         `yaml:"relay"`
+	Api                    ApiConfigure           `yaml:"api"`
+	ProxyProtocol          ProxyProtocolConfigure `yaml:"proxyProtocol"`
+	Discovery              DiscorveryConfigure    `yaml:"discovery"`
+	BroadcastServerAddress string                 `yaml:"broadcastServerAddress"`
+	PacketSize             int                    `yaml:"packetSize"`
+	RequestTimeout         int                    `yaml:"requestTimeout"`
+	Admission              AdmissionConfigure     `yaml:"admission"`
+	Timeout                TimeoutConfigure       `yaml:"timeout"`
 }
 
 type MediaEncodingConfigure struct {
@@ -51,22 +189,133 @@ type MediaEncodingConfigure struct {
 	Frame       int    `yaml:"frame"`
 	Keyint      int    `yaml:"keyint"`
 	SegmentTime int    `yaml:"segmentTime"`
+	Container   string `yaml:"container"`
+}
+
+type MediaLimitConfigure struct {
+	MaxWidth             int     `yaml:"maxWidth"`
+	MaxHeight            int     `yaml:"maxHeight"`
+	MaxFrameRate         float64 `yaml:"maxFrameRate"`
+	MaxVideoDataRate     float64 `yaml:"maxVideoDataRate"`
+	MaxIngestBitrate     float64 `yaml:"maxIngestBitrate"`
+	MaxNodeIngestBitrate float64 `yaml:"maxNodeIngestBitrate"`
+	MeasureWindow        int     `yaml:"measureWindow"`
+	Action               string  `yaml:"action"`
+}
+
+type MediaQualityConfigure struct {
+	Enable              bool `yaml:"enable"`
+	Window              int  `yaml:"window"`
+	MaxKeyFrameInterval int  `yaml:"maxKeyFrameInterval"`
+	MaxTimestampGap     int  `yaml:"maxTimestampGap"`
+	MaxAvDrift          int  `yaml:"maxAvDrift"`
+	WarningScore        int  `yaml:"warningScore"`
+}
+
+type GopCacheConfigure struct {
+	MaxFrames int `yaml:"maxFrames"`
+	MaxSize   int `yaml:"maxSize"`
 }
 
 type MediaConfigure struct {
-	Reserve  string                   `yaml:"reserve"`
-	Encoding []MediaEncodingConfigure `yaml:"encoding"`
+	Reserve   string                   `yaml:"reserve"`
+	Limit     MediaLimitConfigure      `yaml:"limit"`
+	Encoding  []MediaEncodingConfigure `yaml:"encoding"`
+	Alignment string                   `yaml:"alignment"`
+	Quality   MediaQualityConfigure    `yaml:"quality"`
+	GopCache  GopCacheConfigure        `yaml:"gopCache"`
+}
+
+type SegmentRetentionConfigure struct {
+	Policy      string `yaml:"policy"`
+	WindowSize  int    `yaml:"windowSize"`
+	ExpireAfter int    `yaml:"expireAfter"`
+}
+
+type SegmentQuotaConfigure struct {
+	MaxStreamSize int     `yaml:"maxStreamSize"`
+	MaxTotalSize  int     `yaml:"maxTotalSize"`
+	MinFreeSpace  float64 `yaml:"minFreeSpace"`
+}
+
+type SegmentVodConfigure struct {
+	Enable        bool `yaml:"enable"`
+	Mp4           bool `yaml:"mp4"`
+	FinishTimeout int  `yaml:"finishTimeout"`
+}
+
+type SegmentStoreConfigure struct {
+	Type        string `yaml:"type"`
+	Path        string `yaml:"path"`
+	Endpoint    string `yaml:"endpoint"`
+	Region      string `yaml:"region"`
+	Bucket      string `yaml:"bucket"`
+	Prefix      string `yaml:"prefix"`
+	AccessKey   string `yaml:"accessKey"`
+	SecretKey   string `yaml:"secretKey"`
+	PathStyle   bool   `yaml:"pathStyle"`
+	Workers     int    `yaml:"workers"`
+	MaxRetries  int    `yaml:"maxRetries"`
+	DeleteLocal bool   `yaml:"deleteLocal"`
+}
+
+type SegmentSpriteConfigure struct {
+	Enable   bool `yaml:"enable"`
+	Interval int  `yaml:"interval"`
+	Width    int  `yaml:"width"`
+	Columns  int  `yaml:"columns"`
+	Rows     int  `yaml:"rows"`
+}
+
+type SegmentThumbnailConfigure struct {
+	Enable   bool                   `yaml:"enable"`
+	Interval int                    `yaml:"interval"`
+	Width    int                    `yaml:"width"`
+	Sprite   SegmentSpriteConfigure `yaml:"sprite"`
 }
 
 type SegmentConfigure struct {
-	BasePath string `yaml:"basePath"`
-	TsRange  int    `yaml:"tsRange"`
+	BasePath        string                    `yaml:"basePath"`
+	TsRange         int                       `yaml:"tsRange"`
+	Retention       SegmentRetentionConfigure `yaml:"retention"`
+	Quota           SegmentQuotaConfigure     `yaml:"quota"`
+	JanitorInterval int                       `yaml:"janitorInterval"`
+	Vod             SegmentVodConfigure       `yaml:"vod"`
+	Store           SegmentStoreConfigure     `yaml:"store"`
+	Thumbnail       SegmentThumbnailConfigure `yaml:"thumbnail"`
+}
+
+type RecordConfigure struct {
+	Enable      bool   `yaml:"enable"`
+	Default     bool   `yaml:"default"`
+	Path        string `yaml:"path"`
+	Format      string `yaml:"format"`
+	MaxSize     int    `yaml:"maxSize"`
+	MaxDuration int    `yaml:"maxDuration"`
+}
+
+type LogConfigure struct {
+	Level      string            `yaml:"level"`
+	Format     string            `yaml:"format"`
+	NodeId     string            `yaml:"nodeId"`
+	Components map[string]string `yaml:"components"`
+}
+
+type TracingConfigure struct {
+	Enable      bool    `yaml:"enable"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"serviceName"`
+	SampleRatio float64 `yaml:"sampleRatio"`
 }
 
 type Configure struct {
 	Server  ServerConfigure  `yaml:"server"`
 	Media   MediaConfigure   `yaml:"media"`
 	Segment SegmentConfigure `yaml:"segment"`
+	Record  RecordConfigure  `yaml:"record"`
+	Log     LogConfigure     `yaml:"log"`
+	Tracing TracingConfigure `yaml:"tracing"`
 }
 
 func LoadConfigure(filePath string) (*Configure, error) {
@@ -0,0 +1,326 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playauth"
+)
+
+const (
+	MPD_NAMESPACE  = "urn:mpeg:dash:schema:mpd:2011"
+	MPD_PROFILE    = "urn:mpeg:dash:profile:isoff-live:2011"
+	UTC_TIMING     = "urn:mpeg:dash:utc:direct:2014"
+	CHANNEL_SCHEME = "urn:mpeg:dash:23003:3:audio_channel_configuration:2011"
+
+	MPD_TYPE_DYNAMIC = "dynamic"
+	MPD_TYPE_STATIC  = "static"
+
+	// SegmentTimeline is millisecond
+	MPD_TIMESCALE = 1000
+)
+
+type mpd struct {
+	XMLName                    xml.Name       `xml:"MPD"`
+	Namespace                  string         `xml:"xmlns,attr"`
+	Profiles                   string         `xml:"profiles,attr"`
+	Type                       string         `xml:"type,attr"`
+	AvailabilityStartTime      string         `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                string         `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration  string         `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod        string         `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MinBufferTime              string         `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string         `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay string         `xml:"suggestedPresentationDelay,attr,omitempty"`
+	Period                     mpdPeriod      `xml:"Period"`
+	UtcTiming                  *mpdDescriptor `xml:"UTCTiming,omitempty"`
+}
+
+type mpdPeriod struct {
+	Id             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	Id              int                 `xml:"id,attr"`
+	ContentType     string              `xml:"contentType,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	StartWithSap    int                 `xml:"startWithSAP,attr"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	Id                        string             `xml:"id,attr"`
+	Bandwidth                 uint64             `xml:"bandwidth,attr"`
+	Codecs                    string             `xml:"codecs,attr"`
+	Width                     uint32             `xml:"width,attr,omitempty"`
+	Height                    uint32             `xml:"height,attr,omitempty"`
+	AudioSamplingRate         uint32             `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *mpdDescriptor     `xml:"AudioChannelConfiguration,omitempty"`
+	SegmentTemplate           mpdSegmentTemplate `xml:"SegmentTemplate"`
+}
+
+type mpdDescriptor struct {
+	SchemeIdUri string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdSegmentTemplate struct {
+	Timescale              int                `xml:"timescale,attr"`
+	PresentationTimeOffset uint64             `xml:"presentationTimeOffset,attr,omitempty"`
+	Initialization         string             `xml:"initialization,attr"`
+	Media                  string             `xml:"media,attr"`
+	StartNumber            int                `xml:"startNumber,attr"`
+	Timeline               []mpdTimelineEntry `xml:"SegmentTimeline>S"`
+}
+
+type mpdTimelineEntry struct {
+	Time     uint64 `xml:"t,attr,omitempty"`
+	Duration uint64 `xml:"d,attr"`
+	Repeat   int    `xml:"r,attr,omitempty"`
+}
+
+// Presentation is MPD of source stream and its renditions. each stream is representation of video and audio adaptation set
+type Presentation struct {
+	id             string
+	startedAt      time.Time
+	targetDuration uint64
+	windowSize     int
+
+	mutex      sync.Mutex
+	segmenters []*Segmenter
+}
+
+func newPresentation(id string, targetDuration uint64, windowSize int) *Presentation {
+	return &Presentation{
+		id:             id,
+		startedAt:      time.Now(),
+		targetDuration: targetDuration,
+		windowSize:     windowSize,
+		segmenters:     make([]*Segmenter, 0),
+	}
+}
+
+// offset is presentation time of stream which is opened now
+func (p *Presentation) offset() uint64 {
+	return uint64(time.Since(p.startedAt) / time.Millisecond)
+}
+
+// add segmenter and close previous segmenter of same stream
+func (p *Presentation) add(segmenter *Segmenter) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, previous := range p.segmenters {
+		if previous.Name() == segmenter.Name() {
+			previous.Close()
+			p.segmenters[i] = segmenter
+			return
+		}
+	}
+	p.segmenters = append(p.segmenters, segmenter)
+}
+
+// ended is true when all streams are closed
+func (p *Presentation) ended() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segmenter := range p.segmenters {
+		if !segmenter.Ended() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Presentation) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segmenter := range p.segmenters {
+		segmenter.Close()
+	}
+}
+
+// find segmenter and content type of representation id
+func (p *Presentation) find(representationId string) (*Segmenter, string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segmenter := range p.segmenters {
+		for _, contentType := range []string{CONTENT_TYPE_VIDEO, CONTENT_TYPE_AUDIO} {
+			if representationId == representationIdOf(segmenter, contentType) {
+				return segmenter, contentType, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+func representationIdOf(segmenter *Segmenter, contentType string) string {
+	return segmenter.Name() + "-" + contentType
+}
+
+// Manifest make MPD. it is dynamic while any stream is alive and static after all streams are closed.
+// token of player is added to URLs of segment template because player does not pass query of MPD to segments
+func (p *Presentation) Manifest(token string) ([]byte, error) {
+	p.mutex.Lock()
+	segmenters := append([]*Segmenter(nil), p.segmenters...)
+	p.mutex.Unlock()
+
+	video := mpdAdaptationSet{Id: 0, ContentType: CONTENT_TYPE_VIDEO, MimeType: "video/mp4", StartWithSap: 1}
+	audio := mpdAdaptationSet{Id: 1, ContentType: CONTENT_TYPE_AUDIO, MimeType: "audio/mp4", StartWithSap: 1}
+
+	ended := true
+	found := false
+	firstStart := uint64(0)
+	lastEnd := uint64(0)
+	for _, segmenter := range segmenters {
+		segmenter.mutex.Lock()
+		ended = ended && segmenter.ended
+
+		for _, track := range []*track{segmenter.video, segmenter.audio} {
+			if track.init == nil || len(track.segments) == 0 {
+				continue
+			}
+
+			start := track.segments[0].start
+			last := track.segments[len(track.segments)-1]
+			if !found || start < firstStart {
+				firstStart = start
+				found = true
+			}
+
+			if last.start+last.duration > lastEnd {
+				lastEnd = last.start + last.duration
+			}
+
+			representation := representationOf(segmenter, track, token)
+			if track == segmenter.video {
+				video.Representations = append(video.Representations, representation)
+			} else {
+				audio.Representations = append(audio.Representations, representation)
+			}
+		}
+		segmenter.mutex.Unlock()
+	}
+
+	manifest := &mpd{
+		Namespace:     MPD_NAMESPACE,
+		Profiles:      MPD_PROFILE,
+		MinBufferTime: duration(2 * p.targetDuration),
+		Period:        mpdPeriod{Id: "0", Start: duration(0)},
+	}
+
+	for _, adaptationSet := range []mpdAdaptationSet{video, audio} {
+		if len(adaptationSet.Representations) > 0 {
+			manifest.Period.AdaptationSets = append(manifest.Period.AdaptationSets, adaptationSet)
+		}
+	}
+
+	if ended {
+		// segments out of window are not available so that presentation start at first segment
+		manifest.Type = MPD_TYPE_STATIC
+		manifest.MediaPresentationDuration = duration(lastEnd - firstStart)
+		for i := range manifest.Period.AdaptationSets {
+			for j := range manifest.Period.AdaptationSets[i].Representations {
+				manifest.Period.AdaptationSets[i].Representations[j].SegmentTemplate.PresentationTimeOffset = firstStart
+			}
+		}
+	} else {
+		now := time.Now().UTC()
+		manifest.Type = MPD_TYPE_DYNAMIC
+		manifest.AvailabilityStartTime = p.startedAt.UTC().Format(time.RFC3339Nano)
+		manifest.PublishTime = now.Format(time.RFC3339Nano)
+		manifest.MinimumUpdatePeriod = duration(p.targetDuration)
+		manifest.TimeShiftBufferDepth = duration(uint64(p.windowSize) * p.targetDuration)
+		manifest.SuggestedPresentationDelay = duration(3 * p.targetDuration)
+		manifest.UtcTiming = &mpdDescriptor{SchemeIdUri: UTC_TIMING, Value: now.Format(time.RFC3339Nano)}
+	}
+
+	data, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func representationOf(segmenter *Segmenter, track *track, token string) mpdRepresentation {
+	query := playauth.TokenQuery(token)
+	representation := mpdRepresentation{
+		Id:        representationIdOf(segmenter, track.contentType),
+		Bandwidth: track.bandwidth(),
+		Codecs:    track.codecs,
+		SegmentTemplate: mpdSegmentTemplate{
+			Timescale:      MPD_TIMESCALE,
+			Initialization: "$RepresentationID$/" + INIT_SEGMENT_NAME + query,
+			Media:          "$RepresentationID$/$Number$" + SEGMENT_EXTENSION + query,
+			StartNumber:    track.segments[0].number,
+			Timeline:       timeline(track.segments),
+		},
+	}
+
+	if track == segmenter.video {
+		representation.Width, representation.Height = track.muxer.Resolution()
+	} else {
+		representation.AudioSamplingRate = track.muxer.SampleRate()
+		representation.AudioChannelConfiguration = &mpdDescriptor{
+			SchemeIdUri: CHANNEL_SCHEME,
+			Value:       fmt.Sprint(track.muxer.Channels()),
+		}
+	}
+	return representation
+}
+
+// timeline merge continuous segments of same duration. time is written after gap
+func timeline(segments []*segment) []mpdTimelineEntry {
+	entries := make([]mpdTimelineEntry, 0, len(segments))
+	end := uint64(0)
+	for i, segment := range segments {
+		continuous := i > 0 && segment.start == end
+		if continuous && entries[len(entries)-1].Duration == segment.duration {
+			entries[len(entries)-1].Repeat++
+		} else {
+			entry := mpdTimelineEntry{Duration: segment.duration}
+			if !continuous {
+				entry.Time = segment.start
+			}
+			entries = append(entries, entry)
+		}
+		end = segment.start + segment.duration
+	}
+	return entries
+}
+
+// duration is xs:duration of millisecond
+func duration(milliseconds uint64) string {
+	return fmt.Sprintf("PT%d.%03dS", milliseconds/1000, milliseconds%1000)
+}
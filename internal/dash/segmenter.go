@@ -0,0 +1,291 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dash
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+const (
+	CONTENT_TYPE_VIDEO = "video"
+	CONTENT_TYPE_AUDIO = "audio"
+)
+
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// segment is CMAF fragment of track. start is presentation time of millisecond
+type segment struct {
+	number   int
+	start    uint64
+	duration uint64
+	data     []byte
+}
+
+// track is CMAF track of one media type. video and audio are muxed separately so that each has own adaptation set
+type track struct {
+	contentType string
+	muxer       *media.Fmp4Muxer
+	init        []byte
+	codecs      string
+	segments    []*segment
+	number      int
+}
+
+func newTrack(contentType string, offset uint64) *track {
+	muxer := media.NewFmp4Muxer()
+	muxer.SetBaseTimestamp(offset)
+
+	return &track{
+		contentType: contentType,
+		muxer:       muxer,
+		segments:    make([]*segment, 0),
+		number:      0,
+	}
+}
+
+// bandwidth is average bits per second of segments in window
+func (t *track) bandwidth() uint64 {
+	size := uint64(0)
+	duration := uint64(0)
+	for _, segment := range t.segments {
+		size += uint64(len(segment.data))
+		duration += segment.duration
+	}
+
+	if duration == 0 {
+		return 0
+	}
+	return size * 8 * 1000 / duration
+}
+
+func (t *track) find(number int) *segment {
+	for _, segment := range t.segments {
+		if segment.number == number {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Segmenter cut frames of stream to CMAF segments of video and audio track without transcoding.
+// segment is started at key frame after target duration. timestamps are millisecond
+type Segmenter struct {
+	name           string
+	viewer         *playback.Viewer
+	targetDuration uint64
+	windowSize     int
+
+	// presentation time of first frame
+	offset uint64
+
+	mutex sync.Mutex
+	video *track
+	audio *track
+	ended bool
+
+	// guarded by run goroutine
+	hasVideo      bool
+	started       bool
+	segmentStart  uint64
+	lastDts       uint64
+	frameInterval uint64
+}
+
+func newSegmenter(name string, viewer *playback.Viewer, offset, targetDuration uint64, windowSize int) *Segmenter {
+	return &Segmenter{
+		name:           name,
+		viewer:         viewer,
+		targetDuration: targetDuration,
+		windowSize:     windowSize,
+		offset:         offset,
+		video:          newTrack(CONTENT_TYPE_VIDEO, offset),
+		audio:          newTrack(CONTENT_TYPE_AUDIO, offset),
+		ended:          false,
+		started:        false,
+	}
+}
+
+func (s *Segmenter) Name() string {
+	return s.name
+}
+
+func (s *Segmenter) Close() {
+	s.viewer.Close()
+}
+
+func (s *Segmenter) Ended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ended
+}
+
+// run cut frames of viewer until stream is closed
+func (s *Segmenter) run() {
+	for frame := range s.viewer.Frames() {
+		if err := s.write(frame); err != nil {
+			logging.PlaybackStream(logging.COMPONENT_DASH, s.name).Warn("[DashSegmenter][run] muxing fail. ", err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started {
+		s.finishSegment(s.lastDts + s.frameInterval)
+	}
+
+	s.ended = true
+	logging.PlaybackStream(logging.COMPONENT_DASH, s.name).Info("[DashSegmenter][run] end of stream")
+}
+
+func (s *Segmenter) write(frame media.Frame) error {
+	dts := frame.Timestamp().Dts
+	keyFrame := false
+	cutting := false
+
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		s.hasVideo = true
+		keyFrame = media.IsKeyFrame(frame)
+		cutting = true
+	case *media.AudioFrame:
+		cutting = !s.hasVideo
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cutting {
+		if !s.started && (keyFrame || !s.hasVideo) {
+			s.started = true
+			s.segmentStart = dts
+		} else if s.started && (keyFrame || !s.hasVideo) && dts >= s.segmentStart+s.targetDuration {
+			s.finishSegment(dts)
+			s.segmentStart = dts
+		}
+
+		if dts > s.lastDts {
+			s.frameInterval = dts - s.lastDts
+		}
+		s.lastDts = dts
+	}
+
+	if !s.started {
+		return nil
+	}
+
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		return s.video.muxer.WriteVideo(frame)
+	case *media.AudioFrame:
+		return s.audio.muxer.WriteAudio(frame)
+	}
+	return nil
+}
+
+// finishSegment make fragments of tracks which end before frame of endDts.
+// track without frames is not numbered and has gap of time in timeline
+func (s *Segmenter) finishSegment(endDts uint64) {
+	duration := uint64(0)
+	if endDts > s.segmentStart {
+		duration = endDts - s.segmentStart
+	}
+
+	for _, track := range []*track{s.video, s.audio} {
+		data, err := track.muxer.Fragment(endDts)
+		if errors.Is(err, media.ErrCodecNotReady) || (err == nil && data == nil) {
+			continue
+		} else if err != nil {
+			logging.PlaybackStream(logging.COMPONENT_DASH, s.name).Warn("[DashSegmenter][finishSegment] ", track.contentType, " muxing fail. ", err)
+			continue
+		}
+
+		if track.init == nil {
+			if track.init, err = track.muxer.InitSegment(); err != nil {
+				logging.PlaybackStream(logging.COMPONENT_DASH, s.name).Warn("[DashSegmenter][finishSegment] ", track.contentType, " init segment fail. ", err)
+				continue
+			}
+			track.codecs = track.muxer.Codecs()
+		}
+
+		track.segments = append(track.segments, &segment{
+			number:   track.number,
+			start:    s.offset + s.segmentStart,
+			duration: duration,
+			data:     data,
+		})
+
+		track.number++
+
+		if len(track.segments) > s.windowSize {
+			track.segments = track.segments[len(track.segments)-s.windowSize:]
+		}
+	}
+}
+
+func (s *Segmenter) track(contentType string) *track {
+	switch contentType {
+	case CONTENT_TYPE_VIDEO:
+		return s.video
+	case CONTENT_TYPE_AUDIO:
+		return s.audio
+	}
+	return nil
+}
+
+// Init return init segment of track
+func (s *Segmenter) Init(contentType string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	track := s.track(contentType)
+	if track == nil || track.init == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return track.init, nil
+}
+
+// Segment return data of segment of number in window
+func (s *Segmenter) Segment(contentType string, number int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	track := s.track(contentType)
+	if track == nil {
+		return nil, ErrSegmentNotFound
+	}
+
+	segment := track.find(number)
+	if segment == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return segment.data, nil
+}
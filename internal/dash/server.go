@@ -0,0 +1,248 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dash
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playauth"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+const (
+	DEFAULT_PATH             = "/dash"
+	DEFAULT_SEGMENT_DURATION = 2 * time.Second
+	DEFAULT_WINDOW_SIZE      = 10
+
+	MANIFEST_NAME     = "manifest.mpd"
+	INIT_SEGMENT_NAME = "init.mp4"
+	SEGMENT_EXTENSION = ".m4s"
+
+	CONTENT_TYPE_MANIFEST = "application/dash+xml"
+)
+
+// Server serve live MPEG-DASH of streams.
+// GET {path}/{streamId}/manifest.mpd?token={token}, {path}/{streamId}/{representationId}/init.mp4
+// and {path}/{streamId}/{representationId}/{number}.m4s.
+// token is checked on every resource and manifest add it to URLs of segment template
+type Server struct {
+	configure      configure.DashConfigure
+	path           string
+	targetDuration uint64
+	windowSize     int
+	validator      *playauth.Validator
+
+	mutex         sync.Mutex
+	presentations map[string]*Presentation
+}
+
+// NewServer create server. segmentDuration is target duration of segment
+func NewServer(dashConfigure configure.DashConfigure, segmentDuration time.Duration, onValidate playauth.ValidateHandler) *Server {
+	path := strings.TrimSuffix(dashConfigure.Path, "/")
+	if len(path) == 0 {
+		path = DEFAULT_PATH
+	}
+
+	if segmentDuration <= 0 {
+		segmentDuration = DEFAULT_SEGMENT_DURATION
+	}
+
+	windowSize := dashConfigure.Segments
+	if windowSize <= 0 {
+		windowSize = DEFAULT_WINDOW_SIZE
+	}
+
+	return &Server{
+		configure:      dashConfigure,
+		path:           path,
+		targetDuration: uint64(segmentDuration / time.Millisecond),
+		windowSize:     windowSize,
+		validator:      playauth.NewValidator(onValidate),
+		presentations:  make(map[string]*Presentation),
+	}
+}
+
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Open add stream to presentation of id as representations. presentation which is ended is replaced.
+// ended presentation is served as static MPD while segments of window are available
+func (s *Server) Open(id string, stream *playback.Stream) error {
+	viewer, err := stream.SubscribeLossless()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	presentation, exist := s.presentations[id]
+	if !exist || presentation.ended() {
+		presentation = newPresentation(id, s.targetDuration, s.windowSize)
+		s.presentations[id] = presentation
+	}
+
+	segmenter := newSegmenter(stream.Name(), viewer, presentation.offset(), s.targetDuration, s.windowSize)
+	presentation.add(segmenter)
+	s.mutex.Unlock()
+
+	go func() {
+		segmenter.run()
+		if !presentation.ended() {
+			return
+		}
+
+		logging.PlaybackStream(logging.COMPONENT_DASH, id).Info("[DashServer][Open] presentation is ended")
+		retention := time.Duration(uint64(s.windowSize)*s.targetDuration) * time.Millisecond
+		time.AfterFunc(retention, func() {
+			s.remove(presentation)
+		})
+	}()
+	return nil
+}
+
+func (s *Server) remove(presentation *Presentation) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.presentations[presentation.id] != presentation {
+		return
+	}
+	delete(s.presentations, presentation.id)
+	s.validator.Remove(presentation.id)
+}
+
+func (s *Server) presentation(id string) (*Presentation, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	presentation, exist := s.presentations[id]
+	return presentation, exist
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, representationId, file, exist := s.parsePath(r)
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	playauth.WriteCorsHeader(w, s.configure.AllowOrigin)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	presentation, exist := s.presentation(id)
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	// segments are checked as manifest so that URL of manifest is not usable without token
+	if err := s.validator.Validate(presentation.id, r.URL.Query().Get("token"), r.RemoteAddr); err != nil {
+		logging.PlaybackStream(logging.COMPONENT_DASH, presentation.id).Warn("[DashServer][ServeHTTP] reject player. ", err)
+		w.WriteHeader(playauth.StatusOf(err))
+		return
+	}
+
+	if len(representationId) == 0 {
+		s.serveManifest(w, r, presentation)
+		return
+	}
+	s.serveSegment(w, r, presentation, representationId, file)
+}
+
+// parsePath split {path}/{id}/manifest.mpd or {path}/{id}/{representationId}/{file}
+func (s *Server) parsePath(r *http.Request) (string, string, string, bool) {
+	elements, exist := playauth.SplitPath(r, s.path)
+	if !exist {
+		return "", "", "", false
+	}
+
+	switch {
+	case len(elements) == 2 && elements[1] == MANIFEST_NAME:
+		return elements[0], "", elements[1], true
+	case len(elements) == 3:
+		return elements[0], elements[1], elements[2], true
+	}
+	return "", "", "", false
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, presentation *Presentation) {
+	data, err := presentation.Manifest(r.URL.Query().Get("token"))
+	if err != nil {
+		logging.PlaybackStream(logging.COMPONENT_DASH, presentation.id).Error("[DashServer][serveManifest] ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", CONTENT_TYPE_MANIFEST)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+// serveSegment serve init.mp4 or {number}.m4s of representation
+func (s *Server) serveSegment(w http.ResponseWriter, r *http.Request, presentation *Presentation, representationId, file string) {
+	segmenter, contentType, exist := presentation.find(representationId)
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	var data []byte
+	var err error
+	if file == INIT_SEGMENT_NAME {
+		data, err = segmenter.Init(contentType)
+	} else {
+		name, exist := strings.CutSuffix(file, SEGMENT_EXTENSION)
+		number, parseErr := strconv.Atoi(name)
+		if !exist || parseErr != nil || number < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		data, err = segmenter.Segment(contentType, number)
+	}
+
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"/mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
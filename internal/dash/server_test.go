@@ -0,0 +1,124 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dash
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+func newTestServer(t *testing.T) (*Server, *playback.Stream) {
+	t.Helper()
+
+	server := NewServer(configure.DashConfigure{}, time.Second, func(_, token, _ string) error {
+		if token != "good" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+
+	hub := playback.NewHub(256)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	t.Cleanup(func() { hub.Close(stream) })
+
+	if err := server.Open("1", stream); err != nil {
+		t.Fatal(err)
+	}
+	return server, stream
+}
+
+func serve(server *Server, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+func TestEveryResourceNeedToken(t *testing.T) {
+	server, _ := newTestServer(t)
+	representationId := "1-" + CONTENT_TYPE_VIDEO
+	for _, file := range []string{MANIFEST_NAME, representationId + "/" + INIT_SEGMENT_NAME, representationId + "/0.m4s"} {
+		for _, query := range []string{"", "?token=bad"} {
+			if recorder := serve(server, DEFAULT_PATH+"/1/"+file+query); recorder.Code != http.StatusForbidden {
+				t.Fatal(file, query, " is served without valid token. ", recorder.Code)
+			}
+		}
+	}
+}
+
+func TestManifestPassTokenToSegments(t *testing.T) {
+	server, stream := newTestServer(t)
+	for dts := uint64(0); dts <= 2000; dts += 500 {
+		stream.WriteVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{Pts: dts, Dts: dts}, testH264Frame, true))
+	}
+
+	representationId := "1-" + CONTENT_TYPE_VIDEO
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder := serve(server, DEFAULT_PATH+"/1/"+MANIFEST_NAME+"?token=good")
+		if recorder.Code != http.StatusOK {
+			t.Fatal("manifest is not served. ", recorder.Code)
+		}
+
+		body := recorder.Body.String()
+		if strings.Contains(body, "<Representation") {
+			if !strings.Contains(body, `initialization="$RepresentationID$/init.mp4?token=good"`) ||
+				!strings.Contains(body, `media="$RepresentationID$/$Number$.m4s?token=good"`) {
+				t.Fatal("token is not added to segment template. ", body)
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("segment is not made. ", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, file := range []string{INIT_SEGMENT_NAME, "0.m4s"} {
+		target := DEFAULT_PATH + "/1/" + representationId + "/" + file
+		if recorder := serve(server, target); recorder.Code != http.StatusForbidden {
+			t.Fatal(file, " is served without token. ", recorder.Code)
+		}
+
+		if recorder := serve(server, target+"?token=good"); recorder.Code != http.StatusOK {
+			t.Fatal(file, " is not served with token. ", recorder.Code)
+		}
+	}
+}
@@ -27,18 +27,27 @@ package discovery
 import (
 	"errors"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
 	"github.com/hudl/fargo"
 )
 
 const (
 	BroadCastServiceName = "MYSTREAM-BROADCAST"
+
+	DEFAULT_HEART_BEAT_INTERVAL = 30
 )
 
 type DiscorveryClient struct {
-	configure  fargo.Config
-	connection fargo.EurekaConnection
+	configure         fargo.Config
+	connection        fargo.EurekaConnection
+	instanceConfigure configure.InstanceConfigure
+
+	instance *fargo.Instance
+	mutex    sync.Mutex
 }
 
 func NewDiscorveryClient(discoveryConfigure *configure.DiscorveryConfigure) *DiscorveryClient {
@@ -49,12 +58,84 @@ func NewDiscorveryClient(discoveryConfigure *configure.DiscorveryConfigure) *Dis
 	configure.Eureka.Retries = discoveryConfigure.Retry
 
 	client := &DiscorveryClient{
-		configure:  configure,
-		connection: fargo.NewConnFromConfig(configure),
+		configure:         configure,
+		connection:        fargo.NewConnFromConfig(configure),
+		instanceConfigure: discoveryConfigure.Instance,
+		instance:          nil,
 	}
 	return client
 }
 
+// RegistInstance regist this node to discovery server and keep heart beat
+func (dc *DiscorveryClient) RegistInstance(port int) error {
+	instance := &fargo.Instance{
+		InstanceId:     dc.instanceConfigure.HostName + ":" + dc.instanceConfigure.AppName + ":" + strconv.Itoa(port),
+		HostName:       dc.instanceConfigure.HostName,
+		App:            dc.instanceConfigure.AppName,
+		IPAddr:         dc.instanceConfigure.IPAddr,
+		VipAddress:     dc.instanceConfigure.AppName,
+		Status:         fargo.UP,
+		Port:           port,
+		PortEnabled:    true,
+		DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+	}
+
+	if err := dc.connection.RegisterInstance(instance); err != nil {
+		return err
+	}
+
+	dc.mutex.Lock()
+	dc.instance = instance
+	dc.mutex.Unlock()
+
+	go dc.heartBeat()
+	return nil
+}
+
+// UpdateCapacity change status of this node.
+// full node is OUT_OF_SERVICE so that publisher is not routed to.
+func (dc *DiscorveryClient) UpdateCapacity(full bool) error {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	if dc.instance == nil {
+		return nil
+	}
+
+	status := fargo.UP
+	if full {
+		status = fargo.OUTOFSERVICE
+	}
+
+	if err := dc.connection.UpdateInstanceStatus(dc.instance, status); err != nil {
+		logging.Component(logging.COMPONENT_DISCOVERY).Error("[DiscorveryClient][UpdateCapacity] can not update status. ", err)
+		return err
+	}
+
+	dc.instance.Status = status
+	return nil
+}
+
+func (dc *DiscorveryClient) heartBeat() {
+	interval := dc.instanceConfigure.HeartBeatInterval
+	if interval <= 0 {
+		interval = DEFAULT_HEART_BEAT_INTERVAL
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dc.mutex.Lock()
+		err := dc.connection.HeartBeatInstance(dc.instance)
+		dc.mutex.Unlock()
+
+		if err != nil {
+			logging.Component(logging.COMPONENT_DISCOVERY).Warn("[DiscorveryClient][heartBeat] heart beat fail. ", err)
+		}
+	}
+}
+
 func (dc *DiscorveryClient) GetBroadcastServiceAddress() (string, error) {
 	info, err := dc.connection.GetApp(BroadCastServiceName)
 	if err != nil {
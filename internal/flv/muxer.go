@@ -0,0 +1,83 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package flv
+
+import (
+	"bytes"
+
+	gomediaFlv "github.com/yapingcat/gomedia/go-flv"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// Muxer convert H.264 and AAC frames to FLV tags.
+// sequence headers are written before first key frame
+type Muxer struct {
+	buffer *bytes.Buffer
+	writer *gomediaFlv.FlvWriter
+
+	// writer rewrite start codes in place. frame is shared with other viewers
+	videoBuffer []byte
+}
+
+func NewMuxer() *Muxer {
+	buffer := &bytes.Buffer{}
+	return &Muxer{
+		buffer: buffer,
+		writer: gomediaFlv.CreateFlvWriter(buffer),
+	}
+}
+
+// Header return FLV header with first previous tag size
+func (m *Muxer) Header() ([]byte, error) {
+	m.buffer.Reset()
+	if err := m.writer.WriteFlvHeader(); err != nil {
+		return nil, err
+	}
+	return m.buffer.Bytes(), nil
+}
+
+// WriteFrame return FLV tags of frame. returned buffer is valid until next call
+func (m *Muxer) WriteFrame(frame media.Frame) ([]byte, error) {
+	m.buffer.Reset()
+
+	timestamp := frame.Timestamp()
+	pts := uint32(timestamp.Pts)
+	dts := uint32(timestamp.Dts)
+
+	var err error
+	switch frame.MediaType() {
+	case media.MEDIA_VIDEO:
+		m.videoBuffer = append(m.videoBuffer[:0], frame.Data()...)
+		err = m.writer.WriteH264(m.videoBuffer, pts, dts)
+	case media.MEDIA_AUDIO:
+		err = m.writer.WriteAAC(frame.Data(), pts, dts)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return m.buffer.Bytes(), nil
+}
@@ -0,0 +1,292 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package flv
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+const (
+	DEFAULT_PATH          = "/live"
+	DEFAULT_WRITE_TIMEOUT = 10 * time.Second
+	FLV_EXTENSION         = ".flv"
+
+	CONTENT_TYPE_FLV = "video/x-flv"
+)
+
+// SubscribeHandler validate token of player and return viewer of stream
+type SubscribeHandler func(name, token, remoteAddr string) (*playback.Viewer, error)
+
+// Server serve live stream as FLV over HTTP chunked transfer and WebSocket.
+// GET {path}/{name}.flv?token={token}, WebSocket when request is upgrade
+type Server struct {
+	configure    configure.FlvConfigure
+	path         string
+	writeTimeout time.Duration
+	onSubscribe  SubscribeHandler
+	upgrader     websocket.Upgrader
+
+	mutex   sync.Mutex
+	viewers map[string]int
+}
+
+func NewServer(flvConfigure configure.FlvConfigure, onSubscribe SubscribeHandler) *Server {
+	path := strings.TrimSuffix(flvConfigure.Path, "/")
+	if len(path) == 0 {
+		path = DEFAULT_PATH
+	}
+
+	writeTimeout := time.Duration(flvConfigure.WriteTimeout) * time.Second
+	if writeTimeout <= 0 {
+		writeTimeout = DEFAULT_WRITE_TIMEOUT
+	}
+
+	server := &Server{
+		configure:    flvConfigure,
+		path:         path,
+		writeTimeout: writeTimeout,
+		onSubscribe:  onSubscribe,
+		viewers:      make(map[string]int),
+	}
+
+	server.upgrader = websocket.Upgrader{
+		CheckOrigin: server.checkOrigin,
+	}
+	return server
+}
+
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Viewers return count of FLV viewers of each stream
+func (s *Server) Viewers() map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	viewers := make(map[string]int, len(s.viewers))
+	for name, count := range s.viewers {
+		viewers[name] = count
+	}
+	return viewers
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, exist := s.streamName(r)
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.writeCorsHeader(w)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	viewer, err := s.onSubscribe(name, r.URL.Query().Get("token"), remoteAddr)
+	if err != nil {
+		logging.PlaybackStream(logging.COMPONENT_PLAYBACK, name).Warn("[FlvServer][ServeHTTP] reject player. ", err)
+		switch {
+		case errors.Is(err, rtmp.ErrStreamNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, rtmp.ErrServiceUnavailable):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return
+	}
+	defer viewer.Close()
+
+	s.addViewer(name)
+	defer s.removeViewer(name)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r, viewer)
+	} else {
+		s.serveHttp(w, r, viewer)
+	}
+}
+
+// streamName parse name of {path}/{name}.flv
+func (s *Server) streamName(r *http.Request) (string, bool) {
+	name, exist := strings.CutPrefix(r.URL.Path, s.path+"/")
+	if !exist {
+		return "", false
+	}
+
+	name, exist = strings.CutSuffix(name, FLV_EXTENSION)
+	if !exist || len(name) == 0 || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+func (s *Server) writeCorsHeader(w http.ResponseWriter) {
+	allowOrigin := s.configure.AllowOrigin
+	if len(allowOrigin) == 0 {
+		allowOrigin = "*"
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+}
+
+func (s *Server) checkOrigin(r *http.Request) bool {
+	allowOrigin := s.configure.AllowOrigin
+	if len(allowOrigin) == 0 || allowOrigin == "*" {
+		return true
+	}
+	return r.Header.Get("Origin") == allowOrigin
+}
+
+func (s *Server) serveHttp(w http.ResponseWriter, r *http.Request, viewer *playback.Viewer) {
+	controller := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", CONTENT_TYPE_FLV)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, viewer.StreamName()).Info("[FlvServer][serveHttp] start http-flv to ", r.RemoteAddr)
+	err := s.stream(viewer, r.Context().Done(),
+		func(data []byte) error {
+			controller.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			return controller.Flush()
+		})
+
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, viewer.StreamName()).Info("[FlvServer][serveHttp] end http-flv to ", r.RemoteAddr, ". ", err)
+}
+
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request, viewer *playback.Viewer) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.Component(logging.COMPONENT_PLAYBACK).Warn("[FlvServer][serveWebSocket] upgrade fail. ", err)
+		return
+	}
+	defer conn.Close()
+
+	// messages of player are discarded. read fail when player close connection
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, viewer.StreamName()).Info("[FlvServer][serveWebSocket] start ws-flv to ", r.RemoteAddr)
+	err = s.stream(viewer, done,
+		func(data []byte) error {
+			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+			return conn.WriteMessage(websocket.BinaryMessage, data)
+		})
+
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, viewer.StreamName()).Info("[FlvServer][serveWebSocket] end ws-flv to ", r.RemoteAddr, ". ", err)
+}
+
+// stream write FLV header and tags of frames until stream end or player leave.
+// slow player is not blocking stream because viewer drop frames when queue is congested
+func (s *Server) stream(viewer *playback.Viewer, done <-chan struct{}, write func(data []byte) error) error {
+	muxer := NewMuxer()
+	header, err := muxer.Header()
+	if err != nil {
+		return err
+	}
+
+	if err := write(header); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case frame, ok := <-viewer.Frames():
+			if !ok {
+				return nil
+			}
+
+			tags, err := muxer.WriteFrame(frame)
+			if err != nil {
+				return err
+			}
+
+			if len(tags) == 0 {
+				continue
+			}
+
+			if err := write(tags); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) addViewer(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.viewers[name]++
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, name).Info("[FlvServer][addViewer] viewers : ", s.viewers[name])
+}
+
+func (s *Server) removeViewer(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.viewers[name]--
+	if s.viewers[name] <= 0 {
+		delete(s.viewers, name)
+	}
+}
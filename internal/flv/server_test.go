@@ -0,0 +1,254 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package flv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+// keyframe of H264 with SPS and PPS
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+const (
+	flvHeaderSize    = 13
+	flvTagHeaderSize = 11
+	flvTagVideo      = 9
+)
+
+type flvTag struct {
+	tagType   byte
+	timestamp uint32
+	data      []byte
+}
+
+// readTag read a tag and previous tag size after it
+func readTag(reader io.Reader) (flvTag, error) {
+	header := make([]byte, flvTagHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return flvTag{}, err
+	}
+
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	data := make([]byte, size+4)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return flvTag{}, err
+	}
+
+	timestamp := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	return flvTag{tagType: header[0], timestamp: timestamp, data: data[:size]}, nil
+}
+
+func checkHeader(t *testing.T, header []byte) {
+	t.Helper()
+
+	if len(header) != flvHeaderSize || !bytes.HasPrefix(header, []byte("FLV\x01")) {
+		t.Fatalf("invalid flv header. %x", header)
+	}
+}
+
+// checkKeyFrameTags check sequence header and NALU of key frame
+func checkKeyFrameTags(t *testing.T, reader io.Reader) {
+	t.Helper()
+
+	sequenceHeader, err := readTag(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sequenceHeader.tagType != flvTagVideo || sequenceHeader.data[0] != 0x17 || sequenceHeader.data[1] != 0x00 {
+		t.Fatalf("invalid sequence header tag. %x", sequenceHeader.data)
+	}
+
+	keyFrame, err := readTag(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyFrame.tagType != flvTagVideo || keyFrame.data[0] != 0x17 || keyFrame.data[1] != 0x01 || keyFrame.timestamp != 0 {
+		t.Fatalf("invalid key frame tag. %x", keyFrame.data)
+	}
+}
+
+// newTestServer serve stream "1" with token "good"
+func newTestServer(t *testing.T, flvConfigure configure.FlvConfigure) (*Server, *httptest.Server) {
+	t.Helper()
+
+	hub := playback.NewHub(16)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	stream.WriteVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{Pts: 1000, Dts: 1000}, testH264Frame, true))
+
+	server := NewServer(flvConfigure, func(name, token, remoteAddr string) (*playback.Viewer, error) {
+		if name != "1" {
+			return nil, rtmp.ErrStreamNotFound
+		}
+
+		if token != "good" {
+			return nil, errors.New("invalid token")
+		}
+		return hub.Subscribe(name)
+	})
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		hub.Close(stream)
+		httpServer.Close()
+	})
+	return server, httpServer
+}
+
+func waitViewers(t *testing.T, server *Server, expected int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for server.Viewers()["1"] != expected {
+		if time.Now().After(deadline) {
+			t.Fatal("invalid viewers. ", server.Viewers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeHttpFlv(t *testing.T) {
+	server, httpServer := newTestServer(t, configure.FlvConfigure{AllowOrigin: "https://player.example"})
+
+	response, err := http.Get(httpServer.URL + DEFAULT_PATH + "/1.flv?token=good")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != CONTENT_TYPE_FLV {
+		t.Fatal("invalid response. ", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	if origin := response.Header.Get("Access-Control-Allow-Origin"); origin != "https://player.example" {
+		t.Fatal("invalid cors header. ", origin)
+	}
+
+	reader := bufio.NewReader(response.Body)
+	header := make([]byte, flvHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	checkHeader(t, header)
+	checkKeyFrameTags(t, reader)
+	waitViewers(t, server, 1)
+
+	response.Body.Close()
+	waitViewers(t, server, 0)
+}
+
+func TestServeWebSocketFlv(t *testing.T) {
+	server, httpServer := newTestServer(t, configure.FlvConfigure{})
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + DEFAULT_PATH + "/1.flv?token=good"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageType, header, err := conn.ReadMessage()
+	if err != nil || messageType != websocket.BinaryMessage {
+		t.Fatal("header is not received. ", err)
+	}
+	checkHeader(t, header)
+
+	_, tags, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyFrameTags(t, bytes.NewReader(tags))
+	waitViewers(t, server, 1)
+
+	conn.Close()
+	waitViewers(t, server, 0)
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	_, httpServer := newTestServer(t, configure.FlvConfigure{AllowOrigin: "https://player.example"})
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + DEFAULT_PATH + "/1.flv?token=good"
+
+	_, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://other.example"}})
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("upgrade from other origin is accepted. ", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://player.example"}})
+	if err != nil {
+		t.Fatal("upgrade from allowed origin is rejected. ", err)
+	}
+	conn.Close()
+}
+
+func TestServeHttpRejection(t *testing.T) {
+	_, httpServer := newTestServer(t, configure.FlvConfigure{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"invalid token", http.MethodGet, "/1.flv?token=bad", http.StatusForbidden},
+		{"unknown stream", http.MethodGet, "/2.flv?token=good", http.StatusNotFound},
+		{"not flv", http.MethodGet, "/1.ts?token=good", http.StatusNotFound},
+		{"nested path", http.MethodGet, "/a/1.flv?token=good", http.StatusNotFound},
+		{"method", http.MethodPost, "/1.flv?token=good", http.StatusMethodNotAllowed},
+		{"preflight", http.MethodOptions, "/1.flv", http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, _ := http.NewRequest(test.method, httpServer.URL+DEFAULT_PATH+test.path, nil)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != test.status {
+				t.Fatal("invalid status. ", response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	CONTAINER_TS   = "ts"
+	CONTAINER_FMP4 = "fmp4"
+
+	INIT_SEGMENT_NAME = "init.mp4"
+
+	CONTENT_TYPE_TS  = "video/mp2t"
+	CONTENT_TYPE_MP4 = "video/mp4"
+)
+
+// container mux frames to data of segments
+type container interface {
+	// startSegment is called before first frame of segment
+	startSegment()
+	write(frame media.Frame) ([]byte, error)
+
+	// flush return data of frames which are not returned by write. endDts is decode time of next frame
+	flush(endDts uint64) ([]byte, error)
+
+	// initSegment return false when container does not have init segment or it is not made yet
+	initSegment() ([]byte, bool)
+	extension() string
+	contentType() string
+}
+
+// newContainer return MPEG-TS container when kind is empty or unknown
+func newContainer(kind string) container {
+	switch kind {
+	case CONTAINER_FMP4:
+		return &fmp4Container{muxer: media.NewFmp4Muxer()}
+	case CONTAINER_TS, "":
+	default:
+		logging.Component(logging.COMPONENT_HLS).Warn("[Container][newContainer] unknown container ", kind, ". use ", CONTAINER_TS)
+	}
+	return &tsContainer{}
+}
+
+// tsContainer start segment with new muxer so that segment start with PAT and PMT
+type tsContainer struct {
+	muxer *media.TsMuxer
+}
+
+func (c *tsContainer) startSegment() {
+	c.muxer = media.NewTSMuxer()
+}
+
+func (c *tsContainer) write(frame media.Frame) ([]byte, error) {
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		return c.muxer.MuxingVideo(frame)
+	case *media.AudioFrame:
+		return c.muxer.MuxingAudio(frame)
+	}
+	return nil, nil
+}
+
+func (c *tsContainer) flush(_ uint64) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *tsContainer) initSegment() ([]byte, bool) {
+	return nil, false
+}
+
+func (c *tsContainer) extension() string {
+	return ".ts"
+}
+
+func (c *tsContainer) contentType() string {
+	return CONTENT_TYPE_TS
+}
+
+// fmp4Container make CMAF fragment of each part. init segment is made with first fragment
+type fmp4Container struct {
+	muxer *media.Fmp4Muxer
+	init  []byte
+}
+
+func (c *fmp4Container) startSegment() {
+}
+
+func (c *fmp4Container) write(frame media.Frame) ([]byte, error) {
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		return nil, c.muxer.WriteVideo(frame)
+	case *media.AudioFrame:
+		return nil, c.muxer.WriteAudio(frame)
+	}
+	return nil, nil
+}
+
+func (c *fmp4Container) flush(endDts uint64) ([]byte, error) {
+	fragment, err := c.muxer.Fragment(endDts)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.init == nil {
+		if c.init, err = c.muxer.InitSegment(); err != nil {
+			return nil, err
+		}
+	}
+	return fragment, nil
+}
+
+func (c *fmp4Container) initSegment() ([]byte, bool) {
+	return c.init, c.init != nil
+}
+
+func (c *fmp4Container) extension() string {
+	return ".m4s"
+}
+
+func (c *fmp4Container) contentType() string {
+	return CONTENT_TYPE_MP4
+}
@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playauth"
+)
+
+const (
+	PLAYLIST_VERSION = 9
+
+	// parts are listed for last segments which are in PART-HOLD-BACK of player
+	PART_SEGMENTS = 3
+
+	// delta update skip segments which are older than CAN-SKIP-UNTIL multiple of target duration
+	SKIP_TARGET_DURATIONS = 6
+)
+
+// Playlist make media playlist of LL-HLS. segments older than CAN-SKIP-UNTIL are skipped when skip is true.
+// token of player is added to every URI because player does not pass query of playlist to media
+func (s *Segmenter) Playlist(skip bool, token string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	targetDuration := (s.maxDuration + 999) / 1000
+	skipUntil := SKIP_TARGET_DURATIONS * targetDuration * 1000
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "#EXTM3U\n")
+	fmt.Fprintf(builder, "#EXT-X-VERSION:%d\n", PLAYLIST_VERSION)
+	fmt.Fprintf(builder, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(builder, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=%s,PART-HOLD-BACK=%s\n",
+		seconds(skipUntil), seconds(3*s.partDuration))
+	fmt.Fprintf(builder, "#EXT-X-PART-INF:PART-TARGET=%s\n", seconds(s.partDuration))
+
+	mediaSequence := s.nextMsn()
+	if len(s.segments) > 0 {
+		mediaSequence = s.segments[0].msn
+	}
+	fmt.Fprintf(builder, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence)
+
+	query := playauth.TokenQuery(token)
+
+	if _, exist := s.container.initSegment(); exist {
+		fmt.Fprintf(builder, "#EXT-X-MAP:URI=\"%s%s\"\n", INIT_SEGMENT_NAME, query)
+	}
+
+	skipped := 0
+	if skip {
+		skipped = s.skippedSegments(skipUntil)
+		if skipped > 0 {
+			fmt.Fprintf(builder, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", skipped)
+		}
+	}
+
+	extension := s.container.extension() + query
+	for i, segment := range s.segments[skipped:] {
+		if i+skipped >= len(s.segments)-PART_SEGMENTS {
+			writeParts(builder, segment, extension)
+		}
+		fmt.Fprintf(builder, "#EXTINF:%s,\n%d%s\n", seconds(segment.duration), segment.msn, extension)
+	}
+
+	if s.ended {
+		fmt.Fprintf(builder, "#EXT-X-ENDLIST\n")
+		return builder.String()
+	}
+
+	nextPart := 0
+	if s.current != nil {
+		writeParts(builder, s.current, extension)
+		nextPart = len(s.current.parts)
+	}
+	fmt.Fprintf(builder, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%d.%d%s\"\n", s.nextMsn(), nextPart, extension)
+	return builder.String()
+}
+
+// skippedSegments count segments which start before skipUntil from end of playlist
+func (s *Segmenter) skippedSegments(skipUntil uint64) int {
+	remain := uint64(0)
+	if s.current != nil {
+		remain = s.current.duration
+	}
+
+	skipped := len(s.segments)
+	for skipped > 0 && remain < skipUntil {
+		skipped--
+		remain += s.segments[skipped].duration
+	}
+	return skipped
+}
+
+func writeParts(builder *strings.Builder, segment *segment, extension string) {
+	for i, part := range segment.parts {
+		fmt.Fprintf(builder, "#EXT-X-PART:DURATION=%s,URI=\"%d.%d%s\"", seconds(part.duration), segment.msn, i, extension)
+		if part.independent {
+			fmt.Fprintf(builder, ",INDEPENDENT=YES")
+		}
+		fmt.Fprintf(builder, "\n")
+	}
+}
+
+func seconds(milliseconds uint64) string {
+	return fmt.Sprintf("%d.%03d", milliseconds/1000, milliseconds%1000)
+}
@@ -0,0 +1,347 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+var (
+	ErrSegmentNotFound = errors.New("segment not found")
+	ErrFutureSegment   = errors.New("segment is too far in future")
+)
+
+// part is partial segment. data is range of segment
+type part struct {
+	offset      int
+	size        int
+	duration    uint64
+	independent bool
+}
+
+type segment struct {
+	msn      int
+	data     []byte
+	parts    []*part
+	duration uint64
+}
+
+// Segmenter cut frames of stream to MPEG-TS or CMAF segments and partial segments without transcoding.
+// segment is started at key frame after target duration, part is cut before it exceed part duration.
+// timestamps are millisecond
+type Segmenter struct {
+	name           string
+	viewer         *playback.Viewer
+	targetDuration uint64
+	partDuration   uint64
+	windowSize     int
+
+	mutex     sync.Mutex
+	segments  []*segment
+	current   *segment
+	container container
+	updated   chan struct{}
+	ended     bool
+
+	// max duration of segments. target duration of playlist is not decreased
+	maxDuration uint64
+
+	// guarded by run goroutine
+	hasVideo      bool
+	partStart     uint64
+	partKeyFrame  bool
+	lastDts       uint64
+	frameInterval uint64
+}
+
+func newSegmenter(name string, viewer *playback.Viewer, containerKind string, targetDuration, partDuration uint64, windowSize int) *Segmenter {
+	return &Segmenter{
+		name:           name,
+		viewer:         viewer,
+		targetDuration: targetDuration,
+		partDuration:   partDuration,
+		windowSize:     windowSize,
+		segments:       make([]*segment, 0, windowSize),
+		current:        nil,
+		container:      newContainer(containerKind),
+		updated:        make(chan struct{}),
+		ended:          false,
+		maxDuration:    targetDuration,
+	}
+}
+
+func (s *Segmenter) Name() string {
+	return s.name
+}
+
+func (s *Segmenter) Close() {
+	s.viewer.Close()
+}
+
+// run cut frames of viewer until stream is closed
+func (s *Segmenter) run() {
+	for frame := range s.viewer.Frames() {
+		if err := s.write(frame); err != nil {
+			logging.PlaybackStream(logging.COMPONENT_HLS, s.name).Warn("[Segmenter][run] muxing fail. ", err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current != nil {
+		s.finishPart(s.lastDts + s.frameInterval)
+		s.finishSegment()
+	}
+
+	s.ended = true
+	s.notify()
+	logging.PlaybackStream(logging.COMPONENT_HLS, s.name).Info("[Segmenter][run] end of stream")
+}
+
+func (s *Segmenter) write(frame media.Frame) error {
+	dts := frame.Timestamp().Dts
+	keyFrame := false
+	cutting := false
+
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		s.hasVideo = true
+		keyFrame = media.IsKeyFrame(frame)
+		cutting = true
+	case *media.AudioFrame:
+		cutting = !s.hasVideo
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cutting {
+		if s.current == nil && (keyFrame || !s.hasVideo) {
+			s.startSegment(0, dts, keyFrame)
+		} else if s.current != nil {
+			s.cut(dts, keyFrame)
+		}
+
+		if dts > s.lastDts {
+			s.frameInterval = dts - s.lastDts
+		}
+		s.lastDts = dts
+	}
+
+	if s.current == nil {
+		return nil
+	}
+
+	data, err := s.container.write(frame)
+	if err != nil {
+		return err
+	}
+
+	s.current.data = append(s.current.data, data...)
+	return nil
+}
+
+// cut finish part or segment before frame of dts
+func (s *Segmenter) cut(dts uint64, keyFrame bool) {
+	segmentDuration := s.current.duration + (dts - s.partStart)
+	if (keyFrame || !s.hasVideo) && segmentDuration >= s.targetDuration {
+		s.finishPart(dts)
+		msn := s.current.msn + 1
+		s.finishSegment()
+		s.startSegment(msn, dts, keyFrame)
+		return
+	}
+
+	// part should not be longer than part target with next frame
+	if dts+s.frameInterval-s.partStart > s.partDuration {
+		s.finishPart(dts)
+		s.partStart = dts
+		s.partKeyFrame = keyFrame
+	}
+}
+
+func (s *Segmenter) startSegment(msn int, dts uint64, keyFrame bool) {
+	s.current = &segment{
+		msn:   msn,
+		data:  make([]byte, 0),
+		parts: make([]*part, 0),
+	}
+	s.container.startSegment()
+	s.partStart = dts
+	s.partKeyFrame = keyFrame
+}
+
+func (s *Segmenter) finishPart(dts uint64) {
+	data, err := s.container.flush(dts)
+	if err != nil {
+		logging.PlaybackStream(logging.COMPONENT_HLS, s.name).Warn("[Segmenter][finishPart] muxing fail. ", err)
+	}
+	s.current.data = append(s.current.data, data...)
+
+	offset := 0
+	if len(s.current.parts) > 0 {
+		last := s.current.parts[len(s.current.parts)-1]
+		offset = last.offset + last.size
+	}
+
+	size := len(s.current.data) - offset
+	if size == 0 {
+		return
+	}
+
+	duration := uint64(0)
+	if dts > s.partStart {
+		duration = dts - s.partStart
+	}
+
+	s.current.parts = append(s.current.parts, &part{
+		offset:      offset,
+		size:        size,
+		duration:    duration,
+		independent: s.partKeyFrame,
+	})
+	s.current.duration += duration
+	s.notify()
+}
+
+func (s *Segmenter) finishSegment() {
+	if len(s.current.parts) > 0 {
+		s.segments = append(s.segments, s.current)
+		if s.current.duration > s.maxDuration {
+			s.maxDuration = s.current.duration
+		}
+
+		if len(s.segments) > s.windowSize {
+			s.segments = s.segments[len(s.segments)-s.windowSize:]
+		}
+	}
+
+	s.current = nil
+	s.notify()
+}
+
+// notify wake up blocked playlist requests
+func (s *Segmenter) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Wait block until part of msn is available. part is negative when whole segment is waited
+func (s *Segmenter) Wait(ctx context.Context, msn, partIndex int) error {
+	for {
+		s.mutex.Lock()
+		if s.ended || s.available(msn, partIndex) {
+			s.mutex.Unlock()
+			return nil
+		}
+
+		if msn > s.nextMsn()+2 {
+			s.mutex.Unlock()
+			return ErrFutureSegment
+		}
+
+		updated := s.updated
+		s.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Segmenter) available(msn, partIndex int) bool {
+	if s.current != nil && s.current.msn == msn {
+		return partIndex >= 0 && partIndex < len(s.current.parts)
+	}
+	return msn < s.nextMsn()
+}
+
+// nextMsn is msn of current segment or next segment
+func (s *Segmenter) nextMsn() int {
+	if s.current != nil {
+		return s.current.msn
+	}
+
+	if len(s.segments) > 0 {
+		return s.segments[len(s.segments)-1].msn + 1
+	}
+	return 0
+}
+
+func (s *Segmenter) find(msn int) *segment {
+	if s.current != nil && s.current.msn == msn {
+		return s.current
+	}
+
+	for _, segment := range s.segments {
+		if segment.msn == msn {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Init return init segment of container
+func (s *Segmenter) Init() ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.container.initSegment()
+}
+
+// Segment return data of completed segment
+func (s *Segmenter) Segment(msn int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	segment := s.find(msn)
+	if segment == nil || segment == s.current {
+		return nil, ErrSegmentNotFound
+	}
+	return segment.data, nil
+}
+
+// Part return data of partial segment. data of segment is only appended so that slice is not changed
+func (s *Segmenter) Part(msn, partIndex int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	segment := s.find(msn)
+	if segment == nil || partIndex < 0 || partIndex >= len(segment.parts) {
+		return nil, ErrSegmentNotFound
+	}
+
+	part := segment.parts[partIndex]
+	return segment.data[part.offset : part.offset+part.size], nil
+}
@@ -0,0 +1,104 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+// keyframe of H264 with SPS and PPS
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+// testPFrame return disposable P slice whose payload is marked by index
+func testPFrame(index int) []byte {
+	return []byte{0x00, 0x00, 0x00, 0x01, 0x01, 0x9a, 0xab, 0xcd, byte(index), 0xef}
+}
+
+func TestSegmenterDoesNotDropFrames(t *testing.T) {
+	server := NewServer(configure.LlHlsConfigure{}, time.Second, func(_, _, _ string) error { return nil })
+
+	// queue of viewer is smaller than frames which are written at once
+	hub := playback.NewHub(2)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	if err := server.Open(stream, CONTAINER_TS); err != nil {
+		t.Fatal(err)
+	}
+
+	segmenter, exist := server.segmenter("1")
+	if !exist {
+		t.Fatal("segmenter is not found")
+	}
+
+	frames := 0
+	for dts := uint64(0); dts <= 2000; dts += 40 {
+		timestamp := media.Timestamp{Pts: dts, Dts: dts}
+		if dts%1000 == 0 {
+			stream.WriteVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, timestamp, testH264Frame, true))
+			continue
+		}
+		stream.WriteVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, timestamp, testPFrame(frames), false))
+		frames++
+	}
+	hub.Close(stream)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		segmenter.mutex.Lock()
+		ended := segmenter.ended
+		segmenter.mutex.Unlock()
+		if ended {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("segmenter is not ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	segmenter.mutex.Lock()
+	defer segmenter.mutex.Unlock()
+
+	data := make([]byte, 0)
+	for _, segment := range segmenter.segments {
+		data = append(data, segment.data...)
+	}
+
+	for i := 0; i < frames; i++ {
+		if !bytes.Contains(data, testPFrame(i)[4:]) {
+			t.Fatal("frame ", i, " is dropped")
+		}
+	}
+}
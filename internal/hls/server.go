@@ -0,0 +1,298 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playauth"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+const (
+	DEFAULT_PATH             = "/hls"
+	DEFAULT_SEGMENT_DURATION = 2 * time.Second
+	DEFAULT_PART_DURATION    = 333
+	DEFAULT_WINDOW_SIZE      = 10
+
+	PLAYLIST_NAME     = "index.m3u8"
+	SEGMENT_EXTENSION = ".ts"
+
+	CONTENT_TYPE_PLAYLIST = "application/vnd.apple.mpegurl"
+	CONTENT_TYPE_SEGMENT  = "video/mp2t"
+)
+
+// Server serve LL-HLS of streams.
+// GET {path}/{name}/index.m3u8?token={token} with _HLS_msn, _HLS_part and _HLS_skip,
+// {path}/{name}/{msn}.{ts|m4s}, {path}/{name}/{msn}.{part}.{ts|m4s} and {path}/{name}/init.mp4 of CMAF.
+// token is checked on every resource and playlist add it to URIs of media
+type Server struct {
+	configure      configure.LlHlsConfigure
+	path           string
+	targetDuration uint64
+	partDuration   uint64
+	windowSize     int
+	validator      *playauth.Validator
+
+	mutex      sync.Mutex
+	segmenters map[string]*Segmenter
+}
+
+// NewServer create server. segmentDuration is target duration of segment
+func NewServer(llhlsConfigure configure.LlHlsConfigure, segmentDuration time.Duration, onValidate playauth.ValidateHandler) *Server {
+	path := strings.TrimSuffix(llhlsConfigure.Path, "/")
+	if len(path) == 0 {
+		path = DEFAULT_PATH
+	}
+
+	partDuration := uint64(llhlsConfigure.PartDuration)
+	if partDuration == 0 {
+		partDuration = DEFAULT_PART_DURATION
+	}
+
+	if segmentDuration <= 0 {
+		segmentDuration = DEFAULT_SEGMENT_DURATION
+	}
+
+	windowSize := llhlsConfigure.Segments
+	if windowSize <= 0 {
+		windowSize = DEFAULT_WINDOW_SIZE
+	}
+
+	return &Server{
+		configure:      llhlsConfigure,
+		path:           path,
+		targetDuration: uint64(segmentDuration / time.Millisecond),
+		partDuration:   partDuration,
+		windowSize:     windowSize,
+		validator:      playauth.NewValidator(onValidate),
+		segmenters:     make(map[string]*Segmenter),
+	}
+}
+
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Open start segmenter of stream with container of CONTAINER_TS or CONTAINER_FMP4.
+// segmenter is removed when stream is closed
+func (s *Server) Open(stream *playback.Stream, containerKind string) error {
+	viewer, err := stream.SubscribeLossless()
+	if err != nil {
+		return err
+	}
+
+	segmenter := newSegmenter(stream.Name(), viewer, containerKind, s.targetDuration, s.partDuration, s.windowSize)
+
+	s.mutex.Lock()
+	if previous, exist := s.segmenters[segmenter.Name()]; exist {
+		previous.Close()
+	}
+	s.segmenters[segmenter.Name()] = segmenter
+	s.mutex.Unlock()
+
+	go func() {
+		segmenter.run()
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.segmenters[segmenter.Name()] == segmenter {
+			delete(s.segmenters, segmenter.Name())
+		}
+		s.validator.Remove(segmenter.Name())
+	}()
+	return nil
+}
+
+func (s *Server) segmenter(name string) (*Segmenter, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	segmenter, exist := s.segmenters[name]
+	return segmenter, exist
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// {path}/{name}/{file}
+	elements, exist := playauth.SplitPath(r, s.path)
+	if !exist || len(elements) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, file := elements[0], elements[1]
+
+	playauth.WriteCorsHeader(w, s.configure.AllowOrigin)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	segmenter, exist := s.segmenter(name)
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	// media is checked as playlist so that URI of playlist is not usable without token
+	if err := s.validator.Validate(segmenter.Name(), r.URL.Query().Get("token"), r.RemoteAddr); err != nil {
+		logging.PlaybackStream(logging.COMPONENT_HLS, segmenter.Name()).Warn("[HlsServer][ServeHTTP] reject player. ", err)
+		w.WriteHeader(playauth.StatusOf(err))
+		return
+	}
+
+	switch file {
+	case PLAYLIST_NAME:
+		s.servePlaylist(w, r, segmenter)
+	case INIT_SEGMENT_NAME:
+		s.serveInit(w, r, segmenter)
+	default:
+		s.serveSegment(w, r, segmenter, file)
+	}
+}
+
+func (s *Server) servePlaylist(w http.ResponseWriter, r *http.Request, segmenter *Segmenter) {
+	query := r.URL.Query()
+
+	// blocking playlist reload
+	if query.Has("_HLS_msn") {
+		msn, err := strconv.Atoi(query.Get("_HLS_msn"))
+		if err != nil || msn < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		partIndex := -1
+		if query.Has("_HLS_part") {
+			partIndex, err = strconv.Atoi(query.Get("_HLS_part"))
+			if err != nil || partIndex < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !s.wait(w, r, segmenter, msn, partIndex) {
+			return
+		}
+	} else if query.Has("_HLS_part") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	skip := query.Get("_HLS_skip") == "YES"
+
+	w.Header().Set("Content-Type", CONTENT_TYPE_PLAYLIST)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(segmenter.Playlist(skip, query.Get("token"))))
+}
+
+func (s *Server) serveInit(w http.ResponseWriter, r *http.Request, segmenter *Segmenter) {
+	data, exist := segmenter.Init()
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", segmenter.container.contentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// serveSegment serve {msn}.{ext} or {msn}.{part}.{ext}. request of hinted part is blocked until part is available
+func (s *Server) serveSegment(w http.ResponseWriter, r *http.Request, segmenter *Segmenter, file string) {
+	name, exist := strings.CutSuffix(file, segmenter.container.extension())
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	msnValue, partValue, isPart := strings.Cut(name, ".")
+	msn, err := strconv.Atoi(msnValue)
+	if err != nil || msn < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var data []byte
+	if isPart {
+		partIndex, err := strconv.Atoi(partValue)
+		if err != nil || partIndex < 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !s.wait(w, r, segmenter, msn, partIndex) {
+			return
+		}
+		data, err = segmenter.Part(msn, partIndex)
+	} else {
+		data, err = segmenter.Segment(msn)
+	}
+
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", segmenter.container.contentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// wait block request up to 3 target durations. response is written when it fail
+func (s *Server) wait(w http.ResponseWriter, r *http.Request, segmenter *Segmenter, msn, partIndex int) bool {
+	timeout := 3 * time.Duration(s.targetDuration) * time.Millisecond
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	err := segmenter.Wait(ctx, msn, partIndex)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrFutureSegment):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		// request is canceled by player or segmenter is closed
+		logging.PlaybackStream(logging.COMPONENT_HLS, segmenter.Name()).Debug("[HlsServer][wait] wait fail. ", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return false
+}
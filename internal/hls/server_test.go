@@ -0,0 +1,108 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hls
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+func newTestServer(t *testing.T, containerKind string) *Server {
+	t.Helper()
+
+	server := NewServer(configure.LlHlsConfigure{}, time.Second, func(_, token, _ string) error {
+		if token != "good" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+
+	hub := playback.NewHub(16)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	t.Cleanup(func() { hub.Close(stream) })
+
+	if err := server.Open(stream, containerKind); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func serve(server *Server, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+func TestEveryResourceNeedToken(t *testing.T) {
+	server := newTestServer(t, CONTAINER_FMP4)
+	for _, file := range []string{PLAYLIST_NAME, INIT_SEGMENT_NAME, "0.m4s", "0.0.m4s"} {
+		for _, query := range []string{"", "?token=bad"} {
+			if recorder := serve(server, DEFAULT_PATH+"/1/"+file+query); recorder.Code != http.StatusForbidden {
+				t.Fatal(file, query, " is served without valid token. ", recorder.Code)
+			}
+		}
+	}
+
+	// init segment is made with first key frame
+	if recorder := serve(server, DEFAULT_PATH+"/1/"+INIT_SEGMENT_NAME+"?token=good"); recorder.Code != http.StatusNotFound {
+		t.Fatal("invalid status with valid token. ", recorder.Code)
+	}
+}
+
+func TestPlaylistPassTokenToMedia(t *testing.T) {
+	server := newTestServer(t, CONTAINER_TS)
+
+	recorder := serve(server, DEFAULT_PATH+"/1/"+PLAYLIST_NAME+"?token=good")
+	if recorder.Code != http.StatusOK {
+		t.Fatal("playlist is not served. ", recorder.Code)
+	}
+
+	if !strings.Contains(recorder.Body.String(), "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"0.0.ts?token=good\"") {
+		t.Fatal("token is not added to uri of playlist. ", recorder.Body.String())
+	}
+}
+
+func TestBlockingReloadOfCanceledRequest(t *testing.T) {
+	server := newTestServer(t, CONTAINER_TS)
+
+	request := httptest.NewRequest(http.MethodGet, DEFAULT_PATH+"/1/"+PLAYLIST_NAME+"?token=good&_HLS_msn=1", nil)
+	ctx, cancel := context.WithCancel(request.Context())
+	cancel()
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request.WithContext(ctx))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatal("response is not written when wait fail. ", recorder.Code)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+
+	COMPONENT_SESSION   = "session"
+	COMPONENT_SEGMENT   = "segment"
+	COMPONENT_FFMPEG    = "ffmpeg"
+	COMPONENT_DISCOVERY = "discovery"
+	COMPONENT_PLAYBACK  = "playback"
+	COMPONENT_HLS       = "hls"
+	COMPONENT_DASH      = "dash"
+	COMPONENT_RECORD    = "record"
+	COMPONENT_RELAY     = "relay"
+	COMPONENT_SRT       = "srt"
+	COMPONENT_WHIP      = "whip"
+	COMPONENT_PULL      = "pull"
+	COMPONENT_SERVICE   = "service"
+
+	FIELD_COMPONENT       = "component"
+	FIELD_NODE_ID         = "nodeId"
+	FIELD_STREAM_ID       = "streamId"
+	FIELD_STREAM_KEY_HASH = "streamKeyHash"
+	FIELD_REMOTE_ADDR     = "remoteAddr"
+	FIELD_STREAM_NAME     = "streamName"
+	FIELD_RENDITION       = "rendition"
+	FIELD_CONNECTION_ID   = "connectionId"
+	FIELD_RELAY_TARGET    = "relayTarget"
+
+	// length of hex string of stream key hash
+	STREAM_KEY_HASH_LENGTH = 12
+	REDACTED_PREFIX        = "redacted:"
+)
+
+type streamContext struct {
+	streamKey  string
+	remoteAddr string
+}
+
+var (
+	mutex      sync.RWMutex
+	nodeId     string
+	components = make(map[string]*logrus.Logger)
+	streams    = make(map[int]streamContext)
+
+	// stream keys in logs are replaced to hash. value is count of registration
+	streamKeys = make(map[string]int)
+)
+
+// Setup configure format and levels of standard logger and loggers of components.
+// hostName is node id when node id is not configured
+func Setup(logConfigure configure.LogConfigure, hostName string) error {
+	level := logrus.InfoLevel
+	if len(logConfigure.Level) > 0 {
+		parsed, err := logrus.ParseLevel(logConfigure.Level)
+		if err != nil {
+			return err
+		}
+		level = parsed
+	}
+
+	var formatter logrus.Formatter
+	switch logConfigure.Format {
+	case "", FORMAT_TEXT:
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case FORMAT_JSON:
+		formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		return fmt.Errorf("invalid log format %s", logConfigure.Format)
+	}
+	formatter = &redactFormatter{formatter: formatter}
+
+	id := logConfigure.NodeId
+	if len(id) <= 0 {
+		id = hostName
+	}
+	if len(id) <= 0 {
+		id, _ = os.Hostname()
+	}
+
+	loggers := make(map[string]*logrus.Logger)
+	for component, componentLevel := range logConfigure.Components {
+		parsed, err := logrus.ParseLevel(componentLevel)
+		if err != nil {
+			return fmt.Errorf("invalid log level of %s. %w", component, err)
+		}
+
+		loggers[component] = &logrus.Logger{
+			Out:       os.Stderr,
+			Formatter: formatter,
+			Hooks:     make(logrus.LevelHooks),
+			Level:     parsed,
+			ExitFunc:  os.Exit,
+		}
+	}
+
+	logrus.SetLevel(level)
+	logrus.SetFormatter(formatter)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	nodeId = id
+	components = loggers
+	return nil
+}
+
+// NodeId return id of this node which is added to every log
+func NodeId() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return nodeId
+}
+
+// Component return logger of component. standard logger is used when level of component is not configured
+func Component(component string) *logrus.Entry {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	logger, exist := components[component]
+	if !exist {
+		logger = logrus.StandardLogger()
+	}
+	return logger.WithField(FIELD_COMPONENT, component)
+}
+
+// Stream return logger of component with context of registered stream
+func Stream(component string, streamId int) *logrus.Entry {
+	entry := Component(component).WithField(FIELD_STREAM_ID, streamId)
+
+	mutex.RLock()
+	context, exist := streams[streamId]
+	mutex.RUnlock()
+
+	if !exist {
+		return entry
+	}
+	return entry.WithFields(logrus.Fields{
+		FIELD_STREAM_KEY_HASH: StreamKeyHash(context.streamKey),
+		FIELD_REMOTE_ADDR:     context.remoteAddr,
+	})
+}
+
+// PlaybackStream return logger of component with context of playback stream.
+// name is "{streamId}" or "{streamId}_{rendition}"
+func PlaybackStream(component, name string) *logrus.Entry {
+	id, rendition, _ := strings.Cut(name, "_")
+	streamId, err := strconv.Atoi(id)
+	if err != nil {
+		return Component(component).WithField(FIELD_STREAM_NAME, name)
+	}
+
+	entry := Stream(component, streamId)
+	if len(rendition) > 0 {
+		entry = entry.WithField(FIELD_RENDITION, rendition)
+	}
+	return entry
+}
+
+// RegisterStream keep context of publishing stream until ReleaseStream
+func RegisterStream(streamId int, streamKey, remoteAddr string) {
+	RedactStreamKey(streamKey)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if previous, exist := streams[streamId]; exist {
+		releaseStreamKey(previous.streamKey)
+	}
+	streams[streamId] = streamContext{
+		streamKey:  streamKey,
+		remoteAddr: remoteAddr,
+	}
+}
+
+func ReleaseStream(streamId int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if context, exist := streams[streamId]; exist {
+		releaseStreamKey(context.streamKey)
+		delete(streams, streamId)
+	}
+}
+
+// RedactStreamKey replace stream key in messages and fields to hash until ReleaseStreamKey
+func RedactStreamKey(streamKey string) {
+	if len(streamKey) <= 0 {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	streamKeys[streamKey]++
+}
+
+func ReleaseStreamKey(streamKey string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	releaseStreamKey(streamKey)
+}
+
+func releaseStreamKey(streamKey string) {
+	count, exist := streamKeys[streamKey]
+	if !exist {
+		return
+	}
+
+	if count <= 1 {
+		delete(streamKeys, streamKey)
+		return
+	}
+	streamKeys[streamKey] = count - 1
+}
+
+// StreamKeyHash return short hash which correlate logs of stream without exposing stream key
+func StreamKeyHash(streamKey string) string {
+	sum := sha256.Sum256([]byte(streamKey))
+	return hex.EncodeToString(sum[:])[:STREAM_KEY_HASH_LENGTH]
+}
+
+// redactFormatter add node id and replace stream keys before formatting
+type redactFormatter struct {
+	formatter logrus.Formatter
+}
+
+func (f *redactFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	mutex.RLock()
+	entry.Data[FIELD_NODE_ID] = nodeId
+	if len(streamKeys) > 0 {
+		entry.Message = redact(entry.Message)
+		for key, value := range entry.Data {
+			if str, ok := value.(string); ok {
+				entry.Data[key] = redact(str)
+			}
+		}
+	}
+	mutex.RUnlock()
+
+	return f.formatter.Format(entry)
+}
+
+// redact should be called with lock of stream keys
+func redact(str string) string {
+	for streamKey := range streamKeys {
+		if strings.Contains(str, streamKey) {
+			str = strings.ReplaceAll(str, streamKey, REDACTED_PREFIX+StreamKeyHash(streamKey))
+		}
+	}
+	return str
+}
@@ -24,7 +24,13 @@ SOFTWARE.
 
 package media
 
-import rtmpCodec "github.com/yapingcat/gomedia/go-codec"
+import (
+	"errors"
+
+	rtmpCodec "github.com/yapingcat/gomedia/go-codec"
+)
+
+var ErrNotSupportedCodec = errors.New("not supported codec")
 
 type VideoCodec int
 type AudioCodec int
@@ -83,3 +89,43 @@ func ConvertCodec(codecId rtmpCodec.CodecID) (MediaType, int) {
 
 	return mediaType, codec
 }
+
+// VideoCodecId return codec id of gomedia which is used to write frame of codec
+func VideoCodecId(codec VideoCodec) (rtmpCodec.CodecID, error) {
+	switch codec {
+	case CODEC_VIDEO_H264:
+		return rtmpCodec.CODECID_VIDEO_H264, nil
+	}
+	return rtmpCodec.CODECID_UNRECOGNIZED, ErrNotSupportedCodec
+}
+
+// AudioCodecId return codec id of gomedia which is used to write frame of codec
+func AudioCodecId(codec AudioCodec) (rtmpCodec.CodecID, error) {
+	switch codec {
+	case CODEC_AUDIO_AAC:
+		return rtmpCodec.CODECID_AUDIO_AAC, nil
+	}
+	return rtmpCodec.CODECID_UNRECOGNIZED, ErrNotSupportedCodec
+}
+
+// GetResolution return resolution from SPS in frame.
+func GetResolution(frame *VideoFrame) (width int, height int, exist bool) {
+	defer func() {
+		if recover() != nil {
+			width, height, exist = 0, 0, false
+		}
+	}()
+
+	rtmpCodec.SplitFrameWithStartCode(frame.Data(),
+		func(nalu []byte) bool {
+			if rtmpCodec.H264NaluType(nalu) != rtmpCodec.H264_NAL_SPS {
+				return true
+			}
+
+			w, h := rtmpCodec.GetH264Resolution(nalu)
+			width, height, exist = int(w), int(h), true
+			return false
+		})
+
+	return width, height, exist
+}
@@ -0,0 +1,89 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	ALIGNMENT_STRICT = "strict"
+	ALIGNMENT_WARN   = "warn"
+)
+
+// CheckAlignment check that segments of renditions can start at same source timestamps.
+// error is returned on strict alignment, otherwise problems are logged
+func CheckAlignment(mediaConfigure configure.MediaConfigure) error {
+	problems := alignmentProblems(mediaConfigure.Encoding)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if mediaConfigure.Alignment == ALIGNMENT_STRICT {
+		return errors.Join(problems...)
+	}
+
+	for _, problem := range problems {
+		logging.Component(logging.COMPONENT_FFMPEG).Warn("[FFmpeg][CheckAlignment] ", problem)
+	}
+	return nil
+}
+
+// alignmentProblems require same segment time and GOP which divide frames of segment.
+// forced key frame at segment boundary split GOP otherwise
+func alignmentProblems(encodings []configure.MediaEncodingConfigure) []error {
+	problems := make([]error, 0)
+	for _, encoding := range encodings {
+		name := RenditionName(encoding)
+		if encoding.SegmentTime <= 0 || encoding.Frame <= 0 {
+			problems = append(problems, fmt.Errorf("segment time and frame of %s should be positive", name))
+			continue
+		}
+
+		if encoding.SegmentTime != encodings[0].SegmentTime {
+			problems = append(problems, fmt.Errorf("segment time of %s is %ds but %s is %ds",
+				name, encoding.SegmentTime, RenditionName(encodings[0]), encodings[0].SegmentTime))
+		}
+
+		segmentFrames := encoding.Frame * encoding.SegmentTime
+		if keyint := GopSize(encoding); segmentFrames%keyint != 0 {
+			problems = append(problems, fmt.Errorf("keyint %d of %s does not divide %d frames of segment",
+				keyint, name, segmentFrames))
+		}
+	}
+	return problems
+}
+
+// GopSize return keyint of encoding. GOP is segment when keyint is not set
+func GopSize(encoding configure.MediaEncodingConfigure) int {
+	if encoding.Keyint > 0 {
+		return encoding.Keyint
+	}
+	return max(encoding.Frame*encoding.SegmentTime, 1)
+}
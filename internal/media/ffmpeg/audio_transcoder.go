@@ -0,0 +1,141 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ffmpeg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	AudioTranscodeCommand = "ffmpeg -loglevel error -fflags nobuffer -probesize 4096 -analyzeduration 0 " +
+		"-f ogg -i pipe:0 -vn -c:a aac -ar 48000 -b:a 128k -f adts pipe:1"
+
+	ADTS_HEADER_SIZE = 7
+)
+
+// AudioTranscoder transcode ogg opus to AAC.
+// each ADTS frame of output is passed to onFrame.
+type AudioTranscoder struct {
+	cmd        *exec.Cmd
+	inputPipe  io.WriteCloser
+	outputPipe io.ReadCloser
+	onFrame    func(frame []byte)
+}
+
+func NewAudioTranscoder(onFrame func(frame []byte)) *AudioTranscoder {
+	return &AudioTranscoder{
+		cmd:        nil,
+		inputPipe:  nil,
+		outputPipe: nil,
+		onFrame:    onFrame,
+	}
+}
+
+func (t *AudioTranscoder) Open() error {
+	args := strings.Fields(AudioTranscodeCommand)
+	t.cmd = exec.Command(args[0], args[1:]...)
+
+	var err error
+	t.inputPipe, err = t.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	t.outputPipe, err = t.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *AudioTranscoder) Run() error {
+	if err := t.cmd.Start(); err != nil {
+		return err
+	}
+
+	go t.readOutput()
+	return nil
+}
+
+// Write is used as output of ogg writer
+func (t *AudioTranscoder) Write(buffer []byte) (int, error) {
+	return t.inputPipe.Write(buffer)
+}
+
+func (t *AudioTranscoder) Stop() {
+	if t.inputPipe != nil {
+		t.inputPipe.Close()
+	}
+
+	// process is not exist when Run fail
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+		t.cmd.Wait()
+	}
+}
+
+func (t *AudioTranscoder) readOutput() {
+	reader := bufio.NewReader(t.outputPipe)
+	for {
+		frame, err := readAdtsFrame(reader)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, io.ErrClosedPipe) {
+				logging.Component(logging.COMPONENT_FFMPEG).Warn("[AudioTranscoder][readOutput] read fail. ", err)
+			}
+			return
+		}
+
+		t.onFrame(frame)
+	}
+}
+
+func readAdtsFrame(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, ADTS_HEADER_SIZE)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != 0xFF || header[1]&0xF0 != 0xF0 {
+		return nil, errors.New("invalid adts header")
+	}
+
+	frameLength := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5]>>5)
+	if frameLength < ADTS_HEADER_SIZE {
+		return nil, errors.New("invalid adts frame length")
+	}
+
+	frame := make([]byte, frameLength)
+	copy(frame, header)
+	if _, err := io.ReadFull(reader, frame[ADTS_HEADER_SIZE:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
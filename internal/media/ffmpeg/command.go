@@ -31,8 +31,12 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
 )
 
 const (
@@ -42,13 +46,53 @@ const (
 		"-c:v libx264 -x264opts keyint=120:no-scenecut -s 1280x720 -r 60 -profile:v main -preset veryfast -c:a aac -sws_flags bilinear -f segment -segment_time 2 ./temp/720_60/out_720_60_%03d.ts " +
 		"-c:v libx264 -x264opts keyint=60:no-scenecut -s 1280x720 -r 30  -profile:v main -preset veryfast -c:a aac -sws_flags bilinear -f segment -segment_time 2 ./temp/720_30/out_720_30_%03d.ts " +
 		"-c:v libx264 -x264opts keyint=60:no-scenecut -s 852x480 -r 30  -profile:v main -preset veryfast -c:a aac -sws_flags bilinear  -f segment -segment_time 2 ./temp/480_30/out_480_30_%03d.ts "
+
+	OUTPUT_BUFFER_SIZE = 64 * 1024
+
+	// numbered snapshots of key frames are written to directory of stream
+	THUMBNAIL_DIRECTORY = "thumbnail"
+	THUMBNAIL_PATTERN   = "%06d.jpg"
+
+	// container of encoding select format of segments on disk. fmp4 segments of rendition share init segment.
+	// playlist of ffmpeg is written by hls muxer and is not used
+	CONTAINER_FMP4         = "fmp4"
+	TS_SEGMENT_EXTENSION   = ".ts"
+	FMP4_SEGMENT_EXTENSION = ".m4s"
+	FMP4_INIT_NAME         = "init.mp4"
+	FMP4_PLAYLIST_NAME     = "segments.m3u8"
 )
 
+// RenditionOutput receive mpeg ts of transcoded rendition
+type RenditionOutput func(rendition string, data []byte)
+
 type FFmpegWrapper struct {
 	mediaConfigure configure.MediaConfigure
 	basePath       string
 	cmd            *exec.Cmd
 	inputPipe      io.WriteCloser
+
+	renditionOutput RenditionOutput
+	outputReaders   []*os.File
+	outputWriters   []*os.File
+
+	// thumbnail is not written when interval is 0
+	thumbnailInterval int
+	thumbnailWidth    int
+
+	logger *logrus.Entry
+}
+
+// RenditionName return name of rendition which is also directory name of segments
+func RenditionName(configure configure.MediaEncodingConfigure) string {
+	return configure.Resolution + "_" + strconv.Itoa(configure.Frame)
+}
+
+// SegmentExtension return extension of segment files of rendition
+func SegmentExtension(configure configure.MediaEncodingConfigure) string {
+	if configure.Container == CONTAINER_FMP4 {
+		return FMP4_SEGMENT_EXTENSION
+	}
+	return TS_SEGMENT_EXTENSION
 }
 
 func NewFFmpegWrapper(mediaConfigure configure.MediaConfigure, basePath string) *FFmpegWrapper {
@@ -57,16 +101,34 @@ func NewFFmpegWrapper(mediaConfigure configure.MediaConfigure, basePath string)
 		basePath:       basePath,
 		cmd:            nil,
 		inputPipe:      nil,
+		logger:         logging.Component(logging.COMPONENT_FFMPEG),
 	}
 }
 
+// SetStreamId add context of stream to logs of ffmpeg. it should be called before Open
+func (w *FFmpegWrapper) SetStreamId(streamId int) {
+	w.logger = logging.Stream(logging.COMPONENT_FFMPEG, streamId)
+}
+
+// SetRenditionOutput make ffmpeg write each rendition to pipe in addition to segments.
+// it should be called before Open
+func (w *FFmpegWrapper) SetRenditionOutput(output RenditionOutput) {
+	w.renditionOutput = output
+}
+
+// SetThumbnail make ffmpeg write key frame at most once per interval second as jpeg of width.
+// it should be called before Open
+func (w *FFmpegWrapper) SetThumbnail(interval, width int) {
+	w.thumbnailInterval = interval
+	w.thumbnailWidth = width
+}
+
 func (w *FFmpegWrapper) Open() error {
 	if err := w.createDirByResolution(w.basePath); err != nil {
 		return err
 	}
 
 	args := strings.Fields(w.makeCommand())
-	exec.Command(args[0], args[1:]...)
 	w.cmd = exec.Command(args[0], args[1:]...)
 
 	var err error
@@ -75,17 +137,69 @@ func (w *FFmpegWrapper) Open() error {
 		return err
 	}
 
+	if w.renditionOutput == nil {
+		return nil
+	}
+
+	// output of rendition i is written to fd 3 + i of ffmpeg
+	for range w.mediaConfigure.Encoding {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			w.closeOutputs()
+			return err
+		}
+
+		w.outputReaders = append(w.outputReaders, reader)
+		w.outputWriters = append(w.outputWriters, writer)
+	}
+	w.cmd.ExtraFiles = w.outputWriters
 	return nil
 }
 
 func (w *FFmpegWrapper) Run() error {
 	err := w.cmd.Start()
+
+	// write side is owned by ffmpeg. reader get EOF when ffmpeg exit
+	for _, writer := range w.outputWriters {
+		writer.Close()
+	}
+	w.outputWriters = nil
+
 	if err != nil {
+		w.closeOutputs()
 		return err
 	}
+
+	for i, reader := range w.outputReaders {
+		go w.readOutput(RenditionName(w.mediaConfigure.Encoding[i]), reader)
+	}
 	return nil
 }
 
+func (w *FFmpegWrapper) readOutput(rendition string, reader *os.File) {
+	defer reader.Close()
+
+	buffer := make([]byte, OUTPUT_BUFFER_SIZE)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			w.renditionOutput(rendition, buffer[:n])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *FFmpegWrapper) closeOutputs() {
+	for _, file := range append(w.outputReaders, w.outputWriters...) {
+		file.Close()
+	}
+	w.outputReaders = nil
+	w.outputWriters = nil
+}
+
 func (w *FFmpegWrapper) Input(buffer []byte) error {
 	if _, err := io.WriteString(w.inputPipe, string(buffer)); err != nil {
 		return err
@@ -94,42 +208,99 @@ func (w *FFmpegWrapper) Input(buffer []byte) error {
 }
 
 func (w *FFmpegWrapper) Stop() {
+	if w.cmd == nil || w.cmd.Process == nil {
+		return
+	}
 	w.cmd.Process.Kill()
 }
 
+// Finish close input so that ffmpeg write last segments and exit. ffmpeg is killed after timeout
+func (w *FFmpegWrapper) Finish(timeout time.Duration) {
+	if w.cmd == nil || w.cmd.Process == nil {
+		return
+	}
+
+	w.inputPipe.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.cmd.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		w.logger.Warn("[FFmpegWrapper][Finish] ffmpeg does not exit in ", timeout, ". kill")
+		w.cmd.Process.Kill()
+		<-done
+	}
+}
+
 func (s *FFmpegWrapper) makeCommand() string {
 	command := "ffmpeg -i pipe:0 "
 
-	fmt.Println("[TEST] config : ", s.mediaConfigure)
-
-	for _, configure := range s.mediaConfigure.Encoding {
+	for i, configure := range s.mediaConfigure.Encoding {
+		// key frames are forced at segment boundaries of source timestamp so that segments of renditions are aligned
 		videoSubCommand := fmt.Sprintf(
-			"-c:v libx264 -x264opts keyint=%d:no-scenecut -s %s -r %d -profile:v main -preset veryfast ",
-			configure.Keyint, configure.Resolution, configure.Frame)
+			"-c:v libx264 -x264opts keyint=%d:no-scenecut -force_key_frames expr:gte(t,n_forced*%d) -s %s -r %d -profile:v main -preset veryfast ",
+			GopSize(configure), configure.SegmentTime, configure.Resolution, configure.Frame)
 
 		audoSubCommand := fmt.Sprintf("-c:a aac -sws_flags bilinear ")
 
-		fileName := "%06d.ts"
-		path := s.basePath + "/" + configure.Resolution + "_" + strconv.Itoa(configure.Frame) + "/" + fileName
-		segmentSubCommand := fmt.Sprintf("-f segment -segment_time %d %s ", configure.SegmentTime, path)
+		// keep ID3 timed metadata stream if exist
+		mapSubCommand := "-map 0:v -map 0:a? -map 0:d? -c:d copy "
+
+		directory := s.basePath + "/" + RenditionName(configure)
+		// segment is cut at forced key frame within half frame of boundary
+		timeDelta := 0.5 / float64(max(configure.Frame, 1))
+		segmentSubCommand := fmt.Sprintf("-f segment -segment_time %d -segment_time_delta %.4f %s/%%06d%s ",
+			configure.SegmentTime, timeDelta, directory, TS_SEGMENT_EXTENSION)
+		teeOutput := fmt.Sprintf("[f=segment:segment_time=%d:segment_time_delta=%.4f]%s/%%06d%s",
+			configure.SegmentTime, timeDelta, directory, TS_SEGMENT_EXTENSION)
+		if configure.Container == CONTAINER_FMP4 {
+			// hls muxer write init segment once and numbered fragments of segment time
+			segmentSubCommand = fmt.Sprintf(
+				"-f hls -hls_time %d -hls_segment_type fmp4 -hls_fmp4_init_filename %s -hls_list_size 0 -start_number 0 -hls_segment_filename %s/%%06d%s %s/%s ",
+				configure.SegmentTime, FMP4_INIT_NAME, directory, FMP4_SEGMENT_EXTENSION, directory, FMP4_PLAYLIST_NAME)
+			teeOutput = fmt.Sprintf(
+				"[f=hls:hls_time=%d:hls_segment_type=fmp4:hls_fmp4_init_filename=%s:hls_list_size=0:start_number=0:hls_segment_filename=%s/%%06d%s]%s/%s",
+				configure.SegmentTime, FMP4_INIT_NAME, directory, FMP4_SEGMENT_EXTENSION, directory, FMP4_PLAYLIST_NAME)
+		}
+
+		if s.renditionOutput != nil {
+			// same encoded rendition is written to segments and pipe of playback which is always mpeg ts
+			segmentSubCommand = fmt.Sprintf("-f tee %s|[f=mpegts]pipe:%d ", teeOutput, 3+i)
+		}
 
-		command += videoSubCommand + audoSubCommand + segmentSubCommand
+		command += mapSubCommand + videoSubCommand + audoSubCommand + segmentSubCommand
 	}
 
-	fmt.Println("[TEST] command : ", command)
+	if s.thumbnailInterval > 0 {
+		// first key frame after interval from previous thumbnail
+		path := s.basePath + "/" + THUMBNAIL_DIRECTORY + "/" + THUMBNAIL_PATTERN
+		command += fmt.Sprintf(
+			"-map 0:v -vf select=eq(pict_type\\,I)*(isnan(prev_selected_t)+gte(t-prev_selected_t\\,%d)),scale=%d:-2 -vsync vfr -q:v 5 -f image2 %s ",
+			s.thumbnailInterval, s.thumbnailWidth, path)
+	}
 
+	s.logger.Debug("[FFmpegWrapper][makeCommand] ", command)
 	return command
 }
 
 func (s *FFmpegWrapper) createDirByResolution(basePath string) error {
 	for _, configure := range s.mediaConfigure.Encoding {
-		path := s.basePath + "/" + configure.Resolution + "_" + strconv.Itoa(configure.Frame)
-		fmt.Println("[TEST] path : ", path)
+		path := s.basePath + "/" + RenditionName(configure)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			if err := os.Mkdir(path, 0755); err != nil {
 				return err
 			}
 		}
 	}
+
+	if s.thumbnailInterval > 0 {
+		if err := os.MkdirAll(s.basePath+"/"+THUMBNAIL_DIRECTORY, 0755); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,225 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// makeSourceFrames encode test pattern by ffmpeg and return frames of it
+func makeSourceFrames(t *testing.T) []*media.VideoFrame {
+	t.Helper()
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not found")
+	}
+
+	source, err := exec.Command("ffmpeg", "-loglevel", "error",
+		"-f", "lavfi", "-i", "testsrc=duration=4:size=320x240:rate=30",
+		"-c:v", "libx264", "-g", "30", "-bf", "0", "-f", "mpegts", "pipe:1").Output()
+	if err != nil {
+		t.Skip("ffmpeg can not encode h264. ", err)
+	}
+
+	frames := make([]*media.VideoFrame, 0)
+	demuxer := media.NewTsDemuxer()
+	demuxer.OnVideoFrame = func(frame *media.VideoFrame) {
+		frames = append(frames, frame)
+	}
+
+	if err := demuxer.Input(source); err != nil {
+		t.Fatal("demuxing of source fail. ", err)
+	}
+	demuxer.Flush()
+
+	if len(frames) == 0 {
+		t.Fatal("source has no frame")
+	}
+	return frames
+}
+
+// id3Payloads return payloads of PES which are in ID3 timed metadata stream of segment
+func id3Payloads(segment []byte) [][]byte {
+	pmtPids := make(map[uint16]bool)
+	metadataPids := make(map[uint16]bool)
+	payloads := make([][]byte, 0)
+
+	for i := 0; i+188 <= len(segment); i += 188 {
+		packet := segment[i : i+188]
+		if packet[0] != 0x47 {
+			continue
+		}
+
+		pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1FFF
+		payloadUnitStart := packet[1]&0x40 != 0
+		payload := packet[4:]
+		if control := packet[3] >> 4 & 0x3; control == 0x2 || control == 0x3 {
+			payload = payload[1+int(payload[0]):]
+		}
+
+		switch {
+		case pid == 0 && payloadUnitStart:
+			section := payload[1+int(payload[0]):]
+			length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+			for body := section[8 : 3+length-4]; len(body) >= 4; body = body[4:] {
+				if binary.BigEndian.Uint16(body[0:2]) != 0 {
+					pmtPids[binary.BigEndian.Uint16(body[2:4])&0x1FFF] = true
+				}
+			}
+		case pmtPids[pid] && payloadUnitStart:
+			section := payload[1+int(payload[0]):]
+			length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+			programInfoLength := int(binary.BigEndian.Uint16(section[10:12]) & 0x0FFF)
+			for body := section[12+programInfoLength : 3+length-4]; len(body) >= 5; {
+				infoLength := int(binary.BigEndian.Uint16(body[3:5]) & 0x0FFF)
+				if body[0] == byte(media.TS_STREAM_METADATA) {
+					metadataPids[binary.BigEndian.Uint16(body[1:3])&0x1FFF] = true
+				}
+				body = body[5+infoLength:]
+			}
+		case metadataPids[pid]:
+			if payloadUnitStart || len(payloads) == 0 {
+				payloads = append(payloads, make([]byte, 0))
+			}
+			payloads[len(payloads)-1] = append(payloads[len(payloads)-1], payload...)
+		}
+	}
+	return payloads
+}
+
+func TestSegmentKeepID3Metadata(t *testing.T) {
+	frames := makeSourceFrames(t)
+
+	encoding := configure.MediaEncodingConfigure{Resolution: "320x240", Frame: 30, SegmentTime: 2}
+	basePath := t.TempDir()
+	wrapper := NewFFmpegWrapper(configure.MediaConfigure{Encoding: []configure.MediaEncodingConfigure{encoding}}, basePath)
+	if err := wrapper.Open(); err != nil {
+		t.Fatal("open fail. ", err)
+	}
+
+	if err := wrapper.Run(); err != nil {
+		t.Fatal("run fail. ", err)
+	}
+
+	id3Tag := media.MakeID3Tag(map[string]string{"title": "segment test"})
+	muxer := media.NewTSMuxer()
+	for i, frame := range frames {
+		if i%30 == 0 {
+			buffer, err := muxer.MuxingMetadata(id3Tag, frame.Timestamp())
+			if err != nil {
+				t.Fatal("metadata muxing fail. ", err)
+			}
+			wrapper.Input(buffer)
+		}
+
+		buffer, err := muxer.MuxingVideo(frame)
+		if err != nil {
+			t.Fatal("video muxing fail. ", err)
+		}
+		wrapper.Input(buffer)
+	}
+	wrapper.Finish(30 * time.Second)
+
+	segments, _ := filepath.Glob(filepath.Join(basePath, RenditionName(encoding), "*.ts"))
+	if len(segments) == 0 {
+		t.Fatal("segment is not written")
+	}
+
+	for _, path := range segments {
+		segment, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pes := range id3Payloads(segment) {
+			if bytes.Contains(pes, id3Tag) {
+				return
+			}
+		}
+	}
+	t.Fatal("ID3 PES is not found in segments ", segments)
+}
+
+func TestMakeCommandContainer(t *testing.T) {
+	encodings := []configure.MediaEncodingConfigure{
+		{Resolution: "1280x720", Frame: 30, SegmentTime: 2},
+		{Resolution: "852x480", Frame: 30, SegmentTime: 2, Container: CONTAINER_FMP4},
+	}
+
+	testCases := []struct {
+		name     string
+		output   RenditionOutput
+		expected []string
+	}{
+		{
+			name: "segments",
+			expected: []string{
+				"-f segment -segment_time 2 -segment_time_delta 0.0167 /base/1280x720_30/%06d.ts ",
+				"-f hls -hls_time 2 -hls_segment_type fmp4 -hls_fmp4_init_filename init.mp4 -hls_list_size 0 -start_number 0 " +
+					"-hls_segment_filename /base/852x480_30/%06d.m4s /base/852x480_30/segments.m3u8 ",
+			},
+		},
+		{
+			name:   "segments and pipe",
+			output: func(string, []byte) {},
+			expected: []string{
+				"-f tee [f=segment:segment_time=2:segment_time_delta=0.0167]/base/1280x720_30/%06d.ts|[f=mpegts]pipe:3 ",
+				"-f tee [f=hls:hls_time=2:hls_segment_type=fmp4:hls_fmp4_init_filename=init.mp4:hls_list_size=0:start_number=0:" +
+					"hls_segment_filename=/base/852x480_30/%06d.m4s]/base/852x480_30/segments.m3u8|[f=mpegts]pipe:4 ",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			wrapper := NewFFmpegWrapper(configure.MediaConfigure{Encoding: encodings}, "/base")
+			wrapper.SetRenditionOutput(testCase.output)
+
+			command := wrapper.makeCommand()
+			for _, expected := range testCase.expected {
+				if !strings.Contains(command, expected) {
+					t.Fatalf("%q is not in command %q", expected, command)
+				}
+			}
+		})
+	}
+
+	if extension := SegmentExtension(encodings[0]); extension != TS_SEGMENT_EXTENSION {
+		t.Fatal("invalid extension of ts. ", extension)
+	}
+	if extension := SegmentExtension(encodings[1]); extension != FMP4_SEGMENT_EXTENSION {
+		t.Fatal("invalid extension of fmp4. ", extension)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// MakeSprites decode key frames of input and write tiles of interval second to numbered sprite sheets of output pattern.
+// width of tile is width and height keep aspect ratio
+func MakeSprites(ctx context.Context, input, outputPattern string, interval, width, columns, rows int) error {
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:-2,tile=%dx%d", interval, width, columns, rows)
+	args := []string{
+		"-y", "-v", "error",
+		"-skip_frame", "nokey",
+		"-i", input,
+		"-an", "-vf", filter,
+		"-q:v", "5",
+		"-f", "image2", outputPattern,
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return errors.New(err.Error() + ". " + stderr.String())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	rtmpCodec "github.com/yapingcat/gomedia/go-codec"
+)
+
+const (
+	FMP4_VIDEO_TRACK_ID  = 1
+	FMP4_AUDIO_TRACK_ID  = 2
+	FMP4_MOVIE_TIMESCALE = 1000
+	FMP4_VIDEO_TIMESCALE = 90000
+
+	AAC_FRAME_SAMPLES = 1024
+
+	// sample_depends_on and sample_is_non_sync_sample of trun
+	SAMPLE_FLAGS_SYNC     = 0x02000000
+	SAMPLE_FLAGS_NON_SYNC = 0x01010000
+
+	TRUN_DATA_OFFSET       = 0x000001
+	TRUN_SAMPLE_DURATION   = 0x000100
+	TRUN_SAMPLE_SIZE       = 0x000200
+	TRUN_SAMPLE_FLAGS      = 0x000400
+	TRUN_SAMPLE_CTS_OFFSET = 0x000800
+	TFHD_DEFAULT_BASE_MOOF = 0x020000
+)
+
+var ErrCodecNotReady = errors.New("codec configuration is not received")
+
+type fmp4Sample struct {
+	data      []byte
+	dts       uint64
+	ctsOffset int32
+	sync      bool
+}
+
+type fmp4Track struct {
+	id           uint32
+	timescale    uint32
+	samples      []fmp4Sample
+	lastDuration uint32
+}
+
+func (t *fmp4Track) ticks(milliseconds uint64) uint64 {
+	return milliseconds * uint64(t.timescale) / 1000
+}
+
+// Fmp4Muxer make CMAF init segment and fragments(moof, mdat) of H.264 and AAC frames.
+// tracks of init segment are fixed at first fragment, frames of track which appear later are dropped.
+// timestamps of frames are millisecond
+type Fmp4Muxer struct {
+	video *fmp4Track
+	audio *fmp4Track
+
+	// sequence parameter sets with start code
+	spss   [][]byte
+	ppss   [][]byte
+	width  uint32
+	height uint32
+
+	audioConfig *rtmpCodec.AudioSpecificConfiguration
+
+	initialized bool
+	sequence    uint32
+
+	// added to timestamps of frames. millisecond
+	baseTimestamp uint64
+}
+
+func NewFmp4Muxer() *Fmp4Muxer {
+	return &Fmp4Muxer{
+		video:       nil,
+		audio:       nil,
+		initialized: false,
+		sequence:    0,
+	}
+}
+
+// SetBaseTimestamp shift timestamps of fragments so that muxers of streams which start later are aligned
+func (m *Fmp4Muxer) SetBaseTimestamp(milliseconds uint64) {
+	m.baseTimestamp = milliseconds
+}
+
+func (m *Fmp4Muxer) WriteVideo(frame *VideoFrame) error {
+	if frame.codec != CODEC_VIDEO_H264 {
+		return errors.New("invalid frame")
+	}
+
+	sync := false
+	sample := make([]byte, 0, len(frame.Data())+16)
+	rtmpCodec.SplitFrameWithStartCode(frame.Data(),
+		func(nalu []byte) bool {
+			payload := trimStartCode(nalu)
+			if len(payload) == 0 {
+				return true
+			}
+
+			switch rtmpCodec.H264NaluType(nalu) {
+			case rtmpCodec.H264_NAL_SPS:
+				m.setParameterSet(&m.spss, nalu)
+			case rtmpCodec.H264_NAL_PPS:
+				m.setParameterSet(&m.ppss, nalu)
+			case rtmpCodec.H264_NAL_I_SLICE:
+				sync = true
+			}
+
+			sample = append(sample, byte(len(payload)>>24), byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)))
+			sample = append(sample, payload...)
+			return true
+		})
+
+	if m.video == nil {
+		if m.initialized || len(m.spss) == 0 || len(m.ppss) == 0 {
+			return nil
+		}
+
+		m.width, m.height = rtmpCodec.GetH264Resolution(m.spss[0])
+		m.video = &fmp4Track{id: FMP4_VIDEO_TRACK_ID, timescale: FMP4_VIDEO_TIMESCALE}
+	}
+
+	ctsOffset := int64(frame.timestamp.Pts) - int64(frame.timestamp.Dts)
+	m.video.samples = append(m.video.samples, fmp4Sample{
+		data:      sample,
+		dts:       m.video.ticks(frame.timestamp.Dts + m.baseTimestamp),
+		ctsOffset: int32(ctsOffset * FMP4_VIDEO_TIMESCALE / 1000),
+		sync:      sync,
+	})
+	return nil
+}
+
+// setParameterSet keep parameter sets until init segment is made
+func (m *Fmp4Muxer) setParameterSet(sets *[][]byte, nalu []byte) {
+	if m.initialized {
+		return
+	}
+	*sets = [][]byte{append([]byte(nil), nalu...)}
+}
+
+// WriteAudio strip ADTS header of AAC frame
+func (m *Fmp4Muxer) WriteAudio(frame *AudioFrame) error {
+	data := frame.Data()
+	if frame.codec != CODEC_AUDIO_AAC || len(data) < 7 {
+		return errors.New("invalid frame")
+	}
+
+	headerSize := 7
+	if data[1]&0x01 == 0 {
+		headerSize = 9
+	}
+
+	if len(data) <= headerSize {
+		return errors.New("invalid frame")
+	}
+
+	if m.audio == nil {
+		if m.initialized {
+			return nil
+		}
+
+		config, err := rtmpCodec.ConvertADTSToASC(data)
+		if err != nil {
+			return err
+		}
+
+		if int(config.Sample_freq_index) >= len(rtmpCodec.AAC_Sampling_Idx) {
+			return errors.New("invalid sampling frequency index")
+		}
+
+		m.audioConfig = config
+		m.audio = &fmp4Track{
+			id:        FMP4_AUDIO_TRACK_ID,
+			timescale: uint32(rtmpCodec.AACSampleIdxToSample(int(config.Sample_freq_index))),
+		}
+	}
+
+	m.audio.samples = append(m.audio.samples, fmp4Sample{
+		data: append([]byte(nil), data[headerSize:]...),
+		dts:  m.audio.ticks(frame.timestamp.Dts + m.baseTimestamp),
+		sync: true,
+	})
+	return nil
+}
+
+func (m *Fmp4Muxer) tracks() []*fmp4Track {
+	tracks := make([]*fmp4Track, 0, 2)
+	if m.video != nil {
+		tracks = append(tracks, m.video)
+	}
+
+	if m.audio != nil {
+		tracks = append(tracks, m.audio)
+	}
+	return tracks
+}
+
+// InitSegment return ftyp and moov. tracks are fixed when it is called first
+func (m *Fmp4Muxer) InitSegment() ([]byte, error) {
+	if m.video == nil && m.audio == nil {
+		return nil, ErrCodecNotReady
+	}
+	m.initialized = true
+
+	w := &mp4Writer{}
+	w.u32(0).u32(0).u32(FMP4_MOVIE_TIMESCALE).u32(0).
+		u32(0x00010000).u16(0x0100).zeros(10).matrix().zeros(24).
+		u32(FMP4_AUDIO_TRACK_ID + 1)
+
+	payloads := [][]byte{mp4FullBox("mvhd", 0, 0, w.buffer)}
+	trexs := [][]byte{}
+	for _, track := range m.tracks() {
+		trak, err := m.trak(track)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, trak)
+
+		trex := &mp4Writer{}
+		trex.u32(track.id).u32(1).u32(0).u32(0).u32(0)
+		trexs = append(trexs, mp4FullBox("trex", 0, 0, trex.buffer))
+	}
+	payloads = append(payloads, mp4Box("mvex", trexs...))
+
+	ftyp := &mp4Writer{}
+	ftyp.bytes([]byte("iso6")).u32(0).bytes([]byte("iso6cmfcmp41"))
+
+	init := mp4Box("ftyp", ftyp.buffer)
+	return append(init, mp4Box("moov", payloads...)...), nil
+}
+
+// Codecs return RFC 6381 codecs of tracks. it is valid after init segment is made
+func (m *Fmp4Muxer) Codecs() string {
+	codecs := make([]string, 0, 2)
+	if m.video != nil && len(m.spss) > 0 {
+		if sps := trimStartCode(m.spss[0]); len(sps) >= 4 {
+			codecs = append(codecs, fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3]))
+		}
+	}
+
+	if m.audio != nil {
+		codecs = append(codecs, fmt.Sprintf("mp4a.40.%d", m.audioConfig.Audio_object_type))
+	}
+	return strings.Join(codecs, ",")
+}
+
+func (m *Fmp4Muxer) Resolution() (uint32, uint32) {
+	return m.width, m.height
+}
+
+// SampleRate and Channels of audio track are 0 when there is no audio track
+func (m *Fmp4Muxer) SampleRate() uint32 {
+	if m.audio == nil {
+		return 0
+	}
+	return m.audio.timescale
+}
+
+func (m *Fmp4Muxer) Channels() int {
+	if m.audio == nil {
+		return 0
+	}
+	return int(m.audioConfig.Channel_configuration)
+}
+
+func (m *Fmp4Muxer) trak(track *fmp4Track) ([]byte, error) {
+	isVideo := track == m.video
+
+	tkhd := &mp4Writer{}
+	tkhd.u32(0).u32(0).u32(track.id).u32(0).u32(0).zeros(8).u16(0).u16(0)
+	if isVideo {
+		tkhd.u16(0)
+	} else {
+		tkhd.u16(0x0100)
+	}
+	tkhd.u16(0).matrix()
+	if isVideo {
+		tkhd.u32(m.width << 16).u32(m.height << 16)
+	} else {
+		tkhd.u32(0).u32(0)
+	}
+
+	mdhd := &mp4Writer{}
+	mdhd.u32(0).u32(0).u32(track.timescale).u32(0).u16(0x55c4).u16(0)
+
+	hdlr := &mp4Writer{}
+	var mediaHeader []byte
+	var sampleEntry []byte
+	if isVideo {
+		hdlr.u32(0).bytes([]byte("vide")).zeros(12).bytes([]byte("VideoHandler\x00"))
+		mediaHeader = mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+
+		entry, err := m.avc1()
+		if err != nil {
+			return nil, err
+		}
+		sampleEntry = entry
+	} else {
+		hdlr.u32(0).bytes([]byte("soun")).zeros(12).bytes([]byte("SoundHandler\x00"))
+		mediaHeader = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+		sampleEntry = m.mp4a()
+	}
+
+	dref := mp4FullBox("dref", 0, 0, []byte{0, 0, 0, 1}, mp4FullBox("url ", 0, 1))
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, sampleEntry),
+		mp4FullBox("stts", 0, 0, make([]byte, 4)),
+		mp4FullBox("stsc", 0, 0, make([]byte, 4)),
+		mp4FullBox("stsz", 0, 0, make([]byte, 8)),
+		mp4FullBox("stco", 0, 0, make([]byte, 4)))
+
+	minf := mp4Box("minf", mediaHeader, mp4Box("dinf", dref), stbl)
+	mdia := mp4Box("mdia", mp4FullBox("mdhd", 0, 0, mdhd.buffer), mp4FullBox("hdlr", 0, 0, hdlr.buffer), minf)
+	return mp4Box("trak", mp4FullBox("tkhd", 0, 3, tkhd.buffer), mdia), nil
+}
+
+func (m *Fmp4Muxer) avc1() ([]byte, error) {
+	// extradata function trim start code of elements
+	spss := append([][]byte(nil), m.spss...)
+	ppss := append([][]byte(nil), m.ppss...)
+	avcc, err := rtmpCodec.CreateH264AVCCExtradata(spss, ppss)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &mp4Writer{}
+	w.zeros(6).u16(1).u16(0).u16(0).zeros(12).
+		u16(uint16(m.width)).u16(uint16(m.height)).
+		u32(0x00480000).u32(0x00480000).u32(0).u16(1).zeros(32).u16(0x0018).u16(0xFFFF)
+	return mp4Box("avc1", w.buffer, mp4Box("avcC", avcc)), nil
+}
+
+func (m *Fmp4Muxer) mp4a() []byte {
+	channels := uint16(m.audioConfig.Channel_configuration)
+
+	w := &mp4Writer{}
+	w.zeros(6).u16(1).zeros(8).u16(channels).u16(16).u16(0).u16(0).u32(m.audio.timescale << 16)
+
+	decoderConfig := &mp4Writer{}
+	decoderConfig.u8(0x40).u8(0x15).zeros(3).u32(0).u32(0)
+
+	esDescriptor := mp4Descriptor(0x03,
+		[]byte{0, 0, 0},
+		mp4Descriptor(0x04, decoderConfig.buffer, mp4Descriptor(0x05, m.audioConfig.Encode())),
+		mp4Descriptor(0x06, []byte{0x02}))
+	return mp4Box("mp4a", w.buffer, mp4FullBox("esds", 0, 0, esDescriptor))
+}
+
+// Fragment return moof and mdat of written frames. endDts is decode time of next frame.
+// nil is returned when there is no frame
+func (m *Fmp4Muxer) Fragment(endDts uint64) ([]byte, error) {
+	if !m.initialized {
+		if _, err := m.InitSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	tracks := make([]*fmp4Track, 0, 2)
+	for _, track := range m.tracks() {
+		if len(track.samples) > 0 {
+			tracks = append(tracks, track)
+		}
+	}
+
+	if len(tracks) == 0 {
+		return nil, nil
+	}
+
+	endDts += m.baseTimestamp
+	m.sequence++
+	mfhd := mp4FullBox("mfhd", 0, 0, []byte{byte(m.sequence >> 24), byte(m.sequence >> 16), byte(m.sequence >> 8), byte(m.sequence)})
+
+	// size of moof does not depend on data offset
+	moofSize := len(m.moof(mfhd, tracks, endDts, 0))
+	moof := m.moof(mfhd, tracks, endDts, moofSize+8)
+
+	mdatSize := 8
+	for _, track := range tracks {
+		for _, sample := range track.samples {
+			mdatSize += len(sample.data)
+		}
+	}
+
+	fragment := make([]byte, 0, len(moof)+mdatSize)
+	fragment = append(fragment, moof...)
+	fragment = append(fragment, byte(mdatSize>>24), byte(mdatSize>>16), byte(mdatSize>>8), byte(mdatSize))
+	fragment = append(fragment, "mdat"...)
+	for _, track := range tracks {
+		for _, sample := range track.samples {
+			fragment = append(fragment, sample.data...)
+		}
+		track.samples = track.samples[:0]
+	}
+	return fragment, nil
+}
+
+func (m *Fmp4Muxer) moof(mfhd []byte, tracks []*fmp4Track, endDts uint64, dataOffset int) []byte {
+	payloads := [][]byte{mfhd}
+	for _, track := range tracks {
+		payloads = append(payloads, m.traf(track, endDts, dataOffset))
+		for _, sample := range track.samples {
+			dataOffset += len(sample.data)
+		}
+	}
+	return mp4Box("moof", payloads...)
+}
+
+func (m *Fmp4Muxer) traf(track *fmp4Track, endDts uint64, dataOffset int) []byte {
+	tfhd := &mp4Writer{}
+	tfhd.u32(track.id)
+
+	tfdt := &mp4Writer{}
+	tfdt.u64(track.samples[0].dts)
+
+	flags := uint32(TRUN_DATA_OFFSET | TRUN_SAMPLE_DURATION | TRUN_SAMPLE_SIZE | TRUN_SAMPLE_FLAGS)
+	isVideo := track == m.video
+	if isVideo {
+		flags |= TRUN_SAMPLE_CTS_OFFSET
+	}
+
+	trun := &mp4Writer{}
+	trun.u32(uint32(len(track.samples))).u32(uint32(dataOffset))
+	for i, sample := range track.samples {
+		trun.u32(m.sampleDuration(track, i, endDts)).u32(uint32(len(sample.data)))
+		if sample.sync {
+			trun.u32(SAMPLE_FLAGS_SYNC)
+		} else {
+			trun.u32(SAMPLE_FLAGS_NON_SYNC)
+		}
+
+		if isVideo {
+			trun.u32(uint32(sample.ctsOffset))
+		}
+	}
+
+	return mp4Box("traf",
+		mp4FullBox("tfhd", 0, TFHD_DEFAULT_BASE_MOOF, tfhd.buffer),
+		mp4FullBox("tfdt", 1, 0, tfdt.buffer),
+		mp4FullBox("trun", 1, flags, trun.buffer))
+}
+
+// sampleDuration is difference to next sample. last video sample end at endDts,
+// last audio sample has duration of AAC frame
+func (m *Fmp4Muxer) sampleDuration(track *fmp4Track, index int, endDts uint64) uint32 {
+	current := track.samples[index].dts
+
+	var next uint64
+	switch {
+	case index+1 < len(track.samples):
+		next = track.samples[index+1].dts
+	case track == m.audio:
+		next = current + AAC_FRAME_SAMPLES
+	default:
+		next = track.ticks(endDts)
+		if next <= current {
+			next = current + uint64(track.lastDuration)
+		}
+	}
+
+	duration := uint32(0)
+	if next > current {
+		duration = uint32(next - current)
+	}
+
+	track.lastDuration = duration
+	return duration
+}
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files of testdata")
+
+// P slice of 16x16 H264 which follow testH264Frame
+var testH264PFrame = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x02, 0x04, 0x08}
+
+// testAdtsFrame is AAC LC frame of 44100Hz stereo
+var testAdtsFrame = []byte{0xff, 0xf1, 0x50, 0x80, 0x02, 0x1f, 0xfc, 0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80}
+
+// compareGolden compare data with file of testdata. file is written with -update
+func compareGolden(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, golden) {
+		t.Fatalf("%s is different from golden file. size %d, golden %d", name, len(data), len(golden))
+	}
+}
+
+func TestFmp4MuxerGolden(t *testing.T) {
+	muxer := NewFmp4Muxer()
+	muxer.SetBaseTimestamp(1000)
+
+	if _, err := muxer.InitSegment(); err == nil {
+		t.Fatal("init segment is made without codec config")
+	}
+
+	fragments := [][]byte{}
+	for i := 0; i < 2; i++ {
+		base := uint64(i) * 66
+		frames := []Frame{
+			NewVideoFrame(CODEC_VIDEO_H264, Timestamp{Pts: base + 33, Dts: base}, testH264Frame, true),
+			NewAudioFrame(CODEC_AUDIO_AAC, Timestamp{Pts: base, Dts: base}, testAdtsFrame),
+			NewAudioFrame(CODEC_AUDIO_AAC, Timestamp{Pts: base + 23, Dts: base + 23}, testAdtsFrame),
+			NewVideoFrame(CODEC_VIDEO_H264, Timestamp{Pts: base + 66, Dts: base + 33}, testH264PFrame, false),
+			NewAudioFrame(CODEC_AUDIO_AAC, Timestamp{Pts: base + 46, Dts: base + 46}, testAdtsFrame),
+		}
+
+		for _, frame := range frames {
+			var err error
+			switch frame := frame.(type) {
+			case *VideoFrame:
+				err = muxer.WriteVideo(frame)
+			case *AudioFrame:
+				err = muxer.WriteAudio(frame)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fragment, err := muxer.Fragment(base + 66)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fragments = append(fragments, fragment)
+	}
+
+	init, err := muxer.InitSegment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareGolden(t, "fmp4_init.mp4", init)
+	compareGolden(t, "fmp4_fragment_1.m4s", fragments[0])
+	compareGolden(t, "fmp4_fragment_2.m4s", fragments[1])
+
+	if codecs := muxer.Codecs(); codecs != "avc1.42c00a,mp4a.40.2" {
+		t.Fatal("invalid codecs. ", codecs)
+	}
+
+	if fragment, err := muxer.Fragment(200); fragment != nil || err != nil {
+		t.Fatal("fragment is made without frames. ", err)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"encoding/binary"
+)
+
+const (
+	TFHD_BASE_DATA_OFFSET         = 0x000001
+	TFHD_SAMPLE_DESCRIPTION_INDEX = 0x000002
+	TFHD_DEFAULT_SAMPLE_DURATION  = 0x000008
+	TRUN_FIRST_SAMPLE_FLAGS       = 0x000004
+)
+
+// Fmp4TrackInfo is track of init segment which is needed to read timestamps of fragments
+type Fmp4TrackInfo struct {
+	Id              uint32
+	Timescale       uint32
+	Video           bool
+	DefaultDuration uint32
+}
+
+// walkMp4Boxes call handler with type and payload of each box of data. box of size 0 extend to end of data
+func walkMp4Boxes(data []byte, handler func(boxType string, payload []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return ErrInvalidMp4
+		}
+
+		size, headerSize := uint64(binary.BigEndian.Uint32(data[:4])), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return ErrInvalidMp4
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+
+		if size < headerSize || size > uint64(len(data)) {
+			return ErrInvalidMp4
+		}
+
+		if err := handler(string(data[4:8]), data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// fullBoxField return offset of field after version and flags. field of version 1 is 64 bit when wide is set
+func fullBoxField(payload []byte, index int, wide bool) (int, bool) {
+	if len(payload) < 4 {
+		return 0, false
+	}
+
+	offset := 4
+	size := 4
+	if wide && payload[0] == 1 {
+		size = 8
+	}
+	offset += index * size
+	return offset, len(payload) >= offset+4
+}
+
+// ReadFmp4Init return tracks of moov of init segment
+func ReadFmp4Init(data []byte) ([]Fmp4TrackInfo, error) {
+	tracks := make([]Fmp4TrackInfo, 0, 2)
+	defaults := make(map[uint32]uint32)
+
+	err := walkMp4Boxes(data, func(boxType string, payload []byte) error {
+		if boxType != "moov" {
+			return nil
+		}
+
+		return walkMp4Boxes(payload, func(boxType string, payload []byte) error {
+			switch boxType {
+			case "trak":
+				track, err := readFmp4Track(payload)
+				if err != nil {
+					return err
+				}
+				tracks = append(tracks, track)
+			case "mvex":
+				return walkMp4Boxes(payload, func(boxType string, payload []byte) error {
+					// track_ID, default_sample_description_index, default_sample_duration
+					if boxType == "trex" && len(payload) >= 16 {
+						defaults[binary.BigEndian.Uint32(payload[4:8])] = binary.BigEndian.Uint32(payload[12:16])
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tracks) == 0 {
+		return nil, ErrInvalidMp4
+	}
+
+	for i := range tracks {
+		tracks[i].DefaultDuration = defaults[tracks[i].Id]
+	}
+	return tracks, nil
+}
+
+func readFmp4Track(trak []byte) (Fmp4TrackInfo, error) {
+	track := Fmp4TrackInfo{}
+	err := walkMp4Boxes(trak, func(boxType string, payload []byte) error {
+		switch boxType {
+		case "tkhd":
+			// creation_time, modification_time, track_ID
+			offset, valid := fullBoxField(payload, 2, true)
+			if !valid {
+				return ErrInvalidMp4
+			}
+			track.Id = binary.BigEndian.Uint32(payload[offset:])
+		case "mdia":
+			return walkMp4Boxes(payload, func(boxType string, payload []byte) error {
+				switch boxType {
+				case "mdhd":
+					// creation_time, modification_time, timescale
+					offset, valid := fullBoxField(payload, 2, true)
+					if !valid {
+						return ErrInvalidMp4
+					}
+					track.Timescale = binary.BigEndian.Uint32(payload[offset:])
+				case "hdlr":
+					if len(payload) < 12 {
+						return ErrInvalidMp4
+					}
+					track.Video = string(payload[8:12]) == "vide"
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if err == nil && track.Timescale == 0 {
+		err = ErrInvalidMp4
+	}
+	return track, err
+}
+
+// ReadFmp4Duration return decode time of first sample and end of last sample in fragments of data as millisecond.
+// video track is measured if exist
+func ReadFmp4Duration(tracks []Fmp4TrackInfo, data []byte) (uint64, uint64, error) {
+	if len(tracks) == 0 {
+		return 0, 0, ErrInvalidMp4
+	}
+
+	track := tracks[0]
+	for _, candidate := range tracks {
+		if candidate.Video {
+			track = candidate
+			break
+		}
+	}
+
+	start, end, found := uint64(0), uint64(0), false
+	err := walkMp4Boxes(data, func(boxType string, payload []byte) error {
+		if boxType != "moof" {
+			return nil
+		}
+
+		return walkMp4Boxes(payload, func(boxType string, payload []byte) error {
+			if boxType != "traf" {
+				return nil
+			}
+
+			first, duration, exist, err := readFmp4Traf(track, payload)
+			if err != nil || !exist {
+				return err
+			}
+
+			if !found || first < start {
+				start = first
+			}
+			end = max(end, first+duration)
+			found = true
+			return nil
+		})
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !found {
+		return 0, 0, ErrInvalidMp4
+	}
+
+	toMilliseconds := func(ticks uint64) uint64 {
+		return ticks * 1000 / uint64(track.Timescale)
+	}
+	return toMilliseconds(start), toMilliseconds(end), nil
+}
+
+// readFmp4Traf return base decode time and sum of sample durations of traf when it is fragment of track
+func readFmp4Traf(track Fmp4TrackInfo, traf []byte) (uint64, uint64, bool, error) {
+	decodeTime, duration := uint64(0), uint64(0)
+	defaultDuration := track.DefaultDuration
+	matched := false
+
+	err := walkMp4Boxes(traf, func(boxType string, payload []byte) error {
+		switch boxType {
+		case "tfhd":
+			if len(payload) < 8 {
+				return ErrInvalidMp4
+			}
+
+			matched = binary.BigEndian.Uint32(payload[4:8]) == track.Id
+			flags := binary.BigEndian.Uint32(payload[:4]) & 0x00FFFFFF
+			offset := 8
+			if flags&TFHD_BASE_DATA_OFFSET != 0 {
+				offset += 8
+			}
+			if flags&TFHD_SAMPLE_DESCRIPTION_INDEX != 0 {
+				offset += 4
+			}
+			if flags&TFHD_DEFAULT_SAMPLE_DURATION != 0 {
+				if len(payload) < offset+4 {
+					return ErrInvalidMp4
+				}
+				defaultDuration = binary.BigEndian.Uint32(payload[offset:])
+			}
+		case "tfdt":
+			offset, valid := fullBoxField(payload, 0, true)
+			if !valid {
+				return ErrInvalidMp4
+			}
+
+			if payload[0] == 1 {
+				if len(payload) < offset+8 {
+					return ErrInvalidMp4
+				}
+				decodeTime = binary.BigEndian.Uint64(payload[offset:])
+			} else {
+				decodeTime = uint64(binary.BigEndian.Uint32(payload[offset:]))
+			}
+		case "trun":
+			// tfhd precede trun so that default duration is known
+			if !matched {
+				return nil
+			}
+
+			sum, err := trunDuration(payload, defaultDuration)
+			if err != nil {
+				return err
+			}
+			duration += sum
+		}
+		return nil
+	})
+	return decodeTime, duration, matched, err
+}
+
+func trunDuration(trun []byte, defaultDuration uint32) (uint64, error) {
+	if len(trun) < 8 {
+		return 0, ErrInvalidMp4
+	}
+
+	flags := binary.BigEndian.Uint32(trun[:4]) & 0x00FFFFFF
+	count := int(binary.BigEndian.Uint32(trun[4:8]))
+	offset := 8
+	if flags&TRUN_DATA_OFFSET != 0 {
+		offset += 4
+	}
+	if flags&TRUN_FIRST_SAMPLE_FLAGS != 0 {
+		offset += 4
+	}
+
+	sampleSize := 0
+	for _, flag := range []uint32{TRUN_SAMPLE_DURATION, TRUN_SAMPLE_SIZE, TRUN_SAMPLE_FLAGS, TRUN_SAMPLE_CTS_OFFSET} {
+		if flags&flag != 0 {
+			sampleSize += 4
+		}
+	}
+
+	if count < 0 || len(trun) < offset+count*sampleSize {
+		return 0, ErrInvalidMp4
+	}
+
+	if flags&TRUN_SAMPLE_DURATION == 0 {
+		return uint64(count) * uint64(defaultDuration), nil
+	}
+
+	duration := uint64(0)
+	for i := 0; i < count; i++ {
+		duration += uint64(binary.BigEndian.Uint32(trun[offset+i*sampleSize:]))
+	}
+	return duration, nil
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadFmp4Init(t *testing.T) {
+	tracks, err := ReadFmp4Init(readTestdata(t, "fmp4_init.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Fmp4TrackInfo{
+		{Id: FMP4_VIDEO_TRACK_ID, Timescale: FMP4_VIDEO_TIMESCALE, Video: true},
+		{Id: FMP4_AUDIO_TRACK_ID, Timescale: 44100},
+	}
+	if len(tracks) != len(expected) {
+		t.Fatal("invalid tracks. ", tracks)
+	}
+
+	for i := range expected {
+		if tracks[i] != expected[i] {
+			t.Fatalf("track %d is %+v. expected %+v", i, tracks[i], expected[i])
+		}
+	}
+
+	if _, err := ReadFmp4Init(readTestdata(t, "fmp4_fragment_1.m4s")); err == nil {
+		t.Fatal("fragment is read as init segment")
+	}
+}
+
+func TestReadFmp4Duration(t *testing.T) {
+	tracks, err := ReadFmp4Init(readTestdata(t, "fmp4_init.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		tracks   []Fmp4TrackInfo
+		fragment string
+		start    uint64
+		end      uint64
+	}{
+		{name: "video of first fragment", tracks: tracks, fragment: "fmp4_fragment_1.m4s", start: 1000, end: 1066},
+		{name: "video of second fragment", tracks: tracks, fragment: "fmp4_fragment_2.m4s", start: 1066, end: 1132},
+		{name: "audio only", tracks: tracks[1:], fragment: "fmp4_fragment_2.m4s", start: 1065, end: 1135},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			start, end, err := ReadFmp4Duration(testCase.tracks, readTestdata(t, testCase.fragment))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if start != testCase.start || end != testCase.end {
+				t.Fatalf("range is %d ~ %d. expected %d ~ %d", start, end, testCase.start, testCase.end)
+			}
+		})
+	}
+}
+
+func TestReadFmp4DurationDefaultSampleDuration(t *testing.T) {
+	tracks := []Fmp4TrackInfo{{Id: 1, Timescale: 1000, Video: true, DefaultDuration: 40}}
+
+	tfhd := (&mp4Writer{}).u32(1)
+	tfdt := (&mp4Writer{}).u32(2000)
+	trun := (&mp4Writer{}).u32(3).u32(0).u32(10).u32(10).u32(10)
+	moof := mp4Box("moof", mp4Box("traf",
+		mp4FullBox("tfhd", 0, 0, tfhd.buffer),
+		mp4FullBox("tfdt", 0, 0, tfdt.buffer),
+		mp4FullBox("trun", 0, TRUN_DATA_OFFSET|TRUN_SAMPLE_SIZE, trun.buffer)))
+
+	start, end, err := ReadFmp4Duration(tracks, append(moof, mp4Box("mdat", make([]byte, 30))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start != 2000 || end != 2120 {
+		t.Fatalf("range is %d ~ %d. expected 2000 ~ 2120", start, end)
+	}
+
+	// default of tfhd override default of trex
+	overridden := (&mp4Writer{}).u32(1).u32(20)
+	moof = mp4Box("moof", mp4Box("traf",
+		mp4FullBox("tfhd", 0, TFHD_DEFAULT_SAMPLE_DURATION, overridden.buffer),
+		mp4FullBox("tfdt", 0, 0, tfdt.buffer),
+		mp4FullBox("trun", 0, TRUN_DATA_OFFSET|TRUN_SAMPLE_SIZE, trun.buffer)))
+
+	if _, end, err := ReadFmp4Duration(tracks, moof); err != nil || end != 2060 {
+		t.Fatal("default duration of tfhd is not used. ", end, err)
+	}
+}
+
+func TestReadFmp4DurationInvalid(t *testing.T) {
+	tracks, err := ReadFmp4Init(readTestdata(t, "fmp4_init.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragment := readTestdata(t, "fmp4_fragment_1.m4s")
+	testCases := map[string][]byte{
+		"truncated": fragment[:len(fragment)/2],
+		"no moof":   mp4Box("mdat", make([]byte, 8)),
+		"empty":     nil,
+	}
+
+	for name, data := range testCases {
+		if _, _, err := ReadFmp4Duration(tracks, data); err == nil {
+			t.Fatal(name, " fragment is read")
+		}
+	}
+}
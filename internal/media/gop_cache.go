@@ -0,0 +1,198 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"sync"
+
+	rtmpCodec "github.com/yapingcat/gomedia/go-codec"
+)
+
+const (
+	DEFAULT_GOP_CACHE_MAX_FRAMES = 2048
+	DEFAULT_GOP_CACHE_MAX_BYTES  = 32 * 1024 * 1024
+)
+
+// GopCache keep sequence headers and frames from last key frame
+// so that new consumer can start without waiting next key frame.
+// frames are dropped until next key frame when GOP exceed bound.
+type GopCache struct {
+	maxFrames int
+	maxBytes  int
+
+	mutex       sync.Mutex
+	videoHeader []byte
+	frames      []Frame
+	size        int
+}
+
+func NewGopCache(maxFrames, maxBytes int) *GopCache {
+	if maxFrames <= 0 {
+		maxFrames = DEFAULT_GOP_CACHE_MAX_FRAMES
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = DEFAULT_GOP_CACHE_MAX_BYTES
+	}
+
+	return &GopCache{
+		maxFrames: maxFrames,
+		maxBytes:  maxBytes,
+		frames:    make([]Frame, 0),
+	}
+}
+
+// WriteVideo keep frame. frame should not be changed after write
+func (c *GopCache) WriteVideo(frame *VideoFrame) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if header := parameterSets(frame.Data()); len(header) > 0 {
+		c.videoHeader = header
+	}
+
+	if IsKeyFrame(frame) {
+		c.frames = c.frames[:0]
+		c.size = 0
+	}
+	c.append(frame)
+}
+
+// WriteAudio keep frame. ADTS header of AAC frame carry its own configuration
+func (c *GopCache) WriteAudio(frame *AudioFrame) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.append(frame)
+}
+
+func (c *GopCache) append(frame Frame) {
+	// wait first key frame
+	if len(c.frames) == 0 && frame.MediaType() != MEDIA_VIDEO {
+		return
+	}
+
+	if len(c.frames) == 0 && !IsKeyFrame(frame.(*VideoFrame)) {
+		return
+	}
+
+	if len(c.frames) >= c.maxFrames || c.size+len(frame.Data()) > c.maxBytes {
+		c.frames = c.frames[:0]
+		c.size = 0
+		return
+	}
+
+	c.frames = append(c.frames, frame)
+	c.size += len(frame.Data())
+}
+
+// Frames return cached GOP whose timestamps are rebased from key frame and the base.
+// sequence headers are inserted to key frame when key frame does not carry them.
+// live frames after cached GOP should be rebased by same base with RebaseFrame
+func (c *GopCache) Frames() ([]Frame, uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.frames) == 0 {
+		return nil, 0, false
+	}
+
+	keyFrame := c.frames[0].(*VideoFrame)
+	base := keyFrame.Dts()
+
+	frames := make([]Frame, 0, len(c.frames))
+	frames = append(frames, RebaseFrame(WithParameterSets(keyFrame, c.videoHeader), base))
+
+	for _, frame := range c.frames[1:] {
+		frames = append(frames, RebaseFrame(frame, base))
+	}
+	return frames, base, true
+}
+
+// VideoHeader return last SPS and PPS of source
+func (c *GopCache) VideoHeader() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.videoHeader
+}
+
+func (c *GopCache) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.videoHeader = nil
+	c.frames = c.frames[:0]
+	c.size = 0
+}
+
+// RebaseFrame return frame whose timestamp start from base. data of frame is shared
+func RebaseFrame(frame Frame, base uint64) Frame {
+	timestamp := frame.Timestamp()
+	timestamp.Pts = rebaseTimestamp(timestamp.Pts, base)
+	timestamp.Dts = rebaseTimestamp(timestamp.Dts, base)
+
+	switch frame := frame.(type) {
+	case *VideoFrame:
+		return NewVideoFrame(frame.Codec(), timestamp, frame.Data(), frame.HasIDRFrame())
+	case *AudioFrame:
+		return NewAudioFrame(frame.Codec(), timestamp, frame.Data())
+	}
+	return frame
+}
+
+// WithParameterSets return key frame which carry header when frame does not carry SPS and PPS
+func WithParameterSets(keyFrame *VideoFrame, header []byte) *VideoFrame {
+	if len(header) == 0 || len(parameterSets(keyFrame.Data())) > 0 {
+		return keyFrame
+	}
+
+	data := make([]byte, 0, len(header)+len(keyFrame.Data()))
+	data = append(data, header...)
+	data = append(data, keyFrame.Data()...)
+	return NewVideoFrame(keyFrame.Codec(), keyFrame.Timestamp(), data, true)
+}
+
+// audio which is sampled before key frame is clamped to zero
+func rebaseTimestamp(value, base uint64) uint64 {
+	if value < base {
+		return 0
+	}
+	return value - base
+}
+
+// parameterSets return SPS and PPS of annex-b frame
+func parameterSets(data []byte) []byte {
+	var header []byte
+	rtmpCodec.SplitFrameWithStartCode(data,
+		func(nalu []byte) bool {
+			naluType := rtmpCodec.H264NaluType(nalu)
+			if naluType == rtmpCodec.H264_NAL_SPS || naluType == rtmpCodec.H264_NAL_PPS {
+				header = append(header, nalu...)
+			}
+			return true
+		})
+	return header
+}
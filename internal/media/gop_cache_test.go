@@ -0,0 +1,167 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"bytes"
+	"testing"
+)
+
+// slice of H264 P frame
+var testH264PFrameSlice = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x00, 0x10}
+
+// IDR slice without SPS and PPS
+var testH264IdrSlice = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00}
+
+func testVideo(dts uint64, data []byte) *VideoFrame {
+	frame := NewVideoFrame(CODEC_VIDEO_H264, Timestamp{Pts: dts, Dts: dts}, data, false)
+	frame.hasIDRFrame = IsKeyFrame(frame)
+	return frame
+}
+
+func testAudio(dts uint64) *AudioFrame {
+	return NewAudioFrame(CODEC_AUDIO_AAC, Timestamp{Pts: dts, Dts: dts}, []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x7F, 0xFC, 0x21})
+}
+
+func dtsOf(frames []Frame) []uint64 {
+	values := make([]uint64, 0, len(frames))
+	for _, frame := range frames {
+		values = append(values, frame.Timestamp().Dts)
+	}
+	return values
+}
+
+func TestGopCacheWaitKeyFrame(t *testing.T) {
+	cache := NewGopCache(0, 0)
+	cache.WriteAudio(testAudio(0))
+	cache.WriteVideo(testVideo(10, testH264PFrameSlice))
+
+	if _, _, exist := cache.Frames(); exist {
+		t.Fatal("frames before key frame are cached")
+	}
+
+	cache.WriteVideo(testVideo(20, testH264Frame))
+	cache.WriteAudio(testAudio(25))
+	frames, base, exist := cache.Frames()
+	if !exist || base != 20 || len(frames) != 2 {
+		t.Fatal("invalid cache. ", base, len(frames))
+	}
+}
+
+func TestGopCacheRebase(t *testing.T) {
+	cache := NewGopCache(0, 0)
+	cache.WriteVideo(testVideo(1000, testH264Frame))
+	cache.WriteAudio(testAudio(990))
+	cache.WriteVideo(testVideo(1040, testH264PFrameSlice))
+	cache.WriteAudio(testAudio(1050))
+
+	frames, base, _ := cache.Frames()
+	if base != 1000 {
+		t.Fatal("base is not dts of key frame. ", base)
+	}
+
+	// audio sampled before key frame is clamped to zero
+	expected := []uint64{0, 0, 40, 50}
+	for i, dts := range dtsOf(frames) {
+		if dts != expected[i] {
+			t.Fatal("invalid rebased timestamps. ", dtsOf(frames))
+		}
+	}
+
+	// cached frame is not changed by rebasing
+	cached, _, _ := cache.Frames()
+	if cached[0].Timestamp().Dts != 0 || cache.frames[0].Timestamp().Dts != 1000 {
+		t.Fatal("cached frame is changed")
+	}
+}
+
+func TestGopCacheNewKeyFrameReplaceGop(t *testing.T) {
+	cache := NewGopCache(0, 0)
+	cache.WriteVideo(testVideo(0, testH264Frame))
+	cache.WriteVideo(testVideo(40, testH264PFrameSlice))
+	cache.WriteVideo(testVideo(80, testH264IdrSlice))
+	cache.WriteVideo(testVideo(120, testH264PFrameSlice))
+
+	frames, base, _ := cache.Frames()
+	if base != 80 || len(frames) != 2 {
+		t.Fatal("previous GOP is kept. ", base, len(frames))
+	}
+
+	// key frame without parameter sets carry last SPS and PPS of source
+	header := cache.VideoHeader()
+	if len(header) == 0 || !bytes.HasPrefix(frames[0].Data(), header) || !bytes.HasSuffix(frames[0].Data(), testH264IdrSlice) {
+		t.Fatalf("parameter sets are not inserted. %x", frames[0].Data())
+	}
+
+	if !frames[0].(*VideoFrame).HasIDRFrame() {
+		t.Fatal("inserted key frame is not IDR frame")
+	}
+}
+
+func TestGopCacheEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxFrames int
+		maxBytes  int
+	}{
+		{"max frames", 3, 0},
+		{"max bytes", 0, len(testH264Frame) + 2*len(testH264PFrameSlice)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewGopCache(test.maxFrames, test.maxBytes)
+			cache.WriteVideo(testVideo(0, testH264Frame))
+			cache.WriteVideo(testVideo(40, testH264PFrameSlice))
+			cache.WriteVideo(testVideo(80, testH264PFrameSlice))
+			if frames, _, _ := cache.Frames(); len(frames) != 3 {
+				t.Fatal("GOP within bound is not cached. ", len(frames))
+			}
+
+			// GOP exceed bound is dropped until next key frame
+			cache.WriteVideo(testVideo(120, testH264PFrameSlice))
+			cache.WriteVideo(testVideo(160, testH264PFrameSlice))
+			if _, _, exist := cache.Frames(); exist {
+				t.Fatal("GOP exceed bound is kept")
+			}
+
+			cache.WriteVideo(testVideo(200, testH264IdrSlice))
+			frames, base, exist := cache.Frames()
+			if !exist || base != 200 || len(frames) != 1 {
+				t.Fatal("cache is not restarted from next key frame")
+			}
+		})
+	}
+}
+
+func TestGopCacheReset(t *testing.T) {
+	cache := NewGopCache(0, 0)
+	cache.WriteVideo(testVideo(0, testH264Frame))
+	cache.Reset()
+
+	if _, _, exist := cache.Frames(); exist || len(cache.VideoHeader()) != 0 {
+		t.Fatal("cache is not reset")
+	}
+}
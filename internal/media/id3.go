@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"sort"
+)
+
+const (
+	id3HeaderSize       = 10
+	id3TextEncodingUtf8 = 0x03
+)
+
+// MakeID3Tag build ID3v2.4 tag which has one TXXX frame per field,
+// it is carried as timed metadata in the mpeg ts output.
+func MakeID3Tag(fields map[string]string) []byte {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	frames := make([]byte, 0)
+	for _, key := range keys {
+		frames = append(frames, makeID3TextFrame(key, fields[key])...)
+	}
+
+	tag := make([]byte, id3HeaderSize, id3HeaderSize+len(frames))
+	copy(tag, []byte{'I', 'D', '3', 0x04, 0x00, 0x00})
+	putSyncSafeInt(tag[6:10], len(frames))
+	return append(tag, frames...)
+}
+
+func makeID3TextFrame(description, value string) []byte {
+	body := make([]byte, 0, len(description)+len(value)+2)
+	body = append(body, id3TextEncodingUtf8)
+	body = append(body, description...)
+	body = append(body, 0x00)
+	body = append(body, value...)
+
+	frame := make([]byte, id3HeaderSize, id3HeaderSize+len(body))
+	copy(frame, []byte{'T', 'X', 'X', 'X'})
+	putSyncSafeInt(frame[4:8], len(body))
+	return append(frame, body...)
+}
+
+func putSyncSafeInt(buffer []byte, value int) {
+	buffer[0] = byte(value>>21) & 0x7F
+	buffer[1] = byte(value>>14) & 0x7F
+	buffer[2] = byte(value>>7) & 0x7F
+	buffer[3] = byte(value) & 0x7F
+}
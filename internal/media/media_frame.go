@@ -32,6 +32,13 @@ const (
 	MEDIA_AUDIO
 )
 
+// Frame is common interface of VideoFrame and AudioFrame
+type Frame interface {
+	MediaType() MediaType
+	Data() []byte
+	Timestamp() Timestamp
+}
+
 type MediaFrame struct {
 	mediaType MediaType
 	data      []byte
@@ -80,6 +87,13 @@ func NewVideoFrame(codec VideoCodec, timestamp Timestamp, data []byte, hasIDRFra
 	}
 }
 
+// Clone copy data of frame so that frame can be kept after buffer of demuxer is reused
+func (frame *VideoFrame) Clone() *VideoFrame {
+	data := make([]byte, len(frame.data))
+	copy(data, frame.data)
+	return NewVideoFrame(frame.codec, frame.timestamp, data, frame.hasIDRFrame)
+}
+
 func (frame *VideoFrame) Codec() VideoCodec {
 	return frame.codec
 }
@@ -108,6 +122,12 @@ func NewAudioFrame(codec AudioCodec, timestamp Timestamp, data []byte) *AudioFra
 	}
 }
 
+func (frame *AudioFrame) Clone() *AudioFrame {
+	data := make([]byte, len(frame.data))
+	copy(data, frame.data)
+	return NewAudioFrame(frame.codec, frame.timestamp, data)
+}
+
 func (frame *AudioFrame) Codec() AudioCodec {
 	return frame.codec
 }
@@ -0,0 +1,107 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"encoding/binary"
+)
+
+// mp4Box make box of type with payloads
+func mp4Box(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	box := make([]byte, 0, size)
+	box = binary.BigEndian.AppendUint32(box, uint32(size))
+	box = append(box, boxType...)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+	return box
+}
+
+// mp4FullBox make box with version and flags
+func mp4FullBox(boxType string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0x00FFFFFF)
+	return mp4Box(boxType, append([][]byte{header}, payloads...)...)
+}
+
+// mp4Writer append big endian fields
+type mp4Writer struct {
+	buffer []byte
+}
+
+func (w *mp4Writer) u8(value uint8) *mp4Writer {
+	w.buffer = append(w.buffer, value)
+	return w
+}
+
+func (w *mp4Writer) u16(value uint16) *mp4Writer {
+	w.buffer = binary.BigEndian.AppendUint16(w.buffer, value)
+	return w
+}
+
+func (w *mp4Writer) u32(value uint32) *mp4Writer {
+	w.buffer = binary.BigEndian.AppendUint32(w.buffer, value)
+	return w
+}
+
+func (w *mp4Writer) u64(value uint64) *mp4Writer {
+	w.buffer = binary.BigEndian.AppendUint64(w.buffer, value)
+	return w
+}
+
+func (w *mp4Writer) bytes(value []byte) *mp4Writer {
+	w.buffer = append(w.buffer, value...)
+	return w
+}
+
+func (w *mp4Writer) zeros(count int) *mp4Writer {
+	w.buffer = append(w.buffer, make([]byte, count)...)
+	return w
+}
+
+// matrix is unity matrix of mvhd and tkhd
+func (w *mp4Writer) matrix() *mp4Writer {
+	return w.u32(0x00010000).u32(0).u32(0).
+		u32(0).u32(0x00010000).u32(0).
+		u32(0).u32(0).u32(0x40000000)
+}
+
+// mp4Descriptor make descriptor of esds with one byte length
+func mp4Descriptor(tag uint8, payloads ...[]byte) []byte {
+	size := 0
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	descriptor := []byte{tag, uint8(size)}
+	for _, payload := range payloads {
+		descriptor = append(descriptor, payload...)
+	}
+	return descriptor
+}
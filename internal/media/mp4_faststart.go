@@ -0,0 +1,247 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+var ErrInvalidMp4 = errors.New("invalid mp4")
+
+// mp4BoxInfo is position of top level box in file
+type mp4BoxInfo struct {
+	boxType string
+	offset  int64
+	size    int64
+}
+
+// Mp4FastStart move moov box which is written after mdat to front of mdat
+// so that player can start before whole file is downloaded.
+// chunk offsets of stco and co64 are shifted by size of moov. file is replaced when rewrite is done
+func Mp4FastStart(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	boxes, err := readMp4Boxes(file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	moovIndex, mdatIndex := -1, -1
+	for index, box := range boxes {
+		switch {
+		case box.boxType == "moov" && moovIndex < 0:
+			moovIndex = index
+		case box.boxType == "mdat" && mdatIndex < 0:
+			mdatIndex = index
+		}
+	}
+
+	if moovIndex < 0 || mdatIndex < 0 {
+		return ErrInvalidMp4
+	}
+
+	// already fast start
+	if moovIndex < mdatIndex {
+		return nil
+	}
+
+	moovBox := boxes[moovIndex]
+	moov := make([]byte, moovBox.size)
+	if _, err := file.ReadAt(moov, moovBox.offset); err != nil {
+		return err
+	}
+
+	headerSize := mp4BoxHeaderSize(moov)
+	if err := shiftChunkOffsets(moov[headerSize:], uint64(moovBox.size)); err != nil {
+		return err
+	}
+
+	tempPath := path + ".faststart"
+	output, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = writeFastStart(output, file, boxes, moovIndex, mdatIndex, moov)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// writeFastStart copy boxes in front of mdat, moov and rest of boxes
+func writeFastStart(output io.Writer, input io.ReaderAt, boxes []mp4BoxInfo, moovIndex, mdatIndex int, moov []byte) error {
+	for index, box := range boxes {
+		if index == mdatIndex {
+			if _, err := output.Write(moov); err != nil {
+				return err
+			}
+		}
+
+		if index == moovIndex {
+			continue
+		}
+
+		if _, err := io.Copy(output, io.NewSectionReader(input, box.offset, box.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMp4Boxes return top level boxes. box of size 0 extend to end of file
+func readMp4Boxes(file io.ReaderAt, fileSize int64) ([]mp4BoxInfo, error) {
+	boxes := make([]mp4BoxInfo, 0)
+	header := make([]byte, 16)
+	for offset := int64(0); offset < fileSize; {
+		if fileSize-offset < 8 {
+			return nil, ErrInvalidMp4
+		}
+
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch size {
+		case 0:
+			size = fileSize - offset
+		case 1:
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+
+			largeSize := binary.BigEndian.Uint64(header[8:16])
+			if largeSize > math.MaxInt64 {
+				return nil, ErrInvalidMp4
+			}
+			size = int64(largeSize)
+		}
+
+		if size < 8 || offset+size > fileSize {
+			return nil, ErrInvalidMp4
+		}
+
+		boxes = append(boxes, mp4BoxInfo{boxType: string(header[4:8]), offset: offset, size: size})
+		offset += size
+	}
+	return boxes, nil
+}
+
+func mp4BoxHeaderSize(box []byte) int {
+	if binary.BigEndian.Uint32(box[:4]) == 1 {
+		return 16
+	}
+	return 8
+}
+
+// shiftChunkOffsets add shift to offsets of stco and co64 in children of container box
+func shiftChunkOffsets(children []byte, shift uint64) error {
+	for len(children) > 0 {
+		if len(children) < 8 {
+			return ErrInvalidMp4
+		}
+
+		size := uint64(binary.BigEndian.Uint32(children[:4]))
+		headerSize := 8
+		switch size {
+		case 0:
+			size = uint64(len(children))
+		case 1:
+			if len(children) < 16 {
+				return ErrInvalidMp4
+			}
+			size = binary.BigEndian.Uint64(children[8:16])
+			headerSize = 16
+		}
+
+		if size < uint64(headerSize) || size > uint64(len(children)) {
+			return ErrInvalidMp4
+		}
+
+		payload := children[headerSize:size]
+		var err error
+		switch string(children[4:8]) {
+		case "trak", "mdia", "minf", "stbl":
+			err = shiftChunkOffsets(payload, shift)
+		case "stco":
+			err = shiftChunkOffsetTable(payload, shift, 4)
+		case "co64":
+			err = shiftChunkOffsetTable(payload, shift, 8)
+		}
+
+		if err != nil {
+			return err
+		}
+		children = children[size:]
+	}
+	return nil
+}
+
+// shiftChunkOffsetTable shift entries of version, flags, entry count and offsets whose size is entrySize
+func shiftChunkOffsetTable(payload []byte, shift uint64, entrySize int) error {
+	if len(payload) < 8 {
+		return ErrInvalidMp4
+	}
+
+	count := int(binary.BigEndian.Uint32(payload[4:8]))
+	entries := payload[8:]
+	if count > len(entries)/entrySize {
+		return ErrInvalidMp4
+	}
+
+	for index := 0; index < count; index++ {
+		entry := entries[index*entrySize:]
+		if entrySize == 8 {
+			binary.BigEndian.PutUint64(entry, binary.BigEndian.Uint64(entry)+shift)
+			continue
+		}
+
+		offset := uint64(binary.BigEndian.Uint32(entry)) + shift
+		if offset > math.MaxUint32 {
+			return errors.New("chunk offset overflow stco")
+		}
+		binary.BigEndian.PutUint32(entry, uint32(offset))
+	}
+	return nil
+}
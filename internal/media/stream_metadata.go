@@ -0,0 +1,89 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"strconv"
+)
+
+// StreamMetadata is the encoder metadata announced by the publisher.
+// data rates are kbps, zero means the field was not announced.
+type StreamMetadata struct {
+	Width           int
+	Height          int
+	FrameRate       float64
+	VideoDataRate   float64
+	VideoCodecId    string
+	AudioDataRate   float64
+	AudioSampleRate int
+	AudioChannels   int
+	AudioCodecId    string
+	Encoder         string
+}
+
+func (m *StreamMetadata) Resolution() string {
+	return strconv.Itoa(m.Width) + "x" + strconv.Itoa(m.Height)
+}
+
+func (m *StreamMetadata) Fields() map[string]string {
+	fields := make(map[string]string)
+	if m.Width > 0 && m.Height > 0 {
+		fields["width"] = strconv.Itoa(m.Width)
+		fields["height"] = strconv.Itoa(m.Height)
+	}
+
+	if m.FrameRate > 0 {
+		fields["framerate"] = strconv.FormatFloat(m.FrameRate, 'f', -1, 64)
+	}
+
+	if m.VideoDataRate > 0 {
+		fields["videodatarate"] = strconv.FormatFloat(m.VideoDataRate, 'f', -1, 64)
+	}
+
+	if len(m.VideoCodecId) > 0 {
+		fields["videocodecid"] = m.VideoCodecId
+	}
+
+	if m.AudioDataRate > 0 {
+		fields["audiodatarate"] = strconv.FormatFloat(m.AudioDataRate, 'f', -1, 64)
+	}
+
+	if m.AudioSampleRate > 0 {
+		fields["audiosamplerate"] = strconv.Itoa(m.AudioSampleRate)
+	}
+
+	if m.AudioChannels > 0 {
+		fields["audiochannels"] = strconv.Itoa(m.AudioChannels)
+	}
+
+	if len(m.AudioCodecId) > 0 {
+		fields["audiocodecid"] = m.AudioCodecId
+	}
+
+	if len(m.Encoder) > 0 {
+		fields["encoder"] = m.Encoder
+	}
+	return fields
+}
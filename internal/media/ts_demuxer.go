@@ -0,0 +1,261 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/yapingcat/gomedia/go-mpeg2"
+)
+
+const (
+	TS_PACKET_SIZE = 188
+	TS_SYNC_BYTE   = 0x47
+
+	tsPidPat = 0x0000
+)
+
+var errInvalidPes = errors.New("invalid pes packet")
+
+type pesStream struct {
+	streamType mpeg2.TS_STREAM_TYPE
+	buffer     []byte
+}
+
+// TsDemuxer demux MPEG-TS which is pushed by arbitrary sized chunk.
+// frame is emitted when next PES of same stream is started.
+type TsDemuxer struct {
+	OnVideoFrame func(frame *VideoFrame)
+	OnAudioFrame func(frame *AudioFrame)
+
+	remain  []byte
+	pmtPids map[uint16]bool
+	streams map[uint16]*pesStream
+}
+
+func NewTsDemuxer() *TsDemuxer {
+	return &TsDemuxer{
+		OnVideoFrame: nil,
+		OnAudioFrame: nil,
+		remain:       make([]byte, 0, TS_PACKET_SIZE),
+		pmtPids:      make(map[uint16]bool),
+		streams:      make(map[uint16]*pesStream),
+	}
+}
+
+func (d *TsDemuxer) Input(data []byte) error {
+	if len(d.remain) > 0 {
+		data = append(d.remain, data...)
+		d.remain = d.remain[:0]
+	}
+
+	for len(data) >= TS_PACKET_SIZE {
+		if data[0] != TS_SYNC_BYTE {
+			// resync to next sync byte
+			data = data[1:]
+			continue
+		}
+
+		if err := d.inputPacket(data[:TS_PACKET_SIZE]); err != nil {
+			return err
+		}
+		data = data[TS_PACKET_SIZE:]
+	}
+
+	d.remain = append(d.remain, data...)
+	return nil
+}
+
+// Flush emit frames which are waiting next PES
+func (d *TsDemuxer) Flush() {
+	for _, stream := range d.streams {
+		d.emit(stream)
+	}
+}
+
+func (d *TsDemuxer) inputPacket(packet []byte) error {
+	payloadUnitStart := packet[1]&0x40 != 0
+	pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1FFF
+	adaptationField := (packet[3] >> 4) & 0x3
+
+	payload := packet[4:]
+	if adaptationField == 0x2 || adaptationField == 0x3 {
+		length := int(payload[0])
+		if length+1 > len(payload) {
+			return nil
+		}
+		payload = payload[length+1:]
+	}
+
+	if adaptationField == 0x2 || len(payload) == 0 {
+		return nil
+	}
+
+	switch {
+	case pid == tsPidPat:
+		if payloadUnitStart {
+			d.parsePat(payload)
+		}
+	case d.pmtPids[pid]:
+		if payloadUnitStart {
+			d.parsePmt(payload)
+		}
+	default:
+		stream, exist := d.streams[pid]
+		if !exist {
+			return nil
+		}
+
+		if payloadUnitStart {
+			d.emit(stream)
+		}
+		stream.buffer = append(stream.buffer, payload...)
+	}
+	return nil
+}
+
+// section return body of PSI section which is placed after pointer field
+func section(payload []byte) []byte {
+	pointer := int(payload[0])
+	if pointer+1 >= len(payload) {
+		return nil
+	}
+
+	payload = payload[pointer+1:]
+	if len(payload) < 3 {
+		return nil
+	}
+
+	length := int(binary.BigEndian.Uint16(payload[1:3]) & 0x0FFF)
+	if len(payload) < 3+length || length < 9 {
+		return nil
+	}
+
+	// skip table header and CRC
+	return payload[8 : 3+length-4]
+}
+
+func (d *TsDemuxer) parsePat(payload []byte) {
+	body := section(payload)
+	for i := 0; i+4 <= len(body); i += 4 {
+		programNumber := binary.BigEndian.Uint16(body[i : i+2])
+		pid := binary.BigEndian.Uint16(body[i+2:i+4]) & 0x1FFF
+		if programNumber != 0 {
+			d.pmtPids[pid] = true
+		}
+	}
+}
+
+func (d *TsDemuxer) parsePmt(payload []byte) {
+	body := section(payload)
+	if len(body) < 4 {
+		return
+	}
+
+	programInfoLength := int(binary.BigEndian.Uint16(body[2:4]) & 0x0FFF)
+	body = body[4:]
+	if programInfoLength > len(body) {
+		return
+	}
+
+	body = body[programInfoLength:]
+	for len(body) >= 5 {
+		streamType := mpeg2.TS_STREAM_TYPE(body[0])
+		pid := binary.BigEndian.Uint16(body[1:3]) & 0x1FFF
+		infoLength := int(binary.BigEndian.Uint16(body[3:5]) & 0x0FFF)
+
+		if _, exist := d.streams[pid]; !exist {
+			switch streamType {
+			case mpeg2.TS_STREAM_H264, mpeg2.TS_STREAM_AAC:
+				d.streams[pid] = &pesStream{streamType: streamType}
+			}
+		}
+
+		if 5+infoLength > len(body) {
+			return
+		}
+		body = body[5+infoLength:]
+	}
+}
+
+func (d *TsDemuxer) emit(stream *pesStream) {
+	if len(stream.buffer) == 0 {
+		return
+	}
+
+	data, timestamp, err := parsePes(stream.buffer)
+	stream.buffer = stream.buffer[:0]
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	// buffer is reused for next PES
+	frameData := make([]byte, len(data))
+	copy(frameData, data)
+
+	switch stream.streamType {
+	case mpeg2.TS_STREAM_H264:
+		if d.OnVideoFrame != nil {
+			d.OnVideoFrame(NewVideoFrame(CODEC_VIDEO_H264, timestamp, frameData, false))
+		}
+	case mpeg2.TS_STREAM_AAC:
+		if d.OnAudioFrame != nil {
+			d.OnAudioFrame(NewAudioFrame(CODEC_AUDIO_AAC, timestamp, frameData))
+		}
+	}
+}
+
+// parsePes return elementary stream data and timestamp as millisecond
+func parsePes(pes []byte) ([]byte, Timestamp, error) {
+	timestamp := Timestamp{}
+	if len(pes) < 9 || pes[0] != 0x00 || pes[1] != 0x00 || pes[2] != 0x01 {
+		return nil, timestamp, errInvalidPes
+	}
+
+	ptsDtsFlag := pes[7] >> 6
+	headerLength := int(pes[8])
+	if 9+headerLength > len(pes) {
+		return nil, timestamp, errInvalidPes
+	}
+
+	header := pes[9 : 9+headerLength]
+	if ptsDtsFlag&0x2 != 0 && len(header) >= 5 {
+		timestamp.Pts = readPesTimestamp(header[0:5]) / 90
+		timestamp.Dts = timestamp.Pts
+	}
+
+	if ptsDtsFlag == 0x3 && len(header) >= 10 {
+		timestamp.Dts = readPesTimestamp(header[5:10]) / 90
+	}
+
+	return pes[9+headerLength:], timestamp, nil
+}
+
+func readPesTimestamp(data []byte) uint64 {
+	return uint64(data[0]>>1&0x07)<<30 |
+		uint64(data[1])<<22 | uint64(data[2]>>1)<<15 |
+		uint64(data[3])<<7 | uint64(data[4]>>1)
+}
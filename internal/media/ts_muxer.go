@@ -25,26 +25,45 @@ SOFTWARE.
 package media
 
 import (
+	"encoding/binary"
 	"errors"
 
+	"github.com/yapingcat/gomedia/go-codec"
 	"github.com/yapingcat/gomedia/go-mpeg2"
 )
 
+// stream type of ID3 timed metadata in PMT
+const TS_STREAM_METADATA mpeg2.TS_STREAM_TYPE = 0x15
+
+const (
+	// PMT of first program of gomedia muxer
+	tsPidPmt = 0x200
+
+	tsDescriptorMetadataPointer = 0x25
+	tsDescriptorMetadata        = 0x26
+)
+
+// format identifier of ID3 in metadata descriptors
+var id3FormatIdentifier = []byte("ID3 ")
+
 type TsMuxer struct {
-	videoStreamId uint16
-	audioStreamId uint16
-	context       *mpeg2.TSMuxer
+	videoStreamId    uint16
+	audioStreamId    uint16
+	metadataStreamId uint16
+	context          *mpeg2.TSMuxer
 }
 
 func NewTSMuxer() *TsMuxer {
 	tsMuxer := &TsMuxer{
-		videoStreamId: 0,
-		audioStreamId: 0,
-		context:       mpeg2.NewTSMuxer(),
+		videoStreamId:    0,
+		audioStreamId:    0,
+		metadataStreamId: 0,
+		context:          mpeg2.NewTSMuxer(),
 	}
 
 	tsMuxer.videoStreamId = tsMuxer.context.AddStream(mpeg2.TS_STREAM_H264)
 	tsMuxer.audioStreamId = tsMuxer.context.AddStream(mpeg2.TS_STREAM_AAC)
+	tsMuxer.metadataStreamId = tsMuxer.context.AddStream(TS_STREAM_METADATA)
 	return tsMuxer
 }
 
@@ -53,12 +72,35 @@ func (m *TsMuxer) MuxingVideo(frame *VideoFrame) ([]byte, error) {
 		return nil, errors.New("invalid frame")
 	}
 
+	return m.write(m.videoStreamId, frame.Data(), frame.Pts(), frame.Dts())
+}
+
+func (m *TsMuxer) MuxingAudio(frame *AudioFrame) ([]byte, error) {
+	if (frame.mediaType != MEDIA_AUDIO) || (frame.codec != CODEC_AUDIO_AAC) {
+		return nil, errors.New("invalid frame")
+	}
+
+	return m.write(m.audioStreamId, frame.Data(), frame.Pts(), frame.Dts())
+}
+
+func (m *TsMuxer) MuxingMetadata(id3Tag []byte, timestamp Timestamp) ([]byte, error) {
+	if len(id3Tag) == 0 {
+		return nil, errors.New("invalid id3 tag")
+	}
+
+	return m.write(m.metadataStreamId, id3Tag, timestamp.Pts, timestamp.Dts)
+}
+
+func (m *TsMuxer) write(streamId uint16, data []byte, pts, dts uint64) ([]byte, error) {
 	buffer := make([]byte, 0)
-	m.context.OnPacket = func(data []byte) {
-		buffer = append(buffer, data...)
+	m.context.OnPacket = func(packet []byte) {
+		if pid := binary.BigEndian.Uint16(packet[1:3]) & 0x1FFF; pid == tsPidPmt {
+			packet = addMetadataDescriptors(packet)
+		}
+		buffer = append(buffer, packet...)
 	}
 
-	err := m.context.Write(m.videoStreamId, frame.Data(), frame.Pts(), frame.Dts())
+	err := m.context.Write(streamId, data, pts, dts)
 	if err != nil {
 		return nil, err
 	}
@@ -66,20 +108,88 @@ func (m *TsMuxer) MuxingVideo(frame *VideoFrame) ([]byte, error) {
 	return buffer, nil
 }
 
-func (m *TsMuxer) MuxingAudio(frame *AudioFrame) ([]byte, error) {
-	if (frame.mediaType != MEDIA_AUDIO) || (frame.codec != CODEC_AUDIO_AAC) {
-		return nil, errors.New("invalid frame")
+// addMetadataDescriptors rewrite PMT packet of gomedia which has no descriptors.
+// ID3 stream is recognized as timed metadata instead of private data only with
+// metadata_pointer_descriptor of program and metadata_descriptor of stream
+func addMetadataDescriptors(packet []byte) []byte {
+	// ts header and pointer field. gomedia always start section right after pointer field
+	const headerSize = 5
+	if len(packet) != TS_PACKET_SIZE || packet[1]&0x40 == 0 || packet[4] != 0 {
+		return packet
 	}
 
-	buffer := make([]byte, 0)
-	m.context.OnPacket = func(data []byte) {
-		buffer = append(buffer, data...)
+	section := packet[headerSize:]
+	sectionLength := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+	if sectionLength < 13 || 3+sectionLength > len(section) {
+		return packet
 	}
 
-	err := m.context.Write(m.audioStreamId, frame.Data(), frame.Pts(), frame.Dts())
-	if err != nil {
-		return nil, err
+	programNumber := binary.BigEndian.Uint16(section[3:5])
+	programInfoLength := int(binary.BigEndian.Uint16(section[10:12]) & 0x0FFF)
+	if 12+programInfoLength > 3+sectionLength-4 {
+		return packet
 	}
 
-	return buffer, nil
+	// table header to PCR PID is kept. section length is set after streams are written
+	body := make([]byte, 0, TS_PACKET_SIZE)
+	body = append(body, section[:10]...)
+
+	programInfo := append(section[12:12+programInfoLength:12+programInfoLength], metadataPointerDescriptor(programNumber)...)
+	body = binary.BigEndian.AppendUint16(body, 0xF000|uint16(len(programInfo)))
+	body = append(body, programInfo...)
+
+	streams := section[12+programInfoLength : 3+sectionLength-4]
+	for len(streams) >= 5 {
+		infoLength := int(binary.BigEndian.Uint16(streams[3:5]) & 0x0FFF)
+		if 5+infoLength > len(streams) {
+			return packet
+		}
+
+		info := streams[5 : 5+infoLength : 5+infoLength]
+		if mpeg2.TS_STREAM_TYPE(streams[0]) == TS_STREAM_METADATA {
+			info = append(info, metadataDescriptor()...)
+		}
+
+		body = append(body, streams[0], 0xE0|streams[1]&0x1F, streams[2])
+		body = binary.BigEndian.AppendUint16(body, 0xF000|uint16(len(info)))
+		body = append(body, info...)
+		streams = streams[5+infoLength:]
+	}
+
+	// section length count bytes after itself including CRC
+	length := uint16(len(body) - 3 + 4)
+	binary.BigEndian.PutUint16(body[1:3], binary.BigEndian.Uint16(section[1:3])&0xF000|length)
+	body = binary.LittleEndian.AppendUint32(body, codec.CalcCrc32(0xffffffff, body))
+	if headerSize+len(body) > TS_PACKET_SIZE {
+		return packet
+	}
+
+	rewritten := make([]byte, TS_PACKET_SIZE)
+	copy(rewritten, packet[:headerSize])
+	copy(rewritten[headerSize:], body)
+	for i := headerSize + len(body); i < TS_PACKET_SIZE; i++ {
+		rewritten[i] = 0xFF
+	}
+	return rewritten
+}
+
+// metadataPointerDescriptor point ID3 metadata stream which is carried in same program
+func metadataPointerDescriptor(programNumber uint16) []byte {
+	descriptor := []byte{tsDescriptorMetadataPointer, 15, 0xFF, 0xFF}
+	descriptor = append(descriptor, id3FormatIdentifier...)
+	descriptor = append(descriptor, 0xFF)
+	descriptor = append(descriptor, id3FormatIdentifier...)
+	// metadata service id, no locator record and carried in same transport stream
+	descriptor = append(descriptor, 0x00, 0x1F)
+	return binary.BigEndian.AppendUint16(descriptor, programNumber)
+}
+
+// metadataDescriptor describe ID3 format of metadata stream
+func metadataDescriptor() []byte {
+	descriptor := []byte{tsDescriptorMetadata, 13, 0xFF, 0xFF}
+	descriptor = append(descriptor, id3FormatIdentifier...)
+	descriptor = append(descriptor, 0xFF)
+	descriptor = append(descriptor, id3FormatIdentifier...)
+	// metadata service id, no decoder config and no DSM-CC
+	return append(descriptor, 0x00, 0x0F)
 }
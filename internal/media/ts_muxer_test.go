@@ -0,0 +1,175 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+// mpegCrc32 is CRC-32/MPEG-2 of PSI section
+func mpegCrc32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func tsPacketPid(packet []byte) uint16 {
+	return binary.BigEndian.Uint16(packet[1:3]) & 0x1FFF
+}
+
+// tsPayload return payload of packet after adaptation field
+func tsPayload(packet []byte) []byte {
+	payload := packet[4:]
+	if control := packet[3] >> 4 & 0x3; control == 0x2 || control == 0x3 {
+		payload = payload[1+int(payload[0]):]
+	}
+	return payload
+}
+
+// muxTestStream return ts of a video frame and metadata, and pid of metadata stream
+func muxTestStream(t *testing.T, id3Tag []byte) ([]byte, uint16) {
+	t.Helper()
+
+	muxer := NewTSMuxer()
+	stream := make([]byte, 0)
+	video, err := muxer.MuxingVideo(NewVideoFrame(CODEC_VIDEO_H264, Timestamp{Pts: 0, Dts: 0}, testH264Frame, true))
+	if err != nil {
+		t.Fatal("video muxing fail. ", err)
+	}
+	stream = append(stream, video...)
+
+	metadata, err := muxer.MuxingMetadata(id3Tag, Timestamp{Pts: 10, Dts: 10})
+	if err != nil {
+		t.Fatal("metadata muxing fail. ", err)
+	}
+	stream = append(stream, metadata...)
+
+	if len(stream)%TS_PACKET_SIZE != 0 {
+		t.Fatal("stream is not aligned to ts packet. ", len(stream))
+	}
+	return stream, muxer.metadataStreamId
+}
+
+func findPmtSection(t *testing.T, stream []byte) []byte {
+	t.Helper()
+
+	for i := 0; i < len(stream); i += TS_PACKET_SIZE {
+		packet := stream[i : i+TS_PACKET_SIZE]
+		if tsPacketPid(packet) != tsPidPmt {
+			continue
+		}
+
+		payload := tsPayload(packet)
+		section := payload[1+int(payload[0]):]
+		length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+		return section[:3+length]
+	}
+
+	t.Fatal("pmt is not found")
+	return nil
+}
+
+func TestTsMuxerPmtHasID3Descriptors(t *testing.T) {
+	stream, _ := muxTestStream(t, MakeID3Tag(map[string]string{"title": "test"}))
+	section := findPmtSection(t, stream)
+
+	if crc := binary.BigEndian.Uint32(section[len(section)-4:]); crc != mpegCrc32(section[:len(section)-4]) {
+		t.Fatalf("invalid crc of pmt. %08x", crc)
+	}
+
+	programInfoLength := int(binary.BigEndian.Uint16(section[10:12]) & 0x0FFF)
+	programInfo := section[12 : 12+programInfoLength]
+	if len(programInfo) < 17 || programInfo[0] != tsDescriptorMetadataPointer || !bytes.Equal(programInfo[4:8], id3FormatIdentifier) {
+		t.Fatalf("metadata pointer descriptor is not found. %x", programInfo)
+	}
+
+	metadataPid := uint16(0)
+	streams := section[12+programInfoLength : len(section)-4]
+	for len(streams) >= 5 {
+		infoLength := int(binary.BigEndian.Uint16(streams[3:5]) & 0x0FFF)
+		info := streams[5 : 5+infoLength]
+		if streams[0] == byte(TS_STREAM_METADATA) {
+			metadataPid = binary.BigEndian.Uint16(streams[1:3]) & 0x1FFF
+			if len(info) != 15 || info[0] != tsDescriptorMetadata || !bytes.Equal(info[4:8], id3FormatIdentifier) || !bytes.Equal(info[9:13], id3FormatIdentifier) {
+				t.Fatalf("invalid metadata descriptor. %x", info)
+			}
+		} else if infoLength != 0 {
+			t.Fatalf("unexpected descriptor of stream type %x. %x", streams[0], info)
+		}
+		streams = streams[5+infoLength:]
+	}
+
+	if metadataPid == 0 {
+		t.Fatal("metadata stream is not found in pmt")
+	}
+}
+
+func TestTsMuxerMetadataPes(t *testing.T) {
+	id3Tag := MakeID3Tag(map[string]string{"title": "test"})
+	stream, metadataPid := muxTestStream(t, id3Tag)
+
+	pes := make([]byte, 0)
+	for i := 0; i < len(stream); i += TS_PACKET_SIZE {
+		packet := stream[i : i+TS_PACKET_SIZE]
+		if tsPacketPid(packet) == metadataPid {
+			pes = append(pes, tsPayload(packet)...)
+		}
+	}
+
+	if len(pes) < 9 || !bytes.Equal(pes[:3], []byte{0x00, 0x00, 0x01}) || pes[3] != 0xBD {
+		t.Fatalf("invalid pes of metadata. %x", pes)
+	}
+
+	data, timestamp, err := parsePes(pes)
+	if err != nil {
+		t.Fatal("pes parsing fail. ", err)
+	}
+
+	if !bytes.Equal(data, id3Tag) {
+		t.Fatalf("id3 tag is different. %x", data)
+	}
+
+	if timestamp.Pts != 10 {
+		t.Fatal("invalid pts of metadata. ", timestamp.Pts)
+	}
+}
@@ -28,6 +28,11 @@ import (
 	rtmpCodec "github.com/yapingcat/gomedia/go-codec"
 )
 
+// IsKeyFrame check IDR frame without changing timestamp of frame
+func IsKeyFrame(frame *VideoFrame) bool {
+	return rtmpCodec.IsH264IDRFrame(frame.Data())
+}
+
 func CheckIsIDRFrame(frame *VideoFrame) bool {
 	isIDRFrame := false
 	if rtmpCodec.IsH264IDRFrame(frame.Data()) {
@@ -45,3 +50,44 @@ func CheckIsIDRFrame(frame *VideoFrame) bool {
 
 	return isIDRFrame
 }
+
+// IsDisposableFrame check every slice of frame has zero nal_ref_idc.
+// disposable frame is not referenced by other frames so that it can be dropped without breaking decoder
+func IsDisposableFrame(frame *VideoFrame) bool {
+	disposable := false
+	rtmpCodec.SplitFrameWithStartCode(frame.Data(),
+		func(nalu []byte) bool {
+			if rtmpCodec.H264NaluType(nalu) > rtmpCodec.H264_NAL_I_SLICE {
+				return true
+			}
+
+			header, exist := naluHeader(nalu)
+			disposable = exist && header&0x60 == 0
+			return disposable
+		})
+	return disposable
+}
+
+// naluHeader return first byte after start code
+func naluHeader(nalu []byte) (byte, bool) {
+	payload := trimStartCode(nalu)
+	if len(payload) == 0 {
+		return 0, false
+	}
+	return payload[0], true
+}
+
+// trimStartCode return nal unit without start code. nil when start code is not found
+func trimStartCode(nalu []byte) []byte {
+	for i, value := range nalu {
+		if value == 0 {
+			continue
+		}
+
+		if value != 1 {
+			return nil
+		}
+		return nalu[i+1:]
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playauth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+const (
+	// validated token of player is reused while playlist or manifest is reloaded
+	TOKEN_CACHE_TTL = 60 * time.Second
+)
+
+// ValidateHandler validate token of player about stream of name
+type ValidateHandler func(name, token, remoteAddr string) error
+
+// Validator validate token of player for every resource of playback.
+// validated token is cached until stream is removed or TOKEN_CACHE_TTL
+type Validator struct {
+	onValidate ValidateHandler
+
+	mutex  sync.Mutex
+	tokens map[string]time.Time
+}
+
+func NewValidator(onValidate ValidateHandler) *Validator {
+	return &Validator{
+		onValidate: onValidate,
+		tokens:     make(map[string]time.Time),
+	}
+}
+
+// Validate token of player. remoteAddr is host:port of request
+func (v *Validator) Validate(name, token, remoteAddr string) error {
+	key := name + "?" + token
+
+	v.mutex.Lock()
+	expiredAt, exist := v.tokens[key]
+	v.mutex.Unlock()
+
+	if exist && time.Now().Before(expiredAt) {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if err := v.onValidate(name, token, host); err != nil {
+		return err
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	now := time.Now()
+	for cached, expiredAt := range v.tokens {
+		if now.After(expiredAt) {
+			delete(v.tokens, cached)
+		}
+	}
+	v.tokens[key] = now.Add(TOKEN_CACHE_TTL)
+	return nil
+}
+
+// Remove cached tokens of stream
+func (v *Validator) Remove(name string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	prefix := name + "?"
+	for key := range v.tokens {
+		if strings.HasPrefix(key, prefix) {
+			delete(v.tokens, key)
+		}
+	}
+}
+
+// StatusOf return status code of response about error of Validate
+func StatusOf(err error) int {
+	if errors.Is(err, rtmp.ErrStreamNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusForbidden
+}
+
+// WriteCorsHeader write CORS header of GET. empty allowOrigin allow any origin
+func WriteCorsHeader(w http.ResponseWriter, allowOrigin string) {
+	if len(allowOrigin) == 0 {
+		allowOrigin = "*"
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+}
+
+// SplitPath split path of request under prefix into elements. empty element is not allowed
+func SplitPath(r *http.Request, prefix string) ([]string, bool) {
+	path, exist := strings.CutPrefix(r.URL.Path, prefix+"/")
+	if !exist {
+		return nil, false
+	}
+
+	elements := strings.Split(path, "/")
+	for _, element := range elements {
+		if len(element) == 0 {
+			return nil, false
+		}
+	}
+	return elements, true
+}
+
+// TokenQuery return query of token which is added to URI of media. it is empty without token
+func TokenQuery(token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+	return "?" + url.Values{"token": {token}}.Encode()
+}
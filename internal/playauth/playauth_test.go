@@ -0,0 +1,102 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+func TestValidatorCacheToken(t *testing.T) {
+	calls := 0
+	validator := NewValidator(func(name, token, remoteAddr string) error {
+		calls++
+		if remoteAddr != "192.0.2.1" {
+			t.Fatal("port is not removed from remote address. ", remoteAddr)
+		}
+
+		if token != "good" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := validator.Validate("1", "good", "192.0.2.1:5000"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatal("validated token is not cached. ", calls)
+	}
+
+	if err := validator.Validate("1", "bad", "192.0.2.1:5000"); err == nil {
+		t.Fatal("invalid token is accepted")
+	}
+
+	validator.Remove("1")
+	if err := validator.Validate("1", "good", "192.0.2.1:5000"); err != nil || calls != 3 {
+		t.Fatal("token of removed stream is cached. ", err, calls)
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	if status := StatusOf(fmt.Errorf("wrap. %w", rtmp.ErrStreamNotFound)); status != http.StatusNotFound {
+		t.Fatal("invalid status of not found stream. ", status)
+	}
+
+	if status := StatusOf(errors.New("invalid token")); status != http.StatusForbidden {
+		t.Fatal("invalid status of rejected token. ", status)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	elements, exist := SplitPath(httptest.NewRequest(http.MethodGet, "/hls/1/0.ts?token=good", nil), "/hls")
+	if !exist || len(elements) != 2 || elements[0] != "1" || elements[1] != "0.ts" {
+		t.Fatal("invalid elements. ", elements, exist)
+	}
+
+	for _, target := range []string{"/dash/1/0.ts", "/hls/1//0.ts", "/hls/"} {
+		if elements, exist := SplitPath(httptest.NewRequest(http.MethodGet, target, nil), "/hls"); exist {
+			t.Fatal(target, " is split. ", elements)
+		}
+	}
+}
+
+func TestTokenQuery(t *testing.T) {
+	if query := TokenQuery(""); len(query) != 0 {
+		t.Fatal("query without token. ", query)
+	}
+
+	if query := TokenQuery("a$b&c"); query != "?token=a%24b%26c" {
+		t.Fatal("token is not escaped. ", query)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playback
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	DEFAULT_QUEUE_SIZE = 1024
+)
+
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Hub keep live streams which can be played by name.
+// source of session is named by stream id and rendition is named by "{streamId}_{rendition}"
+type Hub struct {
+	queueSize int
+
+	mutex   sync.Mutex
+	streams map[string]*Stream
+}
+
+func NewHub(queueSize int) *Hub {
+	if queueSize <= 0 {
+		queueSize = DEFAULT_QUEUE_SIZE
+	}
+
+	return &Hub{
+		queueSize: queueSize,
+		streams:   make(map[string]*Stream),
+	}
+}
+
+// Open create stream of name which replay gopCache to new viewer. previous stream of same name is closed
+func (h *Hub) Open(name string, gopCache *media.GopCache) *Stream {
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, name).Info("[Hub][Open]")
+	stream := newStream(name, h.queueSize, gopCache)
+
+	h.mutex.Lock()
+	previous := h.streams[name]
+	h.streams[name] = stream
+	h.mutex.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+	return stream
+}
+
+// Close remove stream and close queue of viewers
+func (h *Hub) Close(stream *Stream) {
+	logging.PlaybackStream(logging.COMPONENT_PLAYBACK, stream.name).Info("[Hub][Close]")
+
+	h.mutex.Lock()
+	if target, exist := h.streams[stream.name]; exist && target == stream {
+		delete(h.streams, stream.name)
+	}
+	h.mutex.Unlock()
+
+	stream.close()
+}
+
+// Subscribe create viewer which start from cached GOP of stream
+func (h *Hub) Subscribe(name string) (*Viewer, error) {
+	h.mutex.Lock()
+	stream, exist := h.streams[name]
+	h.mutex.Unlock()
+
+	if !exist {
+		return nil, ErrStreamNotFound
+	}
+	return stream.Subscribe()
+}
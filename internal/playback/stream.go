@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playback
+
+import (
+	"sync"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// Stream fan out frames of publisher to viewers.
+// writer is never blocked by viewer because each viewer has bounded queue, except lossless viewer
+// of in process consumer like segmenter which is faster than real time
+type Stream struct {
+	name      string
+	queueSize int
+	gopCache  *media.GopCache
+
+	mutex   sync.Mutex
+	viewers map[*Viewer]struct{}
+	closed  bool
+}
+
+func newStream(name string, queueSize int, gopCache *media.GopCache) *Stream {
+	return &Stream{
+		name:      name,
+		queueSize: queueSize,
+		gopCache:  gopCache,
+		viewers:   make(map[*Viewer]struct{}),
+		closed:    false,
+	}
+}
+
+func (s *Stream) Name() string {
+	return s.name
+}
+
+func (s *Stream) GopCache() *media.GopCache {
+	return s.gopCache
+}
+
+// WriteVideo copy frame because buffer of frame is reused by demuxer
+func (s *Stream) WriteVideo(frame *media.VideoFrame) {
+	frame = frame.Clone()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.gopCache.WriteVideo(frame)
+	s.write(frame, media.IsKeyFrame(frame))
+}
+
+func (s *Stream) WriteAudio(frame *media.AudioFrame) {
+	frame = frame.Clone()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.gopCache.WriteAudio(frame)
+	s.write(frame, false)
+}
+
+func (s *Stream) write(frame media.Frame, keyFrame bool) {
+	for viewer := range s.viewers {
+		viewer.push(frame, keyFrame)
+	}
+}
+
+// Subscribe start viewer from cached GOP. cache and viewers are updated under same lock
+// so that frame is neither lost nor duplicated between replay and live
+func (s *Stream) Subscribe() (*Viewer, error) {
+	return s.subscribe(false)
+}
+
+// SubscribeLossless start viewer which never drop frames. writer is blocked while queue of viewer is full
+// so that viewer should be read by in process consumer which never stall
+func (s *Stream) SubscribeLossless() (*Viewer, error) {
+	return s.subscribe(true)
+}
+
+func (s *Stream) subscribe(lossless bool) (*Viewer, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrStreamNotFound
+	}
+
+	// queue keep whole cached GOP in addition to queue size so that replay never drop tail of GOP
+	frames, base, exist := s.gopCache.Frames()
+	viewer := newViewer(s, s.queueSize+len(frames), lossless)
+	if exist {
+		viewer.replay(frames, base)
+	}
+
+	s.viewers[viewer] = struct{}{}
+	return viewer, nil
+}
+
+func (s *Stream) unsubscribe(viewer *Viewer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exist := s.viewers[viewer]; exist {
+		delete(s.viewers, viewer)
+		viewer.close()
+	}
+}
+
+func (s *Stream) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.gopCache.Reset()
+	for viewer := range s.viewers {
+		viewer.close()
+	}
+	s.viewers = make(map[*Viewer]struct{})
+}
@@ -0,0 +1,230 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playback
+
+import (
+	"testing"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+var (
+	// SPS, PPS and IDR slice
+	testKeyFrame = []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+		0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+	}
+
+	// P slice which is referenced
+	testPFrame = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x00, 0x10}
+
+	// P slice whose nal_ref_idc is zero
+	testDisposableFrame = []byte{0x00, 0x00, 0x00, 0x01, 0x01, 0x9a, 0x00, 0x10}
+)
+
+func videoFrame(dts uint64, data []byte) *media.VideoFrame {
+	return media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{Pts: dts, Dts: dts}, data, len(data) == len(testKeyFrame))
+}
+
+func audioFrame(dts uint64) *media.AudioFrame {
+	return media.NewAudioFrame(media.CODEC_AUDIO_AAC, media.Timestamp{Pts: dts, Dts: dts}, []byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x7F, 0xFC, 0x21})
+}
+
+func openTestStream(t *testing.T, queueSize int) *Stream {
+	t.Helper()
+
+	hub := NewHub(queueSize)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	t.Cleanup(func() {
+		hub.Close(stream)
+	})
+	return stream
+}
+
+func subscribe(t *testing.T, stream *Stream) *Viewer {
+	t.Helper()
+
+	viewer, err := stream.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return viewer
+}
+
+// drain return timestamps of queued frames
+func drain(viewer *Viewer) []uint64 {
+	values := make([]uint64, 0)
+	for {
+		select {
+		case frame, ok := <-viewer.Frames():
+			if !ok {
+				return values
+			}
+			values = append(values, frame.Timestamp().Dts)
+		default:
+			return values
+		}
+	}
+}
+
+func expectFrames(t *testing.T, viewer *Viewer, expected ...uint64) {
+	t.Helper()
+
+	values := drain(viewer)
+	if len(values) != len(expected) {
+		t.Fatal("invalid frames. ", values)
+	}
+
+	for i := range values {
+		if values[i] != expected[i] {
+			t.Fatal("invalid frames. ", values)
+		}
+	}
+}
+
+func TestViewerWaitKeyFrame(t *testing.T) {
+	stream := openTestStream(t, 16)
+	viewer := subscribe(t, stream)
+
+	stream.WriteAudio(audioFrame(960))
+	stream.WriteVideo(videoFrame(980, testPFrame))
+	stream.WriteVideo(videoFrame(1000, testKeyFrame))
+	stream.WriteAudio(audioFrame(1020))
+	stream.WriteVideo(videoFrame(1040, testPFrame))
+
+	// timestamps start from key frame
+	expectFrames(t, viewer, 0, 20, 40)
+}
+
+func TestViewerDropUntilKeyFrameWhenQueueFull(t *testing.T) {
+	stream := openTestStream(t, 4)
+	viewer := subscribe(t, stream)
+
+	for i := uint64(0); i < 6; i++ {
+		data := testPFrame
+		if i == 0 {
+			data = testKeyFrame
+		}
+		stream.WriteVideo(videoFrame(i*40, data))
+	}
+	expectFrames(t, viewer, 0, 40, 80, 120)
+
+	// frames after full queue are dropped until next key frame even when queue is drained
+	stream.WriteVideo(videoFrame(240, testPFrame))
+	stream.WriteAudio(audioFrame(250))
+	stream.WriteVideo(videoFrame(280, testKeyFrame))
+	stream.WriteVideo(videoFrame(320, testPFrame))
+	expectFrames(t, viewer, 280, 320)
+}
+
+func TestViewerDropDisposableFrameWhenCongested(t *testing.T) {
+	stream := openTestStream(t, 4)
+	viewer := subscribe(t, stream)
+
+	stream.WriteVideo(videoFrame(0, testKeyFrame))
+	stream.WriteVideo(videoFrame(40, testDisposableFrame))
+	stream.WriteVideo(videoFrame(80, testPFrame))
+
+	// queue is over half
+	stream.WriteVideo(videoFrame(120, testDisposableFrame))
+	stream.WriteVideo(videoFrame(160, testPFrame))
+	expectFrames(t, viewer, 0, 40, 80, 160)
+}
+
+func TestLateJoinReplayGop(t *testing.T) {
+	stream := openTestStream(t, 16)
+	stream.WriteVideo(videoFrame(960, testKeyFrame))
+	stream.WriteVideo(videoFrame(1000, testKeyFrame))
+	stream.WriteAudio(audioFrame(1010))
+	stream.WriteVideo(videoFrame(1040, testPFrame))
+
+	viewer := subscribe(t, stream)
+	stream.WriteVideo(videoFrame(1080, testPFrame))
+
+	// cached GOP from last key frame and live frames share base
+	expectFrames(t, viewer, 0, 10, 40, 80)
+}
+
+func TestReplayGopLargerThanQueue(t *testing.T) {
+	stream := openTestStream(t, 2)
+	stream.WriteVideo(videoFrame(0, testKeyFrame))
+	for i := uint64(1); i < 10; i++ {
+		stream.WriteVideo(videoFrame(i*40, testPFrame))
+	}
+
+	viewer := subscribe(t, stream)
+	stream.WriteVideo(videoFrame(400, testPFrame))
+	expectFrames(t, viewer, 0, 40, 80, 120, 160, 200, 240, 280, 320, 360, 400)
+}
+
+func TestViewerIsClosedWithStream(t *testing.T) {
+	hub := NewHub(4)
+	stream := hub.Open("1", media.NewGopCache(0, 0))
+	viewer := subscribe(t, stream)
+
+	hub.Close(stream)
+	if _, ok := <-viewer.Frames(); ok {
+		t.Fatal("queue of viewer is not closed")
+	}
+
+	if _, err := hub.Subscribe("1"); err != ErrStreamNotFound {
+		t.Fatal("closed stream is subscribed. ", err)
+	}
+}
+
+func TestLosslessViewerBlockWriter(t *testing.T) {
+	stream := openTestStream(t, 2)
+	viewer, err := stream.SubscribeLossless()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []uint64)
+	go func() {
+		values := make([]uint64, 0)
+		for frame := range viewer.Frames() {
+			values = append(values, frame.Timestamp().Dts)
+		}
+		received <- values
+	}()
+
+	stream.WriteVideo(videoFrame(0, testKeyFrame))
+	for i := uint64(1); i < 20; i++ {
+		stream.WriteVideo(videoFrame(i*40, testDisposableFrame))
+	}
+	viewer.Close()
+
+	values := <-received
+	if len(values) != 20 {
+		t.Fatal("frames are dropped. ", values)
+	}
+
+	for i, value := range values {
+		if value != uint64(i)*40 {
+			t.Fatal("invalid order of frames. ", values)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playback
+
+import (
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// TsInput demux mpeg ts of transcoded rendition into stream
+type TsInput struct {
+	demuxer *media.TsDemuxer
+	stream  *Stream
+}
+
+func NewTsInput(stream *Stream) *TsInput {
+	demuxer := media.NewTsDemuxer()
+	demuxer.OnVideoFrame = stream.WriteVideo
+	demuxer.OnAudioFrame = stream.WriteAudio
+
+	return &TsInput{
+		demuxer: demuxer,
+		stream:  stream,
+	}
+}
+
+func (t *TsInput) Stream() *Stream {
+	return t.stream
+}
+
+func (t *TsInput) Input(data []byte) error {
+	return t.demuxer.Input(data)
+}
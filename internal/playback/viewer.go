@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package playback
+
+import (
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// Viewer receive frames of stream through bounded queue.
+// disposable video frames are dropped while queue is over half so that slow viewer catch up without broken picture.
+// when queue is full, frames are dropped until next key frame so that decoder of viewer is not broken.
+// lossless viewer block writer instead of dropping frames.
+// timestamps of frames start from zero
+type Viewer struct {
+	stream   *Stream
+	frames   chan media.Frame
+	lossless bool
+
+	// guarded by mutex of stream
+	waitKeyFrame bool
+	started      bool
+	base         uint64
+	dropped      int
+}
+
+func newViewer(stream *Stream, queueSize int, lossless bool) *Viewer {
+	return &Viewer{
+		stream:       stream,
+		frames:       make(chan media.Frame, queueSize),
+		lossless:     lossless,
+		waitKeyFrame: true,
+		started:      false,
+		base:         0,
+		dropped:      0,
+	}
+}
+
+// Frames is closed when stream is closed or viewer is closed
+func (v *Viewer) Frames() <-chan media.Frame {
+	return v.frames
+}
+
+func (v *Viewer) StreamName() string {
+	return v.stream.name
+}
+
+func (v *Viewer) Close() {
+	v.stream.unsubscribe(v)
+}
+
+// replay enqueue cached GOP which is already rebased
+func (v *Viewer) replay(frames []media.Frame, base uint64) {
+	v.waitKeyFrame = false
+	v.started = true
+	v.base = base
+
+	for _, frame := range frames {
+		if v.waitKeyFrame {
+			v.dropped++
+			continue
+		}
+		v.enqueue(frame)
+	}
+}
+
+// congested is true when queue is filled over half
+func (v *Viewer) congested() bool {
+	return len(v.frames)*2 > cap(v.frames)
+}
+
+func (v *Viewer) push(frame media.Frame, keyFrame bool) {
+	if v.waitKeyFrame {
+		if !keyFrame {
+			v.dropped++
+			return
+		}
+		v.waitKeyFrame = false
+
+		if !v.started {
+			v.started = true
+			v.base = frame.Timestamp().Dts
+		}
+	}
+
+	if video, ok := frame.(*media.VideoFrame); ok && !v.lossless && !keyFrame && v.congested() && media.IsDisposableFrame(video) {
+		v.dropped++
+		return
+	}
+
+	v.enqueue(media.RebaseFrame(frame, v.base))
+}
+
+func (v *Viewer) enqueue(frame media.Frame) {
+	if v.lossless {
+		v.frames <- frame
+		return
+	}
+
+	select {
+	case v.frames <- frame:
+	default:
+		logging.PlaybackStream(logging.COMPONENT_PLAYBACK, v.stream.name).Warn("[Viewer][push] queue is full. drop frames until next key frame")
+		v.waitKeyFrame = true
+		v.dropped++
+	}
+}
+
+func (v *Viewer) close() {
+	if v.dropped > 0 {
+		logging.PlaybackStream(logging.COMPONENT_PLAYBACK, v.stream.name).Info("[Viewer][close] dropped frames : ", v.dropped)
+	}
+	close(v.frames)
+}
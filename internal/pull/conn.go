@@ -0,0 +1,234 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pull
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	APP_NAME = "pull"
+
+	PACKET_VIDEO       byte = 1
+	PACKET_AUDIO       byte = 2
+	PACKET_HEADER_SIZE      = 17
+
+	PACKET_QUEUE_SIZE = 1024
+)
+
+var (
+	ErrReceiveOnly    = errors.New("pull connection is receive only")
+	ErrUnsupportedUrl = errors.New("unsupported pull url")
+	errInvalidPacket  = errors.New("invalid pull packet")
+)
+
+// Conn is transporter of pulled source.
+// Read return a H.264 access unit or an AAC frame with packet header of kind, pts and dts.
+// connection is closed when source is finished.
+type Conn struct {
+	id        string
+	streamKey string
+	sourceUrl *url.URL
+	startedAt time.Time
+
+	queue        chan []byte
+	readDeadline time.Time
+	deadlineLock sync.Mutex
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	mutex     sync.Mutex
+	onClose   func()
+}
+
+func NewConn(id, streamKey, sourceUrl string) (*Conn, error) {
+	u, err := url.Parse(sourceUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "rtmp", "http", "https":
+	default:
+		return nil, ErrUnsupportedUrl
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		id:        id,
+		streamKey: streamKey,
+		sourceUrl: u,
+		startedAt: time.Now(),
+		queue:     make(chan []byte, PACKET_QUEUE_SIZE),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+func (c *Conn) Id() string {
+	return c.id
+}
+
+func (c *Conn) StreamKey() string {
+	return c.streamKey
+}
+
+func (c *Conn) Url() string {
+	return c.sourceUrl.String()
+}
+
+// RedactedUrl return url of source for logging. password and query which may carry credentials are removed
+func (c *Conn) RedactedUrl() string {
+	redacted := *c.sourceUrl
+	redacted.RawQuery = ""
+	redacted.Fragment = ""
+	return redacted.Redacted()
+}
+
+func (c *Conn) StartedAt() time.Time {
+	return c.startedAt
+}
+
+// SetCloseHandler regist handler which is called once when connection is closed
+func (c *Conn) SetCloseHandler(onClose func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onClose = onClose
+}
+
+// Open start pulling source on background
+func (c *Conn) Open() {
+	go func() {
+		var err error
+		switch {
+		case c.sourceUrl.Scheme == "rtmp":
+			err = c.pullRtmp()
+		case strings.HasSuffix(c.sourceUrl.Path, ".m3u8"):
+			err = c.pullHls()
+		default:
+			err = c.pullFlv()
+		}
+
+		if err != nil && c.ctx.Err() == nil {
+			logging.Component(logging.COMPONENT_PULL).WithField(logging.FIELD_CONNECTION_ID, c.id).Warn("[PullConn][Open] pull fail. ", err)
+		}
+		c.Close()
+	}()
+}
+
+func (c *Conn) pushVideo(frame *media.VideoFrame) {
+	c.push(PACKET_VIDEO, frame.Timestamp(), frame.Data())
+}
+
+func (c *Conn) pushAudio(frame *media.AudioFrame) {
+	c.push(PACKET_AUDIO, frame.Timestamp(), frame.Data())
+}
+
+func (c *Conn) push(kind byte, timestamp media.Timestamp, data []byte) {
+	packet := make([]byte, PACKET_HEADER_SIZE+len(data))
+	packet[0] = kind
+	binary.BigEndian.PutUint64(packet[1:9], timestamp.Pts)
+	binary.BigEndian.PutUint64(packet[9:PACKET_HEADER_SIZE], timestamp.Dts)
+	copy(packet[PACKET_HEADER_SIZE:], data)
+
+	// source is read as fast as session consume. live source is not faster than real time
+	select {
+	case c.queue <- packet:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *Conn) Read() ([]byte, error) {
+	select {
+	case packet := <-c.queue:
+		return packet, nil
+	default:
+	}
+
+	c.deadlineLock.Lock()
+	deadline := c.readDeadline
+	c.deadlineLock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.queue:
+		return packet, nil
+	case <-c.ctx.Done():
+		return nil, io.EOF
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Write(_ []byte) error {
+	return ErrReceiveOnly
+}
+
+// SetReadDeadline set deadline of Read. zero value means no deadline.
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.readDeadline = deadline
+	return nil
+}
+
+func (c *Conn) RemoteAddr() string {
+	return c.sourceUrl.Host
+}
+
+func (c *Conn) Close() {
+	c.closeOnce.Do(
+		func() {
+			c.cancel()
+
+			c.mutex.Lock()
+			onClose := c.onClose
+			c.mutex.Unlock()
+
+			if onClose != nil {
+				onClose()
+			}
+		})
+}
@@ -0,0 +1,78 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pull
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/yapingcat/gomedia/go-codec"
+	"github.com/yapingcat/gomedia/go-flv"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// pullFlv read HTTP-FLV stream until server finish response
+func (c *Conn) pullFlv() error {
+	request, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.sourceUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("http-flv response %d", response.StatusCode)
+	}
+
+	reader := flv.CreateFlvReader()
+	reader.OnFrame = func(cid codec.CodecID, frame []byte, pts, dts uint32) {
+		timestamp := media.Timestamp{Pts: uint64(pts), Dts: uint64(dts)}
+		switch cid {
+		case codec.CODECID_VIDEO_H264:
+			c.pushVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, timestamp, frame, false))
+		case codec.CODECID_AUDIO_AAC:
+			c.pushAudio(media.NewAudioFrame(media.CODEC_AUDIO_AAC, timestamp, frame))
+		}
+	}
+
+	buffer := make([]byte, READ_BUFFER_SIZE)
+	for {
+		n, err := response.Body.Read(buffer)
+		if n > 0 {
+			if err := reader.Input(buffer[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pull
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	HLS_REQUEST_TIMEOUT = 10 * time.Second
+	HLS_MAX_BODY_SIZE   = 64 * 1024 * 1024
+
+	// live playlist is started from last segments like player
+	HLS_LIVE_START_SEGMENTS = 3
+)
+
+type hlsSegment struct {
+	sequence int
+	uri      *url.URL
+}
+
+type hlsPlaylist struct {
+	targetDuration int
+	endList        bool
+	segments       []hlsSegment
+	variants       []hlsVariant
+}
+
+type hlsVariant struct {
+	bandwidth int
+	uri       *url.URL
+}
+
+// pullHls poll media playlist and demux new segments.
+// highest bandwidth is selected when url is master playlist
+func (c *Conn) pullHls() error {
+	client := &http.Client{Timeout: HLS_REQUEST_TIMEOUT}
+
+	demuxer := media.NewTsDemuxer()
+	demuxer.OnVideoFrame = c.pushVideo
+	demuxer.OnAudioFrame = c.pushAudio
+
+	playlistUrl := c.sourceUrl
+	lastSequence := -1
+	for {
+		playlist, err := c.fetchPlaylist(client, playlistUrl)
+		if err != nil {
+			return err
+		}
+
+		if len(playlist.variants) > 0 {
+			playlistUrl = selectVariant(playlist.variants)
+			continue
+		}
+
+		segments := playlist.segments
+		if lastSequence < 0 && !playlist.endList && len(segments) > HLS_LIVE_START_SEGMENTS {
+			segments = segments[len(segments)-HLS_LIVE_START_SEGMENTS:]
+		}
+
+		for _, segment := range segments {
+			if segment.sequence <= lastSequence {
+				continue
+			}
+
+			data, err := c.fetch(client, segment.uri)
+			if err != nil {
+				return err
+			}
+
+			if err := demuxer.Input(data); err != nil {
+				return err
+			}
+			lastSequence = segment.sequence
+		}
+
+		if playlist.endList {
+			demuxer.Flush()
+			return nil
+		}
+
+		// playlist is reloaded about half of target duration
+		interval := time.Duration(playlist.targetDuration) * time.Second / 2
+		if interval <= 0 {
+			interval = time.Second
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-c.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *Conn) fetch(client *http.Client, u *url.URL) ([]byte, error) {
+	request, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("hls response %d from %s", response.StatusCode, u.Path)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, HLS_MAX_BODY_SIZE))
+}
+
+func (c *Conn) fetchPlaylist(client *http.Client, u *url.URL) (*hlsPlaylist, error) {
+	data, err := c.fetch(client, u)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlaylist(data, u)
+}
+
+func parsePlaylist(data []byte, base *url.URL) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, errors.New("invalid m3u8 playlist")
+	}
+
+	playlist := &hlsPlaylist{}
+	sequence := 0
+	bandwidth := -1
+	segment := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			playlist.targetDuration, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-ENDLIST"):
+			playlist.endList = true
+		case strings.HasPrefix(line, "#EXTINF:"):
+			segment = true
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			bandwidth = attributeInt(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"), "BANDWIDTH")
+		case strings.HasPrefix(line, "#"):
+		default:
+			uri, err := base.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+
+			if bandwidth >= 0 {
+				playlist.variants = append(playlist.variants, hlsVariant{bandwidth: bandwidth, uri: uri})
+				bandwidth = -1
+			} else if segment {
+				playlist.segments = append(playlist.segments, hlsSegment{sequence: sequence, uri: uri})
+				sequence++
+				segment = false
+			}
+		}
+	}
+	return playlist, scanner.Err()
+}
+
+func attributeInt(attributes, name string) int {
+	for _, attribute := range strings.Split(attributes, ",") {
+		key, value, found := strings.Cut(attribute, "=")
+		if found && key == name {
+			result, _ := strconv.Atoi(value)
+			return result
+		}
+	}
+	return 0
+}
+
+func selectVariant(variants []hlsVariant) *url.URL {
+	selected := variants[0]
+	for _, variant := range variants[1:] {
+		if variant.bandwidth > selected.bandwidth {
+			selected = variant
+		}
+	}
+	return selected.uri
+}
@@ -0,0 +1,133 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package pull
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+)
+
+const (
+	DEFAULT_RTMP_PORT = "1935"
+	CONNECT_TIMEOUT   = 5 * time.Second
+	READ_BUFFER_SIZE  = 64 * 1024
+)
+
+// pullRtmp play remote rtmp stream with rtmp.Client
+func (c *Conn) pullRtmp() error {
+	appName, streamKey, found := strings.Cut(strings.TrimPrefix(c.sourceUrl.Path, "/"), "/")
+	if !found || len(appName) == 0 || len(streamKey) == 0 {
+		return errors.New("url should be rtmp://host/app/stream")
+	}
+
+	if len(c.sourceUrl.RawQuery) > 0 {
+		streamKey += "?" + c.sourceUrl.RawQuery
+	}
+
+	address := c.sourceUrl.Host
+	if len(c.sourceUrl.Port()) == 0 {
+		address = net.JoinHostPort(c.sourceUrl.Hostname(), DEFAULT_RTMP_PORT)
+	}
+
+	dialer := net.Dialer{Timeout: CONNECT_TIMEOUT}
+	conn, err := dialer.DialContext(c.ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	handler := &rtmpPlayer{conn: c, failed: make(chan string, 1)}
+	options := rtmp.ClientOptions{Host: c.sourceUrl.Host, AppName: appName, StreamKey: streamKey}
+	client, err := rtmp.NewClientWithOpen(options, transport.NewSocketTransporter(conn, READ_BUFFER_SIZE), handler)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// unblock Run when pull is stopped
+	release := c.afterClose(client.Close)
+	defer release()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Run()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case reason := <-handler.failed:
+		client.Close()
+		return errors.New("play is rejected. " + reason)
+	}
+}
+
+// afterClose call f when connection is closed. returned function release it
+func (c *Conn) afterClose(f func()) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			f()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// rtmpPlayer receive state and frames of rtmp.Client
+type rtmpPlayer struct {
+	conn   *Conn
+	reason string
+	failed chan string
+}
+
+func (p *rtmpPlayer) OnStateChange(state rtmp.ClientState) {
+	if state == rtmp.CLIENT_STATE_FAILED {
+		select {
+		case p.failed <- p.reason:
+		default:
+		}
+	}
+}
+
+func (p *rtmpPlayer) OnError(code, describe string) {
+	p.reason = code + " " + describe
+}
+
+func (p *rtmpPlayer) OnVideoFrame(frame *media.VideoFrame) {
+	p.conn.pushVideo(frame)
+}
+
+func (p *rtmpPlayer) OnAudioFrame(frame *media.AudioFrame) {
+	p.conn.pushAudio(frame)
+}
@@ -0,0 +1,244 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package record
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	FILE_TIME_FORMAT = "20060102150405"
+)
+
+type Options struct {
+	Path   string
+	Format string
+
+	// file is rotated at key frame when size or duration exceed. 0 is unlimited
+	MaxSize     int64
+	MaxDuration time.Duration
+}
+
+type File struct {
+	Path     string
+	Size     int64
+	Duration time.Duration
+	Closed   bool
+}
+
+type Status struct {
+	StreamId  int
+	Format    string
+	StartedAt time.Time
+	Files     []File
+}
+
+// Recorder write untouched frames of source to files of stream.
+// each file start at key frame with its timestamps rebased to zero
+type Recorder struct {
+	streamId    int
+	options     Options
+	directory   string
+	videoHeader func() []byte
+	startedAt   time.Time
+
+	mutex   sync.Mutex
+	writer  fileWriter
+	files   []File
+	base    uint64
+	stopped bool
+
+	// closing files which are finished by own goroutine
+	closing sync.WaitGroup
+}
+
+// NewRecorder create directory of stream. videoHeader return SPS and PPS
+// which are inserted to first key frame of file when key frame does not carry them
+func NewRecorder(streamId int, options Options, videoHeader func() []byte) (*Recorder, error) {
+	if options.Format != FORMAT_MP4 {
+		options.Format = FORMAT_FLV
+	}
+
+	directory := filepath.Join(options.Path, strconv.Itoa(streamId))
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		streamId:    streamId,
+		options:     options,
+		directory:   directory,
+		videoHeader: videoHeader,
+		startedAt:   time.Now(),
+		files:       make([]File, 0),
+	}, nil
+}
+
+func (r *Recorder) StreamId() int {
+	return r.streamId
+}
+
+// WriteVideo open new file at key frame when file is not opened or it should be rotated
+func (r *Recorder) WriteVideo(frame *media.VideoFrame) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	if media.IsKeyFrame(frame) && (r.writer == nil || r.shouldRotate(frame.Dts())) {
+		r.closeFile()
+		r.openFile(frame.Dts())
+		frame = media.WithParameterSets(frame, r.videoHeader())
+	}
+
+	if r.writer == nil {
+		return
+	}
+
+	frame = media.RebaseFrame(frame, r.base).(*media.VideoFrame)
+	if err := r.writer.writeVideo(frame); err != nil {
+		logging.Stream(logging.COMPONENT_RECORD, r.streamId).Warn("[Recorder][WriteVideo] write fail. ", err)
+		r.closeFile()
+		return
+	}
+	r.update(frame.Dts())
+}
+
+// WriteAudio drop frame until first key frame
+func (r *Recorder) WriteAudio(frame *media.AudioFrame) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped || r.writer == nil {
+		return
+	}
+
+	frame = media.RebaseFrame(frame, r.base).(*media.AudioFrame)
+	if err := r.writer.writeAudio(frame); err != nil {
+		logging.Stream(logging.COMPONENT_RECORD, r.streamId).Warn("[Recorder][WriteAudio] write fail. ", err)
+		r.closeFile()
+		return
+	}
+	r.update(frame.Dts())
+}
+
+// Stop close current file and wait until files are finished
+func (r *Recorder) Stop() {
+	r.mutex.Lock()
+	r.stopped = true
+	r.closeFile()
+	r.mutex.Unlock()
+
+	r.closing.Wait()
+	logging.Stream(logging.COMPONENT_RECORD, r.streamId).Info("[Recorder][Stop] recording is stopped")
+}
+
+func (r *Recorder) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	files := make([]File, len(r.files))
+	copy(files, r.files)
+	return Status{
+		StreamId:  r.streamId,
+		Format:    r.options.Format,
+		StartedAt: r.startedAt,
+		Files:     files,
+	}
+}
+
+func (r *Recorder) shouldRotate(dts uint64) bool {
+	if r.options.MaxSize > 0 && r.writer.size() >= r.options.MaxSize {
+		return true
+	}
+
+	duration := time.Duration(dts-min(dts, r.base)) * time.Millisecond
+	return r.options.MaxDuration > 0 && duration >= r.options.MaxDuration
+}
+
+// openFile name file as {startedAt}_{index} so that rotated file in same second is not overwritten
+func (r *Recorder) openFile(base uint64) {
+	name := fmt.Sprintf("%s_%d.%s", time.Now().Format(FILE_TIME_FORMAT), len(r.files), r.options.Format)
+	path := filepath.Join(r.directory, name)
+
+	writer, err := newFileWriter(r.options.Format, path)
+	if err != nil {
+		logging.Stream(logging.COMPONENT_RECORD, r.streamId).Error("[Recorder][openFile] can not open ", path, ". ", err)
+		return
+	}
+
+	logging.Stream(logging.COMPONENT_RECORD, r.streamId).Info("[Recorder][openFile] record to ", path)
+	r.writer = writer
+	r.base = base
+	r.files = append(r.files, File{Path: path})
+}
+
+func (r *Recorder) update(dts uint64) {
+	current := &r.files[len(r.files)-1]
+	current.Size = r.writer.size()
+	current.Duration = max(current.Duration, time.Duration(dts)*time.Millisecond)
+}
+
+// closeFile finish file by own goroutine because mp4 is rewritten when it is closed
+func (r *Recorder) closeFile() {
+	if r.writer == nil {
+		return
+	}
+
+	writer := r.writer
+	index := len(r.files) - 1
+	path := r.files[index].Path
+	r.writer = nil
+
+	r.closing.Add(1)
+	go func() {
+		defer r.closing.Done()
+
+		err := writer.close()
+		if err != nil {
+			logging.Stream(logging.COMPONENT_RECORD, r.streamId).Error("[Recorder][closeFile] can not finish ", path, ". ", err)
+		}
+
+		info, statErr := os.Stat(path)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if statErr == nil {
+			r.files[index].Size = info.Size()
+		}
+		r.files[index].Closed = err == nil
+	}()
+}
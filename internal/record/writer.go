@@ -0,0 +1,201 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package record
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/yapingcat/gomedia/go-mp4"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/flv"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	FORMAT_FLV = "flv"
+	FORMAT_MP4 = "mp4"
+
+	FLV_BUFFER_SIZE = 64 * 1024
+)
+
+// fileWriter write frames of source to a file. first video frame is key frame which carry SPS and PPS
+type fileWriter interface {
+	writeVideo(frame *media.VideoFrame) error
+	writeAudio(frame *media.AudioFrame) error
+
+	// size return written bytes
+	size() int64
+	close() error
+}
+
+func newFileWriter(format, path string) (fileWriter, error) {
+	switch format {
+	case FORMAT_MP4:
+		return newMp4Writer(path)
+	default:
+		return newFlvWriter(path)
+	}
+}
+
+// flvWriter write FLV tags as they are muxed
+type flvWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	muxer   *flv.Muxer
+	written int64
+}
+
+func newFlvWriter(path string) (*flvWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &flvWriter{
+		file:   file,
+		buffer: bufio.NewWriterSize(file, FLV_BUFFER_SIZE),
+		muxer:  flv.NewMuxer(),
+	}
+
+	header, err := writer.muxer.Header()
+	if err == nil {
+		err = writer.write(header)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *flvWriter) writeVideo(frame *media.VideoFrame) error {
+	return w.writeFrame(frame)
+}
+
+func (w *flvWriter) writeAudio(frame *media.AudioFrame) error {
+	return w.writeFrame(frame)
+}
+
+func (w *flvWriter) writeFrame(frame media.Frame) error {
+	tags, err := w.muxer.WriteFrame(frame)
+	if err != nil {
+		return err
+	}
+	return w.write(tags)
+}
+
+func (w *flvWriter) write(data []byte) error {
+	n, err := w.buffer.Write(data)
+	w.written += int64(n)
+	return err
+}
+
+func (w *flvWriter) size() int64 {
+	return w.written
+}
+
+func (w *flvWriter) close() error {
+	err := w.buffer.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mp4Writer write samples to mdat and moov at end of file when it is closed.
+// moov is moved to front of file after that
+type mp4Writer struct {
+	path       string
+	file       *os.File
+	muxer      *mp4.Movmuxer
+	videoTrack uint32
+	audioTrack uint32
+	hasVideo   bool
+	hasAudio   bool
+	written    int64
+
+	// muxer rewrite start codes in place. frame is shared with other viewers
+	videoBuffer []byte
+}
+
+func newMp4Writer(path string) (*mp4Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	muxer, err := mp4.CreateMp4Muxer(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &mp4Writer{
+		path:  path,
+		file:  file,
+		muxer: muxer,
+	}, nil
+}
+
+func (w *mp4Writer) writeVideo(frame *media.VideoFrame) error {
+	if !w.hasVideo {
+		w.videoTrack = w.muxer.AddVideoTrack(mp4.MP4_CODEC_H264)
+		w.hasVideo = true
+	}
+
+	timestamp := frame.Timestamp()
+	w.videoBuffer = append(w.videoBuffer[:0], frame.Data()...)
+	w.written += int64(len(w.videoBuffer))
+	return w.muxer.Write(w.videoTrack, w.videoBuffer, timestamp.Pts, timestamp.Dts)
+}
+
+func (w *mp4Writer) writeAudio(frame *media.AudioFrame) error {
+	if !w.hasAudio {
+		w.audioTrack = w.muxer.AddAudioTrack(mp4.MP4_CODEC_AAC)
+		w.hasAudio = true
+	}
+
+	timestamp := frame.Timestamp()
+	w.written += int64(len(frame.Data()))
+	return w.muxer.Write(w.audioTrack, frame.Data(), timestamp.Pts, timestamp.Dts)
+}
+
+func (w *mp4Writer) size() int64 {
+	return w.written
+}
+
+func (w *mp4Writer) close() error {
+	err := w.muxer.WriteTrailer()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+	return media.Mp4FastStart(w.path)
+}
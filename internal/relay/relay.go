@@ -0,0 +1,333 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+)
+
+const (
+	STATE_CONNECTING = "connecting"
+	STATE_PUBLISHING = "publishing"
+	STATE_FAILED     = "failed"
+	STATE_STOPPED    = "stopped"
+
+	DEFAULT_RTMP_PORT       = "1935"
+	DEFAULT_CONNECT_TIMEOUT = 5 * time.Second
+	DEFAULT_MIN_BACKOFF     = time.Second
+	DEFAULT_MAX_BACKOFF     = 30 * time.Second
+	DEFAULT_PACKET_SIZE     = 4096
+)
+
+// ErrSourceClosed is returned when publisher of source stream is finished
+var ErrSourceClosed = errors.New("source stream is closed")
+
+type Target struct {
+	Name string
+	Url  string
+}
+
+type Status struct {
+	Name   string
+	State  string
+	Reason string
+}
+
+type StatusHandler func(status Status)
+
+type Options struct {
+	ConnectTimeout time.Duration
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	ChunkSize      int
+	PacketSize     int
+}
+
+// Relay push source stream to external rtmp server until source is closed.
+// connection is retried with exponential backoff
+type Relay struct {
+	target   Target
+	options  Options
+	stream   *playback.Stream
+	onStatus StatusHandler
+
+	// last reported state. it is accessed by goroutine of Run
+	state string
+
+	stopSignal chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewRelay(target Target, stream *playback.Stream, options Options, onStatus StatusHandler) *Relay {
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = DEFAULT_CONNECT_TIMEOUT
+	}
+
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = DEFAULT_MAX_BACKOFF
+	}
+
+	if options.PacketSize <= 0 {
+		options.PacketSize = DEFAULT_PACKET_SIZE
+	}
+
+	return &Relay{
+		target:     target,
+		options:    options,
+		stream:     stream,
+		onStatus:   onStatus,
+		stopSignal: make(chan struct{}),
+	}
+}
+
+// log return logger of source stream with name of target
+func (r *Relay) log() *logrus.Entry {
+	return logging.PlaybackStream(logging.COMPONENT_RELAY, r.stream.Name()).WithField(logging.FIELD_RELAY_TARGET, r.target.Name)
+}
+
+func (r *Relay) Run() {
+	backoff := r.options.MinBackoff
+	for {
+		// retry of failed relay keep failed state until publish is started
+		if r.state != STATE_FAILED {
+			r.report(STATE_CONNECTING, "")
+		}
+
+		startedAt := time.Now()
+		err := r.publish()
+		if r.isStopped() || errors.Is(err, ErrSourceClosed) {
+			r.report(STATE_STOPPED, "")
+			return
+		}
+
+		r.log().Warn("[Relay][Run] relay fail. retry after ", backoff, ". ", err)
+		r.report(STATE_FAILED, err.Error())
+
+		// connection which was kept long is not repeated failure
+		if time.Since(startedAt) > r.options.MaxBackoff {
+			backoff = r.options.MinBackoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-r.stopSignal:
+			r.report(STATE_STOPPED, "")
+			return
+		}
+
+		backoff *= 2
+		if backoff > r.options.MaxBackoff {
+			backoff = r.options.MaxBackoff
+		}
+	}
+}
+
+func (r *Relay) Stop() {
+	r.stopOnce.Do(
+		func() {
+			close(r.stopSignal)
+		})
+}
+
+func (r *Relay) isStopped() bool {
+	select {
+	case <-r.stopSignal:
+		return true
+	default:
+		return false
+	}
+}
+
+// report state to handler only when it is changed
+func (r *Relay) report(state, reason string) {
+	if state == r.state {
+		return
+	}
+	r.state = state
+
+	if r.onStatus != nil {
+		r.onStatus(Status{Name: r.target.Name, State: state, Reason: reason})
+	}
+}
+
+func (r *Relay) publish() error {
+	options, address, err := parseUrl(r.target.Url)
+	if err != nil {
+		return err
+	}
+	options.ChunkSize = r.options.ChunkSize
+	options.Publish = true
+
+	conn, err := net.DialTimeout("tcp", address, r.options.ConnectTimeout)
+	if err != nil {
+		return err
+	}
+
+	handler := newClientHandler()
+	client, err := rtmp.NewClientWithOpen(options, transport.NewSocketTransporter(conn, r.options.PacketSize), handler)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	readResult := make(chan error, 1)
+	go func() {
+		readResult <- client.Run()
+	}()
+
+	timer := time.NewTimer(r.options.ConnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case state := <-handler.states:
+		if state != rtmp.CLIENT_STATE_PUBLISHING {
+			return fmt.Errorf("publish is rejected. %s", handler.lastError())
+		}
+	case err := <-readResult:
+		return err
+	case <-timer.C:
+		return errors.New("publish timeout")
+	case <-r.stopSignal:
+		return nil
+	}
+
+	r.log().Info("[Relay][publish] start publish to ", address)
+	r.report(STATE_PUBLISHING, "")
+
+	viewer, err := r.stream.Subscribe()
+	if err != nil {
+		return ErrSourceClosed
+	}
+	defer viewer.Close()
+
+	for {
+		select {
+		case frame, ok := <-viewer.Frames():
+			if !ok {
+				return ErrSourceClosed
+			}
+
+			if err := writeFrame(client, frame); err != nil {
+				return err
+			}
+		case err := <-readResult:
+			return err
+		case <-r.stopSignal:
+			return nil
+		}
+	}
+}
+
+func writeFrame(client *rtmp.Client, frame media.Frame) error {
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		return client.WriteVideo(frame)
+	case *media.AudioFrame:
+		return client.WriteAudio(frame)
+	}
+	return nil
+}
+
+// parseUrl split rtmp://host[:port]/app/streamKey into client options and dial address
+func parseUrl(rawUrl string) (rtmp.ClientOptions, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rtmp.ClientOptions{}, "", err
+	}
+
+	if u.Scheme != "rtmp" {
+		return rtmp.ClientOptions{}, "", errors.New("unsupported scheme " + u.Scheme)
+	}
+
+	appName, streamKey, found := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if !found || len(appName) == 0 || len(streamKey) == 0 {
+		return rtmp.ClientOptions{}, "", errors.New("url should be rtmp://host/app/streamKey")
+	}
+
+	if len(u.RawQuery) > 0 {
+		streamKey += "?" + u.RawQuery
+	}
+
+	address := u.Host
+	if len(u.Port()) == 0 {
+		address = net.JoinHostPort(u.Hostname(), DEFAULT_RTMP_PORT)
+	}
+
+	return rtmp.ClientOptions{Host: u.Host, AppName: appName, StreamKey: streamKey}, address, nil
+}
+
+// clientHandler pass state of one connection to relay
+type clientHandler struct {
+	states chan rtmp.ClientState
+
+	mutex  sync.Mutex
+	reason string
+}
+
+func newClientHandler() *clientHandler {
+	return &clientHandler{
+		states: make(chan rtmp.ClientState, 4),
+	}
+}
+
+func (h *clientHandler) OnStateChange(state rtmp.ClientState) {
+	select {
+	case h.states <- state:
+	default:
+	}
+}
+
+func (h *clientHandler) OnError(code, describe string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.reason = code + " " + describe
+}
+
+func (h *clientHandler) lastError() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.reason
+}
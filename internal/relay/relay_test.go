@@ -0,0 +1,209 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package relay
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+)
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+// ingestHandler is handler of rtmp server which stand in for relay target
+type ingestHandler struct {
+	streamPaths chan string
+	frames      chan *media.VideoFrame
+}
+
+func (h *ingestHandler) OnHandshakeDone() {}
+
+func (h *ingestHandler) OnPrePare(_, streamPath string) error {
+	h.streamPaths <- streamPath
+	return nil
+}
+
+func (h *ingestHandler) OnPublish() {}
+
+func (h *ingestHandler) OnPlay(_, _ string) error {
+	return rtmp.ErrStreamNotFound
+}
+
+func (h *ingestHandler) OnPlayStart() {}
+
+func (h *ingestHandler) OnError() {}
+
+func (h *ingestHandler) OnMetadata(_ *media.StreamMetadata, _ media.Timestamp) error {
+	return nil
+}
+
+func (h *ingestHandler) OnVideoFrame(frame *media.VideoFrame) {
+	select {
+	case h.frames <- frame:
+	default:
+	}
+}
+
+func (h *ingestHandler) OnAudioFrame(_ *media.AudioFrame) {}
+
+// serveRtmp accept a publisher on listener and pass it to handler
+func serveRtmp(listen net.Listener, handler rtmp.ServerHandler) {
+	connection, err := listen.Accept()
+	if err != nil {
+		return
+	}
+
+	socketTransport := transport.NewSocketTransporter(connection, DEFAULT_PACKET_SIZE)
+	defer socketTransport.Close()
+
+	context := rtmp.NewContext()
+	context.RegistHandler(handler, socketTransport)
+	for {
+		data, err := socketTransport.Read()
+		if err != nil {
+			return
+		}
+
+		if err := context.InputStream(data); err != nil {
+			return
+		}
+	}
+}
+
+func newSourceStream() *playback.Stream {
+	hub := playback.NewHub(16)
+	stream := hub.Open("source", media.NewGopCache(0, 0))
+	stream.WriteVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{}, testH264Frame, true))
+	return stream
+}
+
+func waitState(t *testing.T, statuses chan Status, state string) {
+	t.Helper()
+
+	select {
+	case status := <-statuses:
+		if status.State != state {
+			t.Fatal("expected state ", state, " but ", status.State, " ", status.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("state ", state, " is not reported")
+	}
+}
+
+func TestRelayPublish(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listen.Close()
+
+	handler := &ingestHandler{
+		streamPaths: make(chan string, 1),
+		frames:      make(chan *media.VideoFrame, 1),
+	}
+	go serveRtmp(listen, handler)
+
+	statuses := make(chan Status, 16)
+	target := Target{Name: "test", Url: "rtmp://" + listen.Addr().String() + "/live/relay-key"}
+	relay := NewRelay(target, newSourceStream(), Options{}, func(status Status) {
+		statuses <- status
+	})
+
+	done := make(chan struct{})
+	go func() {
+		relay.Run()
+		close(done)
+	}()
+
+	waitState(t, statuses, STATE_CONNECTING)
+	waitState(t, statuses, STATE_PUBLISHING)
+
+	select {
+	case streamPath := <-handler.streamPaths:
+		if streamPath != "relay-key" {
+			t.Fatal("invalid stream path. ", streamPath)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish is not requested")
+	}
+
+	select {
+	case frame := <-handler.frames:
+		if frame.Codec() != media.CODEC_VIDEO_H264 {
+			t.Fatal("invalid codec. ", frame.Codec())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached frame is not relayed")
+	}
+
+	relay.Stop()
+	<-done
+	waitState(t, statuses, STATE_STOPPED)
+}
+
+func TestRelayReportStateChangeOnly(t *testing.T) {
+	// nothing listen on address of closed listener
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listen.Addr().String()
+	listen.Close()
+
+	statuses := make(chan Status, 64)
+	target := Target{Name: "test", Url: "rtmp://" + address + "/live/relay-key"}
+	options := Options{MinBackoff: 5 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	relay := NewRelay(target, newSourceStream(), options, func(status Status) {
+		statuses <- status
+	})
+
+	done := make(chan struct{})
+	go func() {
+		relay.Run()
+		close(done)
+	}()
+
+	// several retries are done in this time
+	time.Sleep(200 * time.Millisecond)
+	relay.Stop()
+	<-done
+
+	waitState(t, statuses, STATE_CONNECTING)
+	waitState(t, statuses, STATE_FAILED)
+	waitState(t, statuses, STATE_STOPPED)
+	if len(statuses) != 0 {
+		t.Fatal("state is reported on every retry. ", len(statuses), " more reports")
+	}
+}
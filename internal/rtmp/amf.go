@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0EcmaArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+)
+
+var errAmfShortBuffer = errors.New("amf0 buffer too short")
+
+// decodeAmf0 decode all amf0 values in data.
+// number, boolean, string, object, ecma array, strict array and null are
+// converted to float64, bool, string, map[string]interface{}, []interface{} and nil.
+func decodeAmf0(data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0)
+	for len(data) > 0 {
+		value, n, err := decodeAmf0Value(data)
+		if err != nil {
+			return values, err
+		}
+
+		values = append(values, value)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+func decodeAmf0Value(data []byte) (interface{}, int, error) {
+	if len(data) < 1 {
+		return nil, 0, errAmfShortBuffer
+	}
+
+	switch data[0] {
+	case amf0Number:
+		if len(data) < 9 {
+			return nil, 0, errAmfShortBuffer
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:9])), 9, nil
+	case amf0Boolean:
+		if len(data) < 2 {
+			return nil, 0, errAmfShortBuffer
+		}
+		return data[1] != 0, 2, nil
+	case amf0String:
+		str, n, err := decodeAmf0String(data[1:])
+		return str, n + 1, err
+	case amf0LongString:
+		if len(data) < 5 {
+			return nil, 0, errAmfShortBuffer
+		}
+		length := int(binary.BigEndian.Uint32(data[1:5]))
+		if len(data) < 5+length {
+			return nil, 0, errAmfShortBuffer
+		}
+		return string(data[5 : 5+length]), 5 + length, nil
+	case amf0Object:
+		object, n, err := decodeAmf0Properties(data[1:])
+		return object, n + 1, err
+	case amf0EcmaArray:
+		if len(data) < 5 {
+			return nil, 0, errAmfShortBuffer
+		}
+		object, n, err := decodeAmf0Properties(data[5:])
+		return object, n + 5, err
+	case amf0StrictArray:
+		if len(data) < 5 {
+			return nil, 0, errAmfShortBuffer
+		}
+		// each value take at least a byte. count is from publisher and should not exceed remained bytes
+		count := binary.BigEndian.Uint32(data[1:5])
+		if count > uint32(len(data)-5) {
+			return nil, 0, errAmfShortBuffer
+		}
+
+		offset := 5
+		array := make([]interface{}, 0, count)
+		for i := uint32(0); i < count; i++ {
+			value, n, err := decodeAmf0Value(data[offset:])
+			if err != nil {
+				return nil, 0, err
+			}
+			array = append(array, value)
+			offset += n
+		}
+		return array, offset, nil
+	case amf0Date:
+		if len(data) < 11 {
+			return nil, 0, errAmfShortBuffer
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:9])), 11, nil
+	case amf0Null, amf0Undefined:
+		return nil, 1, nil
+	default:
+		return nil, 0, errors.New("unsupported amf0 type")
+	}
+}
+
+func decodeAmf0String(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, errAmfShortBuffer
+	}
+
+	length := int(binary.BigEndian.Uint16(data[0:2]))
+	if len(data) < 2+length {
+		return "", 0, errAmfShortBuffer
+	}
+	return string(data[2 : 2+length]), 2 + length, nil
+}
+
+func decodeAmf0Properties(data []byte) (map[string]interface{}, int, error) {
+	object := make(map[string]interface{})
+	offset := 0
+	for {
+		if len(data[offset:]) >= 3 && data[offset] == 0 && data[offset+1] == 0 && data[offset+2] == amf0ObjectEnd {
+			return object, offset + 3, nil
+		}
+
+		key, n, err := decodeAmf0String(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		value, n, err := decodeAmf0Value(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		object[key] = value
+	}
+}
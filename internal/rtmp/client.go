@@ -25,37 +25,191 @@ SOFTWARE.
 package rtmp
 
 import (
+	"errors"
+	"sync"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
-	"github.com/yapingcat/gomedia/go-rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/third_party/gomedia/go-rtmp"
+	"github.com/yapingcat/gomedia/go-codec"
+)
+
+type ClientState int
+
+const (
+	CLIENT_STATE_CONNECTING ClientState = iota
+	CLIENT_STATE_PUBLISHING
+	CLIENT_STATE_PLAYING
+	CLIENT_STATE_FAILED
 )
 
+func (s ClientState) String() string {
+	switch s {
+	case CLIENT_STATE_CONNECTING:
+		return "connecting"
+	case CLIENT_STATE_PUBLISHING:
+		return "publishing"
+	case CLIENT_STATE_PLAYING:
+		return "playing"
+	case CLIENT_STATE_FAILED:
+		return "failed"
+	}
+	return "unknown"
+}
+
+var ErrClientNotReady = errors.New("rtmp client is not publishing")
+
 type ClientOptions struct {
 	Host      string
 	AppName   string
 	StreamKey string
 	ChunkSize int
+	Publish   bool
 }
 
+// Client connect to remote rtmp server on transporter.
+// handler is called on goroutine of Run
 type Client struct {
 	transporter transport.Transporter
+	handler     ClientHandler
 
+	mutex  sync.Mutex
 	engine *rtmp.RtmpClient
+	state  ClientState
+
+	// muxer of engine rewrite start codes in place. frame is shared with other viewers
+	videoBuffer []byte
 }
 
-func NewClientWithOpen(option ClientOptions, transporter transport.Transporter) (*Client, error) {
-	rtmpOptions := []func(*rtmp.RtmpClient){
+func NewClientWithOpen(option ClientOptions, transporter transport.Transporter, handler ClientHandler) (*Client, error) {
+	rtmpOptions := []func(*rtmp.RtmpClient){}
 	if option.ChunkSize != 0 {
 		rtmpOptions = append(rtmpOptions, rtmp.WithChunkSize(uint32(option.ChunkSize)))
 	}
 
-	engine := rtmp.NewRtmpClient(rtmpOptions...)
-	engine.SetOutput(transporter.Write)
+	if option.Publish {
+		rtmpOptions = append(rtmpOptions, rtmp.WithEnablePublish())
+	}
+
+	client := &Client{
+		transporter: transporter,
+		handler:     handler,
+		engine:      rtmp.NewRtmpClient(rtmpOptions...),
+		state:       CLIENT_STATE_CONNECTING,
+	}
+
+	client.engine.SetOutput(transporter.Write)
+	client.engine.OnStateChange(client.onStateChange)
+	client.engine.OnError(
+		func(code, describe string) {
+			handler.OnError(code, describe)
+		})
+
+	if frameHandler, ok := handler.(ClientFrameHandler); ok {
+		client.engine.OnFrame(
+			func(cid codec.CodecID, pts, dts uint32, frame []byte) {
+				mediaType, codec := media.ConvertCodec(cid)
+				timestamp := media.Timestamp{Pts: uint64(pts), Dts: uint64(dts)}
+
+				switch mediaType {
+				case media.MEDIA_VIDEO:
+					frameHandler.OnVideoFrame(media.NewVideoFrame(media.VideoCodec(codec), timestamp, frame, false))
+				case media.MEDIA_AUDIO:
+					frameHandler.OnAudioFrame(media.NewAudioFrame(media.AudioCodec(codec), timestamp, frame))
+				}
+			})
+	}
 
 	url := "rtmp://" + option.Host + "/" + option.AppName + "/" + option.StreamKey
-	engine.Start(url)
 
-	return &Client{
-		transporter: transporter,
-		engine:      engine,
-	}, nil
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.engine.Start(url)
+
+	return client, nil
+}
+
+// Run read response of server until connection is closed
+func (c *Client) Run() error {
+	for {
+		data, err := c.transporter.Read()
+		if err != nil {
+			return err
+		}
+
+		c.mutex.Lock()
+		err = c.engine.Input(data)
+		c.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) State() ClientState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state
+}
+
+// WriteVideo publish frame. timestamp should be rebased by caller
+func (c *Client) WriteVideo(frame *media.VideoFrame) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state != CLIENT_STATE_PUBLISHING {
+		return ErrClientNotReady
+	}
+
+	codecId, err := media.VideoCodecId(frame.Codec())
+	if err != nil {
+		return err
+	}
+
+	timestamp := frame.Timestamp()
+	c.videoBuffer = append(c.videoBuffer[:0], frame.Data()...)
+	return c.engine.WriteVideo(codecId, c.videoBuffer, uint32(timestamp.Pts), uint32(timestamp.Dts))
+}
+
+func (c *Client) WriteAudio(frame *media.AudioFrame) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state != CLIENT_STATE_PUBLISHING {
+		return ErrClientNotReady
+	}
+
+	codecId, err := media.AudioCodecId(frame.Codec())
+	if err != nil {
+		return err
+	}
+
+	timestamp := frame.Timestamp()
+	return c.engine.WriteAudio(codecId, frame.Data(), uint32(timestamp.Pts), uint32(timestamp.Dts))
+}
+
+func (c *Client) Close() {
+	c.transporter.Close()
+}
+
+// onStateChange is called while engine handle input
+func (c *Client) onStateChange(newState rtmp.RtmpState) {
+	state := c.state
+	switch newState {
+	case rtmp.STATE_RTMP_PUBLISH_START:
+		state = CLIENT_STATE_PUBLISHING
+	case rtmp.STATE_RTMP_PLAY_START:
+		state = CLIENT_STATE_PLAYING
+	case rtmp.STATE_RTMP_PUBLISH_FAILED, rtmp.STATE_RTMP_PLAY_FAILED:
+		state = CLIENT_STATE_FAILED
+	}
+
+	if state == c.state {
+		return
+	}
+
+	c.state = state
+	c.handler.OnStateChange(state)
 }
@@ -25,19 +25,30 @@ SOFTWARE.
 package rtmp
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+	"github.com/ISSuh/mystream-media_preprocessor/third_party/gomedia/go-rtmp"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/yapingcat/gomedia/go-codec"
-	"github.com/yapingcat/gomedia/go-rtmp"
 )
 
 type Context struct {
 	handler     ServerHandler
 	transporter transport.Transporter
 
+	// input of publisher and output of player use same handle
+	mutex           sync.Mutex
 	internalHandler *rtmp.RtmpServerHandle
+
+	// error of metadata handler. callback of handle can not return error so it is returned by InputStream
+	metadataErr error
+
+	// muxer of handle rewrite start codes in place. frame is shared with other viewers
+	videoBuffer []byte
 }
 
 func NewContext() *Context {
@@ -58,15 +69,24 @@ func (c *Context) RegistHandler(handler ServerHandler, transporter transport.Tra
 		})
 
 	c.internalHandler.OnPlay(
-		func(_, _ string, _, _ float64, _ bool) rtmp.StatusCode {
-			log.Warn("[RtmpContext][OnPlay] not support")
-			return rtmp.NETSTREAM_CONNECT_REJECTED
+		func(appName, streamPath string, _, _ float64, _ bool) rtmp.StatusCode {
+			err := c.handler.OnPlay(appName, streamPath)
+			if errors.Is(err, ErrServiceUnavailable) {
+				return rtmp.NETCONNECT_CONNECT_FAILED
+			} else if errors.Is(err, ErrStreamNotFound) {
+				return rtmp.NETSTREAM_PLAY_NOTFOUND
+			} else if err != nil {
+				return rtmp.NETSTREAM_PLAY_FAILED
+			}
+			return rtmp.NETSTREAM_PLAY_START
 		})
 
 	c.internalHandler.OnPublish(
 		func(appName, streamPath string) rtmp.StatusCode {
 			err := c.handler.OnPrePare(appName, streamPath)
-			if err != nil {
+			if errors.Is(err, ErrServiceUnavailable) {
+				return rtmp.NETCONNECT_CONNECT_FAILED
+			} else if err != nil {
 				return rtmp.NETCONNECT_CONNECT_REJECTED
 			}
 			return rtmp.NETSTREAM_PUBLISH_START
@@ -75,9 +95,13 @@ func (c *Context) RegistHandler(handler ServerHandler, transporter transport.Tra
 	c.internalHandler.OnStateChange(
 		func(newState rtmp.RtmpState) {
 			switch newState {
+			case rtmp.STATE_HANDSHAKE_DONE:
+				c.handler.OnHandshakeDone()
 			case rtmp.STATE_RTMP_PUBLISH_START:
 				c.handler.OnPublish()
-			case rtmp.STATE_RTMP_PUBLISH_FAILED:
+			case rtmp.STATE_RTMP_PLAY_START:
+				c.handler.OnPlayStart()
+			case rtmp.STATE_RTMP_PUBLISH_FAILED, rtmp.STATE_RTMP_PLAY_FAILED:
 				c.handler.OnError()
 			}
 		})
@@ -96,8 +120,61 @@ func (c *Context) RegistHandler(handler ServerHandler, transporter transport.Tra
 					media.NewAudioFrame(media.AudioCodec(codec), timestamp, frame))
 			}
 		})
+
+	c.internalHandler.OnMetadata(c.onMetadata)
 }
 
 func (c *Context) InputStream(data []byte) error {
-	return c.internalHandler.Input(data)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.internalHandler.Input(data); err != nil {
+		return err
+	}
+
+	err := c.metadataErr
+	c.metadataErr = nil
+	return err
+}
+
+// WriteVideo send frame to player. timestamp should be rebased by caller
+func (c *Context) WriteVideo(frame *media.VideoFrame) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	codecId, err := media.VideoCodecId(frame.Codec())
+	if err != nil {
+		return err
+	}
+
+	timestamp := frame.Timestamp()
+	c.videoBuffer = append(c.videoBuffer[:0], frame.Data()...)
+	return c.internalHandler.WriteVideo(codecId, c.videoBuffer, uint32(timestamp.Pts), uint32(timestamp.Dts))
+}
+
+func (c *Context) WriteAudio(frame *media.AudioFrame) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	codecId, err := media.AudioCodecId(frame.Codec())
+	if err != nil {
+		return err
+	}
+
+	timestamp := frame.Timestamp()
+	return c.internalHandler.WriteAudio(codecId, frame.Data(), uint32(timestamp.Pts), uint32(timestamp.Dts))
+}
+
+// onMetadata is called with AMF0 data message of publisher
+func (c *Context) onMetadata(timestamp uint32, payload []byte) {
+	metadata, err := parseMetadata(payload)
+	if err != nil {
+		log.Debug("[RtmpContext][onMetadata] skip data message. ", err)
+		return
+	}
+
+	err = c.handler.OnMetadata(metadata, media.Timestamp{Pts: uint64(timestamp), Dts: uint64(timestamp)})
+	if err != nil && c.metadataErr == nil {
+		c.metadataErr = err
+	}
 }
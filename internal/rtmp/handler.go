@@ -25,18 +25,38 @@ SOFTWARE.
 package rtmp
 
 import (
+	"errors"
+
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
 )
 
+// ErrServiceUnavailable is returned by ServerHandler when node can not accept stream now.
+var ErrServiceUnavailable = errors.New("service unavailable")
+
+// ErrStreamNotFound is returned by ServerHandler when stream of play is not live.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// ClientHandler is notified about state of Client. it should not call methods of Client
 type ClientHandler interface {
-	OnStateChange()
-	OnError()
+	OnStateChange(state ClientState)
+	OnError(code, describe string)
+}
+
+// ClientFrameHandler is ClientHandler which receive frames of play mode
+type ClientFrameHandler interface {
+	ClientHandler
+	OnVideoFrame(frame *media.VideoFrame)
+	OnAudioFrame(frame *media.AudioFrame)
 }
 
 type ServerHandler interface {
+	OnHandshakeDone()
 	OnPrePare(appName, streamPath string) error
 	OnPublish()
+	OnPlay(appName, streamPath string) error
+	OnPlayStart()
 	OnError()
+	OnMetadata(metadata *media.StreamMetadata, timestamp media.Timestamp) error
 	OnVideoFrame(frame *media.VideoFrame)
 	OnAudioFrame(frame *media.AudioFrame)
 }
@@ -0,0 +1,114 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package rtmp
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	setDataFrameCommand = "@setDataFrame"
+	onMetaDataCommand   = "onMetaData"
+)
+
+var errNotMetadata = errors.New("not metadata message")
+
+// parseMetadata parse amf0 data message like
+// "@setDataFrame", "onMetaData", {...} or "onMetaData", {...}
+func parseMetadata(payload []byte) (*media.StreamMetadata, error) {
+	values, err := decodeAmf0(payload)
+	if err != nil && len(values) == 0 {
+		return nil, err
+	}
+
+	if len(values) > 0 && values[0] == setDataFrameCommand {
+		values = values[1:]
+	}
+
+	if len(values) < 2 || values[0] != onMetaDataCommand {
+		return nil, errNotMetadata
+	}
+
+	properties, ok := values[1].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid onMetaData properties")
+	}
+
+	metadata := &media.StreamMetadata{
+		Width:           int(numberProperty(properties, "width")),
+		Height:          int(numberProperty(properties, "height")),
+		FrameRate:       numberProperty(properties, "framerate"),
+		VideoDataRate:   numberProperty(properties, "videodatarate"),
+		VideoCodecId:    stringProperty(properties, "videocodecid"),
+		AudioDataRate:   numberProperty(properties, "audiodatarate"),
+		AudioSampleRate: int(numberProperty(properties, "audiosamplerate")),
+		AudioChannels:   int(numberProperty(properties, "audiochannels")),
+		AudioCodecId:    stringProperty(properties, "audiocodecid"),
+		Encoder:         stringProperty(properties, "encoder"),
+	}
+
+	if metadata.FrameRate == 0 {
+		metadata.FrameRate = numberProperty(properties, "fps")
+	}
+
+	if metadata.AudioChannels == 0 {
+		if stereo, ok := properties["stereo"].(bool); ok {
+			metadata.AudioChannels = 1
+			if stereo {
+				metadata.AudioChannels = 2
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func numberProperty(properties map[string]interface{}, key string) float64 {
+	switch value := properties[key].(type) {
+	case float64:
+		return value
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0
+		}
+		return number
+	}
+	return 0
+}
+
+// stringProperty return property as string.
+// codec id can be announced as number(7) or fourcc("avc1")
+func stringProperty(properties map[string]interface{}, key string) string {
+	switch value := properties[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,337 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+func amfNumber(value float64) []byte {
+	data := []byte{amf0Number, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(data[1:], math.Float64bits(value))
+	return data
+}
+
+func amfBoolean(value bool) []byte {
+	if value {
+		return []byte{amf0Boolean, 1}
+	}
+	return []byte{amf0Boolean, 0}
+}
+
+func amfKey(key string) []byte {
+	data := []byte{0, 0}
+	binary.BigEndian.PutUint16(data, uint16(len(key)))
+	return append(data, key...)
+}
+
+func amfString(value string) []byte {
+	return append([]byte{amf0String}, amfKey(value)...)
+}
+
+func amfLongString(value string) []byte {
+	data := []byte{amf0LongString, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(data[1:], uint32(len(value)))
+	return append(data, value...)
+}
+
+// amfProperties encode key and value pairs and object end marker
+func amfProperties(pairs ...interface{}) []byte {
+	data := make([]byte, 0)
+	for i := 0; i < len(pairs); i += 2 {
+		data = append(data, amfKey(pairs[i].(string))...)
+		data = append(data, pairs[i+1].([]byte)...)
+	}
+	return append(data, 0, 0, amf0ObjectEnd)
+}
+
+func amfObject(pairs ...interface{}) []byte {
+	return append([]byte{amf0Object}, amfProperties(pairs...)...)
+}
+
+func amfEcmaArray(pairs ...interface{}) []byte {
+	data := []byte{amf0EcmaArray, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(data[1:], uint32(len(pairs)/2))
+	return append(data, amfProperties(pairs...)...)
+}
+
+func amfStrictArray(values ...[]byte) []byte {
+	data := []byte{amf0StrictArray, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(data[1:], uint32(len(values)))
+	for _, value := range values {
+		data = append(data, value...)
+	}
+	return data
+}
+
+func concat(values ...[]byte) []byte {
+	return bytes.Join(values, nil)
+}
+
+func TestDecodeAmf0(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []interface{}
+		err      error
+	}{
+		{
+			name:     "number boolean and null",
+			data:     concat(amfNumber(1.5), amfBoolean(true), []byte{amf0Null}),
+			expected: []interface{}{1.5, true, nil},
+		},
+		{
+			name:     "string and long string",
+			data:     concat(amfString("onMetaData"), amfLongString("encoder")),
+			expected: []interface{}{"onMetaData", "encoder"},
+		},
+		{
+			name:     "object",
+			data:     amfObject("width", amfNumber(1280), "stereo", amfBoolean(false)),
+			expected: []interface{}{map[string]interface{}{"width": 1280.0, "stereo": false}},
+		},
+		{
+			name:     "ecma array",
+			data:     amfEcmaArray("height", amfNumber(720)),
+			expected: []interface{}{map[string]interface{}{"height": 720.0}},
+		},
+		{
+			name:     "strict array",
+			data:     amfStrictArray(amfNumber(1), amfString("a"), amfObject("b", amfNumber(2))),
+			expected: []interface{}{[]interface{}{1.0, "a", map[string]interface{}{"b": 2.0}}},
+		},
+		{
+			name: "truncated number",
+			data: amfNumber(1)[:5],
+			err:  errAmfShortBuffer,
+		},
+		{
+			name: "truncated string",
+			data: amfString("onMetaData")[:6],
+			err:  errAmfShortBuffer,
+		},
+		{
+			name: "truncated long string",
+			data: amfLongString("encoder")[:8],
+			err:  errAmfShortBuffer,
+		},
+		{
+			name: "object without end marker",
+			data: amfObject("width", amfNumber(1280))[:12],
+			err:  errAmfShortBuffer,
+		},
+		{
+			name: "oversized strict array count",
+			data: []byte{amf0StrictArray, 0xFF, 0xFF, 0xFF, 0xFF, amf0Null},
+			err:  errAmfShortBuffer,
+		},
+		{
+			name: "strict array count larger than values",
+			data: []byte{amf0StrictArray, 0, 0, 0, 2, amf0Number, 0},
+			err:  errAmfShortBuffer,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := decodeAmf0(test.data)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v. %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(values, test.expected) {
+				t.Fatalf("invalid values. %v", values)
+			}
+		})
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	properties := []interface{}{
+		"width", amfNumber(1280),
+		"height", amfNumber(720),
+		"framerate", amfNumber(30),
+		"videocodecid", amfString("avc1"),
+		"audiocodecid", amfNumber(10),
+		"audiosamplerate", amfNumber(48000),
+		"audiochannels", amfNumber(2),
+		"encoder", amfString("obs-output module"),
+	}
+
+	expected := &media.StreamMetadata{
+		Width:           1280,
+		Height:          720,
+		FrameRate:       30,
+		VideoCodecId:    "avc1",
+		AudioCodecId:    "10",
+		AudioSampleRate: 48000,
+		AudioChannels:   2,
+		Encoder:         "obs-output module",
+	}
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected *media.StreamMetadata
+		err      error
+	}{
+		{
+			name:     "set data frame with ecma array",
+			payload:  concat(amfString(setDataFrameCommand), amfString(onMetaDataCommand), amfEcmaArray(properties...)),
+			expected: expected,
+		},
+		{
+			name:     "bare on meta data with object",
+			payload:  concat(amfString(onMetaDataCommand), amfObject(properties...)),
+			expected: expected,
+		},
+		{
+			name:     "fps and stereo fallback",
+			payload:  concat(amfString(onMetaDataCommand), amfEcmaArray("fps", amfString("29.97"), "stereo", amfBoolean(false))),
+			expected: &media.StreamMetadata{FrameRate: 29.97, AudioChannels: 1},
+		},
+		{
+			name:     "trailing truncated value",
+			payload:  concat(amfString(onMetaDataCommand), amfObject("width", amfNumber(640)), amfNumber(1)[:3]),
+			expected: &media.StreamMetadata{Width: 640},
+		},
+		{
+			name:    "other data message",
+			payload: concat(amfString(setDataFrameCommand), amfString("onTextData"), amfObject("text", amfString("a"))),
+			err:     errNotMetadata,
+		},
+		{
+			name:    "on meta data without properties",
+			payload: amfString(onMetaDataCommand),
+			err:     errNotMetadata,
+		},
+		{
+			name:    "truncated command",
+			payload: amfString(onMetaDataCommand)[:4],
+			err:     errAmfShortBuffer,
+		},
+		{
+			name:    "oversized strict array",
+			payload: concat(amfString(onMetaDataCommand), []byte{amf0StrictArray, 0xFF, 0xFF, 0xFF, 0xFF}),
+			err:     errNotMetadata,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := parseMetadata(test.payload)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v. %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(metadata, test.expected) {
+				t.Fatalf("invalid metadata. %+v", metadata)
+			}
+		})
+	}
+}
+
+// findPmt return first pmt section of ts stream
+func findPmt(t *testing.T, stream []byte) []byte {
+	t.Helper()
+
+	for i := 0; i+media.TS_PACKET_SIZE <= len(stream); i += media.TS_PACKET_SIZE {
+		packet := stream[i : i+media.TS_PACKET_SIZE]
+		if packet[1]&0x40 == 0 {
+			continue
+		}
+
+		payload := packet[4:]
+		if control := packet[3] >> 4 & 0x3; control == 0x2 || control == 0x3 {
+			payload = payload[1+int(payload[0]):]
+		}
+
+		section := payload[1+int(payload[0]):]
+		if section[0] == 0x02 {
+			length := int(binary.BigEndian.Uint16(section[1:3]) & 0x0FFF)
+			return section[:3+length]
+		}
+	}
+
+	t.Fatal("pmt is not found")
+	return nil
+}
+
+func TestMetadataIsMuxedWithID3Descriptors(t *testing.T) {
+	payload := concat(amfString(setDataFrameCommand), amfString(onMetaDataCommand), amfEcmaArray("width", amfNumber(1280), "height", amfNumber(720)))
+	metadata, err := parseMetadata(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	muxer := media.NewTSMuxer()
+	stream, err := muxer.MuxingMetadata(media.MakeID3Tag(metadata.Fields()), media.Timestamp{})
+	if err != nil {
+		t.Fatal("metadata muxing fail. ", err)
+	}
+
+	section := findPmt(t, stream)
+	programInfoLength := int(binary.BigEndian.Uint16(section[10:12]) & 0x0FFF)
+	programInfo := section[12 : 12+programInfoLength]
+	if len(programInfo) < 8 || programInfo[0] != 0x25 || !bytes.Equal(programInfo[4:8], []byte("ID3 ")) {
+		t.Fatalf("metadata pointer descriptor is not found. %x", programInfo)
+	}
+
+	found := false
+	streams := section[12+programInfoLength : len(section)-4]
+	for len(streams) >= 5 {
+		infoLength := int(binary.BigEndian.Uint16(streams[3:5]) & 0x0FFF)
+		info := streams[5 : 5+infoLength]
+		if streams[0] == 0x15 && len(info) > 8 && info[0] == 0x26 && bytes.Equal(info[4:8], []byte("ID3 ")) {
+			found = true
+		}
+		streams = streams[5+infoLength:]
+	}
+
+	if !found {
+		t.Fatal("metadata descriptor is not found in pmt")
+	}
+}
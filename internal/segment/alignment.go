@@ -0,0 +1,225 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+// AlignmentHandler is called once per stream when segments of same number start at different timestamps across renditions
+type AlignmentHandler func(streamId int, number int, spread time.Duration)
+
+// alignmentWatcher compare start of closed segments of each number across renditions of same container.
+// segments are misaligned when difference exceed a frame of lowest frame rate
+type alignmentWatcher struct {
+	streamId     int
+	path         string
+	renditions   []string
+	containers   []string
+	interval     time.Duration
+	tolerance    uint64
+	onMisaligned AlignmentHandler
+
+	next     int
+	reported bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAlignmentWatcher(streamId int, path string, encodings []configure.MediaEncodingConfigure, onMisaligned AlignmentHandler) *alignmentWatcher {
+	renditions := make([]string, 0, len(encodings))
+	containers := make([]string, 0, len(encodings))
+	minFrame := 0
+	for _, encoding := range encodings {
+		renditions = append(renditions, ffmpeg.RenditionName(encoding))
+		containers = append(containers, ffmpeg.SegmentExtension(encoding))
+		if minFrame == 0 || encoding.Frame < minFrame {
+			minFrame = encoding.Frame
+		}
+	}
+
+	return &alignmentWatcher{
+		streamId:     streamId,
+		path:         path,
+		renditions:   renditions,
+		containers:   containers,
+		interval:     time.Duration(max(encodings[0].SegmentTime, 1)) * time.Second,
+		tolerance:    uint64(1000 / max(minFrame, 1)),
+		onMisaligned: onMisaligned,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (w *alignmentWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.scan()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *alignmentWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+// scan check numbers which are closed in every rendition. segments removed by janitor or uploader are skipped
+func (w *alignmentWatcher) scan() {
+	closed := make([]map[int]string, 0, len(w.renditions))
+	limit := -1
+	for i, rendition := range w.renditions {
+		files, err := segmentFiles(filepath.Join(w.path, rendition))
+		if err != nil || len(files) < 2 {
+			return
+		}
+
+		// last segment is written by ffmpeg
+		numbers := segmentNumbers(files[:len(files)-1])
+		closed = append(closed, numbers)
+
+		last := -1
+		for number := range numbers {
+			last = max(last, number)
+		}
+
+		if i == 0 || last < limit {
+			limit = last
+		}
+	}
+
+	for number := w.next; number <= limit; number++ {
+		w.check(number, closed)
+	}
+	w.next = max(w.next, limit+1)
+}
+
+func (w *alignmentWatcher) check(number int, closed []map[int]string) {
+	// start of fmp4 segment is decode time which is not comparable with PTS of mpeg ts segment.
+	// renditions are compared with renditions of same container
+	firsts, lasts := make(map[string]uint64), make(map[string]uint64)
+	for i, rendition := range w.renditions {
+		name, exist := closed[i][number]
+		if !exist {
+			return
+		}
+
+		start, exist := segmentStart(filepath.Join(w.path, rendition, name))
+		if !exist {
+			return
+		}
+
+		container := w.containers[i]
+		if first, exist := firsts[container]; !exist || start < first {
+			firsts[container] = start
+		}
+		if last, exist := lasts[container]; !exist || start > last {
+			lasts[container] = start
+		}
+	}
+
+	difference := uint64(0)
+	for container, first := range firsts {
+		difference = max(difference, lasts[container]-first)
+	}
+
+	if difference <= w.tolerance {
+		return
+	}
+
+	spread := time.Duration(difference) * time.Millisecond
+	logging.Stream(logging.COMPONENT_SEGMENT, w.streamId).Warn("[AlignmentWatcher][check] segment ", number, " of renditions is misaligned by ", spread)
+	if !w.reported && w.onMisaligned != nil {
+		w.reported = true
+		w.onMisaligned(w.streamId, number, spread)
+	}
+}
+
+// segmentNumbers map number of segment file name to name
+func segmentNumbers(files []string) map[int]string {
+	numbers := make(map[int]string, len(files))
+	for _, file := range files {
+		number, err := strconv.Atoi(strings.TrimSuffix(file, filepath.Ext(file)))
+		if err == nil {
+			numbers[number] = file
+		}
+	}
+	return numbers
+}
+
+// segmentStart return PTS of first video frame of mpeg ts segment or decode time of fmp4 segment as millisecond
+func segmentStart(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	if filepath.Ext(path) == ffmpeg.FMP4_SEGMENT_EXTENSION {
+		init, err := os.ReadFile(filepath.Join(filepath.Dir(path), ffmpeg.FMP4_INIT_NAME))
+		if err != nil {
+			return 0, false
+		}
+
+		tracks, err := media.ReadFmp4Init(init)
+		if err != nil {
+			return 0, false
+		}
+
+		start, _, err := media.ReadFmp4Duration(tracks, data)
+		return start, err == nil
+	}
+
+	start, found := uint64(0), false
+	demuxer := media.NewTsDemuxer()
+	demuxer.OnVideoFrame = func(frame *media.VideoFrame) {
+		if !found {
+			start, found = frame.Pts(), true
+		}
+	}
+
+	if err := demuxer.Input(data); err != nil {
+		return 0, false
+	}
+	demuxer.Flush()
+	return start, found
+}
@@ -0,0 +1,123 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
+)
+
+var (
+	ErrNoFirstSegment = errors.New("stream is closed before first segment")
+)
+
+// FirstSegmentHandler is called once per stream when first segment of any rendition is closed
+type FirstSegmentHandler func(streamId int, elapsed time.Duration)
+
+// firstSegmentWatcher measure time from start of transcoder to first closed segment
+type firstSegmentWatcher struct {
+	streamId       int
+	path           string
+	renditions     []string
+	startedAt      time.Time
+	span           trace.Span
+	onFirstSegment FirstSegmentHandler
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newFirstSegmentWatcher(ctx context.Context, streamId int, path string, encodings []configure.MediaEncodingConfigure, onFirstSegment FirstSegmentHandler) *firstSegmentWatcher {
+	renditions := make([]string, 0, len(encodings))
+	for _, encoding := range encodings {
+		renditions = append(renditions, ffmpeg.RenditionName(encoding))
+	}
+
+	_, span := tracing.Start(ctx, tracing.SPAN_FIRST_SEGMENT, attribute.Int(tracing.ATTRIBUTE_STREAM_ID, streamId))
+	return &firstSegmentWatcher{
+		streamId:       streamId,
+		path:           path,
+		renditions:     renditions,
+		startedAt:      time.Now(),
+		span:           span,
+		onFirstSegment: onFirstSegment,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+func (w *firstSegmentWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(SEGMENT_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if w.scan() {
+				return
+			}
+		case <-w.stop:
+			tracing.End(w.span, ErrNoFirstSegment)
+			return
+		}
+	}
+}
+
+func (w *firstSegmentWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+// scan return true when first segment is closed
+func (w *firstSegmentWatcher) scan() bool {
+	for _, rendition := range w.renditions {
+		files, err := segmentFiles(filepath.Join(w.path, rendition))
+		// last segment is written by ffmpeg
+		if err != nil || len(files) < 2 {
+			continue
+		}
+
+		elapsed := time.Since(w.startedAt)
+		w.span.SetAttributes(attribute.String(tracing.ATTRIBUTE_RENDITION, rendition))
+		w.span.End()
+
+		if w.onFirstSegment != nil {
+			w.onFirstSegment(w.streamId, elapsed)
+		}
+		return true
+	}
+	return false
+}
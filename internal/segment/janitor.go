@@ -0,0 +1,373 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	// live window of each rendition, segments are removed after expireAfter from end of stream
+	RETENTION_WINDOW = "window"
+	// all segments are kept for VOD. only quota removes segments
+	RETENTION_VOD = "vod"
+	// all segments are kept while stream is alive, segments are removed after expireAfter from end of stream
+	RETENTION_EXPIRE = "expire"
+
+	DEFAULT_RETENTION_WINDOW_SIZE = 10
+	DEFAULT_JANITOR_INTERVAL      = 30
+
+	MEGA_BYTE = 1024 * 1024
+)
+
+var ErrDiskFull = errors.New("segment volume is nearly full")
+
+// CheckRetention reject retention which remove segments of VOD asset while stream is alive
+func CheckRetention(segmentConfigure configure.SegmentConfigure) error {
+	if segmentConfigure.Vod.Enable && segmentConfigure.Retention.Policy == RETENTION_WINDOW {
+		return fmt.Errorf("retention policy %s remove segments of VOD. use %s or %s", RETENTION_WINDOW, RETENTION_VOD, RETENTION_EXPIRE)
+	}
+	return nil
+}
+
+type segmentFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	removed bool
+}
+
+// streamFiles is segment files of stream directory. segments are in directory of each rendition
+type streamFiles struct {
+	path         string
+	live         bool
+	renditions   map[string][]*segmentFile
+	size         int64
+	lastModified time.Time
+}
+
+// removable return segments which can be removed by quota from oldest.
+// last segment of live rendition is not removed because it is written by ffmpeg
+func (s *streamFiles) removable() []*segmentFile {
+	files := make([]*segmentFile, 0)
+	for _, segments := range s.renditions {
+		if s.live && len(segments) > 0 {
+			segments = segments[:len(segments)-1]
+		}
+
+		for _, file := range segments {
+			if !file.removed {
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files
+}
+
+func (s *streamFiles) remove(file *segmentFile) {
+	if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logging.Component(logging.COMPONENT_SEGMENT).Warn("[SegmentManager][remove] ", err)
+		return
+	}
+
+	file.removed = true
+	s.size -= file.size
+}
+
+// trim remove oldest segments until size of stream is under maxSize
+func (s *streamFiles) trim(maxSize int64) {
+	for _, file := range s.removable() {
+		if s.size <= maxSize {
+			return
+		}
+		s.remove(file)
+	}
+}
+
+// removeEmptyDirectories remove directories of ended stream when all segments are removed.
+// init segment and playlist of fmp4 rendition are removed with last segment
+func (s *streamFiles) removeEmptyDirectories() {
+	if s.live {
+		return
+	}
+
+	for rendition, files := range s.renditions {
+		removed := true
+		for _, file := range files {
+			removed = removed && file.removed
+		}
+
+		if removed {
+			os.Remove(filepath.Join(rendition, ffmpeg.FMP4_INIT_NAME))
+			os.Remove(filepath.Join(rendition, ffmpeg.FMP4_PLAYLIST_NAME))
+		}
+		os.Remove(rendition)
+	}
+	os.Remove(s.path)
+}
+
+// scanStreamFiles group segment files by stream directory which is parent of rendition directory.
+// VOD asset, playlist and thumbnails are not segments
+func scanStreamFiles(basePath string, livePaths map[string]bool) (map[string]*streamFiles, error) {
+	basePath = filepath.Clean(basePath)
+	streams := make(map[string]*streamFiles)
+
+	err := filepath.WalkDir(basePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == ffmpeg.THUMBNAIL_DIRECTORY {
+			return filepath.SkipDir
+		}
+
+		if !entry.Type().IsRegular() || !isSegmentFile(path) {
+			return nil
+		}
+
+		// {basePath}{uri}/{rendition}/{segment}. base path itself is never removed as stream
+		renditionPath := filepath.Dir(path)
+		streamPath := filepath.Dir(renditionPath)
+		if renditionPath == basePath || streamPath == basePath {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		stream, exist := streams[streamPath]
+		if !exist {
+			stream = &streamFiles{
+				path:       streamPath,
+				live:       livePaths[streamPath],
+				renditions: make(map[string][]*segmentFile),
+			}
+			streams[streamPath] = stream
+		}
+
+		// files of directory are walked in lexical order which is order of segment numbers
+		stream.renditions[renditionPath] = append(stream.renditions[renditionPath], &segmentFile{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		stream.size += info.Size()
+		if info.ModTime().After(stream.lastModified) {
+			stream.lastModified = info.ModTime()
+		}
+		return nil
+	})
+	return streams, err
+}
+
+// RunJanitor apply retention policy and quotas to segments periodically until Stop
+func (sm *SegmentManager) RunJanitor() {
+	retention := sm.segmentConfigure.Retention
+	quota := sm.segmentConfigure.Quota
+	if (len(retention.Policy) == 0 || retention.Policy == RETENTION_VOD) && quota.MaxStreamSize <= 0 && quota.MaxTotalSize <= 0 {
+		return
+	}
+
+	interval := sm.segmentConfigure.JanitorInterval
+	if interval <= 0 {
+		interval = DEFAULT_JANITOR_INTERVAL
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.stopSignal:
+			return
+		case <-ticker.C:
+			if err := sm.clean(); err != nil {
+				logging.Component(logging.COMPONENT_SEGMENT).Warn("[SegmentManager][RunJanitor] clean fail. ", err)
+			}
+		}
+	}
+}
+
+func (sm *SegmentManager) livePaths() map[string]bool {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	paths := make(map[string]bool)
+	for _, streamSegments := range sm.streams {
+		paths[filepath.Clean(streamSegments.streamBasePath)] = true
+	}
+
+	for path := range sm.finalizing {
+		paths[path] = true
+	}
+	return paths
+}
+
+func (sm *SegmentManager) clean() error {
+	streams, err := scanStreamFiles(sm.segmentConfigure.BasePath, sm.livePaths())
+	if err != nil {
+		return err
+	}
+
+	retention := sm.segmentConfigure.Retention
+	quota := sm.segmentConfigure.Quota
+	expireAfter := time.Duration(retention.ExpireAfter) * time.Minute
+
+	total := int64(0)
+	for path, stream := range streams {
+		expirable := retention.Policy == RETENTION_WINDOW || retention.Policy == RETENTION_EXPIRE
+		if !stream.live && expirable && time.Since(stream.lastModified) >= expireAfter {
+			logging.Component(logging.COMPONENT_SEGMENT).Info("[SegmentManager][clean] remove expired segments of ", path)
+			if err := os.RemoveAll(path); err != nil {
+				logging.Component(logging.COMPONENT_SEGMENT).Warn("[SegmentManager][clean] ", err)
+			}
+			delete(streams, path)
+			continue
+		}
+
+		if stream.live && retention.Policy == RETENTION_WINDOW {
+			sm.applyWindow(stream)
+		}
+
+		if quota.MaxStreamSize > 0 {
+			stream.trim(int64(quota.MaxStreamSize) * MEGA_BYTE)
+		}
+
+		total += stream.size
+	}
+
+	if quota.MaxTotalSize > 0 {
+		trimTotal(streams, total-int64(quota.MaxTotalSize)*MEGA_BYTE)
+	}
+
+	for _, stream := range streams {
+		stream.removeEmptyDirectories()
+	}
+	return nil
+}
+
+// applyWindow keep last segments of each rendition of live stream
+func (sm *SegmentManager) applyWindow(stream *streamFiles) {
+	windowSize := sm.segmentConfigure.Retention.WindowSize
+	if windowSize <= 0 {
+		windowSize = DEFAULT_RETENTION_WINDOW_SIZE
+	}
+
+	for _, segments := range stream.renditions {
+		if len(segments) <= windowSize {
+			continue
+		}
+
+		for _, file := range segments[:len(segments)-windowSize] {
+			stream.remove(file)
+		}
+	}
+}
+
+// trimTotal remove oldest segments of ended streams first and then of live streams until excess is freed
+func trimTotal(streams map[string]*streamFiles, excess int64) {
+	if excess <= 0 {
+		return
+	}
+
+	for _, live := range []bool{false, true} {
+		type candidate struct {
+			stream *streamFiles
+			file   *segmentFile
+		}
+
+		candidates := make([]candidate, 0)
+		for _, stream := range streams {
+			if stream.live != live {
+				continue
+			}
+
+			for _, file := range stream.removable() {
+				candidates = append(candidates, candidate{stream: stream, file: file})
+			}
+		}
+
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].file.modTime.Before(candidates[j].file.modTime)
+		})
+
+		for _, candidate := range candidates {
+			if excess <= 0 {
+				return
+			}
+
+			candidate.stream.remove(candidate.file)
+			if candidate.file.removed {
+				excess -= candidate.file.size
+			}
+		}
+	}
+
+	logging.Component(logging.COMPONENT_SEGMENT).Warn("[SegmentManager][trimTotal] segments exceed total quota by ", excess, " bytes")
+}
+
+// CheckDiskSpace return ErrDiskFull when free space of volume of segments is less than quota
+func (sm *SegmentManager) CheckDiskSpace() error {
+	minFreeSpace := sm.segmentConfigure.Quota.MinFreeSpace
+	if minFreeSpace <= 0 {
+		return nil
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(sm.segmentConfigure.BasePath, &stat); err != nil {
+		logging.Component(logging.COMPONENT_SEGMENT).Debug("[SegmentManager][CheckDiskSpace] can not measure free space. ", err)
+		return nil
+	}
+
+	if stat.Blocks == 0 {
+		return nil
+	}
+
+	freeSpace := 100 * float64(stat.Bavail) / float64(stat.Blocks)
+	if freeSpace < minFreeSpace {
+		return fmt.Errorf("%w. free space %.1f%%", ErrDiskFull, freeSpace)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanStreamFilesOnlySegments(t *testing.T) {
+	basePath := t.TempDir()
+	streamPath := filepath.Join(basePath, "app", "1")
+	renditionPath := filepath.Join(streamPath, "1280x720_30")
+
+	writeFile(t, filepath.Join(renditionPath, "000000.ts"), 100)
+	writeFile(t, filepath.Join(renditionPath, "000001.ts"), 100)
+	writeFile(t, filepath.Join(renditionPath, VOD_PLAYLIST_NAME), 1000)
+	writeFile(t, filepath.Join(renditionPath, VOD_MP4_NAME), 1000)
+	writeFile(t, filepath.Join(streamPath, VOD_MANIFEST_NAME), 1000)
+	writeFile(t, filepath.Join(streamPath, ffmpeg.THUMBNAIL_DIRECTORY, "000001.jpg"), 1000)
+	writeFile(t, filepath.Join(streamPath, ffmpeg.THUMBNAIL_DIRECTORY, "sprite", "sprite_000001.jpg"), 1000)
+
+	streams, err := scanStreamFiles(basePath, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, exist := streams[streamPath]
+	if len(streams) != 1 || !exist {
+		t.Fatal("invalid streams. ", streams)
+	}
+
+	if len(stream.renditions) != 1 || len(stream.renditions[renditionPath]) != 2 || stream.size != 200 {
+		t.Fatal("files which are not segment are counted. ", stream.renditions, stream.size)
+	}
+
+	stream.trim(0)
+	for _, name := range []string{VOD_PLAYLIST_NAME, VOD_MP4_NAME} {
+		if _, err := os.Stat(filepath.Join(renditionPath, name)); err != nil {
+			t.Fatal(name, " is removed by quota. ", err)
+		}
+	}
+}
+
+func TestRemoveFmp4Rendition(t *testing.T) {
+	basePath := t.TempDir()
+	streamPath := filepath.Join(basePath, "app", "1")
+	renditionPath := filepath.Join(streamPath, "1280x720_30")
+
+	writeFile(t, filepath.Join(renditionPath, ffmpeg.FMP4_INIT_NAME), 1000)
+	writeFile(t, filepath.Join(renditionPath, ffmpeg.FMP4_PLAYLIST_NAME), 1000)
+	writeFile(t, filepath.Join(renditionPath, "000000.m4s"), 100)
+	writeFile(t, filepath.Join(renditionPath, "000001.m4s"), 100)
+
+	streams, err := scanStreamFiles(basePath, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, exist := streams[streamPath]
+	if !exist || len(stream.renditions[renditionPath]) != 2 || stream.size != 200 {
+		t.Fatal("init segment or playlist is counted as segment. ", streams)
+	}
+
+	stream.trim(100)
+	stream.removeEmptyDirectories()
+	if _, err := os.Stat(filepath.Join(renditionPath, ffmpeg.FMP4_INIT_NAME)); err != nil {
+		t.Fatal("init segment is removed with remaining segment. ", err)
+	}
+
+	stream.trim(0)
+	stream.removeEmptyDirectories()
+	if _, err := os.Stat(streamPath); !os.IsNotExist(err) {
+		t.Fatal("directory of stream is not removed. ", err)
+	}
+}
+
+func TestCheckRetention(t *testing.T) {
+	segmentConfigure := configure.SegmentConfigure{}
+	segmentConfigure.Vod.Enable = true
+
+	for _, policy := range []string{"", RETENTION_VOD, RETENTION_EXPIRE} {
+		segmentConfigure.Retention.Policy = policy
+		if err := CheckRetention(segmentConfigure); err != nil {
+			t.Fatal(policy, " is rejected with VOD. ", err)
+		}
+	}
+
+	segmentConfigure.Retention.Policy = RETENTION_WINDOW
+	if err := CheckRetention(segmentConfigure); err == nil {
+		t.Fatal("window is accepted with VOD")
+	}
+
+	segmentConfigure.Vod.Enable = false
+	if err := CheckRetention(segmentConfigure); err != nil {
+		t.Fatal("window is rejected without VOD. ", err)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	PREVIEW_DIRECTORY      = "preview"
+	PREVIEW_VTT_NAME       = "preview.vtt"
+	PREVIEW_SPRITE_PATTERN = "sprite_%03d.jpg"
+
+	DEFAULT_SPRITE_INTERVAL = 10
+	DEFAULT_SPRITE_WIDTH    = 160
+	DEFAULT_SPRITE_COLUMNS  = 10
+	DEFAULT_SPRITE_ROWS     = 10
+
+	PREVIEW_TIMEOUT = 10 * time.Minute
+)
+
+// VodPreview is seek preview of VOD asset. cue of vtt point tile of sprite sheet with xywh fragment
+type VodPreview struct {
+	Vtt      string   `json:"vtt"`
+	Sprites  []string `json:"sprites"`
+	Interval int      `json:"interval"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Columns  int      `json:"columns"`
+	Rows     int      `json:"rows"`
+}
+
+// makePreview write sprite sheets and vtt of rendition which has lowest resolution to preview directory of location
+func (sm *SegmentManager) makePreview(location string, asset *VodAsset) (*VodPreview, error) {
+	spriteConfigure := sm.segmentConfigure.Thumbnail.Sprite
+	preview := &VodPreview{
+		Vtt:      PREVIEW_DIRECTORY + "/" + PREVIEW_VTT_NAME,
+		Interval: spriteConfigure.Interval,
+		Columns:  spriteConfigure.Columns,
+		Rows:     spriteConfigure.Rows,
+	}
+
+	if preview.Interval <= 0 {
+		preview.Interval = DEFAULT_SPRITE_INTERVAL
+	}
+
+	if preview.Columns <= 0 || preview.Rows <= 0 {
+		preview.Columns = DEFAULT_SPRITE_COLUMNS
+		preview.Rows = DEFAULT_SPRITE_ROWS
+	}
+
+	width := spriteConfigure.Width
+	if width <= 0 {
+		width = DEFAULT_SPRITE_WIDTH
+	}
+
+	rendition := sm.previewRendition(asset)
+	directory := filepath.Join(location, PREVIEW_DIRECTORY)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PREVIEW_TIMEOUT)
+	defer cancel()
+
+	input := filepath.Join(location, filepath.FromSlash(rendition.Playlist))
+	output := filepath.Join(directory, PREVIEW_SPRITE_PATTERN)
+	if err := ffmpeg.MakeSprites(ctx, input, output, preview.Interval, width, preview.Columns, preview.Rows); err != nil {
+		return nil, err
+	}
+
+	sprites, err := spriteFiles(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sprites) == 0 {
+		return nil, errors.New("no sprite sheet")
+	}
+
+	// every sheet has same size. last sheet is padded
+	file, err := os.Open(filepath.Join(directory, sprites[0]))
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	preview.Width = config.Width / preview.Columns
+	preview.Height = config.Height / preview.Rows
+	for _, sprite := range sprites {
+		preview.Sprites = append(preview.Sprites, PREVIEW_DIRECTORY+"/"+sprite)
+	}
+
+	vtt := previewVtt(preview, sprites, rendition.Duration)
+	if err := os.WriteFile(filepath.Join(directory, PREVIEW_VTT_NAME), []byte(vtt), 0644); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// previewRendition return rendition whose width of encoding is lowest
+func (sm *SegmentManager) previewRendition(asset *VodAsset) VodRendition {
+	widths := make(map[string]int)
+	for _, encoding := range sm.mediaConfigure.Encoding {
+		value, _, _ := strings.Cut(encoding.Resolution, "x")
+		if width, err := strconv.Atoi(value); err == nil {
+			widths[ffmpeg.RenditionName(encoding)] = width
+		}
+	}
+
+	selected := asset.Renditions[0]
+	for _, rendition := range asset.Renditions[1:] {
+		width, exist := widths[rendition.Name]
+		if exist && width < widths[selected.Name] {
+			selected = rendition
+		}
+	}
+	return selected
+}
+
+func spriteFiles(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), "sprite_") && strings.HasSuffix(entry.Name(), ".jpg") {
+			files = append(files, entry.Name())
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// previewVtt make cue of each tile. paths of sprite sheets are relative to vtt
+func previewVtt(preview *VodPreview, sprites []string, duration float64) string {
+	tiles := preview.Columns * preview.Rows
+	count := min(int(math.Ceil(duration/float64(preview.Interval))), len(sprites)*tiles)
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "WEBVTT\n")
+	for i := 0; i < count; i++ {
+		start := float64(i * preview.Interval)
+		end := math.Min(start+float64(preview.Interval), duration)
+		x := (i % tiles % preview.Columns) * preview.Width
+		y := (i % tiles / preview.Columns) * preview.Height
+
+		fmt.Fprintf(builder, "\n%s --> %s\n", vttTimestamp(start), vttTimestamp(end))
+		fmt.Fprintf(builder, "%s#xywh=%d,%d,%d,%d\n", sprites[i/tiles], x, y, preview.Width, preview.Height)
+	}
+	return builder.String()
+}
+
+func vttTimestamp(second float64) string {
+	milliseconds := int64(math.Round(second * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, milliseconds%1000)
+}
@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+const DEFAULT_S3_REGION = "us-east-1"
+
+// S3Store store objects to bucket of S3 compatible storage. key is prefixed by configured prefix
+type S3Store struct {
+	bucket   string
+	prefix   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func NewS3Store(storeConfigure configure.SegmentStoreConfigure) (*S3Store, error) {
+	if len(storeConfigure.Bucket) == 0 {
+		return nil, errors.New("bucket of s3 store is empty")
+	}
+
+	region := storeConfigure.Region
+	if len(region) == 0 {
+		region = DEFAULT_S3_REGION
+	}
+
+	config := aws.NewConfig().
+		WithRegion(region).
+		WithS3ForcePathStyle(storeConfigure.PathStyle)
+
+	if len(storeConfigure.Endpoint) > 0 {
+		config = config.WithEndpoint(storeConfigure.Endpoint)
+	}
+
+	// credentials of environment or instance role are used when access key is empty
+	if len(storeConfigure.AccessKey) > 0 {
+		config = config.WithCredentials(credentials.NewStaticCredentials(storeConfigure.AccessKey, storeConfigure.SecretKey, ""))
+	}
+
+	awsSession, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		bucket:   storeConfigure.Bucket,
+		prefix:   strings.Trim(storeConfigure.Prefix, "/"),
+		client:   s3.New(awsSession),
+		uploader: s3manager.NewUploader(awsSession),
+	}, nil
+}
+
+func (s *S3Store) objectKey(key string) string {
+	if len(s.prefix) == 0 {
+		return strings.TrimPrefix(key, "/")
+	}
+	return path.Join(s.prefix, key)
+}
+
+// Put upload data of writer while it is written. upload is completed when writer is closed
+func (s *S3Store) Put(ctx context.Context, key string) (io.WriteCloser, error) {
+	reader, writer := io.Pipe()
+	writerWithResult := &s3Writer{
+		writer: writer,
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.objectKey(key)),
+			Body:   reader,
+		})
+
+		// writer is unblocked when upload is failed
+		reader.CloseWithError(err)
+		writerWithResult.done <- err
+	}()
+	return writerWithResult, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	return err
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	base := s.objectKey("")
+
+	// trailing slash of directory is removed by join
+	objectPrefix := s.objectKey(prefix)
+	if strings.HasSuffix(prefix, "/") && len(objectPrefix) > 0 {
+		objectPrefix += "/"
+	}
+
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(objectPrefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			key := strings.TrimPrefix(strings.TrimPrefix(aws.StringValue(object.Key), base), "/")
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (s *S3Store) Location(key string) string {
+	return "s3://" + s.bucket + "/" + s.objectKey(key)
+}
+
+type s3Writer struct {
+	writer *io.PipeWriter
+	done   chan error
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	return w.writer.Write(data)
+}
+
+// Close finish body of upload and wait result
+func (w *s3Writer) Close() error {
+	w.writer.Close()
+	return <-w.done
+}
@@ -25,45 +25,48 @@ SOFTWARE.
 package segment
 
 import (
-	"os"
+	"context"
+	"io"
 
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
 )
 
 type Segment struct {
-	id       int
-	filePath string
-	file     *os.File
-	isOpend  bool
+	id      int
+	key     string
+	writer  io.WriteCloser
+	isOpend bool
 
 	beginTime media.Timestamp
 	endTime   media.Timestamp
 }
 
-func NewSegment(id int, filePath string) *Segment {
+// NewSegment create segment of key which is relative path from segment base path
+func NewSegment(id int, key string) *Segment {
 	return &Segment{
 		id:        id,
-		filePath:  filePath,
-		file:      nil,
+		key:       key,
+		writer:    nil,
 		isOpend:   false,
 		beginTime: media.Timestamp{Pts: 0, Dts: 0},
 		endTime:   media.Timestamp{Pts: 0, Dts: 0},
 	}
 }
 
-func (s *Segment) open() error {
-	file, err := os.OpenFile(s.filePath, os.O_WRONLY|os.O_CREATE, 0666)
+func (s *Segment) open(store SegmentStore) error {
+	writer, err := store.Put(context.Background(), s.key)
 	if err != nil {
 		return err
 	}
 
-	s.file = file
+	s.writer = writer
 	s.isOpend = true
 	return nil
 }
 
 func (s *Segment) write(data []byte, timestamp media.Timestamp) error {
-	if _, err := s.file.Write(data); err != nil {
+	if _, err := s.writer.Write(data); err != nil {
 		return err
 	}
 
@@ -77,9 +80,10 @@ func (s *Segment) write(data []byte, timestamp media.Timestamp) error {
 }
 
 func (s *Segment) close() {
-	if s.isOpend && s.file != nil {
-		_ = s.file.Sync()
-		s.file.Close()
+	if s.isOpend && s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			logging.Component(logging.COMPONENT_SEGMENT).Warn("[Segment][close] can not store ", s.key, ". ", err)
+		}
 
 		s.isOpend = false
 	}
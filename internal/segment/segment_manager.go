@@ -25,45 +25,164 @@ SOFTWARE.
 package segment
 
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
+	"path/filepath"
+	"sync"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
 )
 
 type SegmentManager struct {
 	segmentConfigure configure.SegmentConfigure
 	mediaConfigure   configure.MediaConfigure
-	streams          map[int]*StreamSegments
+
+	mutex   sync.Mutex
+	streams map[int]*StreamSegments
+
+	// base paths of streams which are made to VOD asset
+	finalizing map[string]bool
+
+	// uploader is nil when store is base path of segments
+	store    SegmentStore
+	uploader *Uploader
+
+	onThumbnail    ThumbnailHandler
+	onMisaligned   AlignmentHandler
+	onFirstSegment FirstSegmentHandler
+
+	stopSignal  chan struct{}
+	stopRunning sync.Once
 }
 
 func NewSessionManager(segmentConfigure configure.SegmentConfigure, mediaConfigure configure.MediaConfigure) *SegmentManager {
-	return &SegmentManager{
+	store, err := NewSegmentStore(segmentConfigure.Store, segmentConfigure.BasePath)
+	if err != nil {
+		logging.Component(logging.COMPONENT_SEGMENT).Error("[SegmentManager][NewSessionManager] can not create segment store. segments are kept in base path. ", err)
+		store = NewLocalStore(segmentConfigure.BasePath)
+	}
+
+	manager := &SegmentManager{
 		segmentConfigure: segmentConfigure,
 		mediaConfigure:   mediaConfigure,
 		streams:          make(map[int]*StreamSegments, 0),
+		finalizing:       make(map[string]bool),
+		store:            store,
+		uploader:         nil,
+		stopSignal:       make(chan struct{}),
+	}
+
+	// segments of ffmpeg are copied to store asynchronously after they are closed
+	if local, isLocal := store.(*LocalStore); !isLocal || local.Root() != filepath.Clean(segmentConfigure.BasePath) {
+		manager.uploader = NewUploader(store, segmentConfigure.Store, segmentConfigure.BasePath)
+		manager.uploader.Run()
+	}
+	return manager
+}
+
+// Stop end janitor and uploader. streams should be finalized before
+func (sm *SegmentManager) Stop() {
+	sm.stopRunning.Do(func() {
+		close(sm.stopSignal)
+		if sm.uploader != nil {
+			sm.uploader.Stop()
+		}
+	})
+}
+
+// SetThumbnailHandler regist receiver of live thumbnails. it should be called before streams are opened
+func (sm *SegmentManager) SetThumbnailHandler(handler ThumbnailHandler) {
+	sm.onThumbnail = handler
+}
+
+// SetAlignmentHandler regist receiver of misaligned segments. it should be called before streams are opened
+func (sm *SegmentManager) SetAlignmentHandler(handler AlignmentHandler) {
+	sm.onMisaligned = handler
+}
+
+// SetFirstSegmentHandler regist receiver of first segment of streams. it should be called before streams are opened
+func (sm *SegmentManager) SetFirstSegmentHandler(handler FirstSegmentHandler) {
+	sm.onFirstSegment = handler
+}
+
+// streamKey return key of stream base path in store
+func (sm *SegmentManager) streamKey(streamBasePath string) string {
+	relative, err := filepath.Rel(sm.segmentConfigure.BasePath, streamBasePath)
+	if err != nil {
+		return filepath.ToSlash(streamBasePath)
 	}
+	return filepath.ToSlash(relative)
 }
 
-func (sm *SegmentManager) OpenStreamSegments(streamId int, uri string) (*StreamSegments, error) {
-	log.Info("[SegmentManager][OpenStreamSegments][", streamId, "]")
+// OpenStreamSegments start transcoding of stream. renditionOutput is nil when renditions are not played.
+// directory creation, transcoder start and first segment are traced under span of ctx
+func (sm *SegmentManager) OpenStreamSegments(ctx context.Context, streamId int, uri string, renditionOutput ffmpeg.RenditionOutput) (*StreamSegments, error) {
+	logging.Stream(logging.COMPONENT_SEGMENT, streamId).Info("[SegmentManager][OpenStreamSegments]")
 	streamSegmentBasePath := sm.segmentConfigure.BasePath + uri
 
-	streamSegments := NewStreamSegments(sm.mediaConfigure, streamSegmentBasePath)
-	if err := streamSegments.Open(); err != nil {
+	streamKey := sm.streamKey(streamSegmentBasePath)
+	streamSegments := NewStreamSegments(sm.mediaConfigure, sm.store, streamSegmentBasePath, streamKey)
+	streamSegments.SetStreamId(streamId)
+	if renditionOutput != nil {
+		streamSegments.SetRenditionOutput(renditionOutput)
+	}
+
+	thumbnailConfigure := sm.segmentConfigure.Thumbnail
+	if thumbnailConfigure.Enable {
+		interval := thumbnailConfigure.Interval
+		if interval <= 0 {
+			interval = DEFAULT_THUMBNAIL_INTERVAL
+		}
+
+		width := thumbnailConfigure.Width
+		if width <= 0 {
+			width = DEFAULT_THUMBNAIL_WIDTH
+		}
+		streamSegments.SetThumbnail(interval, width)
+	}
+
+	if err := streamSegments.Open(ctx); err != nil {
 		return nil, err
 	}
 
+	streamSegments.first = newFirstSegmentWatcher(ctx, streamId, streamSegmentBasePath, sm.mediaConfigure.Encoding, sm.onFirstSegment)
+	go streamSegments.first.run()
+
+	if thumbnailConfigure.Enable {
+		location := sm.store.Location(streamKey + "/" + THUMBNAIL_NAME)
+		streamSegments.thumbnail = newThumbnailWatcher(streamId, streamSegmentBasePath, location, sm.uploader, sm.onThumbnail)
+		go streamSegments.thumbnail.run()
+	}
+
+	if len(sm.mediaConfigure.Encoding) > 1 {
+		streamSegments.alignment = newAlignmentWatcher(streamId, streamSegmentBasePath, sm.mediaConfigure.Encoding, sm.onMisaligned)
+		go streamSegments.alignment.run()
+	}
+
+	if sm.uploader != nil {
+		// segments are needed to make VOD asset after stream is ended
+		deleteLocal := sm.segmentConfigure.Store.DeleteLocal && !sm.segmentConfigure.Vod.Enable
+		streamSegments.watcher = newSegmentWatcher(streamSegmentBasePath, sm.uploader, deleteLocal)
+		go streamSegments.watcher.run()
+	}
+
+	sm.mutex.Lock()
 	sm.streams[streamId] = streamSegments
+	sm.mutex.Unlock()
 	return streamSegments, nil
 }
 
 func (sm *SegmentManager) CloseStreamSegments(userId int) {
-	log.Info("[SegmentManager][CloseStreamSegments][", userId, "]")
+	logging.Stream(logging.COMPONENT_SEGMENT, userId).Info("[SegmentManager][CloseStreamSegments]")
 
+	sm.mutex.Lock()
 	streamSegments := sm.streams[userId]
+	delete(sm.streams, userId)
+	sm.mutex.Unlock()
+
 	if streamSegments != nil {
 		streamSegments.Close()
+		streamSegments.stopWatchers()
 	}
-
-	delete(sm.streams, userId)
 }
@@ -25,6 +25,7 @@ SOFTWARE.
 package segment
 
 import (
+	"context"
 	"errors"
 	"os"
 	"strconv"
@@ -33,24 +34,34 @@ import (
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
 )
 
 type StreamSegments struct {
 	mediaConfigure configure.MediaConfigure
+	store          SegmentStore
 
+	// key of stream is relative path of stream base path in store
 	streamBasePath string
+	streamKey      string
 	currentSegment *Segment
 	segments       []*Segment
 
-	wrapper *ffmpeg.FFmpegWrapper
+	wrapper   *ffmpeg.FFmpegWrapper
+	watcher   *segmentWatcher
+	thumbnail *thumbnailWatcher
+	alignment *alignmentWatcher
+	first     *firstSegmentWatcher
 
 	idCounter int
 }
 
-func NewStreamSegments(mediaConfigure configure.MediaConfigure, basePath string) *StreamSegments {
+func NewStreamSegments(mediaConfigure configure.MediaConfigure, store SegmentStore, basePath, streamKey string) *StreamSegments {
 	return &StreamSegments{
 		mediaConfigure: mediaConfigure,
+		store:          store,
 		streamBasePath: basePath,
+		streamKey:      streamKey,
 		currentSegment: nil,
 		segments:       make([]*Segment, 0),
 		idCounter:      0,
@@ -58,28 +69,81 @@ func NewStreamSegments(mediaConfigure configure.MediaConfigure, basePath string)
 	}
 }
 
-func (s *StreamSegments) Open() error {
+// SetRenditionOutput regist receiver of transcoded renditions. it should be called before Open
+func (s *StreamSegments) SetRenditionOutput(output ffmpeg.RenditionOutput) {
+	s.wrapper.SetRenditionOutput(output)
+}
+
+// SetStreamId add context of stream to logs of transcoder. it should be called before Open
+func (s *StreamSegments) SetStreamId(streamId int) {
+	s.wrapper.SetStreamId(streamId)
+}
+
+// SetThumbnail make transcoder write snapshot of key frame per interval second. it should be called before Open
+func (s *StreamSegments) SetThumbnail(interval, width int) {
+	s.wrapper.SetThumbnail(interval, width)
+}
+
+// Open make directories of renditions and start transcoder. phases are traced under span of ctx
+func (s *StreamSegments) Open(ctx context.Context) error {
+	_, directorySpan := tracing.Start(ctx, tracing.SPAN_SEGMENT_DIRECTORY)
+	err := s.makeDirectory()
+	tracing.End(directorySpan, err)
+	if err != nil {
+		return err
+	}
+
+	_, ffmpegSpan := tracing.Start(ctx, tracing.SPAN_FFMPEG_START)
+	err = s.wrapper.Run()
+	tracing.End(ffmpegSpan, err)
+	return err
+}
+
+// makeDirectory make base path of stream and open transcoder which make directories of renditions
+func (s *StreamSegments) makeDirectory() error {
 	if _, err := os.Stat(s.streamBasePath); errors.Is(err, os.ErrNotExist) {
 		err := os.MkdirAll(s.streamBasePath, os.ModePerm)
 		if err != nil {
 			return err
 		}
 	}
+	return s.wrapper.Open()
+}
 
-	if err := s.wrapper.Open(); err != nil {
-		return err
+func (s *StreamSegments) Close() {
+	if s.currentSegment != nil {
+		s.currentSegment.close()
 	}
 
-	s.wrapper.Run()
-	return nil
+	s.wrapper.Stop()
 }
 
-func (s *StreamSegments) Close() {
+// Finish wait until transcoder write last segments
+func (s *StreamSegments) Finish(timeout time.Duration) {
 	if s.currentSegment != nil {
 		s.currentSegment.close()
 	}
 
-	s.wrapper.Stop()
+	s.wrapper.Finish(timeout)
+}
+
+// stopWatchers stop watchers after last segments are written. remained segments are enqueued to uploader
+func (s *StreamSegments) stopWatchers() {
+	if s.watcher != nil {
+		s.watcher.close()
+	}
+
+	if s.thumbnail != nil {
+		s.thumbnail.close()
+	}
+
+	if s.alignment != nil {
+		s.alignment.close()
+	}
+
+	if s.first != nil {
+		s.first.close()
+	}
 }
 
 func (s *StreamSegments) WriteVideo(data []byte, timeestamp media.Timestamp, isIDRFraem bool) error {
@@ -107,6 +171,10 @@ func (s *StreamSegments) WriteAudio(data []byte, timeestamp media.Timestamp) err
 	return s.wrapper.Input(data)
 }
 
+func (s *StreamSegments) WriteMetadata(data []byte, timeestamp media.Timestamp) error {
+	return s.wrapper.Input(data)
+}
+
 func (s *StreamSegments) needNewSegment(isIDRFraem bool) bool {
 	if s.currentSegment == nil {
 		return true
@@ -121,10 +189,10 @@ func (s *StreamSegments) needNewSegment(isIDRFraem bool) bool {
 
 func (s *StreamSegments) createSegment() (*Segment, error) {
 	now := time.Now().Format("20060102150405")
-	segmentFileName := s.streamBasePath + "/" + now + "_" + strconv.Itoa(s.idCounter) + ".ts"
-	segment := NewSegment(s.idCounter, segmentFileName)
+	segmentKey := s.streamKey + "/" + now + "_" + strconv.Itoa(s.idCounter) + ".ts"
+	segment := NewSegment(s.idCounter, segmentKey)
 
-	if err := segment.open(); err != nil {
+	if err := segment.open(s.store); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,179 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	THUMBNAIL_NAME = "thumbnail.jpg"
+
+	DEFAULT_THUMBNAIL_INTERVAL = 10
+	DEFAULT_THUMBNAIL_WIDTH    = 320
+
+	THUMBNAIL_WATCH_INTERVAL = time.Second
+)
+
+type Thumbnail struct {
+	Location   string
+	CapturedAt time.Time
+}
+
+// ThumbnailHandler is called when new thumbnail of stream is available in store
+type ThumbnailHandler func(streamId int, thumbnail Thumbnail)
+
+// thumbnailWatcher publish newest snapshot of transcoder as THUMBNAIL_NAME of stream directory.
+// older snapshots are removed
+type thumbnailWatcher struct {
+	streamId    int
+	path        string
+	location    string
+	uploader    *Uploader
+	onThumbnail ThumbnailHandler
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newThumbnailWatcher(streamId int, path, location string, uploader *Uploader, onThumbnail ThumbnailHandler) *thumbnailWatcher {
+	return &thumbnailWatcher{
+		streamId:    streamId,
+		path:        path,
+		location:    location,
+		uploader:    uploader,
+		onThumbnail: onThumbnail,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (w *thumbnailWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(THUMBNAIL_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.scan()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *thumbnailWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *thumbnailWatcher) scan() {
+	directory := filepath.Join(w.path, ffmpeg.THUMBNAIL_DIRECTORY)
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".jpg") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	// snapshot which is written by ffmpeg is not complete yet
+	latest := -1
+	for i := len(names) - 1; i >= 0; i-- {
+		if isCompleteJpeg(filepath.Join(directory, names[i])) {
+			latest = i
+			break
+		}
+	}
+
+	if latest < 0 {
+		return
+	}
+
+	for _, name := range names[:latest] {
+		os.Remove(filepath.Join(directory, name))
+	}
+
+	source := filepath.Join(directory, names[latest])
+	info, err := os.Stat(source)
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(w.path, THUMBNAIL_NAME)
+	if err := os.Rename(source, path); err != nil {
+		logging.Stream(logging.COMPONENT_SEGMENT, w.streamId).Warn("[ThumbnailWatcher][scan] can not publish thumbnail. ", err)
+		return
+	}
+
+	w.publish(path, info.ModTime())
+}
+
+// publish upload thumbnail before it is reported when segments are copied to store
+func (w *thumbnailWatcher) publish(path string, capturedAt time.Time) {
+	if w.onThumbnail == nil {
+		return
+	}
+
+	thumbnail := Thumbnail{Location: w.location, CapturedAt: capturedAt}
+	if w.uploader == nil {
+		w.onThumbnail(w.streamId, thumbnail)
+		return
+	}
+
+	err := w.uploader.Enqueue(path, func(err error) {
+		if err == nil {
+			w.onThumbnail(w.streamId, thumbnail)
+		}
+	})
+
+	if err != nil {
+		logging.Stream(logging.COMPONENT_SEGMENT, w.streamId).Warn("[ThumbnailWatcher][publish] ", err)
+	}
+}
+
+// isCompleteJpeg check SOI and EOI markers
+func isCompleteJpeg(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) < 4 {
+		return false
+	}
+	return bytes.HasPrefix(data, []byte{0xFF, 0xD8}) && bytes.HasSuffix(data, []byte{0xFF, 0xD9})
+}
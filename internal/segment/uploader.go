@@ -0,0 +1,348 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	DEFAULT_UPLOAD_WORKERS = 2
+	DEFAULT_UPLOAD_RETRIES = 5
+
+	UPLOAD_QUEUE_SIZE  = 1024
+	UPLOAD_TIMEOUT     = 60 * time.Second
+	UPLOAD_MIN_BACKOFF = time.Second
+	UPLOAD_MAX_BACKOFF = 30 * time.Second
+
+	SEGMENT_WATCH_INTERVAL = time.Second
+)
+
+var ErrUploaderStopped = errors.New("uploader is stopped")
+
+type uploadTask struct {
+	path    string
+	key     string
+	attempt int
+	backoff time.Duration
+
+	// onDone is called once after upload is succeeded or retries are exhausted
+	onDone func(err error)
+}
+
+// Uploader copy closed local files to segment store by workers. failed upload is retried with exponential backoff
+type Uploader struct {
+	store      SegmentStore
+	basePath   string
+	workers    int
+	maxRetries int
+	tasks      chan *uploadTask
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewUploader(store SegmentStore, storeConfigure configure.SegmentStoreConfigure, basePath string) *Uploader {
+	workers := storeConfigure.Workers
+	if workers <= 0 {
+		workers = DEFAULT_UPLOAD_WORKERS
+	}
+
+	maxRetries := storeConfigure.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DEFAULT_UPLOAD_RETRIES
+	}
+
+	return &Uploader{
+		store:      store,
+		basePath:   filepath.Clean(basePath),
+		workers:    workers,
+		maxRetries: maxRetries,
+		tasks:      make(chan *uploadTask, UPLOAD_QUEUE_SIZE),
+		stop:       make(chan struct{}),
+	}
+}
+
+func (u *Uploader) Run() {
+	for i := 0; i < u.workers; i++ {
+		go u.work()
+	}
+}
+
+// Stop workers and retries. queued and waiting tasks are done with ErrUploaderStopped
+func (u *Uploader) Stop() {
+	u.stopOnce.Do(func() {
+		close(u.stop)
+	})
+
+	for {
+		select {
+		case task := <-u.tasks:
+			task.onDone(ErrUploaderStopped)
+		default:
+			return
+		}
+	}
+}
+
+func (u *Uploader) stopped() bool {
+	select {
+	case <-u.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Enqueue upload file of local path to key which is relative path from base path
+func (u *Uploader) Enqueue(path string, onDone func(err error)) error {
+	relative, err := filepath.Rel(u.basePath, path)
+	if err != nil {
+		return err
+	}
+
+	if u.stopped() {
+		return ErrUploaderStopped
+	}
+
+	select {
+	case u.tasks <- &uploadTask{
+		path:    path,
+		key:     filepath.ToSlash(relative),
+		backoff: UPLOAD_MIN_BACKOFF,
+		onDone:  onDone,
+	}:
+		return nil
+	case <-u.stop:
+		return ErrUploaderStopped
+	}
+}
+
+func (u *Uploader) work() {
+	for {
+		select {
+		case task := <-u.tasks:
+			u.process(task)
+		case <-u.stop:
+			return
+		}
+	}
+}
+
+func (u *Uploader) process(task *uploadTask) {
+	err := u.upload(task)
+	if err == nil {
+		task.onDone(nil)
+		return
+	}
+
+	// file which is removed by janitor is not retried
+	task.attempt++
+	if task.attempt > u.maxRetries || errors.Is(err, fs.ErrNotExist) {
+		logging.Component(logging.COMPONENT_SEGMENT).Error("[Uploader][process] give up upload of ", task.key, ". ", err)
+		task.onDone(err)
+		return
+	}
+
+	// task is owned by retry goroutine after backoff of next retry is set
+	backoff := task.backoff
+	task.backoff = min(backoff*2, UPLOAD_MAX_BACKOFF)
+
+	logging.Component(logging.COMPONENT_SEGMENT).Warn("[Uploader][process] upload of ", task.key, " fail. retry after ", backoff, ". ", err)
+	go u.retry(task, backoff)
+}
+
+// retry enqueue task after backoff. task is given up when uploader is stopped while waiting
+func (u *Uploader) retry(task *uploadTask, backoff time.Duration) {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-u.stop:
+		task.onDone(ErrUploaderStopped)
+		return
+	}
+
+	if u.stopped() {
+		task.onDone(ErrUploaderStopped)
+		return
+	}
+
+	select {
+	case u.tasks <- task:
+	case <-u.stop:
+		task.onDone(ErrUploaderStopped)
+	}
+}
+
+func (u *Uploader) upload(task *uploadTask) error {
+	file, err := os.Open(task.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), UPLOAD_TIMEOUT)
+	defer cancel()
+
+	writer, err := u.store.Put(ctx, task.key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, file); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// segmentWatcher enqueue segments of stream when ffmpeg start next segment of rendition.
+// last segment of each rendition is enqueued when watcher is stopped
+type segmentWatcher struct {
+	path        string
+	uploader    *Uploader
+	deleteLocal bool
+
+	queued  map[string]bool
+	pending sync.WaitGroup
+	failed  atomic.Int32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSegmentWatcher(path string, uploader *Uploader, deleteLocal bool) *segmentWatcher {
+	return &segmentWatcher{
+		path:        path,
+		uploader:    uploader,
+		deleteLocal: deleteLocal,
+		queued:      make(map[string]bool),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (w *segmentWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(SEGMENT_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.scan(false)
+		case <-w.stop:
+			w.scan(true)
+			return
+		}
+	}
+}
+
+// close stop watcher after all segments are enqueued
+func (w *segmentWatcher) close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *segmentWatcher) scan(all bool) {
+	entries, err := os.ReadDir(w.path)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		renditionPath := filepath.Join(w.path, entry.Name())
+		files, err := segmentFiles(renditionPath)
+		if err != nil || len(files) == 0 {
+			continue
+		}
+
+		// last segment is written by ffmpeg
+		if !all {
+			files = files[:len(files)-1]
+		}
+
+		// init segment of fmp4 rendition is written before first segment. it is kept for VOD
+		if len(files) > 0 && filepath.Ext(files[0]) == ffmpeg.FMP4_SEGMENT_EXTENSION {
+			w.enqueue(filepath.Join(renditionPath, ffmpeg.FMP4_INIT_NAME), false)
+		}
+
+		for _, file := range files {
+			w.enqueue(filepath.Join(renditionPath, file), w.deleteLocal)
+		}
+	}
+}
+
+// enqueue file once. local file is removed after upload when deleteLocal is set
+func (w *segmentWatcher) enqueue(path string, deleteLocal bool) {
+	if w.queued[path] {
+		return
+	}
+	w.queued[path] = true
+
+	w.pending.Add(1)
+	err := w.uploader.Enqueue(path, func(err error) {
+		defer w.pending.Done()
+		if err != nil {
+			w.failed.Add(1)
+			return
+		}
+
+		if deleteLocal {
+			os.Remove(path)
+		}
+	})
+
+	if err != nil {
+		logging.Component(logging.COMPONENT_SEGMENT).Warn("[SegmentWatcher][enqueue] ", err)
+		w.failed.Add(1)
+		w.pending.Done()
+	}
+}
+
+// wait until uploads are finished and return whether all files are uploaded
+func (w *segmentWatcher) wait() bool {
+	w.pending.Wait()
+	return w.failed.Load() == 0
+}
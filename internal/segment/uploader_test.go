@@ -0,0 +1,187 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+// s3StandIn store objects of PUT in memory. first failures requests are rejected
+type s3StandIn struct {
+	mutex    sync.Mutex
+	failures int
+	requests int
+	objects  map[string][]byte
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	s.requests++
+	if s.requests <= s.failures {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.objects[r.URL.Path] = data
+	w.Header().Set("ETag", `"etag"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *s3StandIn) object(path string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, exist := s.objects[path]
+	return data, exist
+}
+
+func newTestUploader(t *testing.T, failures int) (*Uploader, *s3StandIn, string) {
+	t.Helper()
+
+	standIn := &s3StandIn{failures: failures, objects: make(map[string][]byte)}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	storeConfigure := configure.SegmentStoreConfigure{
+		Type:       STORE_S3,
+		Endpoint:   server.URL,
+		Bucket:     "segments",
+		Prefix:     "live",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		PathStyle:  true,
+		Workers:    1,
+		MaxRetries: 2,
+	}
+
+	store, err := NewS3Store(storeConfigure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basePath := t.TempDir()
+	uploader := NewUploader(store, storeConfigure, basePath)
+	uploader.Run()
+	t.Cleanup(uploader.Stop)
+	return uploader, standIn, basePath
+}
+
+func waitDone(t *testing.T, done chan error, timeout time.Duration) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		t.Fatal("upload is not done in ", timeout)
+	}
+	return nil
+}
+
+func TestUploaderRetryFailedUpload(t *testing.T) {
+	uploader, standIn, basePath := newTestUploader(t, 1)
+
+	path := filepath.Join(basePath, "app", "1", "1280x720_30", "000000.ts")
+	writeFile(t, path, 188)
+
+	done := make(chan error, 1)
+	if err := uploader.Enqueue(path, func(err error) { done <- err }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitDone(t, done, 5*time.Second); err != nil {
+		t.Fatal("upload fail after retry. ", err)
+	}
+
+	data, exist := standIn.object("/segments/live/app/1/1280x720_30/000000.ts")
+	if !exist || len(data) != 188 {
+		t.Fatal("object is not stored. ", standIn.objects)
+	}
+
+	if standIn.requests != 2 {
+		t.Fatal("invalid requests. ", standIn.requests)
+	}
+}
+
+func TestUploaderStopWaitingRetry(t *testing.T) {
+	uploader, standIn, basePath := newTestUploader(t, 1)
+
+	path := filepath.Join(basePath, "app", "1", "1280x720_30", "000000.ts")
+	writeFile(t, path, 188)
+
+	done := make(chan error, 1)
+	if err := uploader.Enqueue(path, func(err error) { done <- err }); err != nil {
+		t.Fatal(err)
+	}
+
+	// first upload is rejected and retry wait backoff
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		standIn.mutex.Lock()
+		requests := standIn.requests
+		standIn.mutex.Unlock()
+
+		if requests > 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("upload is not requested")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	uploader.Stop()
+	if err := waitDone(t, done, UPLOAD_MIN_BACKOFF/2); !errors.Is(err, ErrUploaderStopped) {
+		t.Fatal("waiting retry is not given up. ", err)
+	}
+
+	if err := uploader.Enqueue(path, func(error) {}); !errors.Is(err, ErrUploaderStopped) {
+		t.Fatal("task is enqueued to stopped uploader. ", err)
+	}
+}
@@ -0,0 +1,548 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yapingcat/gomedia/go-mp4"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	VOD_PLAYLIST_NAME = "index.m3u8"
+	VOD_MP4_NAME      = "vod.mp4"
+	VOD_MANIFEST_NAME = "vod.json"
+
+	DEFAULT_VOD_FINISH_TIMEOUT = 10
+)
+
+var ErrStreamNotFound = errors.New("stream segments not found")
+
+// VodSegment and VodRendition describe files of VOD asset. paths are relative to location of asset
+type VodSegment struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+}
+
+type VodRendition struct {
+	Name     string       `json:"name"`
+	Playlist string       `json:"playlist"`
+	Init     string       `json:"init,omitempty"`
+	Mp4      string       `json:"mp4,omitempty"`
+	Mp4Size  int64        `json:"mp4Size,omitempty"`
+	Duration float64      `json:"duration"`
+	Size     int64        `json:"size"`
+	Segments []VodSegment `json:"segments"`
+}
+
+// VodAsset is written to VOD_MANIFEST_NAME of location
+type VodAsset struct {
+	StreamId   int            `json:"streamId"`
+	Location   string         `json:"location"`
+	Manifest   string         `json:"manifest"`
+	Duration   float64        `json:"duration"`
+	CreatedAt  string         `json:"createdAt"`
+	Renditions []VodRendition `json:"renditions"`
+	Preview    *VodPreview    `json:"preview,omitempty"`
+}
+
+// FinalizeStreamSegments stop transcoding after last segments are written and make VOD asset of segments.
+// segments are not removed by janitor while asset is made
+func (sm *SegmentManager) FinalizeStreamSegments(streamId int) (*VodAsset, error) {
+	logging.Stream(logging.COMPONENT_SEGMENT, streamId).Info("[SegmentManager][FinalizeStreamSegments]")
+
+	sm.mutex.Lock()
+	streamSegments, exist := sm.streams[streamId]
+	delete(sm.streams, streamId)
+	if exist {
+		sm.finalizing[filepath.Clean(streamSegments.streamBasePath)] = true
+	}
+	sm.mutex.Unlock()
+
+	if !exist {
+		return nil, ErrStreamNotFound
+	}
+
+	defer func() {
+		sm.mutex.Lock()
+		delete(sm.finalizing, filepath.Clean(streamSegments.streamBasePath))
+		sm.mutex.Unlock()
+	}()
+
+	timeout := sm.segmentConfigure.Vod.FinishTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_VOD_FINISH_TIMEOUT
+	}
+	streamSegments.Finish(time.Duration(timeout) * time.Second)
+	streamSegments.stopWatchers()
+
+	location := streamSegments.streamBasePath
+	asset := &VodAsset{
+		StreamId:   streamId,
+		Location:   sm.store.Location(streamSegments.streamKey),
+		Manifest:   VOD_MANIFEST_NAME,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Renditions: make([]VodRendition, 0, len(sm.mediaConfigure.Encoding)),
+	}
+
+	for _, encoding := range sm.mediaConfigure.Encoding {
+		rendition, err := sm.finalizeRendition(location, ffmpeg.RenditionName(encoding))
+		if err != nil {
+			logging.Stream(logging.COMPONENT_SEGMENT, streamId).Warn("[SegmentManager][FinalizeStreamSegments] skip rendition ", ffmpeg.RenditionName(encoding), ". ", err)
+			continue
+		}
+
+		asset.Renditions = append(asset.Renditions, *rendition)
+		asset.Duration = math.Max(asset.Duration, rendition.Duration)
+	}
+
+	if len(asset.Renditions) == 0 {
+		return nil, errors.New("no segment of renditions")
+	}
+
+	if sm.segmentConfigure.Thumbnail.Sprite.Enable {
+		preview, err := sm.makePreview(location, asset)
+		if err != nil {
+			logging.Stream(logging.COMPONENT_SEGMENT, streamId).Warn("[SegmentManager][FinalizeStreamSegments] skip preview. ", err)
+		} else {
+			asset.Preview = preview
+		}
+	}
+
+	data, err := json.MarshalIndent(asset, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(location, VOD_MANIFEST_NAME), data, 0644); err != nil {
+		return nil, err
+	}
+
+	if streamSegments.watcher != nil {
+		if err := sm.uploadVod(streamSegments.watcher, location, asset); err != nil {
+			return nil, err
+		}
+	}
+	return asset, nil
+}
+
+// uploadVod upload files of asset and wait until segments are uploaded.
+// local files are removed when all files are uploaded and store.deleteLocal is set
+func (sm *SegmentManager) uploadVod(watcher *segmentWatcher, location string, asset *VodAsset) error {
+	files := []string{VOD_MANIFEST_NAME}
+	for _, rendition := range asset.Renditions {
+		files = append(files, rendition.Playlist)
+		if len(rendition.Init) > 0 {
+			files = append(files, rendition.Init)
+		}
+		if len(rendition.Mp4) > 0 {
+			files = append(files, rendition.Mp4)
+		}
+	}
+
+	if asset.Preview != nil {
+		files = append(files, asset.Preview.Vtt)
+		files = append(files, asset.Preview.Sprites...)
+	}
+
+	for _, file := range files {
+		watcher.enqueue(filepath.Join(location, filepath.FromSlash(file)), false)
+	}
+
+	if !watcher.wait() {
+		return errors.New("some files of vod asset are not uploaded")
+	}
+
+	if sm.segmentConfigure.Store.DeleteLocal {
+		return os.RemoveAll(location)
+	}
+	return nil
+}
+
+// vodConcatenator measure timestamps of segments and write them to single mp4 when it is opened
+type vodConcatenator interface {
+	open(path string) error
+	append(path string) (uint64, uint64, int64, error)
+	close() (int64, error)
+}
+
+// finalizeRendition write VOD playlist and concatenated mp4 of segments in directory of rendition.
+// fmp4 segments share init segment of ffmpeg
+func (sm *SegmentManager) finalizeRendition(location, name string) (*VodRendition, error) {
+	path := filepath.Join(location, name)
+	files, err := segmentFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no segment")
+	}
+
+	rendition := &VodRendition{
+		Name:     name,
+		Playlist: name + "/" + VOD_PLAYLIST_NAME,
+		Segments: make([]VodSegment, 0, len(files)),
+	}
+
+	initName := ""
+	var concatenator vodConcatenator = &mp4Concatenator{}
+	if filepath.Ext(files[0]) == ffmpeg.FMP4_SEGMENT_EXTENSION {
+		fmp4, err := newFmp4Concatenator(filepath.Join(path, ffmpeg.FMP4_INIT_NAME))
+		if err != nil {
+			return nil, err
+		}
+
+		concatenator = fmp4
+		initName = ffmpeg.FMP4_INIT_NAME
+		rendition.Init = name + "/" + initName
+	}
+
+	if sm.segmentConfigure.Vod.Mp4 {
+		if err := concatenator.open(filepath.Join(path, VOD_MP4_NAME)); err != nil {
+			return nil, err
+		}
+	}
+
+	// duration of segment is difference of first timestamps. last segment end with its last frame
+	starts := make([]uint64, 0, len(files))
+	end := uint64(0)
+	for _, file := range files {
+		start, last, size, err := concatenator.append(filepath.Join(path, file))
+		if err != nil {
+			concatenator.close()
+			return nil, err
+		}
+
+		starts = append(starts, start)
+		end = last
+		rendition.Segments = append(rendition.Segments, VodSegment{Name: file, Size: size})
+		rendition.Size += size
+	}
+
+	for i := range rendition.Segments {
+		next := end
+		if i+1 < len(starts) {
+			next = starts[i+1]
+		}
+
+		if next > starts[i] {
+			rendition.Segments[i].Duration = float64(next-starts[i]) / 1000
+		}
+		rendition.Duration += rendition.Segments[i].Duration
+	}
+
+	if sm.segmentConfigure.Vod.Mp4 {
+		size, err := concatenator.close()
+		if err != nil {
+			return nil, err
+		}
+
+		rendition.Mp4 = name + "/" + VOD_MP4_NAME
+		rendition.Mp4Size = size
+	}
+
+	if err := os.WriteFile(filepath.Join(path, VOD_PLAYLIST_NAME), []byte(vodPlaylist(rendition.Segments, initName)), 0644); err != nil {
+		return nil, err
+	}
+	return rendition, nil
+}
+
+// segmentFiles return names of segments in order of number
+func segmentFiles(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isSegmentFile(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// isSegmentFile return whether file is mpeg ts or fmp4 segment written by ffmpeg
+func isSegmentFile(name string) bool {
+	extension := filepath.Ext(name)
+	return extension == ffmpeg.TS_SEGMENT_EXTENSION || extension == ffmpeg.FMP4_SEGMENT_EXTENSION
+}
+
+// vodPlaylist make playlist of segments. init is URI of EXT-X-MAP when segments are fmp4
+func vodPlaylist(segments []VodSegment, init string) string {
+	targetDuration := 0.0
+	for _, segment := range segments {
+		targetDuration = math.Max(targetDuration, segment.Duration)
+	}
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "#EXTM3U\n")
+	if len(init) > 0 {
+		fmt.Fprintf(builder, "#EXT-X-VERSION:7\n")
+	} else {
+		fmt.Fprintf(builder, "#EXT-X-VERSION:3\n")
+	}
+	fmt.Fprintf(builder, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(builder, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(targetDuration)))
+	fmt.Fprintf(builder, "#EXT-X-MEDIA-SEQUENCE:0\n")
+	if len(init) > 0 {
+		fmt.Fprintf(builder, "#EXT-X-MAP:URI=\"%s\"\n", init)
+	}
+	for _, segment := range segments {
+		fmt.Fprintf(builder, "#EXTINF:%.3f,\n%s\n", segment.Duration, segment.Name)
+	}
+	fmt.Fprintf(builder, "#EXT-X-ENDLIST\n")
+	return builder.String()
+}
+
+// mp4Concatenator remux frames of MPEG-TS segments to single mp4. timestamps are rebased to first frame.
+// timestamps of segments are measured even if mp4 is not opened
+type mp4Concatenator struct {
+	file       *os.File
+	muxer      *mp4.Movmuxer
+	videoTrack uint32
+	audioTrack uint32
+	hasVideo   bool
+	hasAudio   bool
+
+	started bool
+	base    uint64
+	err     error
+}
+
+func (c *mp4Concatenator) open(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	muxer, err := mp4.CreateMp4Muxer(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	c.file = file
+	c.muxer = muxer
+	return nil
+}
+
+// append remux segment and return first decode time and end time of segment
+func (c *mp4Concatenator) append(path string) (uint64, uint64, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	first, last, interval := uint64(0), uint64(0), uint64(0)
+	found := false
+	measure := func(dts uint64) {
+		if !found || dts < first {
+			first = dts
+		}
+		if dts > last {
+			interval = dts - last
+			last = dts
+		}
+		found = true
+	}
+
+	demuxer := media.NewTsDemuxer()
+	demuxer.OnVideoFrame = func(frame *media.VideoFrame) {
+		measure(frame.Timestamp().Dts)
+		c.writeVideo(frame)
+	}
+	demuxer.OnAudioFrame = func(frame *media.AudioFrame) {
+		measure(frame.Timestamp().Dts)
+		c.writeAudio(frame)
+	}
+
+	if err := demuxer.Input(data); err != nil {
+		return 0, 0, 0, err
+	}
+	demuxer.Flush()
+
+	if c.err != nil {
+		return 0, 0, 0, c.err
+	}
+	// last frame last until next frame
+	if interval > 0 && last > first {
+		last += min(interval, last-first)
+	}
+	return first, last, int64(len(data)), nil
+}
+
+func (c *mp4Concatenator) rebase(timestamp media.Timestamp) (uint64, uint64) {
+	if !c.started {
+		c.started = true
+		c.base = timestamp.Dts
+	}
+
+	pts, dts := uint64(0), uint64(0)
+	if timestamp.Pts > c.base {
+		pts = timestamp.Pts - c.base
+	}
+	if timestamp.Dts > c.base {
+		dts = timestamp.Dts - c.base
+	}
+	return pts, dts
+}
+
+func (c *mp4Concatenator) writeVideo(frame *media.VideoFrame) {
+	if c.muxer == nil || c.err != nil {
+		return
+	}
+
+	if !c.hasVideo {
+		c.videoTrack = c.muxer.AddVideoTrack(mp4.MP4_CODEC_H264)
+		c.hasVideo = true
+	}
+
+	pts, dts := c.rebase(frame.Timestamp())
+	c.err = c.muxer.Write(c.videoTrack, frame.Data(), pts, dts)
+}
+
+func (c *mp4Concatenator) writeAudio(frame *media.AudioFrame) {
+	if c.muxer == nil || c.err != nil {
+		return
+	}
+
+	if !c.hasAudio {
+		c.audioTrack = c.muxer.AddAudioTrack(mp4.MP4_CODEC_AAC)
+		c.hasAudio = true
+	}
+
+	pts, dts := c.rebase(frame.Timestamp())
+	c.err = c.muxer.Write(c.audioTrack, frame.Data(), pts, dts)
+}
+
+// close write moov and return size of mp4
+func (c *mp4Concatenator) close() (int64, error) {
+	if c.file == nil {
+		return 0, nil
+	}
+	defer c.file.Close()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if err := c.muxer.WriteTrailer(); err != nil {
+		return 0, err
+	}
+
+	info, err := c.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// fmp4Concatenator write init segment and fragments of fmp4 segments to single fragmented mp4.
+// timestamps of segments are measured even if mp4 is not opened
+type fmp4Concatenator struct {
+	init   []byte
+	tracks []media.Fmp4TrackInfo
+	file   *os.File
+}
+
+func newFmp4Concatenator(initPath string) (*fmp4Concatenator, error) {
+	init, err := os.ReadFile(initPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := media.ReadFmp4Init(init)
+	if err != nil {
+		return nil, err
+	}
+	return &fmp4Concatenator{init: init, tracks: tracks}, nil
+}
+
+func (c *fmp4Concatenator) open(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(c.init); err != nil {
+		file.Close()
+		return err
+	}
+
+	c.file = file
+	return nil
+}
+
+// append copy fragments of segment and return decode time of first sample and end of last sample
+func (c *fmp4Concatenator) append(path string) (uint64, uint64, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	start, end, err := media.ReadFmp4Duration(c.tracks, data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if c.file != nil {
+		if _, err := c.file.Write(data); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return start, end, int64(len(data)), nil
+}
+
+// close return size of mp4
+func (c *fmp4Concatenator) close() (int64, error) {
+	if c.file == nil {
+		return 0, nil
+	}
+	defer c.file.Close()
+
+	info, err := c.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,126 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package segment
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+// copyFmp4Segments copy init segment and fragments of media testdata as fmp4 segments of ffmpeg
+func copyFmp4Segments(t *testing.T, renditionPath string) [][]byte {
+	t.Helper()
+
+	files := [][2]string{
+		{"fmp4_init.mp4", ffmpeg.FMP4_INIT_NAME},
+		{"fmp4_fragment_1.m4s", "000000.m4s"},
+		{"fmp4_fragment_2.m4s", "000001.m4s"},
+	}
+
+	if err := os.MkdirAll(renditionPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := make([][]byte, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join("..", "media", "testdata", file[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(renditionPath, file[1]), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, data)
+	}
+	return contents
+}
+
+func TestFinalizeFmp4Rendition(t *testing.T) {
+	location := t.TempDir()
+	contents := copyFmp4Segments(t, filepath.Join(location, "1280x720_30"))
+
+	sm := &SegmentManager{segmentConfigure: configure.SegmentConfigure{}}
+	sm.segmentConfigure.Vod.Mp4 = true
+
+	rendition, err := sm.finalizeRendition(location, "1280x720_30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rendition.Init != "1280x720_30/"+ffmpeg.FMP4_INIT_NAME || len(rendition.Segments) != 2 {
+		t.Fatalf("invalid rendition. %+v", rendition)
+	}
+
+	// first segment end at start of second segment, last segment end with its last sample
+	if rendition.Segments[0].Duration != 0.066 || rendition.Segments[1].Duration != 0.066 {
+		t.Fatalf("invalid durations. %+v", rendition.Segments)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(location, rendition.Playlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"#EXT-X-VERSION:7", "#EXT-X-MAP:URI=\"init.mp4\"", "000001.m4s"} {
+		if !strings.Contains(string(playlist), line) {
+			t.Fatalf("%s is not in playlist. %s", line, playlist)
+		}
+	}
+
+	mp4, err := os.ReadFile(filepath.Join(location, rendition.Mp4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(mp4, bytes.Join(contents, nil)) {
+		t.Fatal("mp4 is not init segment and fragments. size ", len(mp4))
+	}
+}
+
+func TestFmp4SegmentStart(t *testing.T) {
+	renditionPath := filepath.Join(t.TempDir(), "1280x720_30")
+	copyFmp4Segments(t, renditionPath)
+
+	numbers := segmentNumbers([]string{"000000.m4s", "000001.m4s"})
+	if numbers[1] != "000001.m4s" {
+		t.Fatal("invalid numbers. ", numbers)
+	}
+
+	if start, exist := segmentStart(filepath.Join(renditionPath, numbers[1])); !exist || start != 1066 {
+		t.Fatal("invalid start of fmp4 segment. ", start, exist)
+	}
+
+	os.Remove(filepath.Join(renditionPath, ffmpeg.FMP4_INIT_NAME))
+	if _, exist := segmentStart(filepath.Join(renditionPath, numbers[1])); exist {
+		t.Fatal("start is measured without init segment")
+	}
+}
@@ -0,0 +1,423 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/flv"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/pull"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/record"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/session"
+)
+
+const (
+	API_PULL_PATH      = "/api/v1/pulls"
+	API_VIEWER_PATH    = "/api/v1/viewers"
+	API_RECORDING_PATH = "/api/v1/recordings"
+	API_QUALITY_PATH   = "/api/v1/qualities"
+
+	MAX_API_REQUEST_SIZE = 64 * 1024
+)
+
+type pullRequest struct {
+	StreamKey string `json:"streamKey"`
+	Url       string `json:"url"`
+}
+
+type pullResponse struct {
+	Id        string `json:"id"`
+	Url       string `json:"url"`
+	StartedAt string `json:"startedAt"`
+}
+
+type recordingFileResponse struct {
+	Path     string  `json:"path"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+	Closed   bool    `json:"closed"`
+}
+
+type recordingResponse struct {
+	StreamId  int                     `json:"streamId"`
+	Format    string                  `json:"format"`
+	StartedAt string                  `json:"startedAt"`
+	Files     []recordingFileResponse `json:"files"`
+}
+
+type qualityResponse struct {
+	StreamId          int      `json:"streamId"`
+	Score             int      `json:"score"`
+	FrameRate         float64  `json:"frameRate"`
+	DeclaredFrameRate float64  `json:"declaredFrameRate"`
+	GopLength         float64  `json:"gopLength"`
+	GopVariance       float64  `json:"gopVariance"`
+	Bitrate           float64  `json:"bitrate"`
+	DeclaredBitrate   float64  `json:"declaredBitrate"`
+	AvDrift           int64    `json:"avDrift"`
+	KeyFrameInterval  float64  `json:"keyFrameInterval"`
+	TimestampGaps     int      `json:"timestampGaps"`
+	NonMonotonicDts   int      `json:"nonMonotonicDts"`
+	Issues            []string `json:"issues"`
+	MeasuredAt        string   `json:"measuredAt"`
+}
+
+// apiServer is http api of this node. admin api is served by admin handler on its own listener
+type apiServer struct {
+	service    *Service
+	mux        *http.ServeMux
+	adminMux   *http.ServeMux
+	adminToken string
+	flvServer  *flv.Server
+
+	mutex sync.Mutex
+	pulls map[string]*pull.Conn
+}
+
+func newApiServer(service *Service) *apiServer {
+	api := &apiServer{
+		service:    service,
+		mux:        http.NewServeMux(),
+		adminMux:   http.NewServeMux(),
+		adminToken: service.configure.Server.Api.Admin.Token,
+		pulls:      make(map[string]*pull.Conn),
+	}
+
+	api.adminMux.HandleFunc(API_PULL_PATH, api.handlePulls)
+	api.adminMux.HandleFunc(API_PULL_PATH+"/", api.handlePull)
+
+	flvConfigure := service.configure.Server.Api.Flv
+	if flvConfigure.Enable {
+		api.flvServer = flv.NewServer(flvConfigure, service.sessionManager.SubscribePlay)
+		api.mux.Handle(api.flvServer.Path()+"/", api.flvServer)
+		api.adminMux.HandleFunc(API_VIEWER_PATH, api.handleViewers)
+	}
+
+	if service.configure.Record.Enable {
+		api.adminMux.HandleFunc(API_RECORDING_PATH, api.handleRecordings)
+		api.adminMux.HandleFunc(API_RECORDING_PATH+"/", api.handleRecording)
+	}
+
+	if service.configure.Media.Quality.Enable {
+		api.adminMux.HandleFunc(API_QUALITY_PATH, api.handleQualities)
+		api.adminMux.HandleFunc(API_QUALITY_PATH+"/", api.handleQuality)
+	}
+
+	if llhls := service.sessionManager.LowLatencyHls(); llhls != nil {
+		api.mux.Handle(llhls.Path()+"/", llhls)
+	}
+
+	if dash := service.sessionManager.Dash(); dash != nil {
+		api.mux.Handle(dash.Path()+"/", dash)
+	}
+	return api
+}
+
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// Admin return handler of admin api. bearer token is checked when it is configured
+func (a *apiServer) Admin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.adminToken) > 0 {
+			token, exist := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !exist || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+				logging.Component(logging.COMPONENT_SERVICE).Warn("[ApiServer][Admin] reject admin request from ", r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		a.adminMux.ServeHTTP(w, r)
+	})
+}
+
+// handlePulls list pull sessions on GET and start pull session on POST
+func (a *apiServer) handlePulls(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.mutex.Lock()
+		responses := make([]pullResponse, 0, len(a.pulls))
+		for _, conn := range a.pulls {
+			responses = append(responses, newPullResponse(conn))
+		}
+		a.mutex.Unlock()
+
+		writeJson(w, http.StatusOK, responses)
+	case http.MethodPost:
+		a.startPull(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleViewers response count of FLV viewers of each stream
+func (a *apiServer) handleViewers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, http.StatusOK, a.flvServer.Viewers())
+}
+
+// handlePull stop pull session of {API_PULL_PATH}/{id} on DELETE
+func (a *apiServer) handlePull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, API_PULL_PATH+"/")
+
+	a.mutex.Lock()
+	conn, exist := a.pulls[id]
+	a.mutex.Unlock()
+
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	logging.Component(logging.COMPONENT_SERVICE).WithField(logging.FIELD_CONNECTION_ID, id).Info("[ApiServer][handlePull] stop pull")
+	conn.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startPull validate stream key and start pulling source.
+// session is run even though validation fail so that closing connection clean up session.
+func (a *apiServer) startPull(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_API_REQUEST_SIZE))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	request := pullRequest{}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.StreamKey) == 0 || len(request.Url) == 0 {
+		http.Error(w, "streamKey and url are required", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := pull.NewConn(newApiId(), request.StreamKey, request.Url)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// pull is removed by close handler however session is failed
+	a.mutex.Lock()
+	a.pulls[conn.Id()] = conn
+	a.mutex.Unlock()
+
+	conn.SetCloseHandler(
+		func() {
+			a.mutex.Lock()
+			delete(a.pulls, conn.Id())
+			a.mutex.Unlock()
+		})
+
+	session := a.service.sessionManager.CreateNewPullSession(conn)
+	err = session.OnPrePare(pull.APP_NAME, conn.StreamKey())
+	go session.Run()
+
+	if err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).WithField(logging.FIELD_CONNECTION_ID, conn.Id()).Warn("[ApiServer][startPull] reject pull of ", conn.RedactedUrl(), ". ", err)
+		conn.Close()
+		if errors.Is(err, rtmp.ErrServiceUnavailable) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return
+	}
+
+	logging.Component(logging.COMPONENT_SERVICE).WithField(logging.FIELD_CONNECTION_ID, conn.Id()).Info("[ApiServer][startPull] start pull from ", conn.RedactedUrl())
+	conn.Open()
+	writeJson(w, http.StatusCreated, newPullResponse(conn))
+}
+
+// handleRecordings list recording streams
+func (a *apiServer) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := a.service.sessionManager.Recordings()
+	responses := make([]recordingResponse, 0, len(statuses))
+	for _, status := range statuses {
+		responses = append(responses, newRecordingResponse(status))
+	}
+	writeJson(w, http.StatusOK, responses)
+}
+
+// handleRecording start recording of {API_RECORDING_PATH}/{streamId} on POST and stop it on DELETE
+func (a *apiServer) handleRecording(w http.ResponseWriter, r *http.Request) {
+	streamId, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, API_RECORDING_PATH+"/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var status record.Status
+	responseStatus := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		status, err = a.service.sessionManager.StartRecording(streamId)
+		responseStatus = http.StatusCreated
+	case http.MethodDelete:
+		status, err = a.service.sessionManager.StopRecording(streamId)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case err == nil:
+		logging.Stream(logging.COMPONENT_SERVICE, streamId).Info("[ApiServer][handleRecording] ", r.Method, " recording")
+		writeJson(w, responseStatus, newRecordingResponse(status))
+	case errors.Is(err, rtmp.ErrStreamNotFound), errors.Is(err, session.ErrRecordingNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, session.ErrRecordingExist):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logging.Stream(logging.COMPONENT_SERVICE, streamId).Warn("[ApiServer][handleRecording] recording fail. ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newRecordingResponse(status record.Status) recordingResponse {
+	files := make([]recordingFileResponse, 0, len(status.Files))
+	for _, file := range status.Files {
+		files = append(files, recordingFileResponse{
+			Path:     file.Path,
+			Size:     file.Size,
+			Duration: file.Duration.Seconds(),
+			Closed:   file.Closed,
+		})
+	}
+
+	return recordingResponse{
+		StreamId:  status.StreamId,
+		Format:    status.Format,
+		StartedAt: status.StartedAt.Format(time.RFC3339),
+		Files:     files,
+	}
+}
+
+// handleQualities list source quality of publishing streams
+func (a *apiServer) handleQualities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	reports := a.service.sessionManager.QualityReports()
+	responses := make([]qualityResponse, 0, len(reports))
+	for _, report := range reports {
+		responses = append(responses, newQualityResponse(report))
+	}
+	writeJson(w, http.StatusOK, responses)
+}
+
+// handleQuality response source quality of {API_QUALITY_PATH}/{streamId}
+func (a *apiServer) handleQuality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	streamId, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, API_QUALITY_PATH+"/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	report, err := a.service.sessionManager.QualityReport(streamId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, newQualityResponse(report))
+}
+
+func newQualityResponse(report session.QualityReport) qualityResponse {
+	issues := report.Issues
+	if issues == nil {
+		issues = []string{}
+	}
+
+	return qualityResponse{
+		StreamId:          report.StreamId,
+		Score:             report.Score,
+		FrameRate:         report.FrameRate,
+		DeclaredFrameRate: report.DeclaredFrameRate,
+		GopLength:         report.GopLength,
+		GopVariance:       report.GopVariance,
+		Bitrate:           report.Bitrate,
+		DeclaredBitrate:   report.DeclaredBitrate,
+		AvDrift:           report.AvDrift.Milliseconds(),
+		KeyFrameInterval:  report.KeyFrameInterval.Seconds(),
+		TimestampGaps:     report.TimestampGaps,
+		NonMonotonicDts:   report.NonMonotonicDts,
+		Issues:            issues,
+		MeasuredAt:        report.MeasuredAt.Format(time.RFC3339),
+	}
+}
+
+func newPullResponse(conn *pull.Conn) pullResponse {
+	return pullResponse{
+		Id:        conn.Id(),
+		Url:       conn.Url(),
+		StartedAt: conn.StartedAt().Format(time.RFC3339),
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).Warn("[ApiServer][writeJson] write fail. ", err)
+	}
+}
+
+func newApiId() string {
+	buffer := make([]byte, 16)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,127 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package service
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	DEFAULT_CERTIFICATE_RELOAD_INTERVAL = 60
+)
+
+// certificateReloader serve certificate for tls handshake
+// and reload it when certificate or key file is changed.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	checkedAt   time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string, intervalSecond int) (*certificateReloader, error) {
+	if intervalSecond <= 0 {
+		intervalSecond = DEFAULT_CERTIFICATE_RELOAD_INTERVAL
+	}
+
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: time.Duration(intervalSecond) * time.Second,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checkedAt) >= r.interval {
+		if modTime, err := r.lastModTime(); err == nil && modTime.After(r.modTime) {
+			if err := r.loadLocked(); err != nil {
+				logging.Component(logging.COMPONENT_SERVICE).Error("[CertificateReloader][GetCertificate] reload fail. keep previous certificate. ", err)
+			} else {
+				logging.Component(logging.COMPONENT_SERVICE).Info("[CertificateReloader][GetCertificate] certificate reloaded")
+			}
+		}
+		r.checkedAt = time.Now()
+	}
+
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) load() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.loadLocked()
+}
+
+func (r *certificateReloader) loadLocked() error {
+	modTime, err := r.lastModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func (r *certificateReloader) lastModTime() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
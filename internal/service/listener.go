@@ -0,0 +1,126 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package service
+
+import (
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	NETWORK_TCP    = "tcp"
+	NETWORK_TCP_V6 = "tcp6"
+	NETWORK_UDP    = "udp"
+
+	DEFAULT_PROXY_HEADER_TIMEOUT = 5
+)
+
+// listenAll listen every address.
+// NETWORK_DEFAULT_IP with port is used when addresses is empty.
+func listenAll(addresses []string, port string, proxyProtocol configure.ProxyProtocolConfigure) ([]net.Listener, error) {
+	if len(addresses) == 0 {
+		addresses = []string{NETWORK_DEFAULT_IP + ":" + port}
+	}
+
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listen, err := listenAddress(address, proxyProtocol)
+		if err != nil {
+			logging.Component(logging.COMPONENT_SERVICE).Error("[Service][listenAll] can not listen ", address, ". ", err)
+			closeAll(listeners)
+			return nil, err
+		}
+
+		listeners = append(listeners, listen)
+	}
+	return listeners, nil
+}
+
+func listenAddress(address string, proxyProtocol configure.ProxyProtocolConfigure) (net.Listener, error) {
+	listen, err := net.Listen(listenNetwork(address), address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !proxyProtocol.Enable {
+		return listen, nil
+	}
+
+	// PROXY header from peer which is not trusted is spoofed. connection with it is rejected
+	policy := func(_ net.Addr) (proxyproto.Policy, error) {
+		return proxyproto.REJECT, nil
+	}
+
+	if len(proxyProtocol.TrustedProxies) > 0 {
+		policy, err = proxyproto.StrictWhiteListPolicy(proxyProtocol.TrustedProxies)
+		if err != nil {
+			listen.Close()
+			return nil, err
+		}
+	} else {
+		logging.Component(logging.COMPONENT_SERVICE).Warn("[Service][listenAddress] proxy protocol is enabled without trusted proxies. PROXY header is rejected on ", address)
+	}
+
+	headerTimeout := proxyProtocol.HeaderTimeout
+	if headerTimeout <= 0 {
+		headerTimeout = DEFAULT_PROXY_HEADER_TIMEOUT
+	}
+
+	return &proxyproto.Listener{
+		Listener:          listen,
+		Policy:            policy,
+		ReadHeaderTimeout: time.Duration(headerTimeout) * time.Second,
+	}, nil
+}
+
+// listenNetwork decide network by host of address.
+// IPv6 only listen is needed so that IPv4 and IPv6 wildcard can be listened on same port.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return NETWORK_TCP
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return NETWORK_TCP
+	case ip.To4() != nil:
+		return NETWORK_TCP_V4
+	default:
+		return NETWORK_TCP_V6
+	}
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, listen := range listeners {
+		listen.Close()
+	}
+}
@@ -0,0 +1,95 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package service
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+// acceptWithProxyHeader send PROXY v1 header of 203.0.113.1 and return remote address seen by server
+func acceptWithProxyHeader(t *testing.T, proxyProtocol configure.ProxyProtocolConfigure) (string, error) {
+	t.Helper()
+
+	listen, err := listenAddress("127.0.0.1:0", proxyProtocol)
+	if err != nil {
+		t.Fatal("listen fail. ", err)
+	}
+	defer listen.Close()
+
+	client, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal("dial fail. ", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("PROXY TCP4 203.0.113.1 127.0.0.1 40000 1935\r\nhello")); err != nil {
+		t.Fatal("write fail. ", err)
+	}
+
+	connection, err := listen.Accept()
+	if err != nil {
+		t.Fatal("accept fail. ", err)
+	}
+	defer connection.Close()
+
+	connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 5)
+	if _, err := connection.Read(buffer); err != nil {
+		return "", err
+	}
+
+	host, _, _ := net.SplitHostPort(connection.RemoteAddr().String())
+	return host, nil
+}
+
+func TestProxyHeaderOfTrustedProxy(t *testing.T) {
+	host, err := acceptWithProxyHeader(t, configure.ProxyProtocolConfigure{
+		Enable:         true,
+		TrustedProxies: []string{"127.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal("read fail. ", err)
+	}
+
+	if host != "203.0.113.1" {
+		t.Fatal("address of PROXY header is not used. ", host)
+	}
+}
+
+func TestProxyHeaderOfUntrustedPeer(t *testing.T) {
+	for _, trustedProxies := range [][]string{nil, {"10.0.0.0/8"}} {
+		host, err := acceptWithProxyHeader(t, configure.ProxyProtocolConfigure{
+			Enable:         true,
+			TrustedProxies: trustedProxies,
+		})
+		if err == nil {
+			t.Fatal("PROXY header of untrusted peer is accepted. trusted proxies : ", trustedProxies, ", address : ", host)
+		}
+	}
+}
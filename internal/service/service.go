@@ -25,19 +25,30 @@ SOFTWARE.
 package service
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
-
-	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/discovery"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/session"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/srt"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/whip"
 )
 
 const (
 	NETWORK_TCP_V4     = "tcp4"
 	NETWORK_DEFAULT_IP = "0.0.0.0"
+	ADMIN_DEFAULT_IP   = "127.0.0.1"
+
+	DEFAULT_SRT_RETRY_INTERVAL = 5
 )
 
 type Service struct {
@@ -55,22 +66,191 @@ func NewService(configure *configure.Configure) *Service {
 }
 
 func (s *Service) Run() error {
-	log.Info("[Service][Run] service running")
+	logging.Component(logging.COMPONENT_SERVICE).Info("[Service][Run] service running")
 
 	if err := s.updateBroadcastServiceAddress(); err != nil {
 		return err
 	}
 
-	address := NETWORK_DEFAULT_IP + ":" + s.configure.Server.RtmpPort
-	listen, err := net.Listen(NETWORK_TCP_V4, address)
+	if err := s.registInstance(); err != nil {
+		return err
+	}
+
+	serverConfigure := s.configure.Server
+	listeners, err := listenAll(serverConfigure.RtmpAddresses, serverConfigure.RtmpPort, serverConfigure.ProxyProtocol)
 	if err != nil {
 		return err
 	}
 
+	if serverConfigure.Rtmps.Enable {
+		tlsListeners, err := s.listenRtmps()
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		listeners = append(listeners, tlsListeners...)
+	}
+
+	srtListeners := []net.Listener{}
+	if serverConfigure.Srt.Enable && len(serverConfigure.Srt.Port) > 0 {
+		srtListeners, err = s.listenSrt()
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+	}
+
+	apiListeners := []net.Listener{}
+	var api *apiServer
+	if serverConfigure.Api.Enable {
+		api = newApiServer(s)
+		apiListeners, err = listenAll(serverConfigure.Api.Addresses, serverConfigure.Api.Port, configure.ProxyProtocolConfigure{})
+		if err != nil {
+			closeAll(listeners)
+			closeAll(srtListeners)
+			return err
+		}
+	}
+
+	adminListeners := []net.Listener{}
+	if serverConfigure.Api.Enable {
+		adminListeners, err = s.listenAdmin()
+		if err != nil {
+			closeAll(listeners)
+			closeAll(srtListeners)
+			closeAll(apiListeners)
+			return err
+		}
+	}
+
+	whipListeners := []net.Listener{}
+	var whipServer *whip.Server
+	if serverConfigure.Whip.Enable {
+		whipServer, whipListeners, err = s.listenWhip()
+		if err != nil {
+			closeAll(listeners)
+			closeAll(srtListeners)
+			closeAll(apiListeners)
+			closeAll(adminListeners)
+			return err
+		}
+	}
+
+	wait := sync.WaitGroup{}
+	for _, listen := range listeners {
+		wait.Add(1)
+		go func(listen net.Listener) {
+			defer wait.Done()
+			s.accept(listen)
+		}(listen)
+	}
+
+	for _, listen := range srtListeners {
+		wait.Add(1)
+		go func(listen net.Listener) {
+			defer wait.Done()
+			s.acceptSrt(listen)
+		}(listen)
+	}
+
+	for _, listen := range whipListeners {
+		wait.Add(1)
+		go func(listen net.Listener) {
+			defer wait.Done()
+			logging.Component(logging.COMPONENT_SERVICE).Info("[Service][Run] whip listen ", listen.Addr())
+			if err := http.Serve(listen, whipServer); err != nil {
+				logging.Component(logging.COMPONENT_SERVICE).Error("[Service][Run] whip server error. ", err)
+			}
+		}(listen)
+	}
+
+	for _, listen := range apiListeners {
+		wait.Add(1)
+		go func(listen net.Listener) {
+			defer wait.Done()
+			logging.Component(logging.COMPONENT_SERVICE).Info("[Service][Run] api listen ", listen.Addr())
+			if err := http.Serve(listen, api); err != nil {
+				logging.Component(logging.COMPONENT_SERVICE).Error("[Service][Run] api server error. ", err)
+			}
+		}(listen)
+	}
+
+	for _, listen := range adminListeners {
+		wait.Add(1)
+		go func(listen net.Listener) {
+			defer wait.Done()
+			logging.Component(logging.COMPONENT_SERVICE).Info("[Service][Run] admin api listen ", listen.Addr())
+			if err := http.Serve(listen, api.Admin()); err != nil {
+				logging.Component(logging.COMPONENT_SERVICE).Error("[Service][Run] admin api server error. ", err)
+			}
+		}(listen)
+	}
+
+	if serverConfigure.Srt.Enable {
+		for _, caller := range serverConfigure.Srt.Callers {
+			wait.Add(1)
+			go func(caller configure.SrtCallerConfigure) {
+				defer wait.Done()
+				s.callSrt(caller)
+			}(caller)
+		}
+	}
+
+	wait.Wait()
+	return nil
+}
+
+// listenAdmin listen admin api on loopback unless addresses are configured. admin api is disabled without port
+func (s *Service) listenAdmin() ([]net.Listener, error) {
+	adminConfigure := s.configure.Server.Api.Admin
+	if len(adminConfigure.Port) == 0 {
+		logging.Component(logging.COMPONENT_SERVICE).Info("[Service][listenAdmin] admin api is disabled")
+		return []net.Listener{}, nil
+	}
+
+	addresses := adminConfigure.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{ADMIN_DEFAULT_IP + ":" + adminConfigure.Port}
+	} else if len(adminConfigure.Token) == 0 {
+		logging.Component(logging.COMPONENT_SERVICE).Warn("[Service][listenAdmin] admin api is served on ", addresses, " without token")
+	}
+	return listenAll(addresses, adminConfigure.Port, configure.ProxyProtocolConfigure{})
+}
+
+func (s *Service) listenRtmps() ([]net.Listener, error) {
+	rtmpsConfigure := s.configure.Server.Rtmps
+	reloader, err := newCertificateReloader(rtmpsConfigure.CertFile, rtmpsConfigure.KeyFile, rtmpsConfigure.ReloadInterval)
+	if err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).Error("[Service][listenRtmps] can not load certificate. ", err)
+		return nil, err
+	}
+
+	tlsConfigure := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	// PROXY header is in front of tls handshake
+	listeners, err := listenAll(rtmpsConfigure.Addresses, rtmpsConfigure.Port, s.configure.Server.ProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, listen := range listeners {
+		listeners[i] = tls.NewListener(listen, tlsConfigure)
+	}
+	return listeners, nil
+}
+
+func (s *Service) accept(listen net.Listener) {
+	logging.Component(logging.COMPONENT_SERVICE).Info("[Service][accept] listen ", listen.Addr())
 	for {
 		connection, err := listen.Accept()
 		if err != nil {
-			log.Warn("[Service][Run] connection error. ", err)
+			logging.Component(logging.COMPONENT_SERVICE).Warn("[Service][accept] connection error. ", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			continue
 		}
 
@@ -80,6 +260,124 @@ func (s *Service) Run() error {
 	}
 }
 
+func (s *Service) srtConfig(streamId string) srt.Config {
+	srtConfigure := s.configure.Server.Srt
+	return srt.Config{
+		Passphrase: srtConfigure.Passphrase,
+		KeyLength:  srtConfigure.PbKeyLength,
+		Latency:    time.Duration(srtConfigure.Latency) * time.Millisecond,
+		StreamId:   streamId,
+	}
+}
+
+func (s *Service) listenSrt() ([]net.Listener, error) {
+	srtConfigure := s.configure.Server.Srt
+	addresses := srtConfigure.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{NETWORK_DEFAULT_IP + ":" + srtConfigure.Port}
+	}
+
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		network := strings.Replace(listenNetwork(address), NETWORK_TCP, NETWORK_UDP, 1)
+		listen, err := srt.Listen(network, address, s.srtConfig(""))
+		if err != nil {
+			logging.Component(logging.COMPONENT_SERVICE).Error("[Service][listenSrt] can not listen ", address, ". ", err)
+			closeAll(listeners)
+			return nil, err
+		}
+
+		listeners = append(listeners, listen)
+	}
+	return listeners, nil
+}
+
+func (s *Service) acceptSrt(listen net.Listener) {
+	logging.Component(logging.COMPONENT_SERVICE).Info("[Service][acceptSrt] listen ", listen.Addr())
+	for {
+		connection, err := listen.Accept()
+		if err != nil {
+			logging.Component(logging.COMPONENT_SERVICE).Warn("[Service][acceptSrt] connection error. ", err)
+			if err == srt.ErrListenerClosed {
+				return
+			}
+			continue
+		}
+
+		streamId := connection.(*srt.Conn).StreamId()
+		socketTransport := transport.NewSocketTransporter(connection, s.configure.Server.PacketSize)
+		session := s.sessionManager.CreateNewSrtSession(socketTransport, streamId)
+		go session.Run()
+	}
+}
+
+// callSrt connect to remote SRT listener and pull stream.
+// connection is retried when stream is ended.
+func (s *Service) callSrt(caller configure.SrtCallerConfigure) {
+	retryInterval := s.configure.Server.Srt.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DEFAULT_SRT_RETRY_INTERVAL
+	}
+
+	network := strings.Replace(listenNetwork(caller.Address), NETWORK_TCP, NETWORK_UDP, 1)
+	for {
+		connection, err := srt.Dial(network, caller.Address, s.srtConfig(caller.StreamId))
+		if err != nil {
+			logging.Component(logging.COMPONENT_SERVICE).Warn("[Service][callSrt] can not connect ", caller.Address, ". ", err)
+		} else {
+			socketTransport := transport.NewSocketTransporter(connection, s.configure.Server.PacketSize)
+			session := s.sessionManager.CreateNewSrtSession(socketTransport, caller.StreamId)
+			session.Run()
+		}
+
+		time.Sleep(time.Duration(retryInterval) * time.Second)
+	}
+}
+
+func (s *Service) listenWhip() (*whip.Server, []net.Listener, error) {
+	whipConfigure := s.configure.Server.Whip
+	whipServer, err := whip.NewServer(whipConfigure, s.publishWhip)
+	if err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).Error("[Service][listenWhip] can not create whip server. ", err)
+		return nil, nil, err
+	}
+
+	listeners, err := listenAll(whipConfigure.Addresses, whipConfigure.Port, configure.ProxyProtocolConfigure{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(whipConfigure.CertFile) == 0 {
+		return whipServer, listeners, nil
+	}
+
+	reloader, err := newCertificateReloader(whipConfigure.CertFile, whipConfigure.KeyFile, whipConfigure.ReloadInterval)
+	if err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).Error("[Service][listenWhip] can not load certificate. ", err)
+		closeAll(listeners)
+		return nil, nil, err
+	}
+
+	tlsConfigure := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	for i, listen := range listeners {
+		listeners[i] = tls.NewListener(listen, tlsConfigure)
+	}
+	return whipServer, listeners, nil
+}
+
+// publishWhip validate stream key before SDP negotiation.
+// session is run even though validation fail so that closing connection clean up session.
+func (s *Service) publishWhip(conn *whip.Conn) error {
+	session := s.sessionManager.CreateNewWhipSession(conn)
+	err := session.OnPrePare(whip.APP_NAME, conn.StreamKey())
+	go session.Run()
+	return err
+}
+
 func (s *Service) updateBroadcastServiceAddress() error {
 	broadcastServerAddress, err := s.discorveryClient.GetBroadcastServiceAddress()
 	if err != nil {
@@ -89,3 +387,25 @@ func (s *Service) updateBroadcastServiceAddress() error {
 	s.configure.Server.BroadcastServerAddress = broadcastServerAddress
 	return nil
 }
+
+func (s *Service) registInstance() error {
+	if !s.configure.Server.Discovery.Instance.Regist {
+		return nil
+	}
+
+	port, err := strconv.Atoi(s.configure.Server.RtmpPort)
+	if err != nil {
+		return err
+	}
+
+	if err := s.discorveryClient.RegistInstance(port); err != nil {
+		logging.Component(logging.COMPONENT_SERVICE).Error("[Service][registInstance] can not regist instance. ", err)
+		return err
+	}
+
+	s.sessionManager.SetCapacityHandler(
+		func(full bool) {
+			s.discorveryClient.UpdateCapacity(full)
+		})
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+	gortmp "github.com/ISSuh/mystream-media_preprocessor/third_party/gomedia/go-rtmp"
+	"github.com/yapingcat/gomedia/go-codec"
+)
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+// writeSelfSignedCertificate write certificate of 127.0.0.1 and return pool which trust it
+func writeSelfSignedCertificate(t *testing.T, certFile, keyFile string) *x509.CertPool {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return pool
+}
+
+// publishHandler record publish of rtmp context
+type publishHandler struct {
+	streamPath chan string
+	published  chan struct{}
+	frames     chan *media.VideoFrame
+}
+
+func (h *publishHandler) OnHandshakeDone() {}
+
+func (h *publishHandler) OnPrePare(_, streamPath string) error {
+	h.streamPath <- streamPath
+	return nil
+}
+
+func (h *publishHandler) OnPublish() {
+	close(h.published)
+}
+
+func (h *publishHandler) OnPlay(_, _ string) error {
+	return rtmp.ErrStreamNotFound
+}
+
+func (h *publishHandler) OnPlayStart() {}
+
+func (h *publishHandler) OnError() {}
+
+func (h *publishHandler) OnMetadata(_ *media.StreamMetadata, _ media.Timestamp) error {
+	return nil
+}
+
+func (h *publishHandler) OnVideoFrame(frame *media.VideoFrame) {
+	h.frames <- frame
+}
+
+func (h *publishHandler) OnAudioFrame(_ *media.AudioFrame) {}
+
+func TestRtmpsPublish(t *testing.T) {
+	directory := t.TempDir()
+	certFile := filepath.Join(directory, "cert.pem")
+	keyFile := filepath.Join(directory, "key.pem")
+	pool := writeSelfSignedCertificate(t, certFile, keyFile)
+
+	service := &Service{configure: &configure.Configure{}}
+	service.configure.Server.Rtmps = configure.RtmpsConfigure{
+		Enable:    true,
+		Addresses: []string{"127.0.0.1:0"},
+		CertFile:  certFile,
+		KeyFile:   keyFile,
+	}
+
+	listeners, err := service.listenRtmps()
+	if err != nil {
+		t.Fatal("listen fail. ", err)
+	}
+	defer closeAll(listeners)
+
+	handler := &publishHandler{
+		streamPath: make(chan string, 1),
+		published:  make(chan struct{}),
+		frames:     make(chan *media.VideoFrame, 4),
+	}
+
+	// server side is same as session except handler
+	go func() {
+		connection, err := listeners[0].Accept()
+		if err != nil {
+			return
+		}
+
+		socketTransport := transport.NewSocketTransporter(connection, 4096)
+		defer socketTransport.Close()
+
+		context := rtmp.NewContext()
+		context.RegistHandler(handler, socketTransport)
+		for {
+			data, err := socketTransport.Read()
+			if err != nil {
+				return
+			}
+
+			if err := context.InputStream(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	connection, err := tls.Dial("tcp", listeners[0].Addr().String(), &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatal("tls dial fail. ", err)
+	}
+	defer connection.Close()
+
+	// client is driven by reader goroutine and test
+	mutex := sync.Mutex{}
+	publishing := make(chan struct{})
+	client := gortmp.NewRtmpClient(gortmp.WithEnablePublish())
+	client.SetOutput(func(data []byte) error {
+		_, err := connection.Write(data)
+		return err
+	})
+	client.OnStateChange(func(state gortmp.RtmpState) {
+		if state == gortmp.STATE_RTMP_PUBLISH_START {
+			close(publishing)
+		}
+	})
+	// gomedia parse url of rtmp scheme only. tls is done by connection
+	client.Start("rtmp://" + listeners[0].Addr().String() + "/live/test-key")
+
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := connection.Read(buffer)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			client.Input(buffer[:n])
+			mutex.Unlock()
+		}
+	}()
+
+	select {
+	case streamPath := <-handler.streamPath:
+		if streamPath != "test-key" {
+			t.Fatal("invalid stream path. ", streamPath)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish is not requested")
+	}
+
+	select {
+	case <-publishing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish is not started")
+	}
+
+	mutex.Lock()
+	err = client.WriteVideo(codec.CODECID_VIDEO_H264, append([]byte{}, testH264Frame...), 0, 0)
+	mutex.Unlock()
+	if err != nil {
+		t.Fatal("write video fail. ", err)
+	}
+
+	select {
+	case frame := <-handler.frames:
+		if frame.Codec() != media.CODEC_VIDEO_H264 {
+			t.Fatal("invalid codec. ", frame.Codec())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("video frame is not received")
+	}
+}
+
+func TestAcceptReturnOnClosedListener(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		(&Service{configure: &configure.Configure{}}).accept(listen)
+		close(done)
+	}()
+
+	listen.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept does not return after listener is closed")
+	}
+}
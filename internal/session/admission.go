@@ -0,0 +1,210 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+const (
+	DEFAULT_ADMISSION_CHECK_INTERVAL = 5
+	PROC_STAT_PATH                   = "/proc/stat"
+)
+
+// admission decide whether node can accept new publish
+// by concurrent sessions, transcoding renditions, cpu usage and free space of segments.
+type admission struct {
+	configure              configure.AdmissionConfigure
+	transcodingsPerSession int
+
+	cpuUsage atomic.Uint64
+	full     atomic.Bool
+
+	mutex             sync.Mutex
+	onCapacityChanged func(full bool)
+
+	// checkDiskSpace return error when volume of segments is nearly full
+	checkDiskSpace func() error
+
+	stopSignal  chan struct{}
+	stopRunning sync.Once
+}
+
+func newAdmission(admissionConfigure configure.AdmissionConfigure, transcodingsPerSession int) *admission {
+	return &admission{
+		configure:              admissionConfigure,
+		transcodingsPerSession: transcodingsPerSession,
+		stopSignal:             make(chan struct{}),
+	}
+}
+
+func (a *admission) setCapacityHandler(handler func(full bool)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.onCapacityChanged = handler
+}
+
+func (a *admission) stop() {
+	a.stopRunning.Do(func() {
+		close(a.stopSignal)
+	})
+}
+
+func (a *admission) admit(sessionCount int) error {
+	if a.configure.MaxSessions > 0 && sessionCount >= a.configure.MaxSessions {
+		return fmt.Errorf("%w. sessions %d", rtmp.ErrServiceUnavailable, sessionCount)
+	}
+
+	transcodings := (sessionCount + 1) * a.transcodingsPerSession
+	if a.configure.MaxTranscodings > 0 && transcodings > a.configure.MaxTranscodings {
+		return fmt.Errorf("%w. transcodings %d", rtmp.ErrServiceUnavailable, transcodings)
+	}
+
+	cpuUsage := a.cpuUsage.Load()
+	if a.configure.MaxCpuUsage > 0 && float64(cpuUsage) >= a.configure.MaxCpuUsage {
+		return fmt.Errorf("%w. cpu usage %d%%", rtmp.ErrServiceUnavailable, cpuUsage)
+	}
+
+	if a.checkDiskSpace != nil {
+		if err := a.checkDiskSpace(); err != nil {
+			return fmt.Errorf("%w. %v", rtmp.ErrServiceUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// update reports capacity when it is changed
+func (a *admission) update(sessionCount int) {
+	full := a.admit(sessionCount) != nil
+	if a.full.Swap(full) == full {
+		return
+	}
+
+	logging.Component(logging.COMPONENT_SESSION).Info("[Admission][update] capacity changed. full : ", full)
+
+	a.mutex.Lock()
+	onCapacityChanged := a.onCapacityChanged
+	a.mutex.Unlock()
+
+	if onCapacityChanged != nil {
+		go onCapacityChanged(full)
+	}
+}
+
+// monitor re-evaluate capacity periodically because cpu usage and free space of segments change without sessions
+func (a *admission) monitor(sessionCount func() int) {
+	measureCpu := a.configure.MaxCpuUsage > 0
+	if !measureCpu && a.checkDiskSpace == nil {
+		return
+	}
+
+	interval := a.configure.CheckInterval
+	if interval <= 0 {
+		interval = DEFAULT_ADMISSION_CHECK_INTERVAL
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	prevIdle, prevTotal := uint64(0), uint64(0)
+	if measureCpu {
+		idle, total, err := readCpuStat()
+		if err != nil {
+			logging.Component(logging.COMPONENT_SESSION).Warn("[Admission][monitor] can not measure cpu usage. ", err)
+			measureCpu = false
+		}
+		prevIdle, prevTotal = idle, total
+	}
+
+	for {
+		select {
+		case <-a.stopSignal:
+			return
+		case <-ticker.C:
+		}
+
+		if measureCpu {
+			idle, total, err := readCpuStat()
+			if err != nil {
+				logging.Component(logging.COMPONENT_SESSION).Warn("[Admission][monitor] can not measure cpu usage. ", err)
+			} else {
+				if total > prevTotal {
+					usage := 100 * (1 - float64(idle-prevIdle)/float64(total-prevTotal))
+					a.cpuUsage.Store(uint64(usage))
+				}
+				prevIdle, prevTotal = idle, total
+			}
+		}
+
+		a.update(sessionCount())
+	}
+}
+
+// readCpuStat return idle and total jiffies of all cpu
+func readCpuStat() (uint64, uint64, error) {
+	file, err := os.Open(PROC_STAT_PATH)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0, 0, errors.New("empty cpu stat")
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("invalid cpu stat")
+	}
+
+	idle, total := uint64(0), uint64(0)
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += value
+		}
+		total += value
+	}
+	return idle, total, nil
+}
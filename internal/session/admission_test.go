@@ -0,0 +1,161 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+func TestAdmissionAdmit(t *testing.T) {
+	diskFull := errors.New("disk is full")
+	tests := []struct {
+		name         string
+		configure    configure.AdmissionConfigure
+		sessionCount int
+		cpuUsage     uint64
+		diskSpace    error
+		admitted     bool
+	}{
+		{"unlimited", configure.AdmissionConfigure{}, 100, 100, nil, true},
+		{"under max sessions", configure.AdmissionConfigure{MaxSessions: 2}, 1, 0, nil, true},
+		{"max sessions", configure.AdmissionConfigure{MaxSessions: 2}, 2, 0, nil, false},
+		{"transcodings of new session fit", configure.AdmissionConfigure{MaxTranscodings: 6}, 1, 0, nil, true},
+		{"transcodings of new session exceed", configure.AdmissionConfigure{MaxTranscodings: 6}, 2, 0, nil, false},
+		{"under cpu usage", configure.AdmissionConfigure{MaxCpuUsage: 80}, 0, 79, nil, true},
+		{"cpu usage", configure.AdmissionConfigure{MaxCpuUsage: 80}, 0, 80, nil, false},
+		{"disk space", configure.AdmissionConfigure{}, 0, 0, diskFull, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			admission := newAdmission(test.configure, 3)
+			admission.cpuUsage.Store(test.cpuUsage)
+			admission.checkDiskSpace = func() error { return test.diskSpace }
+
+			err := admission.admit(test.sessionCount)
+			if test.admitted != (err == nil) {
+				t.Fatal("unexpected admission. ", err)
+			}
+
+			if err != nil && !errors.Is(err, rtmp.ErrServiceUnavailable) {
+				t.Fatal("refusal is not service unavailable. ", err)
+			}
+		})
+	}
+}
+
+func TestAdmissionUpdateReportChangeOnly(t *testing.T) {
+	admission := newAdmission(configure.AdmissionConfigure{MaxSessions: 2}, 0)
+
+	reports := make(chan bool, 4)
+	admission.setCapacityHandler(func(full bool) {
+		reports <- full
+	})
+
+	// capacity is reported from other goroutine so that each change is waited before next update
+	steps := []struct {
+		sessionCounts []int
+		full          bool
+	}{
+		{[]int{1, 2, 3}, true},
+		{[]int{1, 0}, false},
+	}
+
+	for _, step := range steps {
+		for _, sessionCount := range step.sessionCounts {
+			admission.update(sessionCount)
+		}
+
+		select {
+		case report := <-reports:
+			if report != step.full {
+				t.Fatal("invalid capacity. ", report)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("capacity change is not reported")
+		}
+	}
+
+	select {
+	case report := <-reports:
+		t.Fatal("capacity is reported without change. ", report)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAdmissionMonitorDiskSpaceWithoutCpu(t *testing.T) {
+	admission := newAdmission(configure.AdmissionConfigure{CheckInterval: 1}, 0)
+	t.Cleanup(admission.stop)
+
+	var diskFull atomic.Bool
+	admission.checkDiskSpace = func() error {
+		if diskFull.Load() {
+			return errors.New("disk is full")
+		}
+		return nil
+	}
+
+	reports := make(chan bool, 1)
+	admission.setCapacityHandler(func(full bool) {
+		reports <- full
+	})
+
+	go admission.monitor(func() int { return 0 })
+
+	diskFull.Store(true)
+	select {
+	case full := <-reports:
+		if !full {
+			t.Fatal("node is not full")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("capacity of disk space is not re-evaluated")
+	}
+}
+
+func TestAdmissionMonitorStop(t *testing.T) {
+	admission := newAdmission(configure.AdmissionConfigure{CheckInterval: 1}, 0)
+	admission.checkDiskSpace = func() error { return nil }
+
+	done := make(chan struct{})
+	go func() {
+		admission.monitor(func() int { return 0 })
+		close(done)
+	}()
+
+	admission.stop()
+	admission.stop()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("monitor is not stopped")
+	}
+}
@@ -25,7 +25,9 @@ SOFTWARE.
 package dto
 
 type StreamActive struct {
-	StreamKey string `json:"streamKey"`
+	StreamKey  string `json:"streamKey"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	Reason     string `json:"reason,omitempty"`
 }
 
 func NewStreamActive(streamKey string) StreamActive {
@@ -33,3 +35,10 @@ func NewStreamActive(streamKey string) StreamActive {
 		StreamKey: streamKey,
 	}
 }
+
+func NewStreamDeactive(streamKey, reason string) StreamActive {
+	return StreamActive{
+		StreamKey: streamKey,
+		Reason:    reason,
+	}
+}
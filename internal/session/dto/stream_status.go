@@ -25,9 +25,12 @@ SOFTWARE.
 package dto
 
 type StreamStatus struct {
-	StreamId   int    `json:"id"`
-	Active     bool   `json:"active"`
-	Url        string `json:"url"`
-	ActiveAt   string `json:"streamActiveAt"`
-	DeactiveAt string `json:"streamDeactiveAt"`
+	StreamId   int           `json:"id"`
+	Active     bool          `json:"active"`
+	Url        string        `json:"url"`
+	ActiveAt   string        `json:"streamActiveAt"`
+	DeactiveAt string        `json:"streamDeactiveAt"`
+	Limit      StreamLimit   `json:"limit"`
+	Relays     []StreamRelay `json:"relays"`
+	Record     bool          `json:"record"`
 }
@@ -0,0 +1,48 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package dto
+
+// StreamVod is reported when VOD asset of ended stream is made
+type StreamVod struct {
+	StreamKey  string  `json:"streamKey"`
+	Location   string  `json:"location"`
+	Manifest   string  `json:"manifest"`
+	Duration   float64 `json:"duration"`
+	Renditions int     `json:"renditions"`
+
+	// vtt of seek preview which is relative to location
+	Preview string `json:"preview,omitempty"`
+}
+
+func NewStreamVod(streamKey, location, manifest string, duration float64, renditions int, preview string) StreamVod {
+	return StreamVod{
+		StreamKey:  streamKey,
+		Location:   location,
+		Manifest:   manifest,
+		Duration:   duration,
+		Renditions: renditions,
+		Preview:    preview,
+	}
+}
@@ -24,8 +24,18 @@ SOFTWARE.
 
 package session
 
+import (
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
 type Handler interface {
 	checkValidStream(session *Session, appName, streamPath string) error
+	checkValidPlay(session *Session, appName, streamPath string) error
+	checkValidMetadata(session *Session, metadata *media.StreamMetadata) error
+	checkValidResolution(session *Session, width, height int) error
+	checkValidFrameRate(session *Session, frameRate float64) error
+	checkIngestBitrate(session *Session, bitrate float64) error
+	qualityDegraded(session *Session, report *QualityReport)
 	streamStart(session *Session) error
 	streamEnd(session *Session)
 	streamError(session *Session)
@@ -0,0 +1,224 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/session/dto"
+)
+
+type LimitAction string
+
+const (
+	LIMIT_ACTION_WARN       LimitAction = "warn"
+	LIMIT_ACTION_REJECT     LimitAction = "reject"
+	LIMIT_ACTION_DISCONNECT LimitAction = "disconnect"
+)
+
+const (
+	DEFAULT_MEASURE_WINDOW = 5
+)
+
+// kind of limit check. violation is reported once for each kind until it is recovered
+const (
+	limitKindMetadata    = "metadata"
+	limitKindResolution  = "resolution"
+	limitKindFrameRate   = "frameRate"
+	limitKindBitrate     = "ingestBitrate"
+	limitKindNodeBitrate = "nodeIngestBitrate"
+)
+
+type LimitViolation struct {
+	Reason string
+}
+
+func (v *LimitViolation) Error() string {
+	return v.Reason
+}
+
+type publishLimit struct {
+	maxWidth         int
+	maxHeight        int
+	maxFrameRate     float64
+	maxVideoDataRate float64
+	maxIngestBitrate float64
+	action           LimitAction
+
+	// kinds which are violated on last check
+	violating map[string]bool
+
+	// kinds which are checked at least once. first check of each kind is publish time of reject action
+	checked map[string]bool
+}
+
+// newPublishLimit merge limit of stream from broadcast service to configured limit
+func newPublishLimit(limitConfigure configure.MediaLimitConfigure, streamLimit dto.StreamLimit) publishLimit {
+	limit := publishLimit{
+		maxWidth:         limitConfigure.MaxWidth,
+		maxHeight:        limitConfigure.MaxHeight,
+		maxFrameRate:     limitConfigure.MaxFrameRate,
+		maxVideoDataRate: limitConfigure.MaxVideoDataRate,
+		maxIngestBitrate: limitConfigure.MaxIngestBitrate,
+		action:           LimitAction(limitConfigure.Action),
+		violating:        make(map[string]bool),
+		checked:          make(map[string]bool),
+	}
+
+	if streamLimit.MaxWidth > 0 {
+		limit.maxWidth = streamLimit.MaxWidth
+	}
+
+	if streamLimit.MaxHeight > 0 {
+		limit.maxHeight = streamLimit.MaxHeight
+	}
+
+	if streamLimit.MaxFrameRate > 0 {
+		limit.maxFrameRate = streamLimit.MaxFrameRate
+	}
+
+	if streamLimit.MaxIngestBitrate > 0 {
+		limit.maxIngestBitrate = streamLimit.MaxIngestBitrate
+	}
+
+	switch limit.action {
+	case LIMIT_ACTION_WARN, LIMIT_ACTION_REJECT, LIMIT_ACTION_DISCONNECT:
+	default:
+		limit.action = LIMIT_ACTION_DISCONNECT
+	}
+	return limit
+}
+
+func (l *publishLimit) checkMetadata(metadata *media.StreamMetadata) error {
+	if err := l.checkResolution(metadata.Width, metadata.Height); err != nil {
+		return err
+	}
+
+	if err := l.checkFrameRate(metadata.FrameRate); err != nil {
+		return err
+	}
+
+	if l.maxVideoDataRate > 0 && metadata.VideoDataRate > l.maxVideoDataRate {
+		return &LimitViolation{
+			Reason: fmt.Sprintf("video data rate %.0fkbps exceed limit %.0fkbps", metadata.VideoDataRate, l.maxVideoDataRate),
+		}
+	}
+	return nil
+}
+
+func (l *publishLimit) checkResolution(width, height int) error {
+	if (l.maxWidth > 0 && width > l.maxWidth) || (l.maxHeight > 0 && height > l.maxHeight) {
+		return &LimitViolation{
+			Reason: fmt.Sprintf("resolution %dx%d exceed limit %dx%d", width, height, l.maxWidth, l.maxHeight),
+		}
+	}
+	return nil
+}
+
+func (l *publishLimit) checkFrameRate(frameRate float64) error {
+	if l.maxFrameRate > 0 && frameRate > l.maxFrameRate {
+		return &LimitViolation{
+			Reason: fmt.Sprintf("frame rate %.2f exceed limit %.2f", frameRate, l.maxFrameRate),
+		}
+	}
+	return nil
+}
+
+func (l *publishLimit) checkIngestBitrate(bitrate float64) error {
+	if l.maxIngestBitrate > 0 && bitrate > l.maxIngestBitrate {
+		return &LimitViolation{
+			Reason: fmt.Sprintf("ingest bitrate %.0fkbps exceed limit %.0fkbps", bitrate, l.maxIngestBitrate),
+		}
+	}
+	return nil
+}
+
+// changed update violation state of kind and return true when state is changed
+func (l *publishLimit) changed(kind string, violation error) bool {
+	if l.violating == nil {
+		l.violating = make(map[string]bool)
+	}
+
+	violating := violation != nil
+	if l.violating[kind] == violating {
+		return false
+	}
+
+	l.violating[kind] = violating
+	return true
+}
+
+// firstCheck mark kind as checked and return true when kind is checked at first
+func (l *publishLimit) firstCheck(kind string) bool {
+	if l.checked == nil {
+		l.checked = make(map[string]bool)
+	}
+
+	if l.checked[kind] {
+		return false
+	}
+
+	l.checked[kind] = true
+	return true
+}
+
+// rateMeter measure count per second over tumbling window
+type rateMeter struct {
+	window time.Duration
+	begin  time.Time
+	count  int
+	rate   float64
+}
+
+func newRateMeter(windowSecond int) *rateMeter {
+	if windowSecond <= 0 {
+		windowSecond = DEFAULT_MEASURE_WINDOW
+	}
+
+	return &rateMeter{
+		window: time.Duration(windowSecond) * time.Second,
+	}
+}
+
+// add count and return rate when window is completed
+func (m *rateMeter) add(count int, now time.Time) (float64, bool) {
+	if m.begin.IsZero() {
+		m.begin = now
+	}
+
+	m.count += count
+	elapsed := now.Sub(m.begin)
+	if elapsed < m.window {
+		return m.rate, false
+	}
+
+	m.rate = float64(m.count) / elapsed.Seconds()
+	m.begin = now
+	m.count = 0
+	return m.rate, true
+}
@@ -0,0 +1,298 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/session/dto"
+)
+
+func TestRateMeterWindow(t *testing.T) {
+	meter := newRateMeter(5)
+	begin := time.Unix(1000, 0)
+
+	if _, updated := meter.add(1000, begin); updated {
+		t.Fatal("rate is updated on first add")
+	}
+
+	if _, updated := meter.add(1000, begin.Add(5*time.Second-time.Millisecond)); updated {
+		t.Fatal("rate is updated before window is completed")
+	}
+
+	rate, updated := meter.add(3000, begin.Add(5*time.Second))
+	if !updated || rate != 1000 {
+		t.Fatal("invalid rate on window boundary. ", rate, updated)
+	}
+
+	// next window begin from boundary and previous count is dropped
+	if rate, updated := meter.add(500, begin.Add(6*time.Second)); updated || rate != 1000 {
+		t.Fatal("new window is not started. ", rate, updated)
+	}
+
+	rate, updated = meter.add(4500, begin.Add(10*time.Second))
+	if !updated || rate != 1000 {
+		t.Fatal("invalid rate of second window. ", rate, updated)
+	}
+}
+
+func TestRateMeterDefaultWindow(t *testing.T) {
+	meter := newRateMeter(0)
+	if meter.window != DEFAULT_MEASURE_WINDOW*time.Second {
+		t.Fatal("invalid default window. ", meter.window)
+	}
+}
+
+func TestNewPublishLimit(t *testing.T) {
+	limitConfigure := configure.MediaLimitConfigure{
+		MaxWidth:         1920,
+		MaxHeight:        1080,
+		MaxFrameRate:     60,
+		MaxIngestBitrate: 10000,
+		Action:           "unknown",
+	}
+
+	limit := newPublishLimit(limitConfigure, dto.StreamLimit{MaxWidth: 1280, MaxIngestBitrate: 4000})
+	if limit.maxWidth != 1280 || limit.maxHeight != 1080 || limit.maxFrameRate != 60 || limit.maxIngestBitrate != 4000 {
+		t.Fatalf("limit of stream is not merged. %+v", limit)
+	}
+
+	if limit.action != LIMIT_ACTION_DISCONNECT {
+		t.Fatal("unknown action is not disconnect. ", limit.action)
+	}
+}
+
+func TestPublishLimitCheck(t *testing.T) {
+	limit := newPublishLimit(configure.MediaLimitConfigure{
+		MaxWidth:         1280,
+		MaxHeight:        720,
+		MaxFrameRate:     30,
+		MaxVideoDataRate: 4000,
+		MaxIngestBitrate: 5000,
+	}, dto.StreamLimit{})
+
+	tests := []struct {
+		name      string
+		check     func() error
+		violation bool
+	}{
+		{"resolution on limit", func() error { return limit.checkResolution(1280, 720) }, false},
+		{"width exceed", func() error { return limit.checkResolution(1281, 720) }, true},
+		{"height exceed", func() error { return limit.checkResolution(1280, 721) }, true},
+		{"frame rate on limit", func() error { return limit.checkFrameRate(30) }, false},
+		{"frame rate exceed", func() error { return limit.checkFrameRate(30.5) }, true},
+		{"bitrate on limit", func() error { return limit.checkIngestBitrate(5000) }, false},
+		{"bitrate exceed", func() error { return limit.checkIngestBitrate(5001) }, true},
+		{"metadata on limit", func() error {
+			return limit.checkMetadata(&media.StreamMetadata{Width: 1280, Height: 720, FrameRate: 30, VideoDataRate: 4000})
+		}, false},
+		{"metadata data rate exceed", func() error {
+			return limit.checkMetadata(&media.StreamMetadata{Width: 640, Height: 360, VideoDataRate: 4500})
+		}, true},
+		{"metadata frame rate exceed", func() error { return limit.checkMetadata(&media.StreamMetadata{FrameRate: 60}) }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.check()
+			var violation *LimitViolation
+			if test.violation != errors.As(err, &violation) {
+				t.Fatal("unexpected result. ", err)
+			}
+		})
+	}
+
+	unlimited := newPublishLimit(configure.MediaLimitConfigure{}, dto.StreamLimit{})
+	if unlimited.checkResolution(7680, 4320) != nil || unlimited.checkFrameRate(240) != nil || unlimited.checkIngestBitrate(1e6) != nil {
+		t.Fatal("zero limit is not unlimited")
+	}
+}
+
+// newLimitTestManager return manager which send stream warning to returned channel
+func newLimitTestManager(t *testing.T, limitConfigure configure.MediaLimitConfigure) (*Manager, chan string) {
+	warnings := make(chan string, 16)
+	broadcastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == StreamWarningUrlPath {
+			warnings <- string(body)
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(broadcastServer.Close)
+
+	serviceConfigure := &configure.Configure{}
+	serviceConfigure.Server.BroadcastServerAddress = strings.TrimPrefix(broadcastServer.URL, HttpScheme)
+	serviceConfigure.Media.Limit = limitConfigure
+
+	manager := &Manager{
+		configure:  serviceConfigure,
+		sessions:   make(map[int]*Session),
+		httpClient: broadcastServer.Client(),
+	}
+	return manager, warnings
+}
+
+func newLimitTestSession(manager *Manager) *Session {
+	session := newSession(manager, &testTransporter{}, configure.TimeoutConfigure{})
+	session.setLimit(newPublishLimit(manager.configure.Media.Limit, dto.StreamLimit{}), 1)
+	return session
+}
+
+func waitWarning(t *testing.T, warnings chan string, expected bool) {
+	t.Helper()
+
+	select {
+	case warning := <-warnings:
+		if !expected {
+			t.Fatal("unexpected warning. ", warning)
+		}
+	case <-time.After(200 * time.Millisecond):
+		if expected {
+			t.Fatal("warning is not reported")
+		}
+	}
+}
+
+func TestHandleLimitViolation(t *testing.T) {
+	tests := []struct {
+		action LimitAction
+		// result of violation found on first check and after it
+		refuseOnPublish   bool
+		refuseOnStreaming bool
+	}{
+		{LIMIT_ACTION_WARN, false, false},
+		{LIMIT_ACTION_REJECT, true, false},
+		{LIMIT_ACTION_DISCONNECT, true, true},
+	}
+
+	checks := []struct {
+		kind  string
+		check func(manager *Manager, session *Session, exceed bool) error
+	}{
+		{limitKindResolution, func(manager *Manager, session *Session, exceed bool) error {
+			if exceed {
+				return manager.checkValidResolution(session, 1920, 1080)
+			}
+			return manager.checkValidResolution(session, 1280, 720)
+		}},
+		{limitKindFrameRate, func(manager *Manager, session *Session, exceed bool) error {
+			if exceed {
+				return manager.checkValidFrameRate(session, 60)
+			}
+			return manager.checkValidFrameRate(session, 30)
+		}},
+		{limitKindMetadata, func(manager *Manager, session *Session, exceed bool) error {
+			if exceed {
+				return manager.checkValidMetadata(session, &media.StreamMetadata{VideoDataRate: 8000})
+			}
+			return manager.checkValidMetadata(session, &media.StreamMetadata{VideoDataRate: 2000})
+		}},
+		{limitKindBitrate, func(manager *Manager, session *Session, exceed bool) error {
+			if exceed {
+				return manager.checkIngestBitrate(session, 8000)
+			}
+			return manager.checkIngestBitrate(session, 2000)
+		}},
+	}
+
+	for _, test := range tests {
+		for _, check := range checks {
+			t.Run(string(test.action)+"/"+check.kind, func(t *testing.T) {
+				manager, warnings := newLimitTestManager(t, configure.MediaLimitConfigure{
+					MaxWidth:         1280,
+					MaxHeight:        720,
+					MaxFrameRate:     30,
+					MaxVideoDataRate: 4000,
+					MaxIngestBitrate: 4000,
+					Action:           string(test.action),
+				})
+
+				// violation at publish time
+				session := newLimitTestSession(manager)
+				err := check.check(manager, session, true)
+				if (err != nil) != test.refuseOnPublish {
+					t.Fatal("unexpected result at publish time. ", err)
+				}
+				waitWarning(t, warnings, !test.refuseOnPublish)
+
+				// violation after stream is passed first check
+				session = newLimitTestSession(manager)
+				if err := check.check(manager, session, false); err != nil {
+					t.Fatal(err)
+				}
+
+				err = check.check(manager, session, true)
+				if (err != nil) != test.refuseOnStreaming {
+					t.Fatal("unexpected result while streaming. ", err)
+				}
+				waitWarning(t, warnings, !test.refuseOnStreaming)
+
+				if !test.refuseOnStreaming {
+					// warning is reported once until violation is recovered
+					if err := check.check(manager, session, true); err != nil {
+						t.Fatal(err)
+					}
+					waitWarning(t, warnings, false)
+				}
+			})
+		}
+	}
+}
+
+func TestNodeIngestBitrateLimit(t *testing.T) {
+	manager, warnings := newLimitTestManager(t, configure.MediaLimitConfigure{
+		MaxNodeIngestBitrate: 10000,
+		Action:               string(LIMIT_ACTION_DISCONNECT),
+	})
+
+	publisher := newLimitTestSession(manager)
+	publisher.ingestBitrate.Store(6000)
+	manager.sessions[1] = publisher
+
+	session := newLimitTestSession(manager)
+	session.ingestBitrate.Store(3000)
+	manager.sessions[2] = session
+	if err := manager.checkIngestBitrate(session, 3000); err != nil {
+		t.Fatal(err)
+	}
+
+	session.ingestBitrate.Store(5000)
+	if err := manager.checkIngestBitrate(session, 5000); err == nil {
+		t.Fatal("node ingest bitrate exceed while streaming is not handled")
+	}
+	waitWarning(t, warnings, false)
+
+	if err := manager.checkNodeIngestBitrate(); err == nil {
+		t.Fatal("node ingest bitrate exceed is not found")
+	}
+}
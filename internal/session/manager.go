@@ -26,37 +26,76 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/dash"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/hls"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/record"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/relay"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/segment"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/session/dto"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
 )
 
 const (
-	HttpScheme            = "http://"
-	StreamUrlPathPrefix   = "/api/broadcast/v1/streams/"
-	StreamActiveUrlPath   = StreamUrlPathPrefix + "active"
-	StreamDeactiveUrlPath = StreamUrlPathPrefix + "deactive"
+	HttpScheme             = "http://"
+	StreamUrlPathPrefix    = "/api/broadcast/v1/streams/"
+	StreamActiveUrlPath    = StreamUrlPathPrefix + "active"
+	StreamDeactiveUrlPath  = StreamUrlPathPrefix + "deactive"
+	StreamWarningUrlPath   = StreamUrlPathPrefix + "warning"
+	StreamPlayUrlPath      = StreamUrlPathPrefix + "play"
+	StreamRelayUrlPath     = StreamUrlPathPrefix + "relay"
+	StreamVodUrlPath       = StreamUrlPathPrefix + "vod"
+	StreamThumbnailUrlPath = StreamUrlPathPrefix + "thumbnail"
+
+	// placeholder of static relay url which is replaced to id of stream
+	RelayUrlStreamId = "{streamId}"
+)
+
+var (
+	ErrRecordingDisabled  = errors.New("recording is disabled")
+	ErrRecordingExist     = errors.New("stream is already recorded")
+	ErrRecordingNotFound  = errors.New("stream is not recorded")
+	ErrQualityNotMeasured = errors.New("quality of stream is not measured")
 )
 
 type Manager struct {
 	configure *configure.Configure
 	sessions  map[int]*Session
+	reserved  int
+	mutex     sync.Mutex
 	rand      *rand.Rand
+	admission *admission
 
 	httpClient *http.Client
 
 	segmentManager *segment.SegmentManager
+	hub            *playback.Hub
+	llhls          *hls.Server
+	dash           *dash.Server
 }
 
 func NewManager(configure *configure.Configure) *Manager {
@@ -67,8 +106,20 @@ func NewManager(configure *configure.Configure) *Manager {
 		configure:      configure,
 		sessions:       make(map[int]*Session),
 		rand:           rand,
+		admission:      newAdmission(configure.Server.Admission, len(configure.Media.Encoding)),
 		httpClient:     nil,
 		segmentManager: segment.NewSessionManager(configure.Segment, configure.Media),
+		hub:            playback.NewHub(configure.Server.Play.QueueSize),
+	}
+
+	if configure.Server.Api.LlHls.Enable {
+		segmentDuration := time.Duration(configure.Segment.TsRange) * time.Second
+		Manager.llhls = hls.NewServer(configure.Server.Api.LlHls, segmentDuration, Manager.ValidatePlay)
+	}
+
+	if configure.Server.Api.Dash.Enable {
+		segmentDuration := time.Duration(configure.Segment.TsRange) * time.Second
+		Manager.dash = dash.NewServer(configure.Server.Api.Dash, segmentDuration, Manager.authenticatePlay)
 	}
 
 	Manager.httpClient = &http.Client{
@@ -77,18 +128,61 @@ func NewManager(configure *configure.Configure) *Manager {
 		},
 	}
 
+	Manager.admission.checkDiskSpace = Manager.segmentManager.CheckDiskSpace
+	Manager.segmentManager.SetThumbnailHandler(Manager.onThumbnail)
+	Manager.segmentManager.SetAlignmentHandler(Manager.onMisaligned)
+	Manager.segmentManager.SetFirstSegmentHandler(Manager.onFirstSegment)
+
+	go Manager.admission.monitor(Manager.sessionCount)
+	go Manager.segmentManager.RunJanitor()
 	return Manager
 }
 
+// Close stop admission monitor, janitor and uploader of segments. sessions should be closed before
+func (sm *Manager) Close() {
+	sm.admission.stop()
+	sm.segmentManager.Stop()
+}
+
+// LowLatencyHls return nil when LL-HLS is disabled
+func (sm *Manager) LowLatencyHls() *hls.Server {
+	return sm.llhls
+}
+
+// Dash return nil when MPEG-DASH is disabled
+func (sm *Manager) Dash() *dash.Server {
+	return sm.dash
+}
+
+// SetCapacityHandler regist handler which is called when node become full or available
+func (sm *Manager) SetCapacityHandler(handler func(full bool)) {
+	sm.admission.setCapacityHandler(handler)
+}
+
 func (sm *Manager) dialTimeout(network, addr string) (net.Conn, error) {
 	return net.DialTimeout(network, addr, time.Duration(sm.configure.Server.RequestTimeout)*time.Millisecond)
 }
 
 func (sm *Manager) CreateNewSession(transporter transport.Transporter) *Session {
-	return NewSession(sm, transporter)
+	return NewSession(sm, transporter, sm.configure.Server.Timeout)
+}
+
+func (sm *Manager) CreateNewSrtSession(transporter transport.Transporter, streamId string) *Session {
+	return NewSrtSession(sm, transporter, sm.configure.Server.Timeout, streamId)
+}
+
+func (sm *Manager) CreateNewWhipSession(transporter transport.Transporter) *Session {
+	return NewWhipSession(sm, transporter, sm.configure.Server.Timeout)
+}
+
+func (sm *Manager) CreateNewPullSession(transporter transport.Transporter) *Session {
+	return NewPullSession(sm, transporter, sm.configure.Server.Timeout)
 }
 
 func (sm *Manager) TerminateAllSession() {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
 	for sessionId, session := range sm.sessions {
 		session.stop()
 		delete(sm.sessions, sessionId)
@@ -96,8 +190,21 @@ func (sm *Manager) TerminateAllSession() {
 }
 
 func (sm *Manager) checkValidStream(session *Session, appName, streamKey string) error {
-	log.Info("[Manager][checkValidStream]")
-	streamStatus, err := sm.requestValidateStreamKey(streamKey)
+	session.log().Info("[Manager][checkValidStream]")
+	if err := sm.reserveSession(); err != nil {
+		session.log().Warn("[Manager][checkValidStream] reject publish. ", err)
+		return err
+	}
+	defer sm.releaseReservation()
+
+	if err := sm.checkNodeIngestBitrate(); err != nil {
+		session.log().Warn("[Manager][checkValidStream] reject publish. ", err)
+		return err
+	}
+
+	validateContext, validateSpan := tracing.Start(session.traceContext, tracing.SPAN_VALIDATE)
+	streamStatus, err := sm.requestValidateStreamKey(validateContext, session.log(), streamKey, session.remoteAddr)
+	tracing.End(validateSpan, err)
 	if err != nil {
 		return err
 	}
@@ -106,17 +213,26 @@ func (sm *Manager) checkValidStream(session *Session, appName, streamKey string)
 		return errors.New("invalide stream status")
 	}
 
-	if _, exist := sm.sessions[streamStatus.StreamId]; exist {
-		return errors.New("alread exist session")
-	}
-
 	streamId := streamStatus.StreamId
 	streamUrl := streamStatus.Url
 
-	sm.addSession(streamId, session)
+	limitConfigure := sm.configure.Media.Limit
+	session.setLimit(newPublishLimit(limitConfigure, streamStatus.Limit), limitConfigure.MeasureWindow)
+	if sm.configure.Media.Quality.Enable {
+		session.setQualityMonitor(newQualityMonitor(sm.configure.Media.Quality), streamId)
+	}
+	session.relayTargets = sm.relayTargets(streamStatus)
+	session.record = streamStatus.Record
+	if err := sm.addSession(streamId, session); err != nil {
+		return err
+	}
 
-	streamSegments, err := sm.segmentManager.OpenStreamSegments(streamId, streamUrl)
+	renditionOutput := sm.openPlayback(session, streamId)
+	streamSegments, err := sm.segmentManager.OpenStreamSegments(session.traceContext, streamId, streamUrl, renditionOutput)
 	if err != nil {
+		session.log().Error("[Manager][checkValidStream] can not open segments. ", err)
+		sm.removeSession(session)
+		sm.closePlayback(session)
 		return err
 	}
 
@@ -124,63 +240,628 @@ func (sm *Manager) checkValidStream(session *Session, appName, streamKey string)
 	return nil
 }
 
+// openPlayback open stream of source which keep GOP cache for new outputs, and renditions of ladder.
+// DASH representations of renditions are read from ladder even if renditions are not played.
+// returned output is nil when renditions are not played and DASH is disabled
+func (sm *Manager) openPlayback(session *Session, streamId int) ffmpeg.RenditionOutput {
+	name := strconv.Itoa(streamId)
+	session.playbackStream = sm.hub.Open(name, sm.newGopCache())
+	sm.openLowLatencyHls(session, session.playbackStream, sm.configure.Server.Api.LlHls.Container)
+	sm.openDash(session, name, session.playbackStream)
+
+	playConfigure := sm.configure.Server.Play
+	playRenditions := playConfigure.Enable && playConfigure.Renditions
+	if !playRenditions && sm.dash == nil {
+		return nil
+	}
+
+	// each rendition is read by its own goroutine
+	inputs := make(map[string]*playback.TsInput)
+	for _, encoding := range sm.configure.Media.Encoding {
+		rendition := ffmpeg.RenditionName(encoding)
+		input := playback.NewTsInput(sm.hub.Open(name+"_"+rendition, sm.newGopCache()))
+		if playRenditions {
+			sm.openLowLatencyHls(session, input.Stream(), encoding.Container)
+		}
+		sm.openDash(session, name, input.Stream())
+		inputs[rendition] = input
+		session.renditionStreams = append(session.renditionStreams, input.Stream())
+	}
+
+	return func(rendition string, data []byte) {
+		input, exist := inputs[rendition]
+		if !exist {
+			return
+		}
+
+		if err := input.Input(data); err != nil {
+			logging.Stream(logging.COMPONENT_SESSION, streamId).Debug("[Manager][openPlayback] rendition demux fail. ", err)
+		}
+	}
+}
+
+// openLowLatencyHls start segmenter which end with stream
+func (sm *Manager) openLowLatencyHls(session *Session, stream *playback.Stream, container string) {
+	if sm.llhls == nil {
+		return
+	}
+
+	if err := sm.llhls.Open(stream, container); err != nil {
+		session.log().Warn("[Manager][openLowLatencyHls][", stream.Name(), "] can not open segmenter. ", err)
+	}
+}
+
+// openDash add stream to presentation of source stream as representations
+func (sm *Manager) openDash(session *Session, name string, stream *playback.Stream) {
+	if sm.dash == nil {
+		return
+	}
+
+	if err := sm.dash.Open(name, stream); err != nil {
+		session.log().Warn("[Manager][openDash][", stream.Name(), "] can not open segmenter. ", err)
+	}
+}
+
+func (sm *Manager) newGopCache() *media.GopCache {
+	gopCacheConfigure := sm.configure.Media.GopCache
+	return media.NewGopCache(gopCacheConfigure.MaxFrames, gopCacheConfigure.MaxSize*1024)
+}
+
+func (sm *Manager) closePlayback(session *Session) {
+	if session.playbackStream != nil {
+		sm.hub.Close(session.playbackStream)
+	}
+
+	for _, stream := range session.renditionStreams {
+		sm.hub.Close(stream)
+	}
+}
+
+// checkValidPlay authenticate player and subscribe stream.
+// stream path is "{streamId}" or "{streamId}_{rendition}" with "?token={token}"
+func (sm *Manager) checkValidPlay(session *Session, appName, streamPath string) error {
+	session.log().Info("[Manager][checkValidPlay]")
+	name, token := parsePlayPath(streamPath)
+	viewer, err := sm.SubscribePlay(name, token, session.remoteAddr)
+	if err != nil {
+		return err
+	}
+
+	session.streamKey = name
+	session.viewer = viewer
+	return nil
+}
+
+// SubscribePlay validate token of player and subscribe stream of name.
+// name is "{streamId}" or "{streamId}_{rendition}". renditions opened only for DASH are not played
+func (sm *Manager) SubscribePlay(name, token, remoteAddr string) (*playback.Viewer, error) {
+	if err := sm.ValidatePlay(name, token, remoteAddr); err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(name, "_") && !sm.configure.Server.Play.Renditions {
+		return nil, rtmp.ErrStreamNotFound
+	}
+
+	viewer, err := sm.hub.Subscribe(name)
+	if errors.Is(err, playback.ErrStreamNotFound) {
+		return nil, rtmp.ErrStreamNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return viewer, nil
+}
+
+// ValidatePlay validate token of player to broadcast service when play.authenticate is set
+func (sm *Manager) ValidatePlay(name, token, remoteAddr string) error {
+	if !sm.configure.Server.Play.Enable {
+		return errors.New("playback is disabled")
+	}
+	return sm.authenticatePlay(name, token, remoteAddr)
+}
+
+// authenticatePlay validate token of player without play.enable. DASH is enabled by its own configure
+func (sm *Manager) authenticatePlay(name, token, remoteAddr string) error {
+	playConfigure := sm.configure.Server.Play
+	streamId, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+	if err != nil {
+		return rtmp.ErrStreamNotFound
+	}
+
+	if playConfigure.Authenticate {
+		return sm.requestValidatePlay(streamId, token, remoteAddr)
+	}
+	return nil
+}
+
+func parsePlayPath(streamPath string) (string, string) {
+	name, query, _ := strings.Cut(streamPath, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return name, ""
+	}
+	return name, values.Get("token")
+}
+
+func (sm *Manager) checkValidMetadata(session *Session, metadata *media.StreamMetadata) error {
+	return sm.handleLimitViolation(session, limitKindMetadata, session.limit.checkMetadata(metadata))
+}
+
+func (sm *Manager) checkValidResolution(session *Session, width, height int) error {
+	return sm.handleLimitViolation(session, limitKindResolution, session.limit.checkResolution(width, height))
+}
+
+func (sm *Manager) checkValidFrameRate(session *Session, frameRate float64) error {
+	return sm.handleLimitViolation(session, limitKindFrameRate, session.limit.checkFrameRate(frameRate))
+}
+
+func (sm *Manager) checkIngestBitrate(session *Session, bitrate float64) error {
+	if err := sm.handleLimitViolation(session, limitKindNodeBitrate, sm.checkNodeIngestBitrate()); err != nil {
+		return err
+	}
+	return sm.handleLimitViolation(session, limitKindBitrate, session.limit.checkIngestBitrate(bitrate))
+}
+
+// checkNodeIngestBitrate return violation when ingest bitrate of all streams on this node exceed limit
+func (sm *Manager) checkNodeIngestBitrate() error {
+	maxBitrate := sm.configure.Media.Limit.MaxNodeIngestBitrate
+	if maxBitrate <= 0 {
+		return nil
+	}
+
+	bitrate := sm.nodeIngestBitrate()
+	if float64(bitrate) > maxBitrate {
+		return &LimitViolation{
+			Reason: fmt.Sprintf("node ingest bitrate %dkbps exceed limit %.0fkbps", bitrate, maxBitrate),
+		}
+	}
+	return nil
+}
+
+// qualityDegraded warn broadcast service that source of publisher is broken
+func (sm *Manager) qualityDegraded(session *Session, report *QualityReport) {
+	session.log().Warn("[Manager][qualityDegraded] score : ", report.Score, ", issues : ", report.Issues)
+
+	reason := fmt.Sprintf("source quality score %d. %s", report.Score, strings.Join(report.Issues, ", "))
+	go sm.requestStreamWarning(session.log(), dto.NewStreamWarning(session.streamKey, reason))
+}
+
+// handleLimitViolation apply limit action of session.
+// reject refuse publish when violation is found on first check of kind, which is publish time,
+// and warn about violation found after it. warning is reported only when violation of kind is started
+func (sm *Manager) handleLimitViolation(session *Session, kind string, violation error) error {
+	publishing := session.limit.firstCheck(kind)
+	changed := session.limit.changed(kind, violation)
+	if violation == nil {
+		if changed {
+			session.log().Info("[Manager][handleLimitViolation] ", kind, " is recovered")
+		}
+		return nil
+	}
+
+	action := session.limit.action
+	if action == LIMIT_ACTION_WARN || (action == LIMIT_ACTION_REJECT && !publishing) {
+		if !changed {
+			return nil
+		}
+
+		session.log().Warn("[Manager][handleLimitViolation] warn : ", violation)
+		streamWarning := dto.NewStreamWarning(session.streamKey, violation.Error())
+		go sm.requestStreamWarning(session.log(), streamWarning)
+		return nil
+	}
+
+	session.log().Warn("[Manager][handleLimitViolation] ", action, " : ", violation)
+
+	session.setCloseReason(fmt.Sprintf("%s. %s", action, violation.Error()))
+	return violation
+}
+
+func (sm *Manager) nodeIngestBitrate() uint64 {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	bitrate := uint64(0)
+	for _, session := range sm.sessions {
+		bitrate += session.IngestBitrate()
+	}
+	return bitrate
+}
+
 func (sm *Manager) streamStart(session *Session) error {
-	log.Info("[Manager][streamStart]")
+	session.log().Info("[Manager][streamStart]")
+	sm.startRelays(session)
+
+	recordConfigure := sm.configure.Record
+	if recordConfigure.Enable && (recordConfigure.Default || session.record) {
+		if _, err := sm.startRecording(session); err != nil {
+			session.log().Error("[Manager][streamStart] can not start recording. ", err)
+		}
+	}
 	return nil
 }
 
-func (sm *Manager) streamEnd(session *Session) {
-	log.Info("[Manager][streamEnd]")
-	streamDeactive := dto.NewStreamActive(session.streamKey)
-	sm.requestStreamStatus(streamDeactive, false)
+// relayTargets merge relays of stream from broadcast service and static relays of configure
+func (sm *Manager) relayTargets(streamStatus *dto.StreamStatus) []relay.Target {
+	relayConfigure := sm.configure.Server.Relay
+	if !relayConfigure.Enable {
+		return nil
+	}
 
-	sm.segmentManager.CloseStreamSegments(session.sessionId)
-	sm.stopSession(session)
+	targets := make([]relay.Target, 0, len(streamStatus.Relays)+len(relayConfigure.Targets))
+	for _, streamRelay := range streamStatus.Relays {
+		targets = append(targets, relay.Target{Name: streamRelay.Name, Url: streamRelay.Url})
+	}
+
+	for _, target := range relayConfigure.Targets {
+		targetUrl := strings.ReplaceAll(target.Url, RelayUrlStreamId, strconv.Itoa(streamStatus.StreamId))
+		targets = append(targets, relay.Target{Name: target.Name, Url: targetUrl})
+	}
+	return targets
+}
+
+func (sm *Manager) startRelays(session *Session) {
+	if len(session.relayTargets) == 0 || session.playbackStream == nil {
+		return
+	}
+
+	relayConfigure := sm.configure.Server.Relay
+	options := relay.Options{
+		ConnectTimeout: time.Duration(relayConfigure.ConnectTimeout) * time.Second,
+		MinBackoff:     time.Duration(relayConfigure.MinBackoff) * time.Second,
+		MaxBackoff:     time.Duration(relayConfigure.MaxBackoff) * time.Second,
+		ChunkSize:      relayConfigure.ChunkSize,
+		PacketSize:     sm.configure.Server.PacketSize,
+	}
+
+	streamKey := session.streamKey
+	logger := session.log()
+	for _, target := range session.relayTargets {
+		session.log().Info("[Manager][startRelays] relay to ", target.Name)
+		streamRelay := relay.NewRelay(target, session.playbackStream, options,
+			func(status relay.Status) {
+				relayStatus := dto.NewStreamRelayStatus(streamKey, status.Name, status.State, status.Reason)
+				go sm.requestRelayStatus(logger, relayStatus)
+			})
+
+		session.relays = append(session.relays, streamRelay)
+		go streamRelay.Run()
+	}
+}
+
+func (sm *Manager) stopRelays(session *Session) {
+	for _, streamRelay := range session.relays {
+		streamRelay.Stop()
+	}
+	session.relays = nil
+}
+
+// StartRecording start recording of publishing stream
+func (sm *Manager) StartRecording(streamId int) (record.Status, error) {
+	if !sm.configure.Record.Enable {
+		return record.Status{}, ErrRecordingDisabled
+	}
+
+	session, exist := sm.session(streamId)
+	if !exist {
+		return record.Status{}, rtmp.ErrStreamNotFound
+	}
+
+	recorder, err := sm.startRecording(session)
+	if err != nil {
+		return record.Status{}, err
+	}
+	return recorder.Status(), nil
+}
+
+// StopRecording stop recording of stream and return files of recording
+func (sm *Manager) StopRecording(streamId int) (record.Status, error) {
+	session, exist := sm.session(streamId)
+	if !exist {
+		return record.Status{}, rtmp.ErrStreamNotFound
+	}
+
+	recorder := sm.stopRecording(session)
+	if recorder == nil {
+		return record.Status{}, ErrRecordingNotFound
+	}
+	return recorder.Status(), nil
+}
+
+// QualityReports return last source analysis of publishing streams
+func (sm *Manager) QualityReports() []QualityReport {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	reports := make([]QualityReport, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		if report := session.QualityReport(); report != nil {
+			reports = append(reports, *report)
+		}
+	}
+	return reports
+}
+
+// QualityReport return last source analysis of stream
+func (sm *Manager) QualityReport(streamId int) (QualityReport, error) {
+	session, exist := sm.session(streamId)
+	if !exist {
+		return QualityReport{}, rtmp.ErrStreamNotFound
+	}
+
+	report := session.QualityReport()
+	if report == nil {
+		return QualityReport{}, ErrQualityNotMeasured
+	}
+	return *report, nil
+}
+
+// Recordings return status of recording streams
+func (sm *Manager) Recordings() []record.Status {
+	sm.mutex.Lock()
+	recorders := make([]*record.Recorder, 0)
+	for _, session := range sm.sessions {
+		if recorder := session.recorder.Load(); recorder != nil {
+			recorders = append(recorders, recorder)
+		}
+	}
+	sm.mutex.Unlock()
+
+	statuses := make([]record.Status, 0, len(recorders))
+	for _, recorder := range recorders {
+		statuses = append(statuses, recorder.Status())
+	}
+	return statuses
+}
+
+func (sm *Manager) startRecording(session *Session) (*record.Recorder, error) {
+	recordConfigure := sm.configure.Record
+	options := record.Options{
+		Path:        recordConfigure.Path,
+		Format:      recordConfigure.Format,
+		MaxSize:     int64(recordConfigure.MaxSize) * 1024 * 1024,
+		MaxDuration: time.Duration(recordConfigure.MaxDuration) * time.Second,
+	}
+
+	recorder, err := record.NewRecorder(session.sessionId, options,
+		func() []byte {
+			if gopCache := session.GopCache(); gopCache != nil {
+				return gopCache.VideoHeader()
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.recorder.CompareAndSwap(nil, recorder) {
+		return nil, ErrRecordingExist
+	}
+
+	session.log().Info("[Manager][startRecording] start recording")
+	return recorder, nil
+}
+
+// stopRecording return nil when stream is not recorded
+func (sm *Manager) stopRecording(session *Session) *record.Recorder {
+	recorder := session.recorder.Swap(nil)
+	if recorder != nil {
+		recorder.Stop()
+	}
+	return recorder
+}
+
+func (sm *Manager) streamEnd(session *Session) {
+	session.log().Info("[Manager][streamEnd]")
+	sm.closeStream(session)
 }
 
 func (sm *Manager) streamError(session *Session) {
-	log.Info("[Manager][streamError]")
-	streamDeactive := dto.NewStreamActive(session.streamKey)
-	sm.requestStreamStatus(streamDeactive, false)
+	session.log().Info("[Manager][streamError]")
+	sm.closeStream(session)
+}
+
+func (sm *Manager) closeStream(session *Session) {
+	// session which is not registed was never activated
+	if session.sessionId >= 0 {
+		// teardown may be hours after session start so that it is traced as its own root linked to session start
+		ctx, span := tracing.Tracer().Start(context.Background(), tracing.SPAN_SESSION_END,
+			trace.WithLinks(trace.LinkFromContext(session.traceContext)),
+			trace.WithAttributes(attribute.Int(tracing.ATTRIBUTE_STREAM_ID, session.sessionId)))
+
+		streamDeactive := dto.NewStreamDeactive(session.streamKey, session.closeReason)
+		_, err := sm.requestStreamStatus(ctx, session.log(), streamDeactive, false)
+		tracing.End(span, err)
+
+		if sm.configure.Segment.Vod.Enable {
+			go sm.finalizeVod(session.sessionId, session.streamKey)
+		} else {
+			sm.segmentManager.CloseStreamSegments(session.sessionId)
+		}
+	}
+
+	sm.stopRelays(session)
+	sm.stopRecording(session)
+	sm.closePlayback(session)
 
-	sm.segmentManager.CloseStreamSegments(session.sessionId)
 	sm.stopSession(session)
 }
 
+// finalizeVod make VOD asset of segments and report location to broadcast service
+func (sm *Manager) finalizeVod(streamId int, streamKey string) {
+	logger := logging.Stream(logging.COMPONENT_SESSION, streamId)
+	asset, err := sm.segmentManager.FinalizeStreamSegments(streamId)
+	if err != nil {
+		logger.Error("[Manager][finalizeVod] can not make vod asset. ", err)
+		return
+	}
+
+	logger.Info("[Manager][finalizeVod] vod asset is made at ", asset.Location)
+	preview := ""
+	if asset.Preview != nil {
+		preview = asset.Preview.Vtt
+	}
+
+	streamVod := dto.NewStreamVod(streamKey, asset.Location, asset.Manifest, asset.Duration, len(asset.Renditions), preview)
+	sm.requestStreamVod(logger, streamVod)
+}
+
+// onThumbnail report new thumbnail of live stream
+func (sm *Manager) onThumbnail(streamId int, thumbnail segment.Thumbnail) {
+	session, exist := sm.session(streamId)
+	if !exist {
+		return
+	}
+
+	streamThumbnail := dto.NewStreamThumbnail(session.streamKey, thumbnail.Location, thumbnail.CapturedAt.UTC().Format(time.RFC3339))
+	go sm.requestStreamThumbnail(session.log(), streamThumbnail)
+}
+
+// onMisaligned warn broadcast service that renditions of stream can not be switched smoothly
+func (sm *Manager) onMisaligned(streamId int, number int, spread time.Duration) {
+	session, exist := sm.session(streamId)
+	if !exist {
+		return
+	}
+
+	reason := fmt.Sprintf("segment %d of renditions is misaligned by %s", number, spread)
+	go sm.requestStreamWarning(session.log(), dto.NewStreamWarning(session.streamKey, reason))
+}
+
+// onFirstSegment end tracing of session start when first segment is made
+func (sm *Manager) onFirstSegment(streamId int, elapsed time.Duration) {
+	session, exist := sm.session(streamId)
+	if !exist {
+		return
+	}
+
+	session.log().Info("[Manager][onFirstSegment] first segment is made in ", elapsed, ". ", time.Since(session.createdAt), " from connection")
+	session.endStartSpan()
+}
+
 func (sm *Manager) stopSession(session *Session) {
-	_, exist := sm.sessions[session.sessionId]
-	if exist {
+	sm.mutex.Lock()
+	if target, exist := sm.sessions[session.sessionId]; exist && target == session {
 		delete(sm.sessions, session.sessionId)
 	}
+	count := len(sm.sessions) + sm.reserved
+	sm.mutex.Unlock()
 
 	session.stop()
+	sm.admission.update(count)
 }
 
-func (sm *Manager) addSession(streamId int, session *Session) {
+// addSession register session of stream. existence is checked in same lock so that only one session is added for each stream
+func (sm *Manager) addSession(streamId int, session *Session) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, exist := sm.sessions[streamId]; exist {
+		return errors.New("alread exist session")
+	}
+
 	sm.sessions[streamId] = session
 	session.setSessionId(streamId)
+	return nil
+}
+
+// removeSession unregister session which is failed to open. session is stopped when connection is closed
+func (sm *Manager) removeSession(session *Session) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if target, exist := sm.sessions[session.sessionId]; exist && target == session {
+		delete(sm.sessions, session.sessionId)
+		session.clearSessionId()
+	}
+}
+
+// reserveSession hold a slot of admission while new publish is validated
+func (sm *Manager) reserveSession() error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	count := len(sm.sessions) + sm.reserved
+	if err := sm.admission.admit(count); err != nil {
+		return err
+	}
+
+	sm.reserved++
+	return nil
+}
+
+func (sm *Manager) releaseReservation() {
+	sm.mutex.Lock()
+	sm.reserved--
+	count := len(sm.sessions) + sm.reserved
+	sm.mutex.Unlock()
+
+	sm.admission.update(count)
+}
+
+func (sm *Manager) sessionCount() int {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return len(sm.sessions) + sm.reserved
+}
+
+func (sm *Manager) session(streamId int) (*Session, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	session, exist := sm.sessions[streamId]
+	return session, exist
 }
 
 // request about streamKey is validated to mystream-broadcast service
-func (sm *Manager) requestValidateStreamKey(streamKey string) (*dto.StreamStatus, error) {
+func (sm *Manager) requestValidateStreamKey(ctx context.Context, logger *log.Entry, streamKey, remoteAddr string) (*dto.StreamStatus, error) {
 	streamActive := dto.NewStreamActive(streamKey)
-	response, err := sm.requestStreamStatus(streamActive, true)
+	streamActive.RemoteAddr = remoteAddr
+	response, err := sm.requestStreamStatus(ctx, logger, streamActive, true)
 	if err != nil {
 		return nil, err
 	}
 
 	if !response.Success {
-		log.Error("[Manager][requestValidateStreamKey] validate fail from broadcast service. ", response.Error.Message)
+		logger.Error("[Manager][requestValidateStreamKey] validate fail from broadcast service. ", response.Error.Message)
 		return nil, errors.New("validate fail from broadcast service. " + response.Error.Message)
 	}
 
 	return &response.Result, nil
 }
 
-func (sm *Manager) requestStreamStatus(streamActive dto.StreamActive, active bool) (*dto.ApiResponse, error) {
+// request about player token is validated to mystream-broadcast service
+func (sm *Manager) requestValidatePlay(streamId int, token, remoteAddr string) error {
+	logger := logging.Stream(logging.COMPONENT_SESSION, streamId)
+	jsonStr, err := json.Marshal(dto.NewStreamPlay(streamId, token, remoteAddr))
+	if err != nil {
+		logger.Error("[Manager][requestValidatePlay] cat not convert StreamPlay to json. ", err)
+		return err
+	}
+
+	response, err := sm.requestToBroadcastService(context.Background(), logger, StreamPlayUrlPath, string(jsonStr))
+	if err != nil {
+		return err
+	}
+
+	apiResponse := &dto.ApiResponse{}
+	if err := json.Unmarshal(response, apiResponse); err != nil {
+		logger.Error("[Manager][requestValidatePlay] body parse error. ", err, " / ", string(response))
+		return err
+	}
+
+	if !apiResponse.Success {
+		logger.Warn("[Manager][requestValidatePlay] reject player. ", apiResponse.Error.Message)
+		return errors.New("play is rejected from broadcast service. " + apiResponse.Error.Message)
+	}
+	return nil
+}
+
+func (sm *Manager) requestStreamStatus(ctx context.Context, logger *log.Entry, streamActive dto.StreamActive, active bool) (*dto.ApiResponse, error) {
 	jsonStr, err := json.Marshal(streamActive)
 	if err != nil {
-		log.Error("[Manager][requestStreamStatus] cat not convert StreamActive to json. ", err)
+		logger.Error("[Manager][requestStreamStatus] cat not convert StreamActive to json. ", err)
 		return nil, err
 	}
 
@@ -189,7 +870,7 @@ func (sm *Manager) requestStreamStatus(streamActive dto.StreamActive, active boo
 		path = StreamDeactiveUrlPath
 	}
 
-	response, err := sm.requestToBroadcastService(path, string(jsonStr))
+	response, err := sm.requestToBroadcastService(ctx, logger, path, string(jsonStr))
 	if err != nil {
 		return nil, err
 	}
@@ -197,33 +878,103 @@ func (sm *Manager) requestStreamStatus(streamActive dto.StreamActive, active boo
 	apiResponse := &dto.ApiResponse{}
 	err = json.Unmarshal(response, apiResponse)
 	if err != nil {
-		log.Error("[Manager][requestStreamStatus] body parse error. ", err, " / ", string(response))
+		logger.Error("[Manager][requestStreamStatus] body parse error. ", err, " / ", string(response))
 		return nil, err
 	}
 
-	log.Info("[Manager][requestStreamStatus] response : ", string(response))
+	logger.Info("[Manager][requestStreamStatus] response : ", string(response))
 	return apiResponse, nil
 }
 
-func (sm *Manager) requestToBroadcastService(uri string, requestBody string) ([]byte, error) {
+func (sm *Manager) requestStreamWarning(logger *log.Entry, streamWarning dto.StreamWarning) {
+	jsonStr, err := json.Marshal(streamWarning)
+	if err != nil {
+		logger.Error("[Manager][requestStreamWarning] cat not convert StreamWarning to json. ", err)
+		return
+	}
+
+	response, err := sm.requestToBroadcastService(context.Background(), logger, StreamWarningUrlPath, string(jsonStr))
+	if err != nil {
+		return
+	}
+
+	logger.Info("[Manager][requestStreamWarning] response : ", string(response))
+}
+
+func (sm *Manager) requestRelayStatus(logger *log.Entry, relayStatus dto.StreamRelayStatus) {
+	jsonStr, err := json.Marshal(relayStatus)
+	if err != nil {
+		logger.Error("[Manager][requestRelayStatus] cat not convert StreamRelayStatus to json. ", err)
+		return
+	}
+
+	response, err := sm.requestToBroadcastService(context.Background(), logger, StreamRelayUrlPath, string(jsonStr))
+	if err != nil {
+		return
+	}
+
+	logger.Info("[Manager][requestRelayStatus] response : ", string(response))
+}
+
+func (sm *Manager) requestStreamVod(logger *log.Entry, streamVod dto.StreamVod) {
+	jsonStr, err := json.Marshal(streamVod)
+	if err != nil {
+		logger.Error("[Manager][requestStreamVod] cat not convert StreamVod to json. ", err)
+		return
+	}
+
+	response, err := sm.requestToBroadcastService(context.Background(), logger, StreamVodUrlPath, string(jsonStr))
+	if err != nil {
+		return
+	}
+
+	logger.Info("[Manager][requestStreamVod] response : ", string(response))
+}
+
+func (sm *Manager) requestStreamThumbnail(logger *log.Entry, streamThumbnail dto.StreamThumbnail) {
+	jsonStr, err := json.Marshal(streamThumbnail)
+	if err != nil {
+		logger.Error("[Manager][requestStreamThumbnail] cat not convert StreamThumbnail to json. ", err)
+		return
+	}
+
+	response, err := sm.requestToBroadcastService(context.Background(), logger, StreamThumbnailUrlPath, string(jsonStr))
+	if err != nil {
+		return
+	}
+
+	logger.Debug("[Manager][requestStreamThumbnail] response : ", string(response))
+}
+
+// requestToBroadcastService put request body to uri. trace context of ctx is propagated to broadcast service
+func (sm *Manager) requestToBroadcastService(ctx context.Context, logger *log.Entry, uri string, requestBody string) (body []byte, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SPAN_BROADCAST_REQUEST,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.method", http.MethodPut), attribute.String("http.route", uri)))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	url := HttpScheme + sm.configure.Server.BroadcastServerAddress + uri
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer([]byte(requestBody)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBuffer([]byte(requestBody)))
 	if err != nil {
-		log.Error("[Manager][requestToBroadcastService] cat not create http request. ", err)
+		logger.Error("[Manager][requestToBroadcastService] cat not create http request. ", err)
 		return nil, err
 	}
 
 	req.Header.Add("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
 	resp, err := sm.httpClient.Do(req)
 	if err != nil {
-		log.Error("[Manager][requestToBroadcastService] http response error. ", err)
+		logger.Error("[Manager][requestToBroadcastService] http response error. ", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		log.Error("[Manager][requestToBroadcastService] body parse error. ", err)
+		logger.Error("[Manager][requestToBroadcastService] body parse error. ", err)
 		return nil, err
 	}
 	return bytes.Clone(body), nil
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/dash"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+)
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x0a, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+func TestRemoveSessionClearSessionId(t *testing.T) {
+	manager := &Manager{sessions: make(map[int]*Session)}
+	session := newSession(manager, &testTransporter{}, configure.TimeoutConfigure{})
+	if err := manager.addSession(7, session); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.removeSession(session)
+	if session.sessionId != -1 {
+		t.Fatal("session id of failed session is kept. ", session.sessionId)
+	}
+
+	// other session of same stream is not removed by failed session
+	other := newSession(manager, &testTransporter{}, configure.TimeoutConfigure{})
+	if err := manager.addSession(7, other); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.removeSession(session)
+	if _, exist := manager.session(7); !exist || other.sessionId != 7 {
+		t.Fatal("session of stream is removed by other session")
+	}
+}
+
+func TestDashRenditionsWithoutPlay(t *testing.T) {
+	manager := &Manager{
+		configure: &configure.Configure{},
+		sessions:  make(map[int]*Session),
+		hub:       playback.NewHub(256),
+	}
+	manager.configure.Media.Encoding = []configure.MediaEncodingConfigure{
+		{Resolution: "1280x720", Frame: 30, SegmentTime: 1},
+		{Resolution: "852x480", Frame: 30, SegmentTime: 1},
+	}
+	manager.dash = dash.NewServer(configure.DashConfigure{}, time.Second, manager.authenticatePlay)
+
+	session := newSession(manager, &testTransporter{}, configure.TimeoutConfigure{})
+	output := manager.openPlayback(session, 1)
+	t.Cleanup(func() { manager.closePlayback(session) })
+
+	if output == nil || len(session.renditionStreams) != 2 {
+		t.Fatal("renditions are not opened for DASH. ", len(session.renditionStreams))
+	}
+
+	if _, err := manager.SubscribePlay("1_1280x720_30", "", "127.0.0.1"); err == nil {
+		t.Fatal("rendition is played while playback is disabled")
+	}
+
+	muxer := media.NewTSMuxer()
+	for dts := uint64(0); dts <= 2000; dts += 500 {
+		data, err := muxer.MuxingVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{Pts: dts, Dts: dts}, testH264Frame, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output("1280x720_30", data)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder := httptest.NewRecorder()
+		manager.dash.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, dash.DEFAULT_PATH+"/1/"+dash.MANIFEST_NAME, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatal("manifest is not served while playback is disabled. ", recorder.Code)
+		}
+
+		if strings.Contains(recorder.Body.String(), `id="1_1280x720_30-video"`) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("representation of rendition is not in manifest. ", recorder.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,351 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+const (
+	DEFAULT_QUALITY_WINDOW                 = 10
+	DEFAULT_QUALITY_MAX_KEY_FRAME_INTERVAL = 10
+	DEFAULT_QUALITY_MAX_TIMESTAMP_GAP      = 1000
+	DEFAULT_QUALITY_MAX_AV_DRIFT           = 1000
+	DEFAULT_QUALITY_WARNING_SCORE          = 60
+
+	// report is made on this interval over last window
+	QUALITY_MEASURE_INTERVAL = time.Second
+
+	// measured frame rate can differ from declared by this ratio
+	QUALITY_FRAME_RATE_TOLERANCE = 0.1
+	// standard deviation of GOP length can be this ratio of mean
+	QUALITY_GOP_DEVIATION_TOLERANCE = 0.5
+	// measured bitrate can be under declared data rate by this ratio
+	QUALITY_BITRATE_TOLERANCE = 0.5
+
+	QUALITY_PENALTY_STALL         = 50
+	QUALITY_PENALTY_FRAME_RATE    = 20
+	QUALITY_PENALTY_GOP           = 10
+	QUALITY_PENALTY_KEY_FRAME     = 30
+	QUALITY_PENALTY_BITRATE       = 10
+	QUALITY_PENALTY_AV_DRIFT      = 20
+	QUALITY_PENALTY_TIMESTAMP     = 5
+	QUALITY_MAX_PENALTY_TIMESTAMP = 20
+)
+
+// QualityReport is analysis of publisher source over last window
+type QualityReport struct {
+	StreamId          int
+	Score             int
+	FrameRate         float64
+	DeclaredFrameRate float64
+	GopLength         float64
+	GopVariance       float64
+	Bitrate           float64
+	DeclaredBitrate   float64
+	AvDrift           time.Duration
+	KeyFrameInterval  time.Duration
+	TimestampGaps     int
+	NonMonotonicDts   int
+	Issues            []string
+	MeasuredAt        time.Time
+}
+
+// qualityTrack keep last timestamp of a track to find gaps and non-monotonic DTS
+type qualityTrack struct {
+	exist   bool
+	lastDts uint64
+}
+
+// add return whether DTS jumps over maxGap or goes back from previous frame
+func (t *qualityTrack) add(dts uint64, maxGap uint64) (bool, bool) {
+	gap, nonMonotonic := false, false
+	if t.exist {
+		switch {
+		case dts < t.lastDts:
+			nonMonotonic = true
+		case dts-t.lastDts > maxGap:
+			gap = true
+		}
+	}
+
+	t.exist = true
+	t.lastDts = dts
+	return gap, nonMonotonic
+}
+
+// qualitySample is frame observed in window
+type qualitySample struct {
+	at           time.Time
+	bytes        int
+	video        bool
+	keyFrame     bool
+	gap          bool
+	nonMonotonic bool
+}
+
+// qualityMonitor measure publisher source on sliding window.
+// frames are observed on goroutine of session, measure is called periodically so that stalled source is scored
+// and report is read by api
+type qualityMonitor struct {
+	window              time.Duration
+	maxKeyFrameInterval time.Duration
+	maxTimestampGap     uint64
+	maxAvDrift          time.Duration
+	warningScore        int
+
+	mutex             sync.Mutex
+	begin             time.Time
+	samples           []qualitySample
+	lastKeyFrameAt    time.Time
+	video             qualityTrack
+	audio             qualityTrack
+	declaredFrameRate float64
+	declaredBitrate   float64
+	degraded          bool
+
+	report atomic.Pointer[QualityReport]
+}
+
+func newQualityMonitor(qualityConfigure configure.MediaQualityConfigure) *qualityMonitor {
+	window := qualityConfigure.Window
+	if window <= 0 {
+		window = DEFAULT_QUALITY_WINDOW
+	}
+
+	maxKeyFrameInterval := qualityConfigure.MaxKeyFrameInterval
+	if maxKeyFrameInterval <= 0 {
+		maxKeyFrameInterval = DEFAULT_QUALITY_MAX_KEY_FRAME_INTERVAL
+	}
+
+	maxTimestampGap := qualityConfigure.MaxTimestampGap
+	if maxTimestampGap <= 0 {
+		maxTimestampGap = DEFAULT_QUALITY_MAX_TIMESTAMP_GAP
+	}
+
+	maxAvDrift := qualityConfigure.MaxAvDrift
+	if maxAvDrift <= 0 {
+		maxAvDrift = DEFAULT_QUALITY_MAX_AV_DRIFT
+	}
+
+	warningScore := qualityConfigure.WarningScore
+	if warningScore <= 0 {
+		warningScore = DEFAULT_QUALITY_WARNING_SCORE
+	}
+
+	return &qualityMonitor{
+		window:              time.Duration(window) * time.Second,
+		maxKeyFrameInterval: time.Duration(maxKeyFrameInterval) * time.Second,
+		maxTimestampGap:     uint64(maxTimestampGap),
+		maxAvDrift:          time.Duration(maxAvDrift) * time.Millisecond,
+		warningScore:        warningScore,
+	}
+}
+
+// Report return last report. nil before first window is completed
+func (m *qualityMonitor) Report() *QualityReport {
+	return m.report.Load()
+}
+
+// setMetadata keep frame rate and data rate which are declared by publisher
+func (m *qualityMonitor) setMetadata(metadata *media.StreamMetadata) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.declaredFrameRate = metadata.FrameRate
+	m.declaredBitrate = metadata.VideoDataRate + metadata.AudioDataRate
+}
+
+func (m *qualityMonitor) addVideo(frame *media.VideoFrame, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.start(now)
+	sample := qualitySample{at: now, bytes: len(frame.Data()), video: true, keyFrame: media.IsKeyFrame(frame)}
+	sample.gap, sample.nonMonotonic = m.video.add(frame.Dts(), m.maxTimestampGap)
+	if sample.keyFrame {
+		m.lastKeyFrameAt = now
+	}
+	m.samples = append(m.samples, sample)
+}
+
+func (m *qualityMonitor) addAudio(frame *media.AudioFrame, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.start(now)
+	sample := qualitySample{at: now, bytes: len(frame.Data())}
+	sample.gap, sample.nonMonotonic = m.audio.add(frame.Dts(), m.maxTimestampGap)
+	m.samples = append(m.samples, sample)
+}
+
+func (m *qualityMonitor) start(now time.Time) {
+	if m.begin.IsZero() {
+		m.begin = now
+		m.lastKeyFrameAt = now
+	}
+}
+
+// measure make report of last window after first window is completed.
+// degraded is true only when score drops under warning score, so that warning is not repeated on each measure
+func (m *qualityMonitor) measure(streamId int, now time.Time) (*QualityReport, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.begin.IsZero() || now.Sub(m.begin) < m.window {
+		return nil, false
+	}
+
+	// samples are in order of arrival
+	expired := 0
+	for expired < len(m.samples) && now.Sub(m.samples[expired].at) > m.window {
+		expired++
+	}
+	m.samples = append(m.samples[:0], m.samples[expired:]...)
+
+	report := &QualityReport{
+		StreamId:          streamId,
+		Score:             100,
+		DeclaredFrameRate: m.declaredFrameRate,
+		DeclaredBitrate:   m.declaredBitrate,
+		MeasuredAt:        now,
+	}
+
+	videoFrames, bytes := 0, 0
+	gop, gops := -1, make([]int, 0)
+	for _, sample := range m.samples {
+		bytes += sample.bytes
+		if sample.gap {
+			report.TimestampGaps++
+		}
+		if sample.nonMonotonic {
+			report.NonMonotonicDts++
+		}
+
+		if !sample.video {
+			continue
+		}
+		videoFrames++
+
+		// GOP which start before window is not counted
+		if sample.keyFrame {
+			if gop > 0 {
+				gops = append(gops, gop)
+			}
+			gop = 0
+		}
+		if gop >= 0 {
+			gop++
+		}
+	}
+
+	report.FrameRate = float64(videoFrames) / m.window.Seconds()
+	report.Bitrate = float64(bytes) * 8 / 1000 / m.window.Seconds()
+	if m.video.exist {
+		report.KeyFrameInterval = now.Sub(m.lastKeyFrameAt)
+	}
+
+	if len(gops) > 0 {
+		report.GopLength, report.GopVariance = meanAndVariance(gops)
+	}
+
+	if m.video.exist && m.audio.exist {
+		report.AvDrift = time.Duration(int64(m.video.lastDts)-int64(m.audio.lastDts)) * time.Millisecond
+	}
+
+	m.score(report, len(m.samples) == 0)
+	m.report.Store(report)
+
+	degraded := report.Score < m.warningScore
+	changed := degraded && !m.degraded
+	m.degraded = degraded
+	return report, changed
+}
+
+// score deduct penalty of each problem from 100. frame rate, GOP and key frame are not checked without video
+func (m *qualityMonitor) score(report *QualityReport, stalled bool) {
+	penalty := func(value int, issue string) {
+		report.Score -= value
+		report.Issues = append(report.Issues, issue)
+	}
+
+	if stalled {
+		penalty(QUALITY_PENALTY_STALL, fmt.Sprintf("no frame for %s", m.window))
+	}
+
+	hasVideo := m.video.exist
+	declaredFrameRate := report.DeclaredFrameRate
+	if hasVideo && declaredFrameRate > 0 && math.Abs(report.FrameRate-declaredFrameRate) > declaredFrameRate*QUALITY_FRAME_RATE_TOLERANCE {
+		penalty(QUALITY_PENALTY_FRAME_RATE, fmt.Sprintf("frame rate %.2f differ from declared %.2f", report.FrameRate, declaredFrameRate))
+	}
+
+	if hasVideo && report.GopLength > 0 && math.Sqrt(report.GopVariance) > report.GopLength*QUALITY_GOP_DEVIATION_TOLERANCE {
+		penalty(QUALITY_PENALTY_GOP, fmt.Sprintf("GOP length %.1f is irregular. variance %.1f", report.GopLength, report.GopVariance))
+	}
+
+	if hasVideo && report.KeyFrameInterval > m.maxKeyFrameInterval {
+		penalty(QUALITY_PENALTY_KEY_FRAME, fmt.Sprintf("no key frame for %s", report.KeyFrameInterval.Round(time.Second)))
+	}
+
+	if report.DeclaredBitrate > 0 && report.Bitrate < report.DeclaredBitrate*QUALITY_BITRATE_TOLERANCE {
+		penalty(QUALITY_PENALTY_BITRATE, fmt.Sprintf("bitrate %.0fkbps is under declared %.0fkbps", report.Bitrate, report.DeclaredBitrate))
+	}
+
+	if report.AvDrift > m.maxAvDrift || report.AvDrift < -m.maxAvDrift {
+		penalty(QUALITY_PENALTY_AV_DRIFT, fmt.Sprintf("audio and video drift %s", report.AvDrift))
+	}
+
+	if report.TimestampGaps > 0 {
+		penalty(min(report.TimestampGaps*QUALITY_PENALTY_TIMESTAMP, QUALITY_MAX_PENALTY_TIMESTAMP), fmt.Sprintf("%d timestamp gaps", report.TimestampGaps))
+	}
+
+	if report.NonMonotonicDts > 0 {
+		penalty(min(report.NonMonotonicDts*QUALITY_PENALTY_TIMESTAMP, QUALITY_MAX_PENALTY_TIMESTAMP), fmt.Sprintf("%d non-monotonic DTS", report.NonMonotonicDts))
+	}
+
+	report.Score = max(report.Score, 0)
+}
+
+func meanAndVariance(values []int) (float64, float64) {
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	mean := float64(sum) / float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		diff := float64(value) - mean
+		variance += diff * diff
+	}
+	return mean, variance / float64(len(values))
+}
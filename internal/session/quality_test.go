@@ -0,0 +1,198 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+var (
+	testQualityKeyFrame = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00}
+	testQualityPFrame   = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x02, 0x04}
+	testQualityAudio    = []byte{0x21, 0x00, 0x49, 0x90}
+)
+
+// feedVideo add frames of frameRate between from and to. dts of frame is its offset added by shift
+func feedVideo(m *qualityMonitor, begin time.Time, from, to time.Duration, frameRate, gop int, shift uint64) {
+	for i := 0; ; i++ {
+		offset := from + time.Duration(i)*time.Second/time.Duration(frameRate)
+		if offset >= to {
+			return
+		}
+
+		data := testQualityPFrame
+		if i%gop == 0 {
+			data = testQualityKeyFrame
+		}
+
+		dts := uint64(offset/time.Millisecond) + shift
+		m.addVideo(media.NewVideoFrame(media.CODEC_VIDEO_H264, media.Timestamp{Pts: dts, Dts: dts}, data, i%gop == 0), begin.Add(offset))
+	}
+}
+
+// feedAudio add frames of every 20 millisecond between from and to
+func feedAudio(m *qualityMonitor, begin time.Time, from, to time.Duration) {
+	for offset := from; offset < to; offset += 20 * time.Millisecond {
+		dts := uint64(offset / time.Millisecond)
+		m.addAudio(media.NewAudioFrame(media.CODEC_AUDIO_AAC, media.Timestamp{Pts: dts, Dts: dts}, testQualityAudio), begin.Add(offset))
+	}
+}
+
+func TestQualityMonitorScore(t *testing.T) {
+	testCases := []struct {
+		name      string
+		metadata  *media.StreamMetadata
+		feed      func(m *qualityMonitor, begin time.Time)
+		measureAt time.Duration
+		score     int
+		degraded  bool
+	}{
+		{
+			name: "steady source",
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 10*time.Second, 30, 30, 0)
+				feedAudio(m, begin, 0, 10*time.Second)
+			},
+			measureAt: 10 * time.Second,
+			score:     100,
+		},
+		{
+			name: "audio only source",
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedAudio(m, begin, 0, 20*time.Second)
+			},
+			measureAt: 20 * time.Second,
+			score:     100,
+		},
+		{
+			name: "no key frame",
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 20*time.Second, 30, 1000, 0)
+			},
+			measureAt: 20 * time.Second,
+			score:     100 - QUALITY_PENALTY_KEY_FRAME,
+		},
+		{
+			name:     "frame rate under declared",
+			metadata: &media.StreamMetadata{FrameRate: 60},
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 10*time.Second, 30, 30, 0)
+			},
+			measureAt: 10 * time.Second,
+			score:     100 - QUALITY_PENALTY_FRAME_RATE,
+		},
+		{
+			name: "timestamp gap",
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 5*time.Second, 30, 30, 0)
+				feedVideo(m, begin, 5*time.Second, 10*time.Second, 30, 30, 2000)
+			},
+			measureAt: 10 * time.Second,
+			score:     100 - QUALITY_PENALTY_TIMESTAMP,
+		},
+		{
+			name: "stalled source",
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 10*time.Second, 30, 30, 0)
+			},
+			measureAt: 25 * time.Second,
+			score:     100 - QUALITY_PENALTY_STALL - QUALITY_PENALTY_KEY_FRAME,
+			degraded:  true,
+		},
+		{
+			name:     "stalled source with declared rate",
+			metadata: &media.StreamMetadata{FrameRate: 30, VideoDataRate: 1000},
+			feed: func(m *qualityMonitor, begin time.Time) {
+				feedVideo(m, begin, 0, 10*time.Second, 30, 30, 0)
+			},
+			measureAt: 25 * time.Second,
+			score:     0,
+			degraded:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			monitor := newQualityMonitor(configure.MediaQualityConfigure{})
+			if testCase.metadata != nil {
+				monitor.setMetadata(testCase.metadata)
+			}
+
+			begin := time.Now()
+			testCase.feed(monitor, begin)
+
+			report, degraded := monitor.measure(1, begin.Add(testCase.measureAt))
+			if report == nil {
+				t.Fatal("report is not made")
+			}
+
+			if report.Score != testCase.score || degraded != testCase.degraded {
+				t.Fatalf("score %d, degraded %v. expected %d, %v. issues %v",
+					report.Score, degraded, testCase.score, testCase.degraded, report.Issues)
+			}
+
+			// degraded is reported once while score is under warning score
+			if _, degraded := monitor.measure(1, begin.Add(testCase.measureAt+time.Second)); degraded {
+				t.Fatal("degraded is reported again")
+			}
+		})
+	}
+}
+
+func TestQualityMonitorSlidingWindow(t *testing.T) {
+	monitor := newQualityMonitor(configure.MediaQualityConfigure{Window: 10})
+	begin := time.Now()
+
+	feedVideo(monitor, begin, 0, 5*time.Second, 30, 30, 0)
+	if report, _ := monitor.measure(1, begin.Add(5*time.Second)); report != nil {
+		t.Fatal("report is made before first window is completed")
+	}
+
+	feedVideo(monitor, begin, 5*time.Second, 10*time.Second, 30, 30, 0)
+	report, _ := monitor.measure(1, begin.Add(10*time.Second))
+	if report == nil || report.FrameRate != 30 || report.GopLength != 30 {
+		t.Fatal("invalid report of first window. ", report)
+	}
+
+	// last window has 5 second of 30 fps and 5 second of 15 fps
+	feedVideo(monitor, begin, 10*time.Second, 15*time.Second, 15, 15, 0)
+	report, _ = monitor.measure(1, begin.Add(15*time.Second))
+	if report == nil || report.FrameRate != 22.5 {
+		t.Fatal("frame rate is not measured over last window. ", report)
+	}
+
+	bitrate := float64((150*len(testQualityKeyFrame)+75*len(testQualityPFrame))*8) / 1000 / 10
+	if report.Bitrate != bitrate {
+		t.Fatal("bitrate is not measured over last window. ", report.Bitrate, ", expected ", bitrate)
+	}
+
+	if monitor.Report() != report {
+		t.Fatal("last report is not kept")
+	}
+}
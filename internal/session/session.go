@@ -25,45 +25,168 @@ SOFTWARE.
 package session
 
 import (
+	"context"
+	"errors"
 	"io"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/playback"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/pull"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/record"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/relay"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/segment"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/srt"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/transport"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/whip"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+type SessionState int
+
+const (
+	SESSION_STATE_HANDSHAKE SessionState = iota
+	SESSION_STATE_CONNECTED
+	SESSION_STATE_PUBLISHING
+	SESSION_STATE_PLAYING
+)
+
+// streamContext parse protocol of ingest and call handler of session
+type streamContext interface {
+	InputStream(data []byte) error
+}
+
+// frameWriter is streamContext which can send frames to player
+type frameWriter interface {
+	WriteVideo(frame *media.VideoFrame) error
+	WriteAudio(frame *media.AudioFrame) error
+}
+
 type Session struct {
-	sessionId int
-	streamKey string
+	sessionId  int
+	streamKey  string
+	remoteAddr string
+	metadata   *media.StreamMetadata
+
+	limit          publishLimit
+	ingestMeter    *rateMeter
+	frameMeter     *rateMeter
+	quality        *qualityMonitor
+	ingestBitrate  atomic.Uint64
+	sourceWidth    int
+	sourceHeight   int
+	closeReason    string
+	terminateError error
 
 	sessionHandler Handler
 	transporter    transport.Transporter
-	context        *rtmp.Context
+	context        streamContext
 
 	stopSignal  chan struct{}
 	stopRunning sync.Once
 
+	timeout     configure.TimeoutConfigure
+	state       SessionState
+	createdAt   time.Time
+	handshakeAt time.Time
+	lastMediaAt time.Time
+
 	muxer           *media.TsMuxer
 	streamSegmgment *segment.StreamSegments
+
+	// publisher write source to playbackStream which keep GOP cache of session.
+	// renditionStreams are written by transcoder
+	playbackStream   *playback.Stream
+	renditionStreams []*playback.Stream
+
+	// player read frames of viewer
+	viewer *playback.Viewer
+
+	relayTargets []relay.Target
+	relays       []*relay.Relay
+
+	logger atomic.Pointer[logrus.Entry]
+
+	// phases of session start are traced under startSpan which is ended at first segment or start of play
+	traceContext context.Context
+	startSpan    trace.Span
+	endStart     sync.Once
+
+	// recording is requested by broadcast service. recorder is toggled by api while publishing
+	record   bool
+	recorder atomic.Pointer[record.Recorder]
+}
+
+func NewSession(sessionHandler Handler, transporter transport.Transporter, timeout configure.TimeoutConfigure) *Session {
+	session := newSession(sessionHandler, transporter, timeout)
+
+	context := rtmp.NewContext()
+	context.RegistHandler(session, transporter)
+	session.context = context
+	return session
+}
+
+// NewSrtSession create session about SRT connection whose streamid carry stream key
+func NewSrtSession(sessionHandler Handler, transporter transport.Transporter, timeout configure.TimeoutConfigure, streamId string) *Session {
+	session := newSession(sessionHandler, transporter, timeout)
+
+	context := srt.NewContext(streamId)
+	session.context = context
+	context.RegistHandler(session)
+	return session
+}
+
+// NewWhipSession create session about WHIP publisher whose stream key is validated by OnPrePare before negotiation
+func NewWhipSession(sessionHandler Handler, transporter transport.Transporter, timeout configure.TimeoutConfigure) *Session {
+	session := newSession(sessionHandler, transporter, timeout)
+
+	context := whip.NewContext()
+	session.context = context
+	context.RegistHandler(session)
+	return session
 }
 
-func NewSession(sessionHandler Handler, transporter transport.Transporter) *Session {
+// NewPullSession create session about pulled source whose stream key is validated by OnPrePare before pulling
+func NewPullSession(sessionHandler Handler, transporter transport.Transporter, timeout configure.TimeoutConfigure) *Session {
+	session := newSession(sessionHandler, transporter, timeout)
+
+	context := pull.NewContext()
+	session.context = context
+	context.RegistHandler(session)
+	return session
+}
+
+func newSession(sessionHandler Handler, transporter transport.Transporter, timeout configure.TimeoutConfigure) *Session {
 	session := &Session{
 		sessionId:       -1,
 		streamKey:       "",
+		remoteAddr:      transporter.RemoteAddr(),
+		metadata:        nil,
 		sessionHandler:  sessionHandler,
 		transporter:     transporter,
-		context:         rtmp.NewContext(),
+		context:         nil,
 		stopSignal:      make(chan struct{}),
+		timeout:         timeout,
+		state:           SESSION_STATE_HANDSHAKE,
+		createdAt:       time.Now(),
 		muxer:           media.NewTSMuxer(),
 		streamSegmgment: nil,
 	}
 
-	session.context.RegistHandler(session, transporter)
+	session.logger.Store(logging.Component(logging.COMPONENT_SESSION).WithField(logging.FIELD_REMOTE_ADDR, session.remoteAddr))
+	session.traceContext, session.startSpan = tracing.Tracer().Start(context.Background(), tracing.SPAN_SESSION_START,
+		trace.WithTimestamp(session.createdAt),
+		trace.WithAttributes(attribute.String(tracing.ATTRIBUTE_REMOTE_ADDR, session.remoteAddr)))
 	return session
 }
 
@@ -71,16 +194,16 @@ func (s *Session) Run() {
 	for {
 		select {
 		case <-s.stopSignal:
-			log.Info("[Session][run][", s.sessionId, "] terminate session")
+			s.log().Info("[Session][run] terminate session")
 			return
 		default:
 			err := s.passStream()
 			if err != nil {
 				if err == io.EOF {
-					log.Info("[Session][run][", s.sessionId, "] end of stream")
+					s.log().Info("[Session][run] end of stream")
 					s.sessionHandler.streamEnd(s)
 				} else {
-					log.Error("[Session][run][", s.sessionId, "] stream read error. ", err)
+					s.log().Error("[Session][run] stream read error. ", err)
 					s.sessionHandler.streamError(s)
 				}
 			}
@@ -90,15 +213,92 @@ func (s *Session) Run() {
 
 func (s *Session) setSessionId(id int) {
 	s.sessionId = id
+	logging.RegisterStream(id, s.streamKey, s.remoteAddr)
+	s.logger.Store(logging.Stream(logging.COMPONENT_SESSION, id))
+	s.startSpan.SetAttributes(attribute.Int(tracing.ATTRIBUTE_STREAM_ID, id))
+}
+
+// clearSessionId unregister stream id of session which is failed to open, so that closing session does not touch the stream
+func (s *Session) clearSessionId() {
+	logging.ReleaseStream(s.sessionId)
+	s.sessionId = -1
+}
+
+// endStartSpan end span of session start once. it is ended by first of first segment, start of play and stop
+func (s *Session) endStartSpan() {
+	s.endStart.Do(func() {
+		s.startSpan.End()
+	})
+}
+
+// log return logger with context of stream
+func (s *Session) log() *logrus.Entry {
+	return s.logger.Load()
 }
 
 func (s *Session) registStreamSegment(streamSegmgment *segment.StreamSegments) {
 	s.streamSegmgment = streamSegmgment
 }
 
+func (s *Session) setLimit(limit publishLimit, measureWindow int) {
+	s.limit = limit
+	s.ingestMeter = newRateMeter(measureWindow)
+	s.frameMeter = newRateMeter(measureWindow)
+}
+
+// setQualityMonitor start measuring source of stream until session is stopped
+func (s *Session) setQualityMonitor(quality *qualityMonitor, streamId int) {
+	s.quality = quality
+	if s.metadata != nil {
+		quality.setMetadata(s.metadata)
+	}
+	go s.watchQuality(streamId)
+}
+
+func (s *Session) setCloseReason(reason string) {
+	s.closeReason = reason
+}
+
+func (s *Session) RemoteAddr() string {
+	return s.remoteAddr
+}
+
+func (s *Session) Metadata() *media.StreamMetadata {
+	return s.metadata
+}
+
+// GopCache return sequence headers and last GOP of source. nil before publish is validated
+func (s *Session) GopCache() *media.GopCache {
+	if s.playbackStream == nil {
+		return nil
+	}
+	return s.playbackStream.GopCache()
+}
+
+// QualityReport return last analysis of source. nil when quality monitor is disabled or window is not completed
+func (s *Session) QualityReport() *QualityReport {
+	if s.quality == nil {
+		return nil
+	}
+	return s.quality.Report()
+}
+
+// IngestBitrate return last measured ingest bitrate as kbps
+func (s *Session) IngestBitrate() uint64 {
+	return s.ingestBitrate.Load()
+}
+
 func (s *Session) passStream() error {
+	if err := s.transporter.SetReadDeadline(s.readDeadline()); err != nil {
+		return err
+	}
+
 	data, err := s.transporter.Read()
 	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return s.timeoutError()
+		}
 		return err
 	}
 
@@ -106,66 +306,317 @@ func (s *Session) passStream() error {
 	if err != nil {
 		return err
 	}
+
+	if s.terminateError != nil {
+		return s.terminateError
+	}
+
+	if s.isMediaInactive() {
+		return s.timeoutError()
+	}
+
+	return s.measureIngest(len(data))
+}
+
+func (s *Session) readDeadline() time.Time {
+	switch s.state {
+	case SESSION_STATE_HANDSHAKE:
+		return deadlineAfter(s.createdAt, s.timeout.Handshake)
+	case SESSION_STATE_CONNECTED:
+		return deadlineAfter(s.handshakeAt, s.timeout.Publish)
+	case SESSION_STATE_PLAYING:
+		// player does not send media. broken connection is detected by write
+		return time.Time{}
+	default:
+		return deadlineAfter(time.Now(), s.timeout.Inactivity)
+	}
+}
+
+func (s *Session) isMediaInactive() bool {
+	if s.state != SESSION_STATE_PUBLISHING || s.timeout.Inactivity <= 0 {
+		return false
+	}
+	return time.Since(s.lastMediaAt) > time.Duration(s.timeout.Inactivity)*time.Second
+}
+
+func (s *Session) timeoutError() error {
+	reason := ""
+	switch s.state {
+	case SESSION_STATE_HANDSHAKE:
+		reason = "handshake timeout"
+	case SESSION_STATE_CONNECTED:
+		reason = "publish timeout"
+	default:
+		reason = "media inactivity timeout"
+	}
+
+	s.setCloseReason(reason)
+	return errors.New(reason)
+}
+
+// deadlineAfter return zero time which means no deadline when timeout is disabled
+func deadlineAfter(from time.Time, timeoutSecond int) time.Time {
+	if timeoutSecond <= 0 {
+		return time.Time{}
+	}
+	return from.Add(time.Duration(timeoutSecond) * time.Second)
+}
+
+func (s *Session) measureIngest(size int) error {
+	if s.ingestMeter == nil {
+		return nil
+	}
+
+	rate, updated := s.ingestMeter.add(size, time.Now())
+	if !updated {
+		return nil
+	}
+
+	bitrate := rate * 8 / 1000
+	s.ingestBitrate.Store(uint64(bitrate))
+	return s.sessionHandler.checkIngestBitrate(s, bitrate)
+}
+
+func (s *Session) checkVideoSource(frame *media.VideoFrame) error {
+	if s.frameMeter == nil {
+		return nil
+	}
+
+	width, height, exist := media.GetResolution(frame)
+	if exist && (width != s.sourceWidth || height != s.sourceHeight) {
+		s.sourceWidth = width
+		s.sourceHeight = height
+		if err := s.sessionHandler.checkValidResolution(s, width, height); err != nil {
+			return err
+		}
+	}
+
+	if frameRate, updated := s.frameMeter.add(1, time.Now()); updated {
+		return s.sessionHandler.checkValidFrameRate(s, frameRate)
+	}
 	return nil
 }
 
+// watchQuality measure source periodically, so that publisher which stop sending frames is reported
+func (s *Session) watchQuality(streamId int) {
+	ticker := time.NewTicker(QUALITY_MEASURE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopSignal:
+			return
+		case now := <-ticker.C:
+			s.measureQuality(streamId, now)
+		}
+	}
+}
+
+func (s *Session) measureQuality(streamId int, now time.Time) {
+	report, degraded := s.quality.measure(streamId, now)
+	if report == nil {
+		return
+	}
+
+	s.log().Debug("[Session][measureQuality] score : ", report.Score, ", issues : ", report.Issues)
+	if degraded {
+		s.sessionHandler.qualityDegraded(s, report)
+	}
+}
+
 func (s *Session) stop() {
 	s.stopRunning.Do(
 		func() {
-			log.Info("[Session][run][", s.sessionId, "] stop session")
+			s.log().Info("[Session][run] stop session")
+			s.endStartSpan()
 			s.transporter.Close()
+			if s.sessionId >= 0 {
+				logging.ReleaseStream(s.sessionId)
+			}
+			logging.ReleaseStreamKey(s.streamKey)
 			close(s.stopSignal)
+
+			if s.viewer != nil {
+				s.viewer.Close()
+			}
 		})
 }
 
+func (s *Session) OnHandshakeDone() {
+	s.log().Info("[Session][OnHandshakeDone]")
+	s.state = SESSION_STATE_CONNECTED
+	s.handshakeAt = time.Now()
+
+	_, span := tracing.Tracer().Start(s.traceContext, tracing.SPAN_HANDSHAKE, trace.WithTimestamp(s.createdAt))
+	span.End(trace.WithTimestamp(s.handshakeAt))
+}
+
 func (s *Session) OnPrePare(appName, streamPath string) error {
-	log.Info("[Session][OnPrePare] appName : ", appName, " streamPath : ", streamPath)
+	logging.RedactStreamKey(streamPath)
 	s.streamKey = streamPath
+	s.logger.Store(s.log().WithField(logging.FIELD_STREAM_KEY_HASH, logging.StreamKeyHash(streamPath)))
+
+	s.log().Info("[Session][OnPrePare] appName : ", appName, " streamKeyHash : ", logging.StreamKeyHash(streamPath))
 	return s.sessionHandler.checkValidStream(s, appName, streamPath)
 }
 
 func (s *Session) OnPublish() {
-	log.Info("[Session][OnPublish][", s.sessionId, "]")
+	s.log().Info("[Session][OnPublish]")
+	s.state = SESSION_STATE_PUBLISHING
+	s.lastMediaAt = time.Now()
+
 	err := s.sessionHandler.streamStart(s)
 	if err != nil {
 		s.sessionHandler.streamEnd(s)
 	}
 }
 
+func (s *Session) OnPlay(appName, streamPath string) error {
+	s.log().Info("[Session][OnPlay] appName : ", appName, " remoteAddr : ", s.remoteAddr)
+	return s.sessionHandler.checkValidPlay(s, appName, streamPath)
+}
+
+func (s *Session) OnPlayStart() {
+	s.log().Info("[Session][OnPlayStart] ", s.viewer.StreamName())
+	s.state = SESSION_STATE_PLAYING
+	s.endStartSpan()
+	go s.play()
+}
+
+// play send frames of viewer until stream or session is closed
+func (s *Session) play() {
+	writer, ok := s.context.(frameWriter)
+	if !ok {
+		s.log().Error("[Session][play] context can not write frame")
+		s.sessionHandler.streamError(s)
+		return
+	}
+
+	for {
+		select {
+		case <-s.stopSignal:
+			return
+		case frame, ok := <-s.viewer.Frames():
+			if !ok {
+				s.log().Info("[Session][play] end of stream ", s.viewer.StreamName())
+				s.sessionHandler.streamEnd(s)
+				return
+			}
+
+			if err := s.writeFrame(writer, frame); err != nil {
+				s.log().Warn("[Session][play] write fail. ", err)
+				s.sessionHandler.streamError(s)
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) writeFrame(writer frameWriter, frame media.Frame) error {
+	switch frame := frame.(type) {
+	case *media.VideoFrame:
+		return writer.WriteVideo(frame)
+	case *media.AudioFrame:
+		return writer.WriteAudio(frame)
+	}
+	return nil
+}
+
 func (s *Session) OnError() {
-	log.Warn("[Session][OnError][", s.sessionId, "]")
+	s.log().Warn("[Session][OnError]")
 	s.sessionHandler.streamError(s)
 }
 
+func (s *Session) OnMetadata(metadata *media.StreamMetadata, timestamp media.Timestamp) error {
+	s.log().Info("[Session][OnMetadata] ", metadata.Fields())
+	if err := s.sessionHandler.checkValidMetadata(s, metadata); err != nil {
+		s.log().Error("[Session][OnMetadata] invalid metadata. ", err)
+		return err
+	}
+
+	s.metadata = metadata
+	if s.quality != nil {
+		s.quality.setMetadata(metadata)
+	}
+
+	if s.streamSegmgment == nil {
+		return nil
+	}
+
+	buffer, err := s.muxer.MuxingMetadata(media.MakeID3Tag(metadata.Fields()), timestamp)
+	if err != nil {
+		s.log().Warn("[Session][OnMetadata] metadata muxing fail. ", err)
+		return nil
+	}
+
+	err = s.streamSegmgment.WriteMetadata(buffer, timestamp)
+	if err != nil {
+		s.log().Warn("[Session][OnMetadata] segment write fail. ", err)
+	}
+	return nil
+}
+
 func (s *Session) OnVideoFrame(frame *media.VideoFrame) {
-	log.Trace("[Session][OnVideoFrame][", s.sessionId, "]")
+	s.log().Trace("[Session][OnVideoFrame]")
+
+	s.lastMediaAt = time.Now()
+
+	if err := s.checkVideoSource(frame); err != nil {
+		s.terminateError = err
+		return
+	}
+
+	if s.quality != nil {
+		s.quality.addVideo(frame, s.lastMediaAt)
+	}
+
+	if s.playbackStream != nil {
+		s.playbackStream.WriteVideo(frame)
+	}
+
+	if recorder := s.recorder.Load(); recorder != nil {
+		recorder.WriteVideo(frame)
+	}
 
 	buffer, err := s.muxer.MuxingVideo(frame)
 	if err != nil {
-		log.Warn("[Session][OnVideoFrame][", s.sessionId, "] video muxing fail. ", err)
+		s.log().Warn("[Session][OnVideoFrame] video muxing fail. ", err)
 		return
 	}
 
 	isIDRFraem := media.CheckIsIDRFrame(frame)
 	err = s.streamSegmgment.WriteVideo(buffer, frame.Timestamp(), isIDRFraem)
 	if err != nil {
-		log.Warn("[Session][OnVideoFrame][", s.sessionId, "] segment write fail. ", err)
+		s.log().Warn("[Session][OnVideoFrame] segment write fail. ", err)
 		return
 	}
 }
 
 func (s *Session) OnAudioFrame(frame *media.AudioFrame) {
-	log.Trace("[Session][OnAudioFrame][", s.sessionId, "]")
+	s.log().Trace("[Session][OnAudioFrame]")
+	s.lastMediaAt = time.Now()
+
+	if s.quality != nil {
+		s.quality.addAudio(frame, s.lastMediaAt)
+	}
+
+	if s.playbackStream != nil {
+		s.playbackStream.WriteAudio(frame)
+	}
+
+	if recorder := s.recorder.Load(); recorder != nil {
+		recorder.WriteAudio(frame)
+	}
 
 	buffer, err := s.muxer.MuxingAudio(frame)
 	if err != nil {
-		log.Warn("[Session][OnAudioFrame][", s.sessionId, "] audio muxing fail. ", err)
+		s.log().Warn("[Session][OnAudioFrame] audio muxing fail. ", err)
 		return
 	}
 
 	err = s.streamSegmgment.WriteAudio(buffer, frame.Timestamp())
 	if err != nil {
-		log.Warn("[Session][OnAudioFrame][", s.sessionId, "] segment write fail. ", err)
+		s.log().Warn("[Session][OnAudioFrame] segment write fail. ", err)
 		return
 	}
 }
@@ -0,0 +1,169 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
+)
+
+// collectorStandIn receive spans of OTLP/HTTP exporter
+type collectorStandIn struct {
+	mutex sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collectorStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	request := &collectortrace.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+	c.mutex.Unlock()
+
+	data, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+func (c *collectorStandIn) find(name string) []*tracepb.Span {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	spans := make([]*tracepb.Span, 0)
+	for _, span := range c.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+type testTransporter struct{}
+
+func (t *testTransporter) Read() ([]byte, error)                    { return nil, io.EOF }
+func (t *testTransporter) Write(data []byte) error                  { return nil }
+func (t *testTransporter) SetReadDeadline(deadline time.Time) error { return nil }
+func (t *testTransporter) RemoteAddr() string                       { return "192.0.2.1:5000" }
+func (t *testTransporter) Close()                                   {}
+
+func TestTeardownIsTracedAsOwnRoot(t *testing.T) {
+	collector := &collectorStandIn{}
+	collectorServer := httptest.NewServer(collector)
+	defer collectorServer.Close()
+
+	traceparents := make(chan string, 1)
+	broadcastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		io.WriteString(w, `{"success":true}`)
+	}))
+	defer broadcastServer.Close()
+
+	shutdown, err := tracing.Setup(configure.TracingConfigure{
+		Enable:   true,
+		Endpoint: strings.TrimPrefix(collectorServer.URL, HttpScheme),
+		Insecure: true,
+	}, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serviceConfigure := &configure.Configure{}
+	serviceConfigure.Server.BroadcastServerAddress = strings.TrimPrefix(broadcastServer.URL, HttpScheme)
+	serviceConfigure.Segment.BasePath = t.TempDir()
+	manager := NewManager(serviceConfigure)
+	t.Cleanup(manager.Close)
+
+	session := newSession(manager, &testTransporter{}, configure.TimeoutConfigure{})
+	session.setSessionId(1)
+	if err := manager.addSession(1, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// start span is ended by first segment and again by stop of session
+	manager.onFirstSegment(1, time.Second)
+	manager.closeStream(session)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	starts := collector.find(tracing.SPAN_SESSION_START)
+	ends := collector.find(tracing.SPAN_SESSION_END)
+	requests := collector.find(tracing.SPAN_BROADCAST_REQUEST)
+	if len(starts) != 1 || len(ends) != 1 || len(requests) != 1 {
+		t.Fatal("invalid spans. start ", len(starts), ", end ", len(ends), ", request ", len(requests))
+	}
+
+	start, end, request := starts[0], ends[0], requests[0]
+	if len(end.ParentSpanId) != 0 || bytes.Equal(end.TraceId, start.TraceId) {
+		t.Fatal("teardown is traced under session start")
+	}
+
+	if len(end.Links) != 1 || !bytes.Equal(end.Links[0].TraceId, start.TraceId) || !bytes.Equal(end.Links[0].SpanId, start.SpanId) {
+		t.Fatal("teardown is not linked to session start. ", end.Links)
+	}
+
+	if !bytes.Equal(request.TraceId, end.TraceId) || !bytes.Equal(request.ParentSpanId, end.SpanId) {
+		t.Fatal("deactivate request is not traced under teardown")
+	}
+
+	select {
+	case traceparent := <-traceparents:
+		if !strings.Contains(traceparent, fmt.Sprintf("%x", end.TraceId)) {
+			t.Fatal("trace of teardown is not propagated. ", traceparent)
+		}
+	default:
+		t.Fatal("deactivate is not requested")
+	}
+}
@@ -0,0 +1,555 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	DEFAULT_LATENCY   = 120 * time.Millisecond
+	PEER_IDLE_TIMEOUT = 5 * time.Second
+
+	TIMER_INTERVAL     = 10 * time.Millisecond
+	KEEPALIVE_INTERVAL = time.Second
+	MIN_NAK_INTERVAL   = 20 * time.Millisecond
+
+	DELIVER_QUEUE_SIZE = 8192
+
+	// lost sequences which wait retransmission. more losses are given up by latency
+	MAX_LOSS_LIST_SIZE = 1024
+	// loss list of NAK should fit in a datagram
+	MAX_NAK_CONTENT_SIZE = DEFAULT_PAYLOAD_MTU - HEADER_SIZE - 28
+)
+
+var ErrReceiveOnly = errors.New("srt connection is receive only")
+
+type Config struct {
+	Passphrase string
+
+	// length of stream encrypting key when this side generate key. 16, 24 or 32
+	KeyLength int
+	Latency   time.Duration
+
+	// streamid which is sent by caller
+	StreamId string
+}
+
+func (c Config) latencyMs() uint16 {
+	if c.Latency <= 0 {
+		return uint16(DEFAULT_LATENCY / time.Millisecond)
+	}
+	return uint16(c.Latency / time.Millisecond)
+}
+
+type receivedPacket struct {
+	payload   []byte
+	arrivedAt time.Time
+}
+
+// Conn is receiving side of SRT live mode connection.
+// retransmission is requested by NAK and packet which is not recovered in latency is dropped.
+type Conn struct {
+	socketId     uint32
+	peerSocketId uint32
+	streamId     string
+	latency      time.Duration
+	crypto       *cryptoContext
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	output     func(data []byte) error
+	onClose    func()
+	startTime  time.Time
+
+	mutex         sync.Mutex
+	expected      uint32
+	received      map[uint32]*receivedPacket
+	losses        map[uint32]time.Time
+	ackNumber     uint32
+	ackSentAt     map[uint32]time.Time
+	lastAcked     uint32
+	lastSentAt    time.Time
+	lastReceived  time.Time
+	rtt           time.Duration
+	rttVariance   time.Duration
+	receivedBytes int
+
+	// packets which are dropped because reader does not take them
+	dropped  uint64
+	dropping bool
+
+	deliver      chan []byte
+	pending      []byte
+	readDeadline time.Time
+	deadlineLock sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(socketId, peerSocketId, initialSequence uint32, latency time.Duration, localAddr, remoteAddr net.Addr, output func(data []byte) error) *Conn {
+	now := time.Now()
+	return &Conn{
+		socketId:     socketId,
+		peerSocketId: peerSocketId,
+		latency:      latency,
+		localAddr:    localAddr,
+		remoteAddr:   remoteAddr,
+		output:       output,
+		startTime:    now,
+		expected:     initialSequence,
+		lastAcked:    initialSequence,
+		received:     make(map[uint32]*receivedPacket),
+		losses:       make(map[uint32]time.Time),
+		ackSentAt:    make(map[uint32]time.Time),
+		lastSentAt:   now,
+		lastReceived: now,
+		rtt:          100 * time.Millisecond,
+		rttVariance:  50 * time.Millisecond,
+		deliver:      make(chan []byte, DELIVER_QUEUE_SIZE),
+		closed:       make(chan struct{}),
+	}
+}
+
+func (c *Conn) run() {
+	ticker := time.NewTicker(TIMER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			if !c.onTimer(now) {
+				c.log().Warn("[SrtConn][run] peer is idle. ", c.remoteAddr)
+				c.shutdown(false)
+				return
+			}
+		}
+	}
+}
+
+// StreamId return streamid which is sent by caller on handshake
+// log return logger with socket id of connection
+func (c *Conn) log() *logrus.Entry {
+	return logging.Component(logging.COMPONENT_SRT).WithField(logging.FIELD_CONNECTION_ID, c.socketId)
+}
+
+func (c *Conn) StreamId() string {
+	return c.streamId
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		payload, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = payload
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *Conn) next() ([]byte, error) {
+	select {
+	case payload := <-c.deliver:
+		return payload, nil
+	default:
+	}
+
+	c.deadlineLock.Lock()
+	deadline := c.readDeadline
+	c.deadlineLock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case payload := <-c.deliver:
+		return payload, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Write(_ []byte) (int, error) {
+	return 0, ErrReceiveOnly
+}
+
+func (c *Conn) Close() error {
+	c.shutdown(true)
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *Conn) SetDeadline(deadline time.Time) error {
+	return c.SetReadDeadline(deadline)
+}
+
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.readDeadline = deadline
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *Conn) shutdown(notify bool) {
+	c.closeOnce.Do(
+		func() {
+			if notify {
+				c.mutex.Lock()
+				c.sendControl(CONTROL_SHUTDOWN, 0, make([]byte, 4))
+				c.mutex.Unlock()
+			}
+
+			close(c.closed)
+			if c.onClose != nil {
+				c.onClose()
+			}
+		})
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) handlePacket(p *packet) {
+	c.mutex.Lock()
+	c.lastReceived = time.Now()
+	c.mutex.Unlock()
+
+	if !p.isControl {
+		c.handleData(p)
+		return
+	}
+
+	switch p.controlType {
+	case CONTROL_SHUTDOWN:
+		c.log().Info("[SrtConn][handlePacket] shutdown from peer")
+		c.shutdown(false)
+	case CONTROL_ACKACK:
+		c.handleAckAck(p.typeInfo)
+	case CONTROL_DROPREQ:
+		c.handleDropRequest(p.payload)
+	}
+}
+
+func (c *Conn) handleData(p *packet) {
+	payload := p.payload
+	if p.keyFlag != 0 {
+		if c.crypto == nil {
+			return
+		}
+
+		if err := c.crypto.decrypt(p.keyFlag, p.sequence, payload); err != nil {
+			c.log().Warn("[SrtConn][handleData] can not decrypt. ", err)
+			return
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.receivedBytes += len(payload)
+	if sequenceLess(p.sequence, c.expected) {
+		return
+	}
+
+	// sender can not have more packets in flight than flow window of handshake
+	if sequenceDistance(c.expected, p.sequence) >= DEFAULT_FLOW_WINDOW {
+		c.log().Debug("[SrtConn][handleData] drop packet out of flow window. ", p.sequence)
+		return
+	}
+
+	if _, exist := c.received[p.sequence]; exist {
+		return
+	}
+
+	delete(c.losses, p.sequence)
+	c.received[p.sequence] = &receivedPacket{payload: payload, arrivedAt: time.Now()}
+
+	if p.sequence == c.expected {
+		c.drain()
+		return
+	}
+
+	// gap before this packet is reported immediately
+	lost := make([]uint32, 0)
+	for sequence := c.expected; sequenceLess(sequence, p.sequence) && len(c.losses) < MAX_LOSS_LIST_SIZE; sequence = sequenceNext(sequence) {
+		if _, exist := c.received[sequence]; exist {
+			continue
+		}
+
+		if _, exist := c.losses[sequence]; !exist {
+			c.losses[sequence] = time.Now()
+			lost = append(lost, sequence)
+		}
+	}
+
+	if len(lost) > 0 {
+		c.sendNak(lost)
+	}
+}
+
+// drain deliver in order packets. mutex should be locked
+func (c *Conn) drain() {
+	for {
+		received, exist := c.received[c.expected]
+		if !exist {
+			return
+		}
+
+		delete(c.received, c.expected)
+		c.expected = sequenceNext(c.expected)
+
+		select {
+		case c.deliver <- received.payload:
+			if c.dropping {
+				c.log().Warn("[SrtConn][drain] deliver queue is recovered. ", c.dropped, " packets are dropped")
+				c.dropping = false
+			}
+		default:
+			// flow window of ACK is also reduced by queue so that sender slow down
+			c.dropped++
+			if !c.dropping {
+				c.log().Warn("[SrtConn][drain] deliver queue is full. drop packets")
+				c.dropping = true
+			}
+		}
+	}
+}
+
+// skipTo give up lost packets before sequence. mutex should be locked
+func (c *Conn) skipTo(sequence uint32) {
+	for ; sequenceLess(c.expected, sequence); c.expected = sequenceNext(c.expected) {
+		delete(c.losses, c.expected)
+		delete(c.received, c.expected)
+	}
+	c.drain()
+}
+
+func (c *Conn) handleDropRequest(payload []byte) {
+	if len(payload) < 8 {
+		return
+	}
+
+	last := binary.BigEndian.Uint32(payload[4:8]) & SEQUENCE_MAX
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !sequenceLess(last, c.expected) {
+		c.skipTo(sequenceNext(last))
+	}
+}
+
+func (c *Conn) handleAckAck(ackNumber uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sentAt, exist := c.ackSentAt[ackNumber]
+	if !exist {
+		return
+	}
+	delete(c.ackSentAt, ackNumber)
+
+	rtt := time.Since(sentAt)
+	diff := c.rtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+
+	c.rttVariance = (c.rttVariance*3 + diff) / 4
+	c.rtt = (c.rtt*7 + rtt) / 8
+}
+
+// onTimer send periodic ACK, NAK and keepalive. return false when peer is idle
+func (c *Conn) onTimer(now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastReceived) > PEER_IDLE_TIMEOUT {
+		return false
+	}
+
+	c.dropTooLate(now)
+
+	if c.expected != c.lastAcked {
+		c.sendAck(now)
+	}
+
+	nakInterval := 4*c.rtt + c.rttVariance
+	if nakInterval < MIN_NAK_INTERVAL {
+		nakInterval = MIN_NAK_INTERVAL
+	}
+
+	lost := make([]uint32, 0)
+	for sequence, reportedAt := range c.losses {
+		if now.Sub(reportedAt) >= nakInterval {
+			c.losses[sequence] = now
+			lost = append(lost, sequence)
+		}
+	}
+
+	if len(lost) > 0 {
+		c.sendNak(lost)
+	}
+
+	if now.Sub(c.lastSentAt) >= KEEPALIVE_INTERVAL {
+		c.sendControl(CONTROL_KEEPALIVE, 0, make([]byte, 4))
+	}
+	return true
+}
+
+// dropTooLate skip lost packets when first packet after them wait longer than latency
+func (c *Conn) dropTooLate(now time.Time) {
+	for len(c.received) > 0 {
+		first := uint32(0)
+		var firstPacket *receivedPacket
+		for sequence, received := range c.received {
+			if firstPacket == nil || sequenceLess(sequence, first) {
+				first, firstPacket = sequence, received
+			}
+		}
+
+		if now.Sub(firstPacket.arrivedAt) < c.latency {
+			return
+		}
+
+		c.log().Debug("[SrtConn][dropTooLate] drop ", sequenceDistance(c.expected, first), " packets")
+		c.skipTo(first)
+	}
+}
+
+// sendAck send full ACK. mutex should be locked
+func (c *Conn) sendAck(now time.Time) {
+	c.ackNumber++
+	c.ackSentAt[c.ackNumber] = now
+	c.lastAcked = c.expected
+
+	available := DELIVER_QUEUE_SIZE - len(c.deliver) - len(c.received)
+	if available < 2 {
+		available = 2
+	}
+
+	content := make([]byte, 28)
+	binary.BigEndian.PutUint32(content[0:4], c.expected)
+	binary.BigEndian.PutUint32(content[4:8], uint32(c.rtt/time.Microsecond))
+	binary.BigEndian.PutUint32(content[8:12], uint32(c.rttVariance/time.Microsecond))
+	binary.BigEndian.PutUint32(content[12:16], uint32(available))
+	binary.BigEndian.PutUint32(content[24:28], uint32(c.receivedBytes))
+	c.receivedBytes = 0
+
+	c.sendControl(CONTROL_ACK, c.ackNumber, content)
+
+	// ACKACK can be lost
+	for ackNumber, sentAt := range c.ackSentAt {
+		if now.Sub(sentAt) > PEER_IDLE_TIMEOUT {
+			delete(c.ackSentAt, ackNumber)
+		}
+	}
+}
+
+// sendNak send loss list whose continuous sequences are compressed to range
+func (c *Conn) sendNak(lost []uint32) {
+	sort.Slice(lost, func(i, j int) bool { return sequenceLess(lost[i], lost[j]) })
+
+	content := make([]byte, 0, len(lost)*4)
+	for i := 0; i < len(lost) && len(content)+8 <= MAX_NAK_CONTENT_SIZE; {
+		j := i
+		for j+1 < len(lost) && lost[j+1] == sequenceNext(lost[j]) {
+			j++
+		}
+
+		if i == j {
+			content = binary.BigEndian.AppendUint32(content, lost[i])
+		} else {
+			content = binary.BigEndian.AppendUint32(content, lost[i]|0x80000000)
+			content = binary.BigEndian.AppendUint32(content, lost[j])
+		}
+		i = j + 1
+	}
+
+	c.sendControl(CONTROL_NAK, 0, content)
+}
+
+// sendControl send control packet to peer. mutex should be locked
+func (c *Conn) sendControl(controlType ControlType, typeInfo uint32, content []byte) {
+	if c.isClosed() {
+		return
+	}
+
+	p := &packet{
+		isControl:     true,
+		controlType:   controlType,
+		typeInfo:      typeInfo,
+		timestamp:     uint32(time.Since(c.startTime) / time.Microsecond),
+		destinationId: c.peerSocketId,
+		payload:       content,
+	}
+
+	c.lastSentAt = time.Now()
+	if err := c.output(p.marshal()); err != nil {
+		c.log().Debug("[SrtConn][sendControl] send fail. ", err)
+	}
+}
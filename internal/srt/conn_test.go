@@ -0,0 +1,90 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConn(initialSequence uint32) *Conn {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	return newConn(1, 2, initialSequence, DEFAULT_LATENCY, addr, addr, func(_ []byte) error { return nil })
+}
+
+func TestConnDropPacketOutOfFlowWindow(t *testing.T) {
+	c := newTestConn(100)
+	c.handleData(&packet{sequence: 100 + DEFAULT_FLOW_WINDOW, payload: []byte{1}})
+
+	if len(c.received) != 0 || len(c.losses) != 0 {
+		t.Fatal("packet out of flow window is kept. received ", len(c.received), ", losses ", len(c.losses))
+	}
+}
+
+func TestConnLossListIsLimited(t *testing.T) {
+	c := newTestConn(100)
+	c.handleData(&packet{sequence: 100 + DEFAULT_FLOW_WINDOW - 1, payload: []byte{1}})
+
+	if len(c.losses) != MAX_LOSS_LIST_SIZE {
+		t.Fatal("invalid size of loss list. ", len(c.losses))
+	}
+
+	// retransmission fill gap and packets are delivered in order
+	c.handleData(&packet{sequence: 100, payload: []byte{2}})
+	if c.expected != 101 || len(c.deliver) != 1 {
+		t.Fatal("packet is not delivered. expected ", c.expected)
+	}
+}
+
+func TestConnCountDropOfFullQueue(t *testing.T) {
+	c := newTestConn(0)
+	for sequence := uint32(0); sequence < DELIVER_QUEUE_SIZE+10; sequence++ {
+		c.handleData(&packet{sequence: sequence, payload: []byte{1}})
+	}
+
+	if c.dropped != 10 || !c.dropping {
+		t.Fatal("drop is not counted. ", c.dropped)
+	}
+
+	<-c.deliver
+	c.handleData(&packet{sequence: DELIVER_QUEUE_SIZE + 10, payload: []byte{1}})
+	if c.dropping {
+		t.Fatal("recovery of queue is not detected")
+	}
+}
+
+func TestConnDropTooLate(t *testing.T) {
+	c := newTestConn(0)
+	c.handleData(&packet{sequence: 5, payload: []byte{1}})
+
+	c.mutex.Lock()
+	c.dropTooLate(time.Now().Add(DEFAULT_LATENCY))
+	c.mutex.Unlock()
+
+	if c.expected != 6 || len(c.losses) != 0 || len(c.deliver) != 1 {
+		t.Fatal("lost packets are not given up. expected ", c.expected)
+	}
+}
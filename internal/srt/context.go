@@ -0,0 +1,88 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// Context demux MPEG-TS from SRT connection.
+// handshake of SRT is already done when connection is accepted, so first data is treated as publish.
+type Context struct {
+	handler   ServerHandler
+	streamId  string
+	published bool
+
+	demuxer *media.TsDemuxer
+}
+
+func NewContext(streamId string) *Context {
+	return &Context{
+		handler:   nil,
+		streamId:  streamId,
+		published: false,
+		demuxer:   media.NewTsDemuxer(),
+	}
+}
+
+func (c *Context) RegistHandler(handler ServerHandler) {
+	c.handler = handler
+
+	c.demuxer.OnVideoFrame = func(frame *media.VideoFrame) {
+		c.handler.OnVideoFrame(frame)
+	}
+
+	c.demuxer.OnAudioFrame = func(frame *media.AudioFrame) {
+		c.handler.OnAudioFrame(frame)
+	}
+
+	c.handler.OnHandshakeDone()
+}
+
+func (c *Context) InputStream(data []byte) error {
+	if !c.published {
+		if err := c.publish(); err != nil {
+			return err
+		}
+	}
+	return c.demuxer.Input(data)
+}
+
+func (c *Context) publish() error {
+	appName, streamKey, err := ParseStreamId(c.streamId)
+	if err != nil {
+		logging.Component(logging.COMPONENT_SRT).Warn("[SrtContext][publish] invalid streamid. ", err)
+		return err
+	}
+
+	if err := c.handler.OnPrePare(appName, streamKey); err != nil {
+		return err
+	}
+
+	c.published = true
+	c.handler.OnPublish()
+	return nil
+}
@@ -0,0 +1,218 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+
+	keywrap "github.com/go-jose/go-jose/v4/cipher"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	KM_SIGNATURE      = 0x2029
+	KM_VERSION        = 1
+	KM_PACKET_TYPE    = 2
+	KM_CIPHER_AES_CTR = 2
+	KM_SE_MPEGTS      = 2
+	KM_HEADER_SIZE    = 16
+	KM_SALT_SIZE      = 16
+
+	KEY_FLAG_EVEN = 0x1
+	KEY_FLAG_ODD  = 0x2
+
+	PBKDF2_ITERATION = 2048
+)
+
+// key material status of KMRSP
+const (
+	KM_STATE_BADSECRET = 3
+	KM_STATE_NOSECRET  = 4
+)
+
+var (
+	ErrBadSecret = errors.New("srt passphrase mismatch")
+	ErrNoSecret  = errors.New("srt passphrase is not configured")
+)
+
+// cryptoContext decrypt payload of data packet with stream encrypting key
+type cryptoContext struct {
+	salt  []byte
+	keys  [2]cipher.Block
+	keyKK uint8
+}
+
+// newCryptoContext parse key material of KMREQ and unwrap keys with passphrase
+func newCryptoContext(passphrase string, keyMaterial []byte) (*cryptoContext, error) {
+	if len(keyMaterial) < KM_HEADER_SIZE {
+		return nil, errInvalidPacket
+	}
+
+	header := binary.BigEndian.Uint32(keyMaterial[0:4])
+	if (header>>28)&0x7 != KM_VERSION || (header>>24)&0xF != KM_PACKET_TYPE || uint16(header>>8) != KM_SIGNATURE {
+		return nil, errInvalidPacket
+	}
+
+	if keyMaterial[8] != KM_CIPHER_AES_CTR {
+		return nil, errors.New("unsupported srt cipher")
+	}
+
+	keyFlag := uint8(header & 0x3)
+	saltLength := int(keyMaterial[14]) * 4
+	keyLength := int(keyMaterial[15]) * 4
+	keyCount := 1
+	if keyFlag == KEY_FLAG_EVEN|KEY_FLAG_ODD {
+		keyCount = 2
+	}
+
+	wrappedLength := keyLength*keyCount + 8
+	if saltLength != KM_SALT_SIZE || len(keyMaterial) < KM_HEADER_SIZE+saltLength+wrappedLength {
+		return nil, errInvalidPacket
+	}
+
+	if len(passphrase) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	salt := keyMaterial[KM_HEADER_SIZE : KM_HEADER_SIZE+saltLength]
+	wrapped := keyMaterial[KM_HEADER_SIZE+saltLength : KM_HEADER_SIZE+saltLength+wrappedLength]
+
+	kek := pbkdf2.Key([]byte(passphrase), salt[saltLength-8:], PBKDF2_ITERATION, keyLength, sha1.New)
+	keys, err := keyUnwrap(kek, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	context := &cryptoContext{
+		salt:  append([]byte{}, salt...),
+		keyKK: keyFlag,
+	}
+
+	index := 0
+	for i, flag := range []uint8{KEY_FLAG_EVEN, KEY_FLAG_ODD} {
+		if keyFlag&flag == 0 {
+			continue
+		}
+
+		block, err := aes.NewCipher(keys[index*keyLength : (index+1)*keyLength])
+		if err != nil {
+			return nil, err
+		}
+
+		context.keys[i] = block
+		index++
+	}
+	return context, nil
+}
+
+// makeKeyMaterial generate new even key and return key material of KMREQ which is wrapped with passphrase
+func makeKeyMaterial(passphrase string, keyLength int) (*cryptoContext, []byte, error) {
+	salt := make([]byte, KM_SALT_SIZE)
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	kek := pbkdf2.Key([]byte(passphrase), salt[KM_SALT_SIZE-8:], PBKDF2_ITERATION, keyLength, sha1.New)
+	wrapped, err := keyWrap(kek, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyMaterial := make([]byte, KM_HEADER_SIZE)
+	binary.BigEndian.PutUint32(keyMaterial[0:4], KM_VERSION<<28|KM_PACKET_TYPE<<24|KM_SIGNATURE<<8|KEY_FLAG_EVEN)
+	keyMaterial[8] = KM_CIPHER_AES_CTR
+	keyMaterial[10] = KM_SE_MPEGTS
+	keyMaterial[14] = KM_SALT_SIZE / 4
+	keyMaterial[15] = byte(keyLength / 4)
+	keyMaterial = append(keyMaterial, salt...)
+	keyMaterial = append(keyMaterial, wrapped...)
+
+	context, err := newCryptoContext(passphrase, keyMaterial)
+	if err != nil {
+		return nil, nil, err
+	}
+	return context, keyMaterial, nil
+}
+
+// decrypt payload in place. counter is salt xor packet sequence
+func (c *cryptoContext) decrypt(keyFlag uint8, sequence uint32, payload []byte) error {
+	var block cipher.Block
+	switch keyFlag {
+	case KEY_FLAG_EVEN:
+		block = c.keys[0]
+	case KEY_FLAG_ODD:
+		block = c.keys[1]
+	}
+
+	if block == nil {
+		return errors.New("srt key is not exist")
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[10:14], sequence)
+	for i := 0; i < 14; i++ {
+		iv[i] ^= c.salt[i]
+	}
+
+	cipher.NewCTR(block, iv).XORKeyStream(payload, payload)
+	return nil
+}
+
+// keyWrap wrap key with AES key wrap of RFC 3394
+func keyWrap(kek, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return keywrap.KeyWrap(block, plain)
+}
+
+// keyUnwrap unwrap key with AES key wrap of RFC 3394. mismatched passphrase make integrity check fail
+func keyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errInvalidPacket
+	}
+
+	keys, err := keywrap.KeyUnwrap(block, wrapped)
+	if err != nil {
+		return nil, ErrBadSecret
+	}
+	return keys, nil
+}
@@ -0,0 +1,84 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestKeyWrapRfc3394(t *testing.T) {
+	// 4.1 of RFC 3394. wrap 128 bits of key data with 128 bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	expected, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped, err := keyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(wrapped, expected) {
+		t.Fatalf("invalid wrapped key. %X", wrapped)
+	}
+
+	unwrapped, err := keyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("invalid unwrapped key. %X", unwrapped)
+	}
+}
+
+func TestKeyMaterialPassphrase(t *testing.T) {
+	for _, keyLength := range []int{16, 24, 32} {
+		sender, keyMaterial, err := makeKeyMaterial("passphrase-of-test", keyLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		receiver, err := newCryptoContext("passphrase-of-test", keyMaterial)
+		if err != nil {
+			t.Fatal("key material is not accepted. ", err)
+		}
+
+		// CTR of same key and counter decrypt what is encrypted
+		payload := []byte("mpeg ts payload of srt data packet")
+		encrypted := append([]byte{}, payload...)
+		sender.decrypt(KEY_FLAG_EVEN, 7, encrypted)
+		receiver.decrypt(KEY_FLAG_EVEN, 7, encrypted)
+		if !bytes.Equal(encrypted, payload) {
+			t.Fatal("payload is not recovered. key length ", keyLength)
+		}
+
+		if _, err := newCryptoContext("other-passphrase", keyMaterial); !errors.Is(err, ErrBadSecret) {
+			t.Fatal("mismatched passphrase is not detected. ", err)
+		}
+	}
+}
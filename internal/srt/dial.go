@@ -0,0 +1,240 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	DEFAULT_KEY_LENGTH   = 16
+	HANDSHAKE_TIMEOUT    = 3 * time.Second
+	HANDSHAKE_RETRANSMIT = 250 * time.Millisecond
+	HANDSHAKE_UDT_DGRAM  = 2
+)
+
+// Dial connect to SRT listener as caller and receive stream from it.
+// streamid of config is sent to listener.
+func Dial(network, address string, config Config) (*Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, err := net.DialUDP(network, nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := handshakeCaller(udpConn, config)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+
+	conn.onClose = func() {
+		udpConn.Close()
+	}
+
+	go conn.run()
+	go readCaller(udpConn, conn)
+	return conn, nil
+}
+
+func handshakeCaller(udpConn *net.UDPConn, config Config) (*Conn, error) {
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return nil, err
+	}
+
+	socketId := binary.BigEndian.Uint32(buffer[0:4])&0x3FFFFFFF | 1
+	initialSequence := binary.BigEndian.Uint32(buffer[4:8]) & SEQUENCE_MAX
+
+	induction := &handshake{
+		version:         HANDSHAKE_VERSION_4,
+		extensionField:  HANDSHAKE_UDT_DGRAM,
+		initialSequence: initialSequence,
+		mtu:             DEFAULT_PAYLOAD_MTU,
+		flowWindow:      DEFAULT_FLOW_WINDOW,
+		handshakeType:   HANDSHAKE_INDUCTION,
+		socketId:        socketId,
+	}
+
+	response, err := exchangeHandshake(udpConn, induction)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.version < HANDSHAKE_VERSION_5 || response.extensionField != SRT_MAGIC_CODE {
+		return nil, errors.New("srt listener does not support handshake v5")
+	}
+
+	latencyMs := config.latencyMs()
+	conclusion := &handshake{
+		version:         HANDSHAKE_VERSION_5,
+		extensionField:  EXTENSION_FLAG_HSREQ,
+		initialSequence: initialSequence,
+		mtu:             DEFAULT_PAYLOAD_MTU,
+		flowWindow:      DEFAULT_FLOW_WINDOW,
+		handshakeType:   HANDSHAKE_CONCLUSION,
+		socketId:        socketId,
+		synCookie:       response.synCookie,
+		extensions: []extension{
+			makeHandshakeExtension(EXTENSION_HSREQ, SRT_FLAG_TSBPDSND|SRT_FLAG_TSBPDRCV|SRT_FLAG_CRYPT|SRT_FLAG_TLPKTDROP|SRT_FLAG_PERIODICNAK|SRT_FLAG_REXMITFLG, latencyMs),
+		},
+	}
+
+	var crypto *cryptoContext
+	if len(config.Passphrase) > 0 {
+		keyLength := config.KeyLength
+		if keyLength == 0 {
+			keyLength = DEFAULT_KEY_LENGTH
+		}
+
+		var keyMaterial []byte
+		crypto, keyMaterial, err = makeKeyMaterial(config.Passphrase, keyLength)
+		if err != nil {
+			return nil, err
+		}
+
+		conclusion.encryptionField = uint16(keyLength / 8)
+		conclusion.extensionField |= EXTENSION_FLAG_KMREQ
+		conclusion.extensions = append(conclusion.extensions, extension{extensionType: EXTENSION_KMREQ, content: keyMaterial})
+	}
+
+	if len(config.StreamId) > 0 {
+		conclusion.extensionField |= EXTENSION_FLAG_CONFIG
+		conclusion.extensions = append(conclusion.extensions, extension{extensionType: EXTENSION_SID, content: encodeStreamId(config.StreamId)})
+	}
+
+	response, err = exchangeHandshake(udpConn, conclusion)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.handshakeType != HANDSHAKE_CONCLUSION {
+		return nil, fmt.Errorf("srt handshake is rejected. reason %d", response.handshakeType)
+	}
+
+	if content := response.extension(EXTENSION_HSRSP); content != nil {
+		if _, peerLatencyMs, err := parseHandshakeExtension(content); err == nil && peerLatencyMs > latencyMs {
+			latencyMs = peerLatencyMs
+		}
+	}
+
+	if crypto != nil {
+		keyResponse := response.extension(EXTENSION_KMRSP)
+		if len(keyResponse) <= 4 {
+			return nil, ErrBadSecret
+		}
+	}
+
+	conn := newConn(socketId, response.socketId, response.initialSequence, time.Duration(latencyMs)*time.Millisecond, udpConn.LocalAddr(), udpConn.RemoteAddr(),
+		func(data []byte) error {
+			_, err := udpConn.Write(data)
+			return err
+		})
+	conn.crypto = crypto
+	conn.streamId = config.StreamId
+
+	logging.Component(logging.COMPONENT_SRT).Info("[Srt][Dial] connected ", udpConn.RemoteAddr(),
+		" streamid hash : ", logging.StreamKeyHash(config.StreamId), " latency : ", latencyMs, "ms")
+	return conn, nil
+}
+
+// exchangeHandshake send handshake until response is received
+func exchangeHandshake(udpConn *net.UDPConn, request *handshake) (*handshake, error) {
+	p := &packet{
+		isControl:     true,
+		controlType:   CONTROL_HANDSHAKE,
+		destinationId: 0,
+		payload:       request.marshal(),
+	}
+	data := p.marshal()
+
+	buffer := make([]byte, MAX_PACKET_SIZE)
+	deadline := time.Now().Add(HANDSHAKE_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if _, err := udpConn.Write(data); err != nil {
+			return nil, err
+		}
+
+		udpConn.SetReadDeadline(time.Now().Add(HANDSHAKE_RETRANSMIT))
+		for {
+			n, err := udpConn.Read(buffer)
+			if err != nil {
+				break
+			}
+
+			response, err := parsePacket(buffer[:n])
+			if err != nil || !response.isControl || response.controlType != CONTROL_HANDSHAKE || response.destinationId != request.socketId {
+				continue
+			}
+
+			hs, err := parseHandshake(append([]byte{}, response.payload...))
+			if err != nil {
+				continue
+			}
+
+			udpConn.SetReadDeadline(time.Time{})
+			return hs, nil
+		}
+	}
+
+	udpConn.SetReadDeadline(time.Time{})
+	return nil, errors.New("srt handshake timeout")
+}
+
+func readCaller(udpConn *net.UDPConn, conn *Conn) {
+	buffer := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, err := udpConn.Read(buffer)
+		if err != nil {
+			if !conn.isClosed() {
+				logging.Component(logging.COMPONENT_SRT).Warn("[Srt][readCaller] read error. ", err)
+				conn.shutdown(false)
+			}
+			return
+		}
+
+		// buffer is reused for next packet
+		p, err := parsePacket(append([]byte{}, buffer[:n]...))
+		if err != nil || p.destinationId != conn.socketId {
+			continue
+		}
+
+		if p.isControl && p.controlType == CONTROL_HANDSHAKE {
+			continue
+		}
+		conn.handlePacket(p)
+	}
+}
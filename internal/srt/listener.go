@@ -0,0 +1,373 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+)
+
+const (
+	ACCEPT_QUEUE_SIZE = 64
+	COOKIE_PERIOD     = time.Minute
+)
+
+var ErrListenerClosed = errors.New("srt listener closed")
+
+type handshakeResult struct {
+	conn     *Conn
+	response []byte
+}
+
+// Listener accept SRT callers on a UDP socket.
+// packets are dispatched to connection by destination socket id.
+type Listener struct {
+	conn   *net.UDPConn
+	config Config
+	secret []byte
+
+	mutex      sync.Mutex
+	conns      map[uint32]*Conn
+	handshakes map[string]*handshakeResult
+
+	accepted  chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func Listen(network, address string, config Config) (*Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP(network, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	listener := &Listener{
+		conn:       conn,
+		config:     config,
+		secret:     secret,
+		conns:      make(map[uint32]*Conn),
+		handshakes: make(map[string]*handshakeResult),
+		accepted:   make(chan *Conn, ACCEPT_QUEUE_SIZE),
+		closed:     make(chan struct{}),
+	}
+
+	go listener.readLoop()
+	return listener, nil
+}
+
+// Accept return next connection which finished handshake
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(
+		func() {
+			close(l.closed)
+
+			l.mutex.Lock()
+			conns := make([]*Conn, 0, len(l.conns))
+			for _, conn := range l.conns {
+				conns = append(conns, conn)
+			}
+			l.mutex.Unlock()
+
+			for _, conn := range conns {
+				conn.Close()
+			}
+			l.conn.Close()
+		})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) readLoop() {
+	buffer := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, peer, err := l.conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+
+			logging.Component(logging.COMPONENT_SRT).Warn("[SrtListener][readLoop] read error. ", err)
+			continue
+		}
+
+		// buffer is reused for next packet
+		p, err := parsePacket(append([]byte{}, buffer[:n]...))
+		if err != nil {
+			continue
+		}
+
+		if p.isControl && p.controlType == CONTROL_HANDSHAKE {
+			l.handleHandshake(p, peer)
+			continue
+		}
+
+		l.dispatch(p, peer)
+	}
+}
+
+// dispatch pass packet to connection of destination socket id.
+// socket id is guessable so that packet which is not from peer of connection is dropped
+func (l *Listener) dispatch(p *packet, peer *net.UDPAddr) {
+	l.mutex.Lock()
+	conn, exist := l.conns[p.destinationId]
+	l.mutex.Unlock()
+
+	if !exist {
+		return
+	}
+
+	remoteAddr, ok := conn.remoteAddr.(*net.UDPAddr)
+	if !ok || !remoteAddr.IP.Equal(peer.IP) || remoteAddr.Port != peer.Port {
+		return
+	}
+	conn.handlePacket(p)
+}
+
+func (l *Listener) handleHandshake(p *packet, peer *net.UDPAddr) {
+	hs, err := parseHandshake(p.payload)
+	if err != nil {
+		return
+	}
+
+	switch hs.handshakeType {
+	case HANDSHAKE_INDUCTION:
+		l.handleInduction(hs, peer)
+	case HANDSHAKE_CONCLUSION:
+		l.handleConclusion(hs, peer)
+	}
+}
+
+func (l *Listener) handleInduction(request *handshake, peer *net.UDPAddr) {
+	response := &handshake{
+		version:         HANDSHAKE_VERSION_5,
+		encryptionField: 0,
+		extensionField:  SRT_MAGIC_CODE,
+		initialSequence: request.initialSequence,
+		mtu:             request.mtu,
+		flowWindow:      request.flowWindow,
+		handshakeType:   HANDSHAKE_INDUCTION,
+		socketId:        0,
+		synCookie:       l.cookie(peer, time.Now()),
+		peerIp:          request.peerIp,
+	}
+
+	l.sendHandshake(response, request.socketId, peer)
+}
+
+func (l *Listener) handleConclusion(request *handshake, peer *net.UDPAddr) {
+	now := time.Now()
+	if request.synCookie != l.cookie(peer, now) && request.synCookie != l.cookie(peer, now.Add(-COOKIE_PERIOD)) {
+		logging.Component(logging.COMPONENT_SRT).Warn("[SrtListener][handleConclusion] invalid cookie from ", peer)
+		return
+	}
+
+	key := peer.String() + "/" + strconv.FormatUint(uint64(request.socketId), 10)
+
+	l.mutex.Lock()
+	result, exist := l.handshakes[key]
+	l.mutex.Unlock()
+
+	// response is lost. caller retransmit conclusion
+	if exist {
+		l.write(result.response, peer)
+		return
+	}
+
+	if request.version < HANDSHAKE_VERSION_5 {
+		l.reject(request, peer, REJECT_VERSION)
+		return
+	}
+
+	latencyMs := l.config.latencyMs()
+	if content := request.extension(EXTENSION_HSREQ); content != nil {
+		_, peerLatencyMs, err := parseHandshakeExtension(content)
+		if err == nil && peerLatencyMs > latencyMs {
+			latencyMs = peerLatencyMs
+		}
+	}
+
+	var crypto *cryptoContext
+	keyMaterial := request.extension(EXTENSION_KMREQ)
+	if keyMaterial != nil {
+		var err error
+		if crypto, err = newCryptoContext(l.config.Passphrase, keyMaterial); err != nil {
+			logging.Component(logging.COMPONENT_SRT).Warn("[SrtListener][handleConclusion] key material error from ", peer, ". ", err)
+			l.reject(request, peer, REJECT_BADSECRET)
+			return
+		}
+	} else if len(l.config.Passphrase) > 0 {
+		l.reject(request, peer, REJECT_UNSECURE)
+		return
+	}
+
+	socketId := l.newSocketId()
+	conn := newConn(socketId, request.socketId, request.initialSequence, time.Duration(latencyMs)*time.Millisecond, l.conn.LocalAddr(), peer,
+		func(data []byte) error {
+			return l.write(data, peer)
+		})
+	conn.crypto = crypto
+	conn.streamId = decodeStreamId(request.extension(EXTENSION_SID))
+
+	response := &handshake{
+		version:         HANDSHAKE_VERSION_5,
+		encryptionField: request.encryptionField,
+		extensionField:  EXTENSION_FLAG_HSREQ,
+		initialSequence: request.initialSequence,
+		mtu:             request.mtu,
+		flowWindow:      request.flowWindow,
+		handshakeType:   HANDSHAKE_CONCLUSION,
+		socketId:        socketId,
+		synCookie:       request.synCookie,
+		peerIp:          request.peerIp,
+		extensions: []extension{
+			makeHandshakeExtension(EXTENSION_HSRSP, SRT_FLAG_TSBPDSND|SRT_FLAG_TSBPDRCV|SRT_FLAG_CRYPT|SRT_FLAG_TLPKTDROP|SRT_FLAG_PERIODICNAK|SRT_FLAG_REXMITFLG, latencyMs),
+		},
+	}
+
+	if keyMaterial != nil {
+		response.extensionField |= EXTENSION_FLAG_KMREQ
+		response.extensions = append(response.extensions, extension{extensionType: EXTENSION_KMRSP, content: keyMaterial})
+	}
+
+	data := l.makeHandshakePacket(response, request.socketId)
+	conn.onClose = func() {
+		l.mutex.Lock()
+		delete(l.conns, socketId)
+		delete(l.handshakes, key)
+		l.mutex.Unlock()
+	}
+
+	l.mutex.Lock()
+	l.conns[socketId] = conn
+	l.handshakes[key] = &handshakeResult{conn: conn, response: data}
+	l.mutex.Unlock()
+
+	select {
+	case l.accepted <- conn:
+	default:
+		logging.Component(logging.COMPONENT_SRT).Warn("[SrtListener][handleConclusion] accept queue is full. reject ", peer)
+		conn.onClose()
+		l.reject(request, peer, REJECT_RESOURCE)
+		return
+	}
+
+	// streamid has stream key
+	conn.log().Info("[SrtListener][handleConclusion] connected ", peer, " streamid hash : ", logging.StreamKeyHash(conn.streamId), " latency : ", latencyMs, "ms")
+	l.write(data, peer)
+	go conn.run()
+}
+
+func (l *Listener) reject(request *handshake, peer *net.UDPAddr, reason HandshakeType) {
+	response := &handshake{
+		version:         HANDSHAKE_VERSION_5,
+		extensionField:  0,
+		initialSequence: request.initialSequence,
+		mtu:             request.mtu,
+		flowWindow:      request.flowWindow,
+		handshakeType:   reason,
+		socketId:        0,
+		synCookie:       request.synCookie,
+		peerIp:          request.peerIp,
+	}
+
+	l.sendHandshake(response, request.socketId, peer)
+}
+
+func (l *Listener) sendHandshake(hs *handshake, destinationId uint32, peer *net.UDPAddr) {
+	l.write(l.makeHandshakePacket(hs, destinationId), peer)
+}
+
+func (l *Listener) makeHandshakePacket(hs *handshake, destinationId uint32) []byte {
+	p := &packet{
+		isControl:     true,
+		controlType:   CONTROL_HANDSHAKE,
+		destinationId: destinationId,
+		payload:       hs.marshal(),
+	}
+	return p.marshal()
+}
+
+func (l *Listener) write(data []byte, peer *net.UDPAddr) error {
+	_, err := l.conn.WriteToUDP(data, peer)
+	return err
+}
+
+// cookie is derived from peer address and time so that listener is stateless until conclusion
+func (l *Listener) cookie(peer *net.UDPAddr, now time.Time) uint32 {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(peer.String()))
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(now.Unix()/int64(COOKIE_PERIOD/time.Second))))
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+func (l *Listener) newSocketId() uint32 {
+	buffer := make([]byte, 4)
+	for {
+		rand.Read(buffer)
+		socketId := binary.BigEndian.Uint32(buffer) & 0x3FFFFFFF
+
+		l.mutex.Lock()
+		_, exist := l.conns[socketId]
+		l.mutex.Unlock()
+
+		if socketId != 0 && !exist {
+			return socketId
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerDropPacketFromOtherPeer(t *testing.T) {
+	conn := newTestConn(0)
+	listener := &Listener{
+		conns: map[uint32]*Conn{conn.socketId: conn},
+	}
+
+	spoofed := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}
+	listener.dispatch(&packet{destinationId: conn.socketId, sequence: 0, payload: []byte{1}}, spoofed)
+	if conn.expected != 0 || len(conn.deliver) != 0 {
+		t.Fatal("packet from other peer is dispatched")
+	}
+
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	listener.dispatch(&packet{destinationId: conn.socketId, sequence: 0, payload: []byte{1}}, peer)
+	if conn.expected != 1 || len(conn.deliver) != 1 {
+		t.Fatal("packet from peer is not dispatched")
+	}
+}
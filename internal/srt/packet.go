@@ -0,0 +1,320 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	HEADER_SIZE         = 16
+	HANDSHAKE_CIF_SIZE  = 48
+	MAX_PACKET_SIZE     = 1500
+	DEFAULT_PAYLOAD_MTU = 1500
+	DEFAULT_FLOW_WINDOW = 8192
+
+	SEQUENCE_MAX = 0x7FFFFFFF
+)
+
+type ControlType uint16
+
+const (
+	CONTROL_HANDSHAKE ControlType = 0x0000
+	CONTROL_KEEPALIVE ControlType = 0x0001
+	CONTROL_ACK       ControlType = 0x0002
+	CONTROL_NAK       ControlType = 0x0003
+	CONTROL_SHUTDOWN  ControlType = 0x0005
+	CONTROL_ACKACK    ControlType = 0x0006
+	CONTROL_DROPREQ   ControlType = 0x0007
+)
+
+type HandshakeType uint32
+
+const (
+	HANDSHAKE_DONE       HandshakeType = 0xFFFFFFFD
+	HANDSHAKE_AGREEMENT  HandshakeType = 0xFFFFFFFE
+	HANDSHAKE_CONCLUSION HandshakeType = 0xFFFFFFFF
+	HANDSHAKE_WAVEAHAND  HandshakeType = 0x00000000
+	HANDSHAKE_INDUCTION  HandshakeType = 0x00000001
+)
+
+// rejection reason of handshake, 1000 is base of SRT_REJ_*
+const (
+	REJECT_UNKNOWN    HandshakeType = 1000
+	REJECT_PEER       HandshakeType = 1002
+	REJECT_ROGUE      HandshakeType = 1004
+	REJECT_BADSECRET  HandshakeType = 1010
+	REJECT_UNSECURE   HandshakeType = 1011
+	REJECT_VERSION    HandshakeType = 1012
+	REJECT_RESOURCE   HandshakeType = 1003
+	REJECT_PREDEFINED HandshakeType = 2000
+)
+
+const (
+	HANDSHAKE_VERSION_4 = 4
+	HANDSHAKE_VERSION_5 = 5
+
+	SRT_MAGIC_CODE = 0x4A17
+	SRT_VERSION    = 0x010502
+
+	EXTENSION_FLAG_HSREQ  = 0x1
+	EXTENSION_FLAG_KMREQ  = 0x2
+	EXTENSION_FLAG_CONFIG = 0x4
+)
+
+type ExtensionType uint16
+
+const (
+	EXTENSION_HSREQ ExtensionType = 1
+	EXTENSION_HSRSP ExtensionType = 2
+	EXTENSION_KMREQ ExtensionType = 3
+	EXTENSION_KMRSP ExtensionType = 4
+	EXTENSION_SID   ExtensionType = 5
+)
+
+const (
+	SRT_FLAG_TSBPDSND    = 0x00000001
+	SRT_FLAG_TSBPDRCV    = 0x00000002
+	SRT_FLAG_CRYPT       = 0x00000004
+	SRT_FLAG_TLPKTDROP   = 0x00000008
+	SRT_FLAG_PERIODICNAK = 0x00000010
+	SRT_FLAG_REXMITFLG   = 0x00000020
+)
+
+var errInvalidPacket = errors.New("invalid srt packet")
+
+type packet struct {
+	isControl bool
+
+	// data packet
+	sequence      uint32
+	messageNumber uint32
+	keyFlag       uint8
+
+	// control packet
+	controlType ControlType
+	subtype     uint16
+	typeInfo    uint32
+
+	timestamp     uint32
+	destinationId uint32
+	payload       []byte
+}
+
+func parsePacket(data []byte) (*packet, error) {
+	if len(data) < HEADER_SIZE {
+		return nil, errInvalidPacket
+	}
+
+	p := &packet{
+		timestamp:     binary.BigEndian.Uint32(data[8:12]),
+		destinationId: binary.BigEndian.Uint32(data[12:16]),
+		payload:       data[HEADER_SIZE:],
+	}
+
+	word0 := binary.BigEndian.Uint32(data[0:4])
+	word1 := binary.BigEndian.Uint32(data[4:8])
+	if word0&0x80000000 != 0 {
+		p.isControl = true
+		p.controlType = ControlType((word0 >> 16) & 0x7FFF)
+		p.subtype = uint16(word0)
+		p.typeInfo = word1
+	} else {
+		p.sequence = word0 & SEQUENCE_MAX
+		p.keyFlag = uint8((word1 >> 27) & 0x3)
+		p.messageNumber = word1 & 0x03FFFFFF
+	}
+	return p, nil
+}
+
+func (p *packet) marshal() []byte {
+	data := make([]byte, HEADER_SIZE+len(p.payload))
+	if p.isControl {
+		binary.BigEndian.PutUint32(data[0:4], 0x80000000|uint32(p.controlType)<<16|uint32(p.subtype))
+		binary.BigEndian.PutUint32(data[4:8], p.typeInfo)
+	} else {
+		binary.BigEndian.PutUint32(data[0:4], p.sequence&SEQUENCE_MAX)
+		binary.BigEndian.PutUint32(data[4:8], uint32(p.keyFlag&0x3)<<27|p.messageNumber&0x03FFFFFF)
+	}
+
+	binary.BigEndian.PutUint32(data[8:12], p.timestamp)
+	binary.BigEndian.PutUint32(data[12:16], p.destinationId)
+	copy(data[HEADER_SIZE:], p.payload)
+	return data
+}
+
+type extension struct {
+	extensionType ExtensionType
+	content       []byte
+}
+
+type handshake struct {
+	version         uint32
+	encryptionField uint16
+	extensionField  uint16
+	initialSequence uint32
+	mtu             uint32
+	flowWindow      uint32
+	handshakeType   HandshakeType
+	socketId        uint32
+	synCookie       uint32
+	peerIp          [16]byte
+	extensions      []extension
+}
+
+func parseHandshake(data []byte) (*handshake, error) {
+	if len(data) < HANDSHAKE_CIF_SIZE {
+		return nil, errInvalidPacket
+	}
+
+	hs := &handshake{
+		version:         binary.BigEndian.Uint32(data[0:4]),
+		encryptionField: binary.BigEndian.Uint16(data[4:6]),
+		extensionField:  binary.BigEndian.Uint16(data[6:8]),
+		initialSequence: binary.BigEndian.Uint32(data[8:12]) & SEQUENCE_MAX,
+		mtu:             binary.BigEndian.Uint32(data[12:16]),
+		flowWindow:      binary.BigEndian.Uint32(data[16:20]),
+		handshakeType:   HandshakeType(binary.BigEndian.Uint32(data[20:24])),
+		socketId:        binary.BigEndian.Uint32(data[24:28]),
+		synCookie:       binary.BigEndian.Uint32(data[28:32]),
+	}
+	copy(hs.peerIp[:], data[32:48])
+
+	data = data[HANDSHAKE_CIF_SIZE:]
+	for len(data) >= 4 {
+		extensionType := ExtensionType(binary.BigEndian.Uint16(data[0:2]))
+		length := int(binary.BigEndian.Uint16(data[2:4])) * 4
+		if len(data) < 4+length {
+			return nil, errInvalidPacket
+		}
+
+		hs.extensions = append(hs.extensions, extension{extensionType: extensionType, content: data[4 : 4+length]})
+		data = data[4+length:]
+	}
+	return hs, nil
+}
+
+func (hs *handshake) marshal() []byte {
+	data := make([]byte, HANDSHAKE_CIF_SIZE)
+	binary.BigEndian.PutUint32(data[0:4], hs.version)
+	binary.BigEndian.PutUint16(data[4:6], hs.encryptionField)
+	binary.BigEndian.PutUint16(data[6:8], hs.extensionField)
+	binary.BigEndian.PutUint32(data[8:12], hs.initialSequence)
+	binary.BigEndian.PutUint32(data[12:16], hs.mtu)
+	binary.BigEndian.PutUint32(data[16:20], hs.flowWindow)
+	binary.BigEndian.PutUint32(data[20:24], uint32(hs.handshakeType))
+	binary.BigEndian.PutUint32(data[24:28], hs.socketId)
+	binary.BigEndian.PutUint32(data[28:32], hs.synCookie)
+	copy(data[32:48], hs.peerIp[:])
+
+	for _, ext := range hs.extensions {
+		content := ext.content
+		if len(content)%4 != 0 {
+			content = append(content, make([]byte, 4-len(content)%4)...)
+		}
+
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint16(header[0:2], uint16(ext.extensionType))
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(content)/4))
+		data = append(data, header...)
+		data = append(data, content...)
+	}
+	return data
+}
+
+func (hs *handshake) extension(extensionType ExtensionType) []byte {
+	for _, ext := range hs.extensions {
+		if ext.extensionType == extensionType {
+			return ext.content
+		}
+	}
+	return nil
+}
+
+// makeHandshakeExtension build HSREQ or HSRSP
+func makeHandshakeExtension(extensionType ExtensionType, flags uint32, latencyMs uint16) extension {
+	content := make([]byte, 12)
+	binary.BigEndian.PutUint32(content[0:4], SRT_VERSION)
+	binary.BigEndian.PutUint32(content[4:8], flags)
+	binary.BigEndian.PutUint16(content[8:10], latencyMs)
+	binary.BigEndian.PutUint16(content[10:12], latencyMs)
+	return extension{extensionType: extensionType, content: content}
+}
+
+// parseHandshakeExtension return flags and latency(max of sender and receiver delay) of HSREQ or HSRSP
+func parseHandshakeExtension(content []byte) (uint32, uint16, error) {
+	if len(content) < 12 {
+		return 0, 0, errInvalidPacket
+	}
+
+	flags := binary.BigEndian.Uint32(content[4:8])
+	senderDelay := binary.BigEndian.Uint16(content[8:10])
+	receiverDelay := binary.BigEndian.Uint16(content[10:12])
+	if senderDelay > receiverDelay {
+		return flags, senderDelay, nil
+	}
+	return flags, receiverDelay, nil
+}
+
+// stream id is carried as string whose every 4 bytes are in little endian
+func encodeStreamId(streamId string) []byte {
+	data := []byte(streamId)
+	if len(data)%4 != 0 {
+		data = append(data, make([]byte, 4-len(data)%4)...)
+	}
+
+	for i := 0; i < len(data); i += 4 {
+		data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+	}
+	return data
+}
+
+func decodeStreamId(content []byte) string {
+	data := make([]byte, len(content))
+	copy(data, content)
+	for i := 0; i+3 < len(data); i += 4 {
+		data[i], data[i+1], data[i+2], data[i+3] = data[i+3], data[i+2], data[i+1], data[i]
+	}
+
+	end := len(data)
+	for end > 0 && data[end-1] == 0 {
+		end--
+	}
+	return string(data[:end])
+}
+
+// sequence number compare in 31 bit circular space
+func sequenceLess(a, b uint32) bool {
+	return a != b && ((b-a)&SEQUENCE_MAX) < (SEQUENCE_MAX/2)
+}
+
+func sequenceNext(sequence uint32) uint32 {
+	return (sequence + 1) & SEQUENCE_MAX
+}
+
+func sequenceDistance(from, to uint32) uint32 {
+	return (to - from) & SEQUENCE_MAX
+}
@@ -0,0 +1,74 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package srt
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	DEFAULT_APP_NAME = "srt"
+
+	streamIdPrefix   = "#!::"
+	streamIdResource = "r"
+	streamIdMode     = "m"
+	streamIdPublish  = "publish"
+)
+
+// ParseStreamId return app name and stream key from streamid.
+// access control syntax like "#!::r=live/streamKey,m=publish" and plain "live/streamKey" are supported.
+func ParseStreamId(streamId string) (string, string, error) {
+	resource := streamId
+	if strings.HasPrefix(streamId, streamIdPrefix) {
+		resource = ""
+		for _, pair := range strings.Split(streamId[len(streamIdPrefix):], ",") {
+			key, value, found := strings.Cut(pair, "=")
+			if !found {
+				continue
+			}
+
+			switch key {
+			case streamIdResource:
+				resource = value
+			case streamIdMode:
+				if value != streamIdPublish {
+					return "", "", errors.New("not support srt mode " + value)
+				}
+			}
+		}
+	}
+
+	resource = strings.Trim(resource, "/")
+	if len(resource) == 0 {
+		return "", "", errors.New("empty stream key on streamid")
+	}
+
+	index := strings.LastIndex(resource, "/")
+	if index < 0 {
+		return DEFAULT_APP_NAME, resource, nil
+	}
+	return resource[:index], resource[index+1:], nil
+}
@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+const (
+	TRACER_NAME          = "github.com/ISSuh/mystream-media_preprocessor"
+	DEFAULT_SERVICE_NAME = "mystream-media_preprocessor"
+	DEFAULT_ENDPOINT     = "localhost:4318"
+	DEFAULT_SAMPLE_RATIO = 1.0
+
+	// root span from accepting connection to first segment of publisher or start of player
+	SPAN_SESSION_START     = "session.start"
+	SPAN_HANDSHAKE         = "session.handshake"
+	SPAN_VALIDATE          = "broadcast.validate"
+	SPAN_BROADCAST_REQUEST = "broadcast.request"
+	SPAN_SEGMENT_DIRECTORY = "segment.directory"
+	SPAN_FFMPEG_START      = "ffmpeg.start"
+	SPAN_FIRST_SEGMENT     = "segment.first"
+
+	// root span of teardown which is linked to span of session start
+	SPAN_SESSION_END = "session.end"
+
+	ATTRIBUTE_STREAM_ID   = "stream.id"
+	ATTRIBUTE_REMOTE_ADDR = "net.peer.addr"
+	ATTRIBUTE_RENDITION   = "segment.rendition"
+)
+
+// Setup export spans to OTLP/HTTP collector and propagate W3C trace context.
+// spans are not recorded when tracing is disabled. returned function flush spans and stop exporter
+func Setup(tracingConfigure configure.TracingConfigure, nodeId string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracingConfigure.Enable {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint := tracingConfigure.Endpoint
+	if len(endpoint) <= 0 {
+		endpoint = DEFAULT_ENDPOINT
+	}
+
+	serviceName := tracingConfigure.ServiceName
+	if len(serviceName) <= 0 {
+		serviceName = DEFAULT_SERVICE_NAME
+	}
+
+	sampleRatio := tracingConfigure.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = DEFAULT_SAMPLE_RATIO
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if tracingConfigure.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	traceResource := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceInstanceID(nodeId),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(traceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// Start start span of phase under span of ctx
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End record error of phase and end span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject write trace context of ctx to header of outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -26,6 +26,7 @@ package transport
 
 import (
 	"net"
+	"time"
 )
 
 type SocketTransporter struct {
@@ -54,6 +55,17 @@ func (t *SocketTransporter) Write(data []byte) error {
 	return err
 }
 
+// SetReadDeadline set deadline of Read. zero value means no deadline.
+func (t *SocketTransporter) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+// RemoteAddr return address of peer.
+// it is address from PROXY header when listener is behind load balancer.
+func (t *SocketTransporter) RemoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
 func (t *SocketTransporter) Close() {
 	t.conn.Close()
 }
@@ -24,8 +24,14 @@ SOFTWARE.
 
 package transport
 
+import (
+	"time"
+)
+
 type Transporter interface {
 	Read() ([]byte, error)
 	Write(data []byte) error
+	SetReadDeadline(deadline time.Time) error
+	RemoteAddr() string
 	Close()
 }
@@ -0,0 +1,309 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package whip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+)
+
+const (
+	PACKET_VIDEO       byte = 1
+	PACKET_AUDIO       byte = 2
+	PACKET_HEADER_SIZE      = 9
+
+	VIDEO_CLOCK_RATE  = 90000
+	OPUS_SAMPLE_RATE  = 48000
+	OPUS_CHANNELS     = 2
+	AAC_FRAME_SAMPLES = 1024
+
+	PACKET_QUEUE_SIZE = 1024
+	MAX_LATE_PACKETS  = 512
+)
+
+var (
+	ErrReceiveOnly   = errors.New("whip connection is receive only")
+	errInvalidPacket = errors.New("invalid whip packet")
+)
+
+// Conn is transporter of WHIP publisher.
+// Read return a H.264 access unit or an AAC frame with packet header of kind and pts.
+type Conn struct {
+	id         string
+	streamKey  string
+	remoteAddr string
+	startTime  time.Time
+
+	peerConnection *webrtc.PeerConnection
+	transcoder     *ffmpeg.AudioTranscoder
+	audioBase      uint64
+	audioFrames    uint64
+
+	queue        chan []byte
+	readDeadline time.Time
+	deadlineLock sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newConn(id, streamKey, remoteAddr string) *Conn {
+	return &Conn{
+		id:         id,
+		streamKey:  streamKey,
+		remoteAddr: remoteAddr,
+		startTime:  time.Now(),
+		queue:      make(chan []byte, PACKET_QUEUE_SIZE),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *Conn) StreamKey() string {
+	return c.streamKey
+}
+
+// open start opus transcoder and handle tracks of peer connection
+func (c *Conn) open(peerConnection *webrtc.PeerConnection) error {
+	c.peerConnection = peerConnection
+	c.transcoder = ffmpeg.NewAudioTranscoder(c.onAudioFrame)
+	if err := c.transcoder.Open(); err != nil {
+		return err
+	}
+
+	if err := c.transcoder.Run(); err != nil {
+		return err
+	}
+
+	peerConnection.OnTrack(
+		func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			mimeType := track.Codec().MimeType
+			c.log().Info("[WhipConn][OnTrack] ", mimeType)
+
+			switch {
+			case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+				go c.readVideo(track)
+			case strings.EqualFold(mimeType, webrtc.MimeTypeOpus):
+				go c.readAudio(track)
+			default:
+				c.log().Warn("[WhipConn][OnTrack] not support codec ", mimeType)
+			}
+		})
+
+	peerConnection.OnConnectionStateChange(
+		func(state webrtc.PeerConnectionState) {
+			c.log().Info("[WhipConn][OnConnectionStateChange] ", state)
+			switch state {
+			case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+				// callback can be called while peer connection is closed by Close
+				go c.Close()
+			}
+		})
+	return nil
+}
+
+func (c *Conn) readVideo(track *webrtc.TrackRemote) {
+	builder := samplebuilder.New(MAX_LATE_PACKETS, &codecs.H264Packet{}, VIDEO_CLOCK_RATE)
+	clock := newRtpClock(VIDEO_CLOCK_RATE, c.startTime)
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			if err != io.EOF {
+				c.log().Warn("[WhipConn][readVideo] read fail. ", err)
+			}
+			return
+		}
+
+		builder.Push(packet)
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			c.push(PACKET_VIDEO, clock.milliseconds(sample.PacketTimestamp), sample.Data)
+		}
+	}
+}
+
+func (c *Conn) readAudio(track *webrtc.TrackRemote) {
+	writer, err := oggwriter.NewWith(c.transcoder, OPUS_SAMPLE_RATE, OPUS_CHANNELS)
+	if err != nil {
+		c.log().Error("[WhipConn][readAudio] can not create ogg writer. ", err)
+		return
+	}
+
+	started := false
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			if err != io.EOF {
+				c.log().Warn("[WhipConn][readAudio] read fail. ", err)
+			}
+			return
+		}
+
+		// AAC frame timestamp is counted from arrival of first opus packet
+		if !started {
+			started = true
+			c.audioBase = uint64(time.Since(c.startTime) / time.Millisecond)
+		}
+
+		if err := writer.WriteRTP(packet); err != nil {
+			c.log().Warn("[WhipConn][readAudio] transcoder write fail. ", err)
+			return
+		}
+	}
+}
+
+func (c *Conn) onAudioFrame(frame []byte) {
+	pts := c.audioBase + c.audioFrames*AAC_FRAME_SAMPLES*1000/OPUS_SAMPLE_RATE
+	c.audioFrames++
+	c.push(PACKET_AUDIO, pts, frame)
+}
+
+func (c *Conn) push(kind byte, pts uint64, data []byte) {
+	packet := make([]byte, PACKET_HEADER_SIZE+len(data))
+	packet[0] = kind
+	binary.BigEndian.PutUint64(packet[1:PACKET_HEADER_SIZE], pts)
+	copy(packet[PACKET_HEADER_SIZE:], data)
+
+	select {
+	case c.queue <- packet:
+	case <-c.closed:
+	default:
+		c.log().Warn("[WhipConn][push] queue is full. drop frame")
+	}
+}
+
+func (c *Conn) Read() ([]byte, error) {
+	select {
+	case packet := <-c.queue:
+		return packet, nil
+	default:
+	}
+
+	c.deadlineLock.Lock()
+	deadline := c.readDeadline
+	c.deadlineLock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.queue:
+		return packet, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Write(_ []byte) error {
+	return ErrReceiveOnly
+}
+
+// SetReadDeadline set deadline of Read. zero value means no deadline.
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.readDeadline = deadline
+	return nil
+}
+
+// log return logger with id of connection
+func (c *Conn) log() *logrus.Entry {
+	return logging.Component(logging.COMPONENT_WHIP).WithField(logging.FIELD_CONNECTION_ID, c.id)
+}
+
+func (c *Conn) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+func (c *Conn) Close() {
+	c.closeOnce.Do(
+		func() {
+			close(c.closed)
+			if c.peerConnection != nil {
+				c.peerConnection.Close()
+			}
+
+			if c.transcoder != nil {
+				c.transcoder.Stop()
+			}
+
+			if c.onClose != nil {
+				c.onClose()
+			}
+		})
+}
+
+// rtpClock convert RTP timestamp to millisecond from start of connection
+type rtpClock struct {
+	clockRate uint32
+	startTime time.Time
+	started   bool
+	base      uint64
+	last      uint32
+	elapsed   int64
+}
+
+func newRtpClock(clockRate uint32, startTime time.Time) *rtpClock {
+	return &rtpClock{
+		clockRate: clockRate,
+		startTime: startTime,
+	}
+}
+
+func (r *rtpClock) milliseconds(timestamp uint32) uint64 {
+	if !r.started {
+		r.started = true
+		r.base = uint64(time.Since(r.startTime) / time.Millisecond)
+		r.last = timestamp
+	}
+
+	// difference of 32 bit timestamp is valid across wrap around
+	r.elapsed += int64(int32(timestamp - r.last))
+	r.last = timestamp
+	if r.elapsed < 0 {
+		return r.base
+	}
+	return r.base + uint64(r.elapsed)*1000/uint64(r.clockRate)
+}
@@ -0,0 +1,75 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package whip
+
+import (
+	"encoding/binary"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media"
+)
+
+// Context convert packet from WHIP connection to media frame.
+// stream key is validated on HTTP request, so first packet is treated as publish.
+type Context struct {
+	handler   ServerHandler
+	published bool
+}
+
+func NewContext() *Context {
+	return &Context{
+		handler:   nil,
+		published: false,
+	}
+}
+
+func (c *Context) RegistHandler(handler ServerHandler) {
+	c.handler = handler
+	c.handler.OnHandshakeDone()
+}
+
+func (c *Context) InputStream(data []byte) error {
+	if len(data) < PACKET_HEADER_SIZE {
+		return errInvalidPacket
+	}
+
+	if !c.published {
+		c.published = true
+		c.handler.OnPublish()
+	}
+
+	pts := binary.BigEndian.Uint64(data[1:PACKET_HEADER_SIZE])
+	timestamp := media.Timestamp{Pts: pts, Dts: pts}
+	payload := data[PACKET_HEADER_SIZE:]
+
+	switch data[0] {
+	case PACKET_VIDEO:
+		c.handler.OnVideoFrame(media.NewVideoFrame(media.CODEC_VIDEO_H264, timestamp, payload, false))
+	case PACKET_AUDIO:
+		c.handler.OnAudioFrame(media.NewAudioFrame(media.CODEC_AUDIO_AAC, timestamp, payload))
+	default:
+		return errInvalidPacket
+	}
+	return nil
+}
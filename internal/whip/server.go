@@ -0,0 +1,334 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package whip
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/intervalpli"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/rtmp"
+)
+
+const (
+	APP_NAME     = "whip"
+	DEFAULT_PATH = "/whip"
+	RESOURCE     = "/resource/"
+
+	CONTENT_TYPE_SDP = "application/sdp"
+	MAX_OFFER_SIZE   = 64 * 1024
+
+	GATHERING_TIMEOUT = 5 * time.Second
+	PLI_INTERVAL      = 2 * time.Second
+)
+
+// ErrServiceUnavailable of publish handler is responded as 503
+var ErrServiceUnavailable = rtmp.ErrServiceUnavailable
+
+var errInvalidOffer = errors.New("invalid sdp offer")
+
+// PublishHandler validate stream key of connection and start session.
+// connection is negotiated after handler return nil.
+type PublishHandler func(conn *Conn) error
+
+// h264 profiles which browsers offer. packetization mode 1 is needed for FU-A
+var h264Profiles = []string{"42e01f", "42001f", "4d001f", "640032"}
+
+type Server struct {
+	configure configure.WhipConfigure
+	path      string
+	api       *webrtc.API
+	onPublish PublishHandler
+
+	mutex sync.Mutex
+	conns map[string]*Conn
+}
+
+func NewServer(whipConfigure configure.WhipConfigure, onPublish PublishHandler) (*Server, error) {
+	api, err := newApi(whipConfigure)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.TrimSuffix(whipConfigure.Path, "/")
+	if len(path) == 0 {
+		path = DEFAULT_PATH
+	}
+
+	return &Server{
+		configure: whipConfigure,
+		path:      path,
+		api:       api,
+		onPublish: onPublish,
+		conns:     make(map[string]*Conn),
+	}, nil
+}
+
+// newApi create webrtc api which only accept H.264 and opus
+func newApi(whipConfigure configure.WhipConfigure) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	feedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+	for i, profile := range h264Profiles {
+		err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    VIDEO_CLOCK_RATE,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profile,
+				RTCPFeedback: feedback,
+			},
+			PayloadType: webrtc.PayloadType(102 + i*2),
+		}, webrtc.RTPCodecTypeVideo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   OPUS_SAMPLE_RATE,
+			Channels:    OPUS_CHANNELS,
+			SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+
+	// keyframe is requested periodically so that segmenter does not wait long GOP of browser
+	pli, err := intervalpli.NewReceiverInterceptor(intervalpli.GeneratorInterval(PLI_INTERVAL))
+	if err != nil {
+		return nil, err
+	}
+	registry.Add(pli)
+
+	settingEngine := webrtc.SettingEngine{}
+	if whipConfigure.UdpPortMin > 0 && whipConfigure.UdpPortMax > 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(whipConfigure.UdpPortMin, whipConfigure.UdpPortMax); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(whipConfigure.PublicIps) > 0 {
+		settingEngine.SetNAT1To1IPs(whipConfigure.PublicIps, webrtc.ICECandidateTypeHost)
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(settingEngine)), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, s.path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.writeCorsHeader(w)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Accept-Post", CONTENT_TYPE_SDP)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.publish(w, r)
+	case http.MethodDelete:
+		s.terminate(w, r)
+	default:
+		// trickle ICE and ICE restart are not supported. candidates are gathered before answer
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) writeCorsHeader(w http.ResponseWriter) {
+	allowOrigin := s.configure.AllowOrigin
+	if len(allowOrigin) == 0 {
+		allowOrigin = "*"
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+}
+
+func (s *Server) publish(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), CONTENT_TYPE_SDP) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	streamKey := s.streamKey(r)
+	if len(streamKey) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, MAX_OFFER_SIZE))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn := newConn(newResourceId(), streamKey, r.RemoteAddr)
+	if err := s.onPublish(conn); err != nil {
+		logging.Component(logging.COMPONENT_WHIP).Warn("[WhipServer][publish] reject publish from ", conn.remoteAddr, ". ", err)
+		conn.Close()
+		if errors.Is(err, ErrServiceUnavailable) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return
+	}
+
+	answer, err := s.negotiate(conn, string(offer))
+	if err != nil {
+		logging.Component(logging.COMPONENT_WHIP).Error("[WhipServer][publish] negotiation fail. ", err)
+		conn.Close()
+		if errors.Is(err, errInvalidOffer) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.mutex.Lock()
+	s.conns[conn.id] = conn
+	s.mutex.Unlock()
+
+	conn.onClose = func() {
+		s.mutex.Lock()
+		delete(s.conns, conn.id)
+		s.mutex.Unlock()
+	}
+
+	w.Header().Set("Content-Type", CONTENT_TYPE_SDP)
+	w.Header().Set("Location", s.path+RESOURCE+conn.id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+func (s *Server) negotiate(conn *Conn, offer string) (string, error) {
+	iceServers := []webrtc.ICEServer{}
+	if len(s.configure.IceServers) > 0 {
+		iceServers = append(iceServers, webrtc.ICEServer{URLs: s.configure.IceServers})
+	}
+
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return "", err
+	}
+
+	if err := conn.open(peerConnection); err != nil {
+		peerConnection.Close()
+		return "", err
+	}
+
+	err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		return "", fmt.Errorf("%w. %v", errInvalidOffer, err)
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+
+	select {
+	case <-gatheringComplete:
+	case <-time.After(GATHERING_TIMEOUT):
+		logging.Component(logging.COMPONENT_WHIP).Warn("[WhipServer][negotiate] ice gathering timeout. answer with gathered candidates")
+	}
+
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+func (s *Server) terminate(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, s.path+RESOURCE)
+
+	s.mutex.Lock()
+	conn, exist := s.conns[id]
+	s.mutex.Unlock()
+
+	if !exist {
+		http.NotFound(w, r)
+		return
+	}
+
+	if conn.streamKey != s.streamKey(r) && len(s.streamKey(r)) > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	logging.Component(logging.COMPONENT_WHIP).WithField(logging.FIELD_CONNECTION_ID, id).Info("[WhipServer][terminate]")
+	conn.Close()
+	w.WriteHeader(http.StatusOK)
+}
+
+// streamKey return bearer token of WHIP or last path element of endpoint
+func (s *Server) streamKey(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if token, found := strings.CutPrefix(authorization, "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, s.path), "/")
+	if len(path) == 0 || strings.Contains(path, "/") {
+		return ""
+	}
+	return path
+}
+
+func newResourceId() string {
+	buffer := make([]byte, 16)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
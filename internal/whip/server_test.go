@@ -0,0 +1,208 @@
+/*
+MIT License
+
+Copyright (c) 2023 ISSuh
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package whip
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+
+	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+)
+
+// keyframe of 16x16 H264 which is only used as payload
+var testH264Frame = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xe0, 0x1f, 0xd9, 0x1f, 0x9e, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xc8, 0x3c, 0x48, 0x99, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x68, 0xcb, 0x83, 0xcb, 0x20,
+	0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x2b, 0xff, 0xfe, 0xd8, 0xe7, 0xf3, 0x2c, 0xa8,
+}
+
+// useFakeFFmpeg put ffmpeg which discard input in front of PATH. opus transcoder is not tested
+func useFakeFFmpeg(t *testing.T) {
+	t.Helper()
+
+	directory := t.TempDir()
+	script := "#!/bin/sh\nexec cat > /dev/null\n"
+	if err := os.WriteFile(filepath.Join(directory, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", directory+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// newPublisher create peer connection of browser which send H.264 only and return its offer
+func newPublisher(t *testing.T) (*webrtc.PeerConnection, *webrtc.TrackLocalStaticSample, string) {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peerConnection.Close() })
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   VIDEO_CLOCK_RATE,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	}, "video", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = peerConnection.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatheringComplete
+
+	return peerConnection, track, peerConnection.LocalDescription().SDP
+}
+
+func TestWhipPublish(t *testing.T) {
+	useFakeFFmpeg(t)
+
+	conns := make(chan *Conn, 1)
+	server, err := NewServer(configure.WhipConfigure{}, func(conn *Conn) error {
+		conns <- conn
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	peerConnection, track, offer := newPublisher(t)
+
+	request, _ := http.NewRequest(http.MethodPost, httpServer.URL+DEFAULT_PATH, strings.NewReader(offer))
+	request.Header.Set("Content-Type", CONTENT_TYPE_SDP)
+	request.Header.Set("Authorization", "Bearer test-key")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatal("publish is not accepted. ", response.StatusCode, " ", string(answer))
+	}
+
+	location := response.Header.Get("Location")
+	if !strings.HasPrefix(location, DEFAULT_PATH+RESOURCE) {
+		t.Fatal("invalid location. ", location)
+	}
+
+	conn := <-conns
+	if conn.StreamKey() != "test-key" {
+		t.Fatal("invalid stream key. ", conn.StreamKey())
+	}
+
+	err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)})
+	if err != nil {
+		t.Fatal("invalid answer. ", err)
+	}
+
+	// sample builder emit access unit when packet of next access unit is arrived
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(33 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				track.WriteSample(media.Sample{Data: testH264Frame, Duration: 33 * time.Millisecond})
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	packet, err := conn.Read()
+	if err != nil {
+		t.Fatal("video is not received. ", err)
+	}
+
+	if packet[0] != PACKET_VIDEO || !bytes.Contains(packet[PACKET_HEADER_SIZE:], []byte{0x65, 0x88, 0x84}) {
+		t.Fatalf("invalid packet. %x", packet)
+	}
+
+	request, _ = http.NewRequest(http.MethodDelete, httpServer.URL+location, nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatal("resource is not deleted. ", response.StatusCode)
+	}
+
+	select {
+	case <-conn.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not closed")
+	}
+}
+
+func TestWhipRejectWithoutStreamKey(t *testing.T) {
+	server, err := NewServer(configure.WhipConfigure{}, func(_ *Conn) error {
+		t.Fatal("publish handler is called without stream key")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, DEFAULT_PATH, strings.NewReader("v=0"))
+	request.Header.Set("Content-Type", CONTENT_TYPE_SDP)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatal("invalid status. ", recorder.Code)
+	}
+}
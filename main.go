@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/ISSuh/mystream-media_preprocessor/internal/configure"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/logging"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/media/ffmpeg"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/segment"
 	"github.com/ISSuh/mystream-media_preprocessor/internal/service"
+	"github.com/ISSuh/mystream-media_preprocessor/internal/tracing"
 )
 
 func main() {
@@ -23,6 +28,28 @@ func main() {
 		return
 	}
 
+	if err := logging.Setup(configure.Log, configure.Server.Discovery.Instance.HostName); err != nil {
+		log.Fatal("log configure error. ", err)
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(configure.Tracing, logging.NodeId())
+	if err != nil {
+		log.Fatal("tracing configure error. ", err)
+		return
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := ffmpeg.CheckAlignment(configure.Media); err != nil {
+		log.Fatal("segments of encodings can not be aligned. ", err)
+		return
+	}
+
+	if err := segment.CheckRetention(configure.Segment); err != nil {
+		log.Fatal("invalid retention of segments. ", err)
+		return
+	}
+
 	service := service.NewService(configure)
 	service.Run()
 }